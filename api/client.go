@@ -95,12 +95,24 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 		reqBody = bytes.NewReader(data)
 	}
 
+	path, query, _ := strings.Cut(path, "?")
 	requestURL := c.base.JoinPath(path)
+	requestURL.RawQuery = query
 	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), reqBody)
 	if err != nil {
 		return err
 	}
 
+	if f, ok := reqBody.(*os.File); ok {
+		// files are sent with their length so the server can check it has
+		// room for them before they're uploaded
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			if offset, err := f.Seek(0, io.SeekCurrent); err == nil {
+				request.ContentLength = info.Size() - offset
+			}
+		}
+	}
+
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
@@ -131,18 +143,24 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 const maxBufferSize = 512 * format.KiloByte
 
 func (c *Client) stream(ctx context.Context, method, path string, data any, fn func([]byte) error) error {
-	var buf *bytes.Buffer
-	if data != nil {
+	var body io.Reader
+	switch data := data.(type) {
+	case io.Reader:
+		// data is already an io.Reader
+		body = data
+	case nil:
+		// noop
+	default:
 		bts, err := json.Marshal(data)
 		if err != nil {
 			return err
 		}
 
-		buf = bytes.NewBuffer(bts)
+		body = bytes.NewBuffer(bts)
 	}
 
 	requestURL := c.base.JoinPath(path)
-	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), buf)
+	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), body)
 	if err != nil {
 		return err
 	}
@@ -264,6 +282,15 @@ func (c *Client) List(ctx context.Context) (*ListResponse, error) {
 	return &lr, nil
 }
 
+// ListModels is List with the models filtered and sorted by req
+func (c *Client) ListModels(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	var lr ListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/tags?"+req.values().Encode(), nil, &lr); err != nil {
+		return nil, err
+	}
+	return &lr, nil
+}
+
 func (c *Client) Copy(ctx context.Context, req *CopyRequest) error {
 	if err := c.do(ctx, http.MethodPost, "/api/copy", req, nil); err != nil {
 		return err
@@ -278,6 +305,38 @@ func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	return nil
 }
 
+func (c *Client) CreateAlias(ctx context.Context, req *AliasRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/aliases", req, nil)
+}
+
+func (c *Client) DeleteAlias(ctx context.Context, req *AliasRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/aliases", req, nil)
+}
+
+func (c *Client) ListAliases(ctx context.Context) (*AliasesResponse, error) {
+	var resp AliasesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/aliases", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) RemoteTags(ctx context.Context, req *RemoteTagsRequest) (*RemoteTagsResponse, error) {
+	var resp RemoteTagsResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tags/remote", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Pin(ctx context.Context, req *PinRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/pin", req, nil)
+}
+
+func (c *Client) Unpin(ctx context.Context, req *PinRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/unpin", req, nil)
+}
+
 func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
 	var resp ShowResponse
 	if err := c.do(ctx, http.MethodPost, "/api/show", req, &resp); err != nil {
@@ -286,6 +345,147 @@ func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, err
 	return &resp, nil
 }
 
+func (c *Client) GarbageCollect(ctx context.Context, req *GCRequest) (*GCResponse, error) {
+	var resp GCResponse
+	if err := c.do(ctx, http.MethodPost, "/api/gc", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+type VerifyProgressFunc func(VerifyResponse) error
+
+func (c *Client) Verify(ctx context.Context, req *VerifyRequest, fn VerifyProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/verify", req, func(bts []byte) error {
+		var resp VerifyResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
+// Updates checks the local models called models, or every local model if
+// there are none, against their registries and returns the outdated ones
+func (c *Client) Updates(ctx context.Context, insecure bool, models ...string) (*UpdatesResponse, error) {
+	query := url.Values{"model": models}
+	if insecure {
+		query.Set("insecure", "true")
+	}
+
+	var resp UpdatesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/updates?"+query.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Estimate returns the memory a model is estimated to need without loading it
+func (c *Client) Estimate(ctx context.Context, req *EstimateRequest) (*EstimateResponse, error) {
+	var resp EstimateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/estimate", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) ListPulls(ctx context.Context) (*PullsResponse, error) {
+	var resp PullsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/pulls", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) CancelPull(ctx context.Context, model string) error {
+	return c.do(ctx, http.MethodDelete, "/api/pulls/"+model, nil, nil)
+}
+
+func (c *Client) PausePull(ctx context.Context, model string) error {
+	return c.do(ctx, http.MethodPost, "/api/pulls/"+model+"/pause", nil, nil)
+}
+
+func (c *Client) ResumePull(ctx context.Context, model string) error {
+	return c.do(ctx, http.MethodPost, "/api/pulls/"+model+"/resume", nil, nil)
+}
+
+// Save writes the model archive to w, calling fn as it is received
+func (c *Client) Save(ctx context.Context, req *SaveRequest, w io.Writer, fn func(ProgressResponse) error) error {
+	bts, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	requestURL := c.base.JoinPath("/api/save")
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL.String(), bytes.NewReader(bts))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/x-tar")
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		return checkError(response, body)
+	}
+
+	buf := make([]byte, 1<<20)
+	var completed int64
+	for {
+		n, err := response.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			completed += int64(n)
+			if err := fn(ProgressResponse{Status: "saving", Total: response.ContentLength, Completed: completed}); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if response.ContentLength > 0 && completed != response.ContentLength {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+type LoadProgressFunc func(LoadResponse) error
+
+// Load imports the models in the archive read from r. The last response lists
+// the models which were loaded.
+func (c *Client) Load(ctx context.Context, r io.Reader, fn LoadProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/load", r, func(bts []byte) error {
+		var resp LoadResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 func (c *Client) Heartbeat(ctx context.Context) error {
 	if err := c.do(ctx, http.MethodHead, "/", nil, nil); err != nil {
 		return err
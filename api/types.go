@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"net/url"
 	"os"
 	"reflect"
 	"strconv"
@@ -144,10 +145,44 @@ type CreateRequest struct {
 	Modelfile string `json:"modelfile"`
 	Stream    *bool  `json:"stream,omitempty"`
 
+	// Quantize is the file type, such as Q4_K_M, to quantize a model converted
+	// from safetensors or pytorch to
+	Quantize string `json:"quantize,omitempty"`
+
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 }
 
+type AliasRequest struct {
+	Alias  string `json:"alias"`
+	Target string `json:"target,omitempty"`
+}
+
+type Alias struct {
+	Alias  string `json:"alias"`
+	Target string `json:"target"`
+}
+
+type AliasesResponse struct {
+	Aliases []Alias `json:"aliases"`
+}
+
+type RemoteTagsRequest struct {
+	Model    string `json:"model"`
+	Insecure bool   `json:"insecure,omitempty"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type RemoteTagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+// PinRequest pins a model so it isn't removed to free space, or unpins it
+type PinRequest struct {
+	Model string `json:"model"`
+}
+
 type DeleteRequest struct {
 	Model string `json:"model"`
 
@@ -162,6 +197,13 @@ type ShowRequest struct {
 
 	Options map[string]interface{} `json:"options"`
 
+	// Verbose includes the model's metadata and tensors
+	Verbose bool `json:"verbose,omitempty"`
+
+	// FullArrays includes every element of long metadata arrays, such as the
+	// vocabulary, which are otherwise elided
+	FullArrays bool `json:"full_arrays,omitempty"`
+
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 }
@@ -174,6 +216,20 @@ type ShowResponse struct {
 	System     string       `json:"system,omitempty"`
 	Details    ModelDetails `json:"details,omitempty"`
 	Messages   []Message    `json:"messages,omitempty"`
+	Signer     string       `json:"signer,omitempty"`
+
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Tensors  []TensorInfo   `json:"tensors,omitempty"`
+}
+
+// TensorInfo describes a tensor of a model. Offset is relative to the start of
+// the tensor data.
+type TensorInfo struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Shape  []uint64 `json:"shape"`
+	Offset uint64   `json:"offset"`
+	Size   uint64   `json:"size"`
 }
 
 type CopyRequest struct {
@@ -182,16 +238,119 @@ type CopyRequest struct {
 }
 
 type PullRequest struct {
-	Model    string `json:"model"`
-	Insecure bool   `json:"insecure,omitempty"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Stream   *bool  `json:"stream,omitempty"`
+	Model         string `json:"model"`
+	Insecure      bool   `json:"insecure,omitempty"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	RequireSigned bool   `json:"require_signed,omitempty"`
+	LimitRate     int64  `json:"limit_rate,omitempty"`
+	Stream        *bool  `json:"stream,omitempty"`
 
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 }
 
+type UpdatesResponse struct {
+	Models []ModelUpdate `json:"models"`
+}
+
+// ModelUpdate describes a local model which differs from its registry, or
+// which couldn't be checked if Error is set
+type ModelUpdate struct {
+	Model        string `json:"model"`
+	Digest       string `json:"digest,omitempty"`
+	RemoteDigest string `json:"remote_digest,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	RemoteSize   int64  `json:"remote_size,omitempty"`
+
+	// Download is the size of the blobs which aren't already local
+	Download int64  `json:"download,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type EstimateRequest struct {
+	Model string `json:"model"`
+
+	// Options are the runner options, such as num_ctx, num_gpu and num_batch,
+	// to estimate the model with
+	Options map[string]interface{} `json:"options"`
+}
+
+// EstimateResponse is the memory a model is estimated to need and how many of
+// its layers would be offloaded to the gpu. Sizes are in bytes.
+type EstimateResponse struct {
+	Model   string `json:"model"`
+	Library string `json:"library"`
+
+	NumCtx   int `json:"num_ctx"`
+	NumBatch int `json:"num_batch"`
+
+	Layers      int `json:"layers"`
+	TotalLayers int `json:"total_layers"`
+
+	Size  int64 `json:"size"`
+	KV    int64 `json:"kv"`
+	Graph int64 `json:"graph"`
+
+	VRAM          int64 `json:"vram"`
+	RAM           int64 `json:"ram"`
+	AvailableVRAM int64 `json:"available_vram"`
+	AvailableRAM  int64 `json:"available_ram,omitempty"`
+	Fits          bool  `json:"fits"`
+}
+
+type PullsResponse struct {
+	Pulls []PullStatus `json:"pulls"`
+}
+
+// PullStatus is the progress of a pull. State is "pulling", "queued" or "paused".
+type PullStatus struct {
+	Model     string `json:"model"`
+	State     string `json:"state"`
+	Status    string `json:"status,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+}
+
+type GCRequest struct {
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// GCResponse lists what garbage collection removed, or would remove with
+// DryRun, and the bytes that frees
+type GCResponse struct {
+	Blobs     []string `json:"blobs,omitempty"`
+	Partials  []string `json:"partials,omitempty"`
+	Reclaimed int64    `json:"reclaimed"`
+}
+
+type VerifyRequest struct {
+	Model  string `json:"model,omitempty"`
+	Repair bool   `json:"repair,omitempty"`
+}
+
+// VerifyResponse is the progress of a verify. Corrupt blobs are reported with
+// the "corrupt" status, the models using them and the reason.
+type VerifyResponse struct {
+	Status    string   `json:"status"`
+	Digest    string   `json:"digest,omitempty"`
+	Total     int64    `json:"total,omitempty"`
+	Completed int64    `json:"completed,omitempty"`
+	Models    []string `json:"models,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+type SaveRequest struct {
+	Model string `json:"model"`
+}
+
+// LoadResponse is the progress of loading an archive. The last response lists
+// the models which were loaded.
+type LoadResponse struct {
+	ProgressResponse
+	Models []string `json:"models,omitempty"`
+}
+
 type ProgressResponse struct {
 	Status    string `json:"status"`
 	Digest    string `json:"digest,omitempty"`
@@ -204,12 +363,50 @@ type PushRequest struct {
 	Insecure bool   `json:"insecure,omitempty"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Sign     bool   `json:"sign,omitempty"`
 	Stream   *bool  `json:"stream,omitempty"`
 
 	// Name is deprecated, see Model
 	Name string `json:"name"`
 }
 
+// ListRequest filters and sorts the models listed by /api/tags
+type ListRequest struct {
+	// Name is a glob such as llama2:* or llama2, which matches every tag
+	Name              string
+	Family            string
+	Format            string
+	ParameterSize     string
+	QuantizationLevel string
+
+	// ModifiedAfter and ModifiedBefore are a date, a RFC 3339 time or an age
+	// such as 36h or 7d
+	ModifiedAfter  string
+	ModifiedBefore string
+
+	// Sort is name, size or modified, prefixed with - to sort in descending order
+	Sort string
+}
+
+func (r *ListRequest) values() url.Values {
+	values := make(url.Values)
+	set := func(key, value string) {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+
+	set("name", r.Name)
+	set("family", r.Family)
+	set("format", r.Format)
+	set("parameter_size", r.ParameterSize)
+	set("quantization_level", r.QuantizationLevel)
+	set("modified_after", r.ModifiedAfter)
+	set("modified_before", r.ModifiedBefore)
+	set("sort", r.Sort)
+	return values
+}
+
 type ListResponse struct {
 	Models []ModelResponse `json:"models"`
 }
@@ -220,6 +417,9 @@ type ModelResponse struct {
 	ModifiedAt time.Time    `json:"modified_at"`
 	Size       int64        `json:"size"`
 	Digest     string       `json:"digest"`
+	Pinned     bool         `json:"pinned,omitempty"`
+	Root       string       `json:"root,omitempty"`
+	ReadOnly   bool         `json:"read_only,omitempty"`
 	Details    ModelDetails `json:"details,omitempty"`
 }
 
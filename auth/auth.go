@@ -5,11 +5,13 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -59,3 +61,32 @@ func Sign(ctx context.Context, bts []byte) (string, error) {
 	// signature is <pubkey>:<signature>
 	return fmt.Sprintf("%s:%s", bytes.TrimSpace(parts[1]), base64.StdEncoding.EncodeToString(signedData.Blob)), nil
 }
+
+// Verify checks a signature created by Sign and returns the public key which made it
+func Verify(bts []byte, signature string) (ssh.PublicKey, error) {
+	encodedKey, encodedSignature, ok := strings.Cut(signature, ":")
+	if !ok {
+		return nil, errors.New("malformed signature")
+	}
+
+	keyBytes, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := ssh.ParsePublicKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := publicKey.Verify(bts, &ssh.Signature{Format: publicKey.Type(), Blob: signatureBytes}); err != nil {
+		return nil, err
+	}
+
+	return publicKey, nil
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -18,8 +19,11 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/containerd/console"
@@ -29,8 +33,10 @@ import (
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/exp/slices"
 	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
 
 	"github.com/jmorganca/ollama/api"
+	"github.com/jmorganca/ollama/convert"
 	"github.com/jmorganca/ollama/format"
 	"github.com/jmorganca/ollama/parser"
 	"github.com/jmorganca/ollama/progress"
@@ -77,7 +83,12 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 	for _, c := range commands {
 		switch c.Name {
 		case "model", "adapter":
-			path := c.Args
+			arg := c.Args
+			if c.Name == "adapter" {
+				arg, _ = parser.AdapterArgs(c.Args)
+			}
+
+			path := arg
 			if path == "~" {
 				path = home
 			} else if strings.HasPrefix(path, "~/") {
@@ -95,7 +106,6 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				return err
 			}
 
-			// TODO make this work w/ adapters
 			if fi.IsDir() {
 				tf, err := os.CreateTemp("", "ollama-tf")
 				if err != nil {
@@ -105,23 +115,56 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 
 				zf := zip.NewWriter(tf)
 
-				files, err := filepath.Glob(filepath.Join(path, "model-*.safetensors"))
-				if err != nil {
-					return err
-				}
+				var files, optional []string
+				if c.Name == "adapter" {
+					files, err = convert.AdapterFiles(os.DirFS(path))
+					if err != nil {
+						return err
+					}
 
-				if len(files) == 0 {
-					return fmt.Errorf("no safetensors files were found in '%s'", path)
-				}
+					for i := range files {
+						files[i] = filepath.Join(path, files[i])
+					}
+
+					if len(files) == 0 {
+						return fmt.Errorf("no adapter_model.safetensors or adapter_model.bin was found in '%s'", path)
+					}
+
+					files = append(files, filepath.Join(path, "adapter_config.json"))
+				} else {
+					files, err = filepath.Glob(filepath.Join(path, "model-*.safetensors"))
+					if err != nil {
+						return err
+					}
 
-				// add the safetensor config file + tokenizer
-				files = append(files, filepath.Join(path, "config.json"))
-				files = append(files, filepath.Join(path, "added_tokens.json"))
-				files = append(files, filepath.Join(path, "tokenizer.model"))
+					if len(files) == 0 {
+						// fall back to pytorch checkpoints
+						files, err = convert.TorchFiles(os.DirFS(path))
+						if err != nil {
+							return err
+						}
+
+						for i := range files {
+							files[i] = filepath.Join(path, files[i])
+						}
+					}
+
+					if len(files) == 0 {
+						return fmt.Errorf("no safetensors or pytorch files were found in '%s'", path)
+					}
+
+					// add the config file + tokenizer
+					files = append(files, filepath.Join(path, "config.json"))
+
+					optional = []string{"added_tokens.json", "tokenizer.model", "tokenizer.json", "tokenizer_config.json"}
+					for _, fn := range optional {
+						files = append(files, filepath.Join(path, fn))
+					}
+				}
 
 				for _, fn := range files {
 					f, err := os.Open(fn)
-					if os.IsNotExist(err) && strings.HasSuffix(fn, "added_tokens.json") {
+					if os.IsNotExist(err) && slices.Contains(optional, filepath.Base(fn)) {
 						continue
 					} else if err != nil {
 						return err
@@ -167,7 +210,7 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				return err
 			}
 
-			modelfile = bytes.ReplaceAll(modelfile, []byte(c.Args), []byte("@"+digest))
+			modelfile = bytes.ReplaceAll(modelfile, []byte(arg), []byte("@"+digest))
 		}
 	}
 
@@ -182,6 +225,18 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				p.Add(resp.Digest, bar)
 			}
 
+			bar.Set(resp.Completed)
+		} else if resp.Total > 0 {
+			// tensors being converted or quantized
+			spinner.Stop()
+
+			bar, ok := bars[resp.Status]
+			if !ok {
+				bar = progress.NewBar(resp.Status, resp.Total, resp.Completed)
+				bars[resp.Status] = bar
+				p.Add(resp.Status, bar)
+			}
+
 			bar.Set(resp.Completed)
 		} else if status != resp.Status {
 			spinner.Stop()
@@ -194,7 +249,9 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.CreateRequest{Name: args[0], Modelfile: string(modelfile)}
+	quantize, _ := cmd.Flags().GetString("quantize")
+
+	request := api.CreateRequest{Name: args[0], Modelfile: string(modelfile), Quantize: quantize}
 	if err := client.Create(cmd.Context(), &request, fn); err != nil {
 		return err
 	}
@@ -239,75 +296,945 @@ func RunHandler(cmd *cobra.Command, args []string) error {
 			return err
 		}
 
-		show, err = client.Show(cmd.Context(), &api.ShowRequest{Name: name})
+		show, err = client.Show(cmd.Context(), &api.ShowRequest{Name: name})
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	interactive := true
+
+	opts := runOptions{
+		Model:       args[0],
+		WordWrap:    os.Getenv("TERM") == "xterm-256color",
+		Options:     map[string]interface{}{},
+		MultiModal:  slices.Contains(show.Details.Families, "clip"),
+		ParentModel: show.Details.ParentModel,
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	opts.Format = format
+
+	prompts := args[1:]
+	// prepend stdin to the prompt if provided
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		in, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		prompts = append([]string{string(in)}, prompts...)
+		opts.WordWrap = false
+		interactive = false
+	}
+	opts.Prompt = strings.Join(prompts, " ")
+	if len(prompts) > 0 {
+		interactive = false
+	}
+
+	nowrap, err := cmd.Flags().GetBool("nowordwrap")
+	if err != nil {
+		return err
+	}
+	opts.WordWrap = !nowrap
+
+	if !interactive {
+		return generate(cmd, opts)
+	}
+
+	return generateInteractive(cmd, opts)
+}
+
+func PushHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	insecure, err := cmd.Flags().GetBool("insecure")
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+	var status string
+	var spinner *progress.Spinner
+
+	fn := func(resp api.ProgressResponse) error {
+		if resp.Digest != "" {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			bar, ok := bars[resp.Digest]
+			if !ok {
+				bar = progress.NewBar(fmt.Sprintf("pushing %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
+				bars[resp.Digest] = bar
+				p.Add(resp.Digest, bar)
+			}
+
+			bar.Set(resp.Completed)
+		} else if status != resp.Status {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	username, password, err := registryCredentials(cmd)
+	if err != nil {
+		return err
+	}
+
+	sign, err := cmd.Flags().GetBool("sign")
+	if err != nil {
+		return err
+	}
+
+	request := api.PushRequest{Name: args[0], Insecure: insecure, Username: username, Password: password, Sign: sign}
+	if err := client.Push(cmd.Context(), &request, fn); err != nil {
+		return err
+	}
+
+	spinner.Stop()
+	return nil
+}
+
+func ListHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	req := api.ListRequest{}
+	for flag, value := range map[string]*string{
+		"name":            &req.Name,
+		"family":          &req.Family,
+		"model-format":    &req.Format,
+		"parameter-size":  &req.ParameterSize,
+		"quantization":    &req.QuantizationLevel,
+		"modified-after":  &req.ModifiedAfter,
+		"modified-before": &req.ModifiedBefore,
+		"sort":            &req.Sort,
+	} {
+		if *value, err = cmd.Flags().GetString(flag); err != nil {
+			return err
+		}
+	}
+
+	outputFormat, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	models, err := client.ListModels(cmd.Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		models.Models = slices.DeleteFunc(models.Models, func(m api.ModelResponse) bool {
+			return !strings.HasPrefix(m.Name, args[0])
+		})
+	}
+
+	if outputFormat != "" {
+		return formatOutput(os.Stdout, outputFormat, models.Models)
+	}
+
+	// the root is only shown when models are spread across several
+	roots := make(map[string]bool)
+	for _, m := range models.Models {
+		roots[m.Root] = true
+	}
+
+	var data [][]string
+
+	for _, m := range models.Models {
+		row := []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")}
+		if len(roots) > 1 {
+			root := m.Root
+			if m.ReadOnly {
+				root += " (read-only)"
+			}
+
+			row = append(row, root)
+		}
+
+		data = append(data, row)
+	}
+
+	header := []string{"NAME", "ID", "SIZE", "MODIFIED"}
+	if len(roots) > 1 {
+		header = append(header, "ROOT")
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("\t")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
+// formatOutput writes v as json or yaml, or executes output as a template
+// for each element of v
+func formatOutput[T any](w io.Writer, output string, v []T) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		// go through json so the json field names are used
+		bts, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		var generic any
+		if err := json.Unmarshal(bts, &generic); err != nil {
+			return err
+		}
+
+		return yaml.NewEncoder(w).Encode(generic)
+	}
+
+	tmpl, err := template.New("format").Parse(output)
+	if err != nil {
+		return fmt.Errorf("--format must be json, yaml or a template: %w", err)
+	}
+
+	for _, e := range v {
+		if err := tmpl.Execute(w, e); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+func DeleteHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args {
+		req := api.DeleteRequest{Name: name}
+		if err := client.Delete(cmd.Context(), &req); err != nil {
+			return err
+		}
+		fmt.Printf("deleted '%s'\n", name)
+	}
+	return nil
+}
+
+func TagHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	list, err := cmd.Flags().GetBool("list")
+	if err != nil {
+		return err
+	}
+
+	remove, err := cmd.Flags().GetBool("delete")
+	if err != nil {
+		return err
+	}
+
+	remote, err := cmd.Flags().GetBool("remote")
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case list:
+		resp, err := client.ListAliases(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		var data [][]string
+		for _, a := range resp.Aliases {
+			data = append(data, []string{a.Alias, a.Target})
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ALIAS", "TARGET"})
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetHeaderLine(false)
+		table.SetBorder(false)
+		table.SetNoWhiteSpace(true)
+		table.SetTablePadding("\t")
+		table.AppendBulk(data)
+		table.Render()
+	case remove:
+		if len(args) == 0 {
+			return errors.New("missing alias")
+		}
+
+		for _, alias := range args {
+			if err := client.DeleteAlias(cmd.Context(), &api.AliasRequest{Alias: alias}); err != nil {
+				return err
+			}
+			fmt.Printf("deleted alias '%s'\n", alias)
+		}
+	case remote:
+		if len(args) != 1 {
+			return errors.New("missing model name")
+		}
+
+		insecure, err := cmd.Flags().GetBool("insecure")
+		if err != nil {
+			return err
+		}
+
+		username, password, err := registryCredentials(cmd)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.RemoteTags(cmd.Context(), &api.RemoteTagsRequest{
+			Model:    args[0],
+			Insecure: insecure,
+			Username: username,
+			Password: password,
+		})
+		if err != nil {
+			return err
+		}
+
+		// print the names as they would be pulled
+		repository := args[0]
+		if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+			repository = repository[:i]
+		}
+
+		for _, tag := range resp.Tags {
+			fmt.Printf("%s:%s\n", repository, tag)
+		}
+	default:
+		if len(args) != 2 {
+			return errors.New("usage: ollama tag SOURCE ALIAS")
+		}
+
+		if err := client.CreateAlias(cmd.Context(), &api.AliasRequest{Alias: args[1], Target: args[0]}); err != nil {
+			return err
+		}
+		fmt.Printf("'%s' now refers to '%s'\n", args[1], args[0])
+	}
+
+	return nil
+}
+
+func PinHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args {
+		if err := client.Pin(cmd.Context(), &api.PinRequest{Model: name}); err != nil {
+			return err
+		}
+		fmt.Printf("pinned '%s'\n", name)
+	}
+	return nil
+}
+
+func UnpinHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args {
+		if err := client.Unpin(cmd.Context(), &api.PinRequest{Model: name}); err != nil {
+			return err
+		}
+		fmt.Printf("unpinned '%s'\n", name)
+	}
+	return nil
+}
+
+func ShowHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 {
+		return errors.New("missing model name")
+	}
+
+	license, errLicense := cmd.Flags().GetBool("license")
+	modelfile, errModelfile := cmd.Flags().GetBool("modelfile")
+	parameters, errParams := cmd.Flags().GetBool("parameters")
+	system, errSystem := cmd.Flags().GetBool("system")
+	template, errTemplate := cmd.Flags().GetBool("template")
+	signer, errSigner := cmd.Flags().GetBool("signer")
+
+	for _, boolErr := range []error{errLicense, errModelfile, errParams, errSystem, errTemplate, errSigner} {
+		if boolErr != nil {
+			return errors.New("error retrieving flags")
+		}
+	}
+
+	flagsSet := 0
+	showType := ""
+
+	if license {
+		flagsSet++
+		showType = "license"
+	}
+
+	if modelfile {
+		flagsSet++
+		showType = "modelfile"
+	}
+
+	if parameters {
+		flagsSet++
+		showType = "parameters"
+	}
+
+	if system {
+		flagsSet++
+		showType = "system"
+	}
+
+	if template {
+		flagsSet++
+		showType = "template"
+	}
+
+	if signer {
+		flagsSet++
+		showType = "signer"
+	}
+
+	metadata, _ := cmd.Flags().GetBool("metadata")
+	tensors, _ := cmd.Flags().GetBool("tensors")
+	fullArrays, _ := cmd.Flags().GetBool("full-arrays")
+
+	if metadata || tensors {
+		if flagsSet > 0 {
+			return errors.New("'--metadata' and '--tensors' can't be combined with other flags")
+		}
+
+		req := api.ShowRequest{Name: args[0], Verbose: true, FullArrays: fullArrays}
+		resp, err := client.Show(cmd.Context(), &req)
+		if err != nil {
+			return err
+		}
+
+		if metadata {
+			showMetadata(resp.Metadata)
+		}
+
+		if metadata && tensors {
+			fmt.Println()
+		}
+
+		if tensors {
+			showTensors(resp.Tensors)
+		}
+
+		return nil
+	}
+
+	if flagsSet > 1 {
+		return errors.New("only one of '--license', '--modelfile', '--parameters', '--system', '--template', or '--signer' can be specified")
+	} else if flagsSet == 0 {
+		return errors.New("one of '--license', '--modelfile', '--parameters', '--system', '--template', '--signer', '--metadata' or '--tensors' must be specified")
+	}
+
+	req := api.ShowRequest{Name: args[0]}
+	resp, err := client.Show(cmd.Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	switch showType {
+	case "license":
+		fmt.Println(resp.License)
+	case "modelfile":
+		fmt.Println(resp.Modelfile)
+	case "parameters":
+		fmt.Println(resp.Parameters)
+	case "system":
+		fmt.Println(resp.System)
+	case "template":
+		fmt.Println(resp.Template)
+	case "signer":
+		if resp.Signer == "" {
+			fmt.Println("unsigned")
+		} else {
+			fmt.Println(resp.Signer)
+		}
+	}
+
+	return nil
+}
+
+func showMetadata(metadata map[string]any) {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var data [][]string
+	for _, k := range keys {
+		v := metadata[k]
+		if s, ok := v.(string); ok {
+			v = strconv.Quote(s)
+		}
+
+		data = append(data, []string{k, fmt.Sprint(v)})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"KEY", "VALUE"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetAutoWrapText(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("\t")
+	table.AppendBulk(data)
+	table.Render()
+}
+
+func showTensors(tensors []api.TensorInfo) {
+	var data [][]string
+	for _, t := range tensors {
+		shape := make([]string, len(t.Shape))
+		for i, n := range t.Shape {
+			shape[i] = strconv.FormatUint(n, 10)
+		}
+
+		data = append(data, []string{
+			t.Name,
+			t.Type,
+			"[" + strings.Join(shape, ", ") + "]",
+			strconv.FormatUint(t.Offset, 10),
+			format.HumanBytes(int64(t.Size)),
+		})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "TYPE", "SHAPE", "OFFSET", "SIZE"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("\t")
+	table.AppendBulk(data)
+	table.Render()
+}
+
+func CopyHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	req := api.CopyRequest{Source: args[0], Destination: args[1]}
+	if err := client.Copy(cmd.Context(), &req); err != nil {
+		return err
+	}
+	fmt.Printf("copied '%s' to '%s'\n", args[0], args[1])
+	return nil
+}
+
+func SaveHandler(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w = f
+	} else if term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("refusing to write the archive to a terminal, use -o or redirect stdout")
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	var bar *progress.Bar
+	fn := func(resp api.ProgressResponse) error {
+		if bar == nil {
+			bar = progress.NewBar(fmt.Sprintf("saving %s...", args[0]), resp.Total, resp.Completed)
+			p.Add(args[0], bar)
+		}
+
+		bar.Set(resp.Completed)
+		return nil
+	}
+
+	if err := client.Save(cmd.Context(), &api.SaveRequest{Model: args[0]}, w, fn); err != nil {
+		if output != "" {
+			os.Remove(output)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func LoadHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	var r io.Reader = os.Stdin
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		bar := progress.NewBar(fmt.Sprintf("loading %s...", filepath.Base(args[0])), fi.Size(), 0)
+		p.Add(args[0], bar)
+		r = &progressReader{Reader: f, bar: bar}
+	} else {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.New("no archive given, pass a file or pipe one to stdin")
+		}
+
+		spinner := progress.NewSpinner("loading archive")
+		p.Add("", spinner)
+	}
+
+	bars := make(map[string]*progress.Bar)
+
+	var status string
+	var spinner *progress.Spinner
+	var models []string
+	fn := func(resp api.LoadResponse) error {
+		switch {
+		case len(resp.Models) > 0:
+			models = resp.Models
+		case resp.Digest != "":
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			bar, ok := bars[resp.Digest]
+			if !ok {
+				bar = progress.NewBar(fmt.Sprintf("verifying %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
+				bars[resp.Digest] = bar
+				p.Add(resp.Digest, bar)
+			}
+
+			bar.Set(resp.Completed)
+		case status != resp.Status:
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	if err := client.Load(cmd.Context(), r, fn); err != nil {
+		return err
+	}
+
+	p.StopAndClear()
+	for _, name := range models {
+		fmt.Printf("loaded '%s'\n", name)
+	}
+
+	return nil
+}
+
+type progressReader struct {
+	io.Reader
+	bar       *progress.Bar
+	completed int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.completed += int64(n)
+	r.bar.Set(r.completed)
+	return n, err
+}
+
+func GarbageCollectHandler(cmd *cobra.Command, args []string) error {
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.GarbageCollect(cmd.Context(), &api.GCRequest{DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+
+	for _, blob := range resp.Blobs {
+		fmt.Printf("%s unused blob %s\n", verb, blob)
+	}
+
+	for _, partial := range resp.Partials {
+		fmt.Printf("%s stale partial download %s\n", verb, partial)
+	}
+
+	if dryRun {
+		fmt.Printf("%s reclaimable\n", format.HumanBytes(resp.Reclaimed))
+	} else {
+		fmt.Printf("%s reclaimed\n", format.HumanBytes(resp.Reclaimed))
+	}
+
+	return nil
+}
+
+func VerifyHandler(cmd *cobra.Command, args []string) error {
+	repair, err := cmd.Flags().GetBool("repair")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+
+	var status string
+	var spinner *progress.Spinner
+	var corrupt []api.VerifyResponse
+
+	fn := func(resp api.VerifyResponse) error {
+		switch {
+		case resp.Status == "corrupt":
+			corrupt = append(corrupt, resp)
+		case resp.Digest != "":
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			key := resp.Status + resp.Digest
+			bar, ok := bars[key]
+			if !ok {
+				bar = progress.NewBar(resp.Status, resp.Total, resp.Completed)
+				bars[key] = bar
+				p.Add(key, bar)
+			}
+
+			bar.Set(resp.Completed)
+		case status != resp.Status:
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	req := api.VerifyRequest{Repair: repair}
+	if len(args) > 0 {
+		req.Model = args[0]
+	}
+
+	if err := client.Verify(cmd.Context(), &req, fn); err != nil {
+		return err
+	}
+
+	p.StopAndClear()
+
+	for _, c := range corrupt {
+		name := c.Digest
+		if name == "" {
+			name = "manifest"
+		}
+
+		fmt.Printf("%s (%s): %s\n", name, strings.Join(c.Models, ", "), c.Reason)
+	}
+
+	if len(corrupt) > 0 && !repair {
+		return fmt.Errorf("found %d corrupt blobs, run 'ollama verify --repair' to pull them again", len(corrupt))
+	}
+
+	return nil
+}
+
+func PullHandler(cmd *cobra.Command, args []string) error {
+	insecure, err := cmd.Flags().GetBool("insecure")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	// run pulls missing models without the flags for managing pulls
+	var allOutdated bool
+	if cmd.Flags().Lookup("list") != nil {
+		if handled, err := managePulls(cmd, client, args); handled || err != nil {
+			return err
+		}
+
+		if allOutdated, err = cmd.Flags().GetBool("all-outdated"); err != nil {
+			return err
+		}
+	}
+
+	names := args
+	if allOutdated {
+		resp, err := client.Updates(cmd.Context(), insecure, args...)
 		if err != nil {
 			return err
 		}
-	case err != nil:
-		return err
-	}
 
-	interactive := true
+		names = nil
+		for _, update := range resp.Models {
+			if update.Error == "" {
+				names = append(names, update.Model)
+			}
+		}
 
-	opts := runOptions{
-		Model:       args[0],
-		WordWrap:    os.Getenv("TERM") == "xterm-256color",
-		Options:     map[string]interface{}{},
-		MultiModal:  slices.Contains(show.Details.Families, "clip"),
-		ParentModel: show.Details.ParentModel,
+		if len(names) == 0 {
+			fmt.Println("all models are up to date")
+			return nil
+		}
+	} else if len(args) != 1 {
+		return errors.New("missing model name")
 	}
 
-	format, err := cmd.Flags().GetString("format")
+	username, password, err := registryCredentials(cmd)
 	if err != nil {
 		return err
 	}
-	opts.Format = format
 
-	prompts := args[1:]
-	// prepend stdin to the prompt if provided
-	if !term.IsTerminal(int(os.Stdin.Fd())) {
-		in, err := io.ReadAll(os.Stdin)
-		if err != nil {
+	// run pulls missing models without the --require-signed or --limit-rate flags
+	var requireSigned bool
+	if cmd.Flags().Lookup("require-signed") != nil {
+		if requireSigned, err = cmd.Flags().GetBool("require-signed"); err != nil {
 			return err
 		}
-
-		prompts = append([]string{string(in)}, prompts...)
-		opts.WordWrap = false
-		interactive = false
-	}
-	opts.Prompt = strings.Join(prompts, " ")
-	if len(prompts) > 0 {
-		interactive = false
 	}
 
-	nowrap, err := cmd.Flags().GetBool("nowordwrap")
-	if err != nil {
-		return err
-	}
-	opts.WordWrap = !nowrap
+	var limitRate int64
+	if cmd.Flags().Lookup("limit-rate") != nil {
+		s, err := cmd.Flags().GetString("limit-rate")
+		if err != nil {
+			return err
+		}
 
-	if !interactive {
-		return generate(cmd, opts)
+		if s != "" {
+			if limitRate, err = format.ParseBytes(s); err != nil {
+				return err
+			}
+		}
 	}
 
-	return generateInteractive(cmd, opts)
-}
+	for _, name := range names {
+		if allOutdated {
+			fmt.Printf("updating %s\n", name)
+		}
 
-func PushHandler(cmd *cobra.Command, args []string) error {
-	client, err := api.ClientFromEnvironment()
-	if err != nil {
-		return err
+		request := api.PullRequest{
+			Name:          name,
+			Insecure:      insecure,
+			Username:      username,
+			Password:      password,
+			RequireSigned: requireSigned,
+			LimitRate:     limitRate,
+		}
+		if err := pullWithProgress(cmd.Context(), client, &request); err != nil {
+			return err
+		}
 	}
 
-	insecure, err := cmd.Flags().GetBool("insecure")
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
+func pullWithProgress(ctx context.Context, client *api.Client, request *api.PullRequest) error {
 	p := progress.NewProgress(os.Stderr)
 	defer p.Stop()
 
 	bars := make(map[string]*progress.Bar)
+
 	var status string
 	var spinner *progress.Spinner
 
@@ -319,7 +1246,7 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 
 			bar, ok := bars[resp.Digest]
 			if !ok {
-				bar = progress.NewBar(fmt.Sprintf("pushing %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
+				bar = progress.NewBar(fmt.Sprintf("pulling %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
 				bars[resp.Digest] = bar
 				p.Add(resp.Digest, bar)
 			}
@@ -338,36 +1265,38 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PushRequest{Name: args[0], Insecure: insecure}
-	if err := client.Push(cmd.Context(), &request, fn); err != nil {
-		return err
-	}
-
-	spinner.Stop()
-	return nil
+	return client.Pull(ctx, request, fn)
 }
 
-func ListHandler(cmd *cobra.Command, args []string) error {
-	client, err := api.ClientFromEnvironment()
+// checkUpdates handles the --check flag of pull
+func checkUpdates(cmd *cobra.Command, client *api.Client, args []string) error {
+	insecure, err := cmd.Flags().GetBool("insecure")
 	if err != nil {
 		return err
 	}
 
-	models, err := client.List(cmd.Context())
+	resp, err := client.Updates(cmd.Context(), insecure, args...)
 	if err != nil {
 		return err
 	}
 
-	var data [][]string
+	if len(resp.Models) == 0 {
+		fmt.Println("all models are up to date")
+		return nil
+	}
 
-	for _, m := range models.Models {
-		if len(args) == 0 || strings.HasPrefix(m.Name, args[0]) {
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")})
+	var data [][]string
+	for _, update := range resp.Models {
+		if update.Error != "" {
+			data = append(data, []string{update.Model, "-", "-", update.Error})
+			continue
 		}
+
+		data = append(data, []string{update.Model, signedBytes(update.RemoteSize - update.Size), format.HumanBytes(update.Download), "outdated"})
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"NAME", "ID", "SIZE", "MODIFIED"})
+	table.SetHeader([]string{"NAME", "SIZE CHANGE", "DOWNLOAD", "STATUS"})
 	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.SetHeaderLine(false)
@@ -380,166 +1309,151 @@ func ListHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func DeleteHandler(cmd *cobra.Command, args []string) error {
-	client, err := api.ClientFromEnvironment()
-	if err != nil {
-		return err
+// signedBytes formats a change in size such as +1.2 GB
+func signedBytes(n int64) string {
+	if n < 0 {
+		return "-" + format.HumanBytes(-n)
 	}
 
-	for _, name := range args {
-		req := api.DeleteRequest{Name: name}
-		if err := client.Delete(cmd.Context(), &req); err != nil {
-			return err
-		}
-		fmt.Printf("deleted '%s'\n", name)
-	}
-	return nil
+	return "+" + format.HumanBytes(n)
 }
 
-func ShowHandler(cmd *cobra.Command, args []string) error {
-	client, err := api.ClientFromEnvironment()
+// managePulls handles the --list, --check, --pause, --resume and --cancel flags of
+// pull. It reports whether the command is done, a resumed pull continues to
+// show its progress.
+func managePulls(cmd *cobra.Command, client *api.Client, args []string) (bool, error) {
+	list, err := cmd.Flags().GetBool("list")
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	if len(args) != 1 {
-		return errors.New("missing model name")
+	check, err := cmd.Flags().GetBool("check")
+	if err != nil {
+		return false, err
 	}
 
-	license, errLicense := cmd.Flags().GetBool("license")
-	modelfile, errModelfile := cmd.Flags().GetBool("modelfile")
-	parameters, errParams := cmd.Flags().GetBool("parameters")
-	system, errSystem := cmd.Flags().GetBool("system")
-	template, errTemplate := cmd.Flags().GetBool("template")
+	if check {
+		return true, checkUpdates(cmd, client, args)
+	}
 
-	for _, boolErr := range []error{errLicense, errModelfile, errParams, errSystem, errTemplate} {
-		if boolErr != nil {
-			return errors.New("error retrieving flags")
+	if list {
+		resp, err := client.ListPulls(cmd.Context())
+		if err != nil {
+			return true, err
 		}
-	}
 
-	flagsSet := 0
-	showType := ""
+		var data [][]string
+		for _, p := range resp.Pulls {
+			progress := "-"
+			if p.Total > 0 {
+				progress = fmt.Sprintf("%s/%s (%d%%)", format.HumanBytes(p.Completed), format.HumanBytes(p.Total), p.Completed*100/p.Total)
+			}
 
-	if license {
-		flagsSet++
-		showType = "license"
-	}
+			data = append(data, []string{p.Model, p.State, progress})
+		}
 
-	if modelfile {
-		flagsSet++
-		showType = "modelfile"
-	}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"NAME", "STATE", "PROGRESS"})
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetHeaderLine(false)
+		table.SetBorder(false)
+		table.SetNoWhiteSpace(true)
+		table.SetTablePadding("\t")
+		table.AppendBulk(data)
+		table.Render()
 
-	if parameters {
-		flagsSet++
-		showType = "parameters"
+		return true, nil
 	}
 
-	if system {
-		flagsSet++
-		showType = "system"
-	}
+	for _, action := range []string{"pause", "resume", "cancel"} {
+		set, err := cmd.Flags().GetBool(action)
+		if err != nil {
+			return false, err
+		}
 
-	if template {
-		flagsSet++
-		showType = "template"
-	}
+		if !set {
+			continue
+		}
 
-	if flagsSet > 1 {
-		return errors.New("only one of '--license', '--modelfile', '--parameters', '--system', or '--template' can be specified")
-	} else if flagsSet == 0 {
-		return errors.New("one of '--license', '--modelfile', '--parameters', '--system', or '--template' must be specified")
-	}
+		if len(args) != 1 {
+			return true, errors.New("missing model name")
+		}
 
-	req := api.ShowRequest{Name: args[0]}
-	resp, err := client.Show(cmd.Context(), &req)
-	if err != nil {
-		return err
-	}
+		switch action {
+		case "pause":
+			if err := client.PausePull(cmd.Context(), args[0]); err != nil {
+				return true, err
+			}
 
-	switch showType {
-	case "license":
-		fmt.Println(resp.License)
-	case "modelfile":
-		fmt.Println(resp.Modelfile)
-	case "parameters":
-		fmt.Println(resp.Parameters)
-	case "system":
-		fmt.Println(resp.System)
-	case "template":
-		fmt.Println(resp.Template)
+			fmt.Printf("paused pull of '%s'\n", args[0])
+			return true, nil
+		case "resume":
+			// the pull continues in the server, follow its progress as a normal pull
+			return false, client.ResumePull(cmd.Context(), args[0])
+		case "cancel":
+			if err := client.CancelPull(cmd.Context(), args[0]); err != nil {
+				return true, err
+			}
+
+			fmt.Printf("canceled pull of '%s'\n", args[0])
+			return true, nil
+		}
 	}
 
-	return nil
+	return false, nil
 }
 
-func CopyHandler(cmd *cobra.Command, args []string) error {
-	client, err := api.ClientFromEnvironment()
-	if err != nil {
-		return err
-	}
-
-	req := api.CopyRequest{Source: args[0], Destination: args[1]}
-	if err := client.Copy(cmd.Context(), &req); err != nil {
-		return err
+// registryCredentials reads the --username and --password-stdin flags, or
+// prompts for the password of --username if stdin is a terminal. Without them
+// the server falls back to the docker client configuration.
+func registryCredentials(cmd *cobra.Command) (string, string, error) {
+	// run pulls missing models but doesn't take credentials
+	if cmd.Flags().Lookup("username") == nil {
+		return "", "", nil
 	}
-	fmt.Printf("copied '%s' to '%s'\n", args[0], args[1])
-	return nil
-}
 
-func PullHandler(cmd *cobra.Command, args []string) error {
-	insecure, err := cmd.Flags().GetBool("insecure")
+	username, err := cmd.Flags().GetString("username")
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	client, err := api.ClientFromEnvironment()
+	passwordStdin, err := cmd.Flags().GetBool("password-stdin")
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	p := progress.NewProgress(os.Stderr)
-	defer p.Stop()
-
-	bars := make(map[string]*progress.Bar)
-
-	var status string
-	var spinner *progress.Spinner
-
-	fn := func(resp api.ProgressResponse) error {
-		if resp.Digest != "" {
-			if spinner != nil {
-				spinner.Stop()
-			}
-
-			bar, ok := bars[resp.Digest]
-			if !ok {
-				bar = progress.NewBar(fmt.Sprintf("pulling %s...", resp.Digest[7:19]), resp.Total, resp.Completed)
-				bars[resp.Digest] = bar
-				p.Add(resp.Digest, bar)
-			}
+	switch {
+	case username == "" && passwordStdin:
+		return "", "", errors.New("--password-stdin requires --username")
+	case username == "":
+		return "", "", nil
+	case !passwordStdin:
+		// a username without a password would be sent without either
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return "", "", errors.New("--username requires --password-stdin when stdin isn't a terminal")
+		}
 
-			bar.Set(resp.Completed)
-		} else if status != resp.Status {
-			if spinner != nil {
-				spinner.Stop()
-			}
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", "", err
+		}
 
-			status = resp.Status
-			spinner = progress.NewSpinner(status)
-			p.Add(status, spinner)
+		if len(password) == 0 {
+			return "", "", errors.New("a password is required with --username")
 		}
 
-		return nil
+		return username, string(password), nil
 	}
 
-	request := api.PullRequest{Name: args[0], Insecure: insecure}
-	if err := client.Pull(cmd.Context(), &request, fn); err != nil {
-		return err
+	password, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", "", err
 	}
 
-	return nil
+	return username, strings.TrimRight(string(password), "\r\n"), nil
 }
 
 type generateContextKey string
@@ -932,6 +1846,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	createCmd.Flags().StringP("file", "f", "Modelfile", "Name of the Modelfile (default \"Modelfile\")")
+	createCmd.Flags().StringP("quantize", "q", "", "Quantize a converted model to a file type, e.g. Q4_K_M")
 
 	showCmd := &cobra.Command{
 		Use:     "show MODEL",
@@ -946,6 +1861,10 @@ func NewCLI() *cobra.Command {
 	showCmd.Flags().Bool("parameters", false, "Show parameters of a model")
 	showCmd.Flags().Bool("template", false, "Show template of a model")
 	showCmd.Flags().Bool("system", false, "Show system message of a model")
+	showCmd.Flags().Bool("signer", false, "Show the key which signed a model")
+	showCmd.Flags().Bool("metadata", false, "Show metadata of a model's weights")
+	showCmd.Flags().Bool("tensors", false, "Show tensors of a model's weights")
+	showCmd.Flags().Bool("full-arrays", false, "Show every element of long metadata arrays")
 
 	runCmd := &cobra.Command{
 		Use:     "run MODEL [PROMPT]",
@@ -971,19 +1890,38 @@ Environment Variables:
 
     OLLAMA_HOST         The host:port to bind to (default "127.0.0.1:11434")
     OLLAMA_ORIGINS      A comma separated list of allowed origins.
-    OLLAMA_MODELS       The path to the models directory (default is "~/.ollama/models")
+    OLLAMA_MODELS       The path to the models directory, or a list of them searched in order where models are written to the first writable one (default is "~/.ollama/models")
     OLLAMA_KEEP_ALIVE   The duration that models stay loaded in memory (default is "5m")
+    OLLAMA_REGISTRIES   The path to the registry mirrors config (default is "~/.ollama/registries.json")
+    OLLAMA_REGISTRY     Serve local models read-only to other Ollama instances under /v2/
+    OLLAMA_REGISTRY_CACHE  A comma separated list of registries to act as a pull-through cache for
+    OLLAMA_TRUST_POLICY The path to the trusted signing keys (default is "~/.ollama/trust.json")
+    OLLAMA_MAX_DOWNLOAD_RATE  The maximum download rate per second for all pulls (e.g. "10MB")
+    OLLAMA_DOWNLOAD_PARTS  The number of parts downloaded concurrently for each blob (default 64)
+    OLLAMA_MAX_PULLS    The maximum number of pulls to run at once, later pulls are queued (default unlimited)
+    OLLAMA_MAX_STORE_SIZE  The maximum size of the models directory, least recently used models are removed to make room (e.g. "100GB")
 `)
 
 	pullCmd := &cobra.Command{
 		Use:     "pull MODEL",
 		Short:   "Pull a model from a registry",
-		Args:    cobra.ExactArgs(1),
+		Args:    cobra.MaximumNArgs(1),
 		PreRunE: checkServerHeartbeat,
 		RunE:    PullHandler,
 	}
 
 	pullCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pullCmd.Flags().String("username", "", "Username for the registry")
+	pullCmd.Flags().Bool("password-stdin", false, "Read the registry password from stdin")
+	pullCmd.Flags().Bool("require-signed", false, "Only pull models signed by a trusted key")
+	pullCmd.Flags().String("limit-rate", "", "Maximum download rate per second (e.g. 10MB)")
+	pullCmd.Flags().Bool("list", false, "List active and paused pulls")
+	pullCmd.Flags().Bool("pause", false, "Pause a pull")
+	pullCmd.Flags().Bool("resume", false, "Resume a paused pull")
+	pullCmd.Flags().Bool("cancel", false, "Cancel a pull")
+	pullCmd.Flags().Bool("check", false, "List models which are outdated")
+	pullCmd.Flags().Bool("all-outdated", false, "Pull every outdated model")
+	pullCmd.MarkFlagsMutuallyExclusive("list", "pause", "resume", "cancel", "check", "all-outdated")
 
 	pushCmd := &cobra.Command{
 		Use:     "push MODEL",
@@ -994,14 +1932,100 @@ Environment Variables:
 	}
 
 	pushCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pushCmd.Flags().String("username", "", "Username for the registry")
+	pushCmd.Flags().Bool("password-stdin", false, "Read the registry password from stdin")
+	pushCmd.Flags().Bool("sign", false, "Sign the manifest with your Ollama key")
+
+	saveCmd := &cobra.Command{
+		Use:     "save MODEL",
+		Short:   "Save a model to an archive",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    SaveHandler,
+	}
+
+	saveCmd.Flags().StringP("output", "o", "", "Write the archive to a file instead of stdout")
+
+	loadCmd := &cobra.Command{
+		Use:     "load [FILE]",
+		Short:   "Load models from an archive",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    LoadHandler,
+	}
+
+	tagCmd := &cobra.Command{
+		Use:   "tag SOURCE ALIAS",
+		Short: "Create an alias which refers to a model",
+		Long: `Create an alias which refers to a model. Unlike cp the alias follows
+the model it refers to when that model is pulled or created again.`,
+		Args:    cobra.MaximumNArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    TagHandler,
+	}
+
+	tagCmd.Flags().Bool("list", false, "List aliases")
+	tagCmd.Flags().Bool("delete", false, "Delete aliases")
+	tagCmd.Flags().Bool("remote", false, "List the tags of a model's repository in its registry")
+	tagCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	tagCmd.Flags().String("username", "", "Username for the registry")
+	tagCmd.Flags().Bool("password-stdin", false, "Read the registry password from stdin")
+	tagCmd.MarkFlagsMutuallyExclusive("list", "delete", "remote")
+
+	pinCmd := &cobra.Command{
+		Use:     "pin MODEL [MODEL...]",
+		Short:   "Keep a model from being removed to free space",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    PinHandler,
+	}
+
+	unpinCmd := &cobra.Command{
+		Use:     "unpin MODEL [MODEL...]",
+		Short:   "Allow a model to be removed to free space",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    UnpinHandler,
+	}
+
+	gcCmd := &cobra.Command{
+		Use:     "gc",
+		Short:   "Remove unused blobs and stale partial downloads",
+		Args:    cobra.NoArgs,
+		PreRunE: checkServerHeartbeat,
+		RunE:    GarbageCollectHandler,
+	}
+
+	gcCmd.Flags().Bool("dry-run", false, "Show what would be removed without removing it")
+
+	verifyCmd := &cobra.Command{
+		Use:     "verify [MODEL]",
+		Short:   "Check the blobs of models against their digests",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    VerifyHandler,
+	}
+
+	verifyCmd.Flags().Bool("repair", false, "Pull models with corrupt blobs again")
 
 	listCmd := &cobra.Command{
-		Use:     "list",
+		Use:     "list [PREFIX]",
 		Aliases: []string{"ls"},
 		Short:   "List models",
+		Args:    cobra.MaximumNArgs(1),
 		PreRunE: checkServerHeartbeat,
 		RunE:    ListHandler,
 	}
+
+	listCmd.Flags().String("name", "", "Only list models matching a glob, e.g. 'llama2:*'")
+	listCmd.Flags().String("family", "", "Only list models of a family, e.g. llama")
+	listCmd.Flags().String("model-format", "", "Only list models of a format, e.g. gguf")
+	listCmd.Flags().String("parameter-size", "", "Only list models with a parameter size, e.g. 7B")
+	listCmd.Flags().String("quantization", "", "Only list models with a quantization level, e.g. Q4_0")
+	listCmd.Flags().String("modified-after", "", "Only list models modified after a date, time or age, e.g. 7d")
+	listCmd.Flags().String("modified-before", "", "Only list models modified before a date, time or age")
+	listCmd.Flags().String("sort", "", "Sort by name, size or modified, prefix with - to reverse")
+	listCmd.Flags().String("format", "", "Print as json, yaml or with a Go template, e.g. '{{.Name}}'")
 	copyCmd := &cobra.Command{
 		Use:     "cp SOURCE TARGET",
 		Short:   "Copy a model",
@@ -1018,15 +2042,71 @@ Environment Variables:
 		RunE:    DeleteHandler,
 	}
 
+	ggufCmd := &cobra.Command{
+		Use:   "gguf",
+		Short: "Inspect and edit the metadata of GGUF files",
+		Long: `Inspect and edit the metadata of GGUF files. Edits rewrite the file with
+its tensor data unchanged. Edit files before creating models from them since
+the blobs of models can't be changed.`,
+		Args: cobra.NoArgs,
+	}
+
+	ggufInspectCmd := &cobra.Command{
+		Use:   "inspect FILE",
+		Short: "Show the metadata of a GGUF file",
+		Args:  cobra.ExactArgs(1),
+		RunE:  GGUFInspectHandler,
+	}
+
+	ggufInspectCmd.Flags().Bool("tensors", false, "Show the tensors of the file")
+	ggufInspectCmd.Flags().Bool("full-arrays", false, "Show every element of long arrays")
+
+	ggufSetCmd := &cobra.Command{
+		Use:   "set FILE KEY VALUE",
+		Short: "Set a metadata key of a GGUF file",
+		Long: `Set a metadata key of a GGUF file. The value keeps the type of the key's
+current value, new keys are strings unless a type is given. Arrays are JSON
+arrays, e.g. '["a", "b"]'.`,
+		Args: cobra.ExactArgs(3),
+		RunE: GGUFSetHandler,
+	}
+
+	ggufSetCmd.Flags().String("type", "", "Type of the value, e.g. uint32, string or []int32")
+
+	ggufDeleteCmd := &cobra.Command{
+		Use:   "delete FILE KEY [KEY...]",
+		Short: "Delete metadata keys of a GGUF file",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  GGUFDeleteHandler,
+	}
+
+	ggufDiffCmd := &cobra.Command{
+		Use:   "diff FILE1 FILE2",
+		Short: "Compare the metadata and tensors of two GGUF files",
+		Args:  cobra.ExactArgs(2),
+		RunE:  GGUFDiffHandler,
+	}
+
+	ggufDiffCmd.Flags().Bool("full-arrays", false, "Show every element of long arrays")
+
+	ggufCmd.AddCommand(ggufInspectCmd, ggufSetCmd, ggufDeleteCmd, ggufDiffCmd)
+
 	for _, cmd := range []*cobra.Command{
 		createCmd,
 		showCmd,
 		runCmd,
 		pullCmd,
 		pushCmd,
+		saveCmd,
+		loadCmd,
 		listCmd,
 		copyCmd,
+		tagCmd,
 		deleteCmd,
+		pinCmd,
+		unpinCmd,
+		gcCmd,
+		verifyCmd,
 	} {
 		appendHostEnvDocs(cmd)
 	}
@@ -1038,9 +2118,17 @@ Environment Variables:
 		runCmd,
 		pullCmd,
 		pushCmd,
+		saveCmd,
+		loadCmd,
 		listCmd,
 		copyCmd,
+		tagCmd,
 		deleteCmd,
+		pinCmd,
+		unpinCmd,
+		gcCmd,
+		verifyCmd,
+		ggufCmd,
 	)
 
 	return rootCmd
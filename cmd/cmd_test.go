@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/term"
+)
+
+func TestRegistryCredentials(t *testing.T) {
+	cli := NewCLI()
+	pull, _, err := cli.Find([]string{"pull"})
+	assert.Nil(t, err)
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		t.Skip("stdin is a terminal")
+	}
+
+	// without a terminal the password can't be prompted for
+	assert.Nil(t, pull.Flags().Set("username", "alice"))
+	_, _, err = registryCredentials(pull)
+	assert.ErrorContains(t, err, "--username requires --password-stdin")
+
+	assert.Nil(t, pull.Flags().Set("username", ""))
+	assert.Nil(t, pull.Flags().Set("password-stdin", "true"))
+	_, _, err = registryCredentials(pull)
+	assert.ErrorContains(t, err, "--password-stdin requires --username")
+}
@@ -0,0 +1,408 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/jmorganca/ollama/format"
+	"github.com/jmorganca/ollama/gguf"
+	"github.com/jmorganca/ollama/llm"
+)
+
+// maxGGUFArrayLen is the length above which arrays are elided
+const maxGGUFArrayLen = 16
+
+func GGUFInspectHandler(cmd *cobra.Command, args []string) error {
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	gf, err := gguf.Decode(f, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	fullArrays, _ := cmd.Flags().GetBool("full-arrays")
+	tensors, _ := cmd.Flags().GetBool("tensors")
+
+	order := "little endian"
+	if gf.ByteOrder == binary.BigEndian {
+		order = "big endian"
+	}
+
+	fmt.Printf("GGUF version %d, %s, %d key-values, %d tensors\n\n", gf.Version, order, len(gf.KV), len(gf.Tensors))
+
+	var data [][]string
+	for _, kv := range gf.KV {
+		data = append(data, []string{kv.Key, ggufTypeName(kv.Value), formatGGUFValue(kv.Value, fullArrays)})
+	}
+
+	table := newGGUFTable(os.Stdout, "KEY", "TYPE", "VALUE")
+	table.SetAutoWrapText(false)
+	table.AppendBulk(data)
+	table.Render()
+
+	if tensors {
+		data = nil
+		for _, t := range gf.Tensors {
+			data = append(data, []string{
+				t.Name,
+				llm.Tensor{Kind: t.Type}.TypeName(),
+				formatGGUFShape(t.Shape),
+				strconv.FormatUint(t.Offset, 10),
+				format.HumanBytes(int64(t.Size())),
+			})
+		}
+
+		fmt.Println()
+		table := newGGUFTable(os.Stdout, "NAME", "TYPE", "SHAPE", "OFFSET", "SIZE")
+		table.AppendBulk(data)
+		table.Render()
+	}
+
+	return nil
+}
+
+func GGUFSetHandler(cmd *cobra.Command, args []string) error {
+	typeName, _ := cmd.Flags().GetString("type")
+
+	path, key, value := args[0], args[1], args[2]
+	return rewriteGGUF(path, func(f *gguf.File) error {
+		// new keys are strings unless a type is given
+		t, elem := gguf.TypeString, gguf.Type(0)
+
+		var err error
+		if typeName != "" {
+			t, elem, err = parseGGUFType(typeName)
+		} else if v, ok := f.Value(key); ok {
+			// keep the type of the existing value
+			t, elem, err = gguf.TypeOf(v)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		v, err := parseGGUFValue(value, t, elem)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		return f.Set(key, v)
+	})
+}
+
+func GGUFDeleteHandler(cmd *cobra.Command, args []string) error {
+	return rewriteGGUF(args[0], func(f *gguf.File) error {
+		for _, key := range args[1:] {
+			if !f.Delete(key) {
+				return fmt.Errorf("key '%s' not found", key)
+			}
+		}
+
+		return nil
+	})
+}
+
+func GGUFDiffHandler(cmd *cobra.Command, args []string) error {
+	var files []*gguf.File
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		gf, err := gguf.Decode(f, fi.Size())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		files = append(files, gf)
+	}
+
+	fullArrays, _ := cmd.Flags().GetBool("full-arrays")
+
+	fmt.Printf("--- %s\n+++ %s\n", args[0], args[1])
+	if !diffGGUF(os.Stdout, files[0], files[1], fullArrays) {
+		fmt.Println("the files have the same metadata and tensors")
+	}
+
+	return nil
+}
+
+// rewriteGGUF edits the metadata of the GGUF file at path and writes the file
+// again, copying the data of its tensors as they are. The file is replaced
+// once it's written so it isn't left half written.
+func rewriteGGUF(path string, edit func(*gguf.File) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	gf, err := gguf.Decode(f, fi.Size())
+	if err != nil {
+		return err
+	}
+
+	if err := edit(gf); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.partial")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if err := gf.Encode(temp); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(temp.Name(), fi.Mode().Perm()); err != nil {
+		return err
+	}
+
+	// the file can't be replaced while it's open on windows
+	f.Close()
+	return os.Rename(temp.Name(), path)
+}
+
+// diffGGUF writes the key-values and tensors which differ between a and b,
+// and reports whether any do. Tensors are compared by their type and shape.
+func diffGGUF(w io.Writer, a, b *gguf.File, fullArrays bool) bool {
+	var differ bool
+	line := func(prefix, s string) {
+		fmt.Fprintln(w, prefix, s)
+		differ = true
+	}
+
+	kv := func(k string, v any) string {
+		return fmt.Sprintf("%s %s = %s", k, ggufTypeName(v), formatGGUFValue(v, fullArrays))
+	}
+
+	for _, akv := range a.KV {
+		bv, ok := b.Value(akv.Key)
+		switch {
+		case !ok:
+			line("-", kv(akv.Key, akv.Value))
+		case !reflect.DeepEqual(akv.Value, bv):
+			line("-", kv(akv.Key, akv.Value))
+			line("+", kv(akv.Key, bv))
+		}
+	}
+
+	for _, bkv := range b.KV {
+		if _, ok := a.Value(bkv.Key); !ok {
+			line("+", kv(bkv.Key, bkv.Value))
+		}
+	}
+
+	tensor := func(t *gguf.Tensor) string {
+		return fmt.Sprintf("tensor %s %s %s", t.Name, llm.Tensor{Kind: t.Type}.TypeName(), formatGGUFShape(t.Shape))
+	}
+
+	for i := range a.Tensors {
+		at := &a.Tensors[i]
+		bt, ok := b.Tensor(at.Name)
+		switch {
+		case !ok:
+			line("-", tensor(at))
+		case at.Type != bt.Type || !slices.Equal(at.Shape, bt.Shape):
+			line("-", tensor(at))
+			line("+", tensor(bt))
+		}
+	}
+
+	for i := range b.Tensors {
+		if _, ok := a.Tensor(b.Tensors[i].Name); !ok {
+			line("+", tensor(&b.Tensors[i]))
+		}
+	}
+
+	return differ
+}
+
+// ggufTypes are the Go types of values of each type
+var ggufTypes = map[gguf.Type]reflect.Type{
+	gguf.TypeUint8:   reflect.TypeFor[uint8](),
+	gguf.TypeInt8:    reflect.TypeFor[int8](),
+	gguf.TypeUint16:  reflect.TypeFor[uint16](),
+	gguf.TypeInt16:   reflect.TypeFor[int16](),
+	gguf.TypeUint32:  reflect.TypeFor[uint32](),
+	gguf.TypeInt32:   reflect.TypeFor[int32](),
+	gguf.TypeFloat32: reflect.TypeFor[float32](),
+	gguf.TypeBool:    reflect.TypeFor[bool](),
+	gguf.TypeString:  reflect.TypeFor[string](),
+	gguf.TypeUint64:  reflect.TypeFor[uint64](),
+	gguf.TypeInt64:   reflect.TypeFor[int64](),
+	gguf.TypeFloat64: reflect.TypeFor[float64](),
+}
+
+// parseGGUFType parses the name of a type such as uint32, or []uint32 for
+// arrays
+func parseGGUFType(s string) (t gguf.Type, elem gguf.Type, err error) {
+	name, array := strings.CutPrefix(s, "[]")
+	for t := range ggufTypes {
+		if t.String() == name {
+			if array {
+				return gguf.TypeArray, t, nil
+			}
+
+			return t, 0, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("unknown type '%s'", s)
+}
+
+func ggufTypeName(v any) string {
+	t, elem, err := gguf.TypeOf(v)
+	switch {
+	case err != nil:
+		return "unknown"
+	case t == gguf.TypeArray:
+		return "[]" + elem.String()
+	default:
+		return t.String()
+	}
+}
+
+// parseGGUFValue parses s as a value of the type t. Arrays are JSON arrays.
+func parseGGUFValue(s string, t, elem gguf.Type) (any, error) {
+	if t == gguf.TypeArray {
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(s), &items); err != nil {
+			return nil, fmt.Errorf("arrays must be JSON arrays: %w", err)
+		}
+
+		a := reflect.MakeSlice(reflect.SliceOf(ggufTypes[elem]), len(items), len(items))
+		for i, item := range items {
+			s := string(item)
+			if elem == gguf.TypeString {
+				if err := json.Unmarshal(item, &s); err != nil {
+					return nil, err
+				}
+			}
+
+			v, err := parseGGUFValue(s, elem, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			a.Index(i).Set(reflect.ValueOf(v))
+		}
+
+		return a.Interface(), nil
+	}
+
+	goType, ok := ggufTypes[t]
+	if !ok {
+		return nil, fmt.Errorf("unknown type %s", t)
+	}
+
+	var v any
+	var err error
+	switch t {
+	case gguf.TypeString:
+		return s, nil
+	case gguf.TypeBool:
+		v, err = strconv.ParseBool(s)
+	case gguf.TypeUint8, gguf.TypeUint16, gguf.TypeUint32, gguf.TypeUint64:
+		v, err = strconv.ParseUint(s, 10, goType.Bits())
+	case gguf.TypeInt8, gguf.TypeInt16, gguf.TypeInt32, gguf.TypeInt64:
+		v, err = strconv.ParseInt(s, 10, goType.Bits())
+	case gguf.TypeFloat32, gguf.TypeFloat64:
+		v, err = strconv.ParseFloat(s, goType.Bits())
+	}
+
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		return nil, fmt.Errorf("'%s' isn't a valid %s", s, t)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return reflect.ValueOf(v).Convert(goType).Interface(), nil
+}
+
+// formatGGUFValue formats a value for display, quoting strings. Arrays
+// longer than maxGGUFArrayLen are replaced by their length unless fullArrays
+// is set.
+func formatGGUFValue(v any, fullArrays bool) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+
+	a := reflect.ValueOf(v)
+	if a.Kind() != reflect.Slice {
+		return fmt.Sprint(v)
+	}
+
+	if a.Len() > maxGGUFArrayLen && !fullArrays {
+		return fmt.Sprintf("[%d values]", a.Len())
+	}
+
+	items := make([]string, a.Len())
+	for i := range items {
+		items[i] = formatGGUFValue(a.Index(i).Interface(), fullArrays)
+	}
+
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+func formatGGUFShape(shape []uint64) string {
+	dims := make([]string, len(shape))
+	for i, n := range shape {
+		dims[i] = strconv.FormatUint(n, 10)
+	}
+
+	return "[" + strings.Join(dims, ", ") + "]"
+}
+
+func newGGUFTable(w io.Writer, header ...string) *tablewriter.Table {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("\t")
+	return table
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/gguf"
+)
+
+func TestGGUFEdit(t *testing.T) {
+	f := gguf.File{
+		KV: []gguf.KV{
+			{Key: "general.architecture", Value: "llama"},
+			{Key: "llama.context_length", Value: uint32(2048)},
+			{Key: "tokenizer.ggml.eos_token_id", Value: uint32(2)},
+		},
+		Tensors: []gguf.Tensor{
+			{Name: "output_norm.weight", Type: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader([]byte("0123456789abcdef"))},
+		},
+	}
+
+	var b bytes.Buffer
+	assert.Nil(t, f.Encode(&b))
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	assert.Nil(t, os.WriteFile(path, b.Bytes(), 0o644))
+
+	run := func(args ...string) error {
+		cli := NewCLI()
+		cli.SetArgs(append([]string{"gguf"}, args...))
+		return cli.Execute()
+	}
+
+	assert.Nil(t, run("set", path, "llama.context_length", "4096"))
+	assert.Nil(t, run("set", path, "tokenizer.chat_template", "{{ messages }}"))
+	assert.Nil(t, run("set", path, "--type", "[]int32", "general.ids", "[1, -2]"))
+	assert.Nil(t, run("delete", path, "tokenizer.ggml.eos_token_id"))
+
+	assert.ErrorContains(t, run("set", path, "--", "llama.context_length", "-1"), "'-1' isn't a valid uint32")
+	assert.ErrorContains(t, run("set", path, "--type", "int", "general.int", "1"), "unknown type 'int'")
+	assert.ErrorContains(t, run("delete", path, "general.missing"), "key 'general.missing' not found")
+
+	edited, err := os.Open(path)
+	assert.Nil(t, err)
+	defer edited.Close()
+
+	fi, err := edited.Stat()
+	assert.Nil(t, err)
+
+	g, err := gguf.Decode(edited, fi.Size())
+	assert.Nil(t, err)
+	assert.Equal(t, []gguf.KV{
+		{Key: "general.architecture", Value: "llama"},
+		{Key: "llama.context_length", Value: uint32(4096)},
+		{Key: "tokenizer.chat_template", Value: "{{ messages }}"},
+		{Key: "general.ids", Value: []int32{1, -2}},
+	}, g.KV)
+
+	// the tensor data is unchanged
+	data := make([]byte, 16)
+	_, err = g.Tensors[0].Data().Read(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789abcdef", string(data))
+
+	// the partial files were removed
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	f.Tensors[0].WriterTo = bytes.NewReader([]byte("0123456789abcdef"))
+	f.Tensors = append(f.Tensors, gguf.Tensor{Name: "token_embd.weight", Type: 2, Shape: []uint64{32, 1}, WriterTo: bytes.NewReader(make([]byte, 18))})
+	g.Tensors[0].Type = 1
+
+	b.Reset()
+	assert.True(t, diffGGUF(&b, &f, g, false))
+	assert.Equal(t, `- llama.context_length uint32 = 2048
++ llama.context_length uint32 = 4096
+- tokenizer.ggml.eos_token_id uint32 = 2
++ tokenizer.chat_template string = "{{ messages }}"
++ general.ids []int32 = [1, -2]
+- tensor output_norm.weight F32 [4]
++ tensor output_norm.weight F16 [4]
+- tensor token_embd.weight Q4_0 [32, 1]
+`, b.String())
+
+	b.Reset()
+	assert.False(t, diffGGUF(&b, g, g, false))
+	assert.Empty(t, b.String())
+}
+
+func TestFormatGGUFValue(t *testing.T) {
+	assert.Equal(t, `"a\nb"`, formatGGUFValue("a\nb", false))
+	assert.Equal(t, "1.5", formatGGUFValue(float32(1.5), false))
+	assert.Equal(t, `["a", "b"]`, formatGGUFValue([]string{"a", "b"}, false))
+	assert.Equal(t, "[17 values]", formatGGUFValue(make([]uint32, 17), false))
+	assert.Len(t, formatGGUFValue(make([]uint32, 17), true), 2+17+16*2)
+}
@@ -0,0 +1,55 @@
+package convert
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+)
+
+// zipFS is a zip archive whose uncompressed files can be read at offsets, so
+// their tensors can be read in place
+type zipFS struct {
+	*zip.Reader
+	ra io.ReaderAt
+}
+
+// NewZipFS returns the files of the zip archive in ra, such as the archive of
+// a checkpoint directory the client uploads. Tensors are read from the archive
+// directly so checkpoints needn't be extracted.
+func NewZipFS(ra io.ReaderAt, size int64) (fs.FS, error) {
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return zipFS{Reader: r, ra: ra}, nil
+}
+
+// zipFile is an uncompressed file in a zip archive
+type zipFile struct {
+	fs.File
+	io.ReaderAt
+}
+
+func (z zipFS) Open(name string) (fs.File, error) {
+	f, err := z.Reader.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, zf := range z.File {
+		if zf.Name != name || zf.Method != zip.Store {
+			continue
+		}
+
+		offset, err := zf.DataOffset()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		return zipFile{File: f, ReaderAt: io.NewSectionReader(z.ra, offset, int64(zf.UncompressedSize64))}, nil
+	}
+
+	return f, nil
+}
@@ -0,0 +1,94 @@
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// archive zips the files of dir like the client does when it uploads a
+// checkpoint directory
+func archive(t *testing.T, dir string, method uint16) *bytes.Reader {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, entry := range entries {
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+
+		zf, err := w.CreateHeader(&zip.FileHeader{Name: entry.Name(), Method: method})
+		assert.Nil(t, err)
+
+		_, err = io.Copy(zf, f)
+		assert.Nil(t, err)
+		f.Close()
+	}
+
+	assert.Nil(t, w.Close())
+	return bytes.NewReader(b.Bytes())
+}
+
+func TestZipFS(t *testing.T) {
+	params := Params{
+		Architectures:    []string{"LlamaForCausalLM"},
+		VocabSize:        4,
+		HiddenSize:       8,
+		HiddenLayers:     1,
+		ContextSize:      64,
+		IntermediateSize: 16,
+		AttentionHeads:   2,
+		NormEPS:          1e-5,
+		BoSTokenID:       1,
+		EoSTokenID:       2,
+	}
+
+	shapes := layerShapes(0, 8, 8, 16)
+	shapes["model.embed_tokens.weight"] = []int{4, 8}
+	shapes["model.norm.weight"] = []int{8}
+	shapes["lm_head.weight"] = []int{4, 8}
+
+	dir := checkpoint(t, params, "F16", shapes)
+
+	convert := func(t *testing.T, r *bytes.Reader) ([]byte, error) {
+		fsys, err := NewZipFS(r, r.Size())
+		assert.Nil(t, err)
+
+		p, err := GetParams(fsys)
+		assert.Nil(t, err)
+
+		conv, err := GetConverter(p)
+		assert.Nil(t, err)
+
+		tensors, err := GetTensors(fsys, conv, p)
+		assert.Nil(t, err)
+
+		vocab, err := LoadTokens(fsys)
+		assert.Nil(t, err)
+
+		var b bytes.Buffer
+		err = WriteGGUF(&b, "llama", conv, tensors, p, vocab, WriteOptions{})
+		return b.Bytes(), err
+	}
+
+	// the tensors are read from the archive in place
+	bts, err := convert(t, archive(t, dir, zip.Store))
+	assert.Nil(t, err)
+
+	actual, err := json.Marshal(decodeGolden(t, bts))
+	assert.Nil(t, err)
+
+	expected, err := os.ReadFile(filepath.Join("testdata", "llama.json"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+
+	_, err = convert(t, archive(t, dir, zip.Deflate))
+	assert.ErrorContains(t, err, "model-00001-of-00001.safetensors can't be read in place, it mustn't be compressed")
+}
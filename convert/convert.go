@@ -5,15 +5,19 @@ import (
 	"cmp"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"maps"
 	"regexp"
 	"slices"
+	"strings"
 
+	"github.com/d4l3k/go-bfloat16"
 	"github.com/mitchellh/mapstructure"
+	"github.com/x448/float16"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/jmorganca/ollama/convert/sentencepiece"
@@ -29,10 +33,56 @@ type Params struct {
 	IntermediateSize int      `json:"intermediate_size"`
 	AttentionHeads   int      `json:"num_attention_heads"` // n_head
 	KeyValHeads      int      `json:"num_key_value_heads"`
+	HeadDim          int      `json:"head_dim"`
 	NormEPS          float64  `json:"rms_norm_eps"`
 	RopeFreqBase     float64  `json:"rope_theta"`
 	BoSTokenID       int      `json:"bos_token_id"`
 	EoSTokenID       int      `json:"eos_token_id"`
+	PadTokenID       int      `json:"pad_token_id"`
+
+	// mixture of experts
+	Experts     int `json:"num_local_experts"`
+	ExpertsUsed int `json:"num_experts_per_tok"`
+}
+
+// Converter converts the checkpoint of a Hugging Face architecture to gguf
+type Converter interface {
+	// KV returns the architecture's key-values including general.architecture.
+	// They replace the general and tokenizer key-values set by WriteGGUF.
+	KV(params *Params) llm.KV
+
+	// TensorName returns the gguf name of the checkpoint tensor n
+	TensorName(n string) (string, error)
+
+	// Repack transforms the data of the tensor with gguf name n, which has
+	// the checkpoint's shape, before it's written
+	Repack(n string, data []float32, shape []uint64, params *Params) ([]float32, error)
+}
+
+var ErrUnsupportedArchitecture = errors.New("unsupported architecture")
+
+var converters = make(map[string]Converter)
+
+// Register makes c the converter for the Hugging Face architecture arch, as
+// named in the architectures of a model's config.json
+func Register(arch string, c Converter) {
+	if _, ok := converters[arch]; ok {
+		panic("convert: converter already registered for " + arch)
+	}
+
+	converters[arch] = c
+}
+
+// GetConverter returns the converter for the first of params' architectures
+// which has one
+func GetConverter(params *Params) (Converter, error) {
+	for _, arch := range params.Architectures {
+		if c, ok := converters[arch]; ok {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedArchitecture, strings.Join(params.Architectures, ", "))
 }
 
 type MetaData struct {
@@ -41,8 +91,8 @@ type MetaData struct {
 	Offsets []int  `mapstructure:"data_offsets"`
 }
 
-func ReadSafeTensors(fn string, offset uint64) ([]llm.Tensor, uint64, error) {
-	f, err := os.Open(fn)
+func ReadSafeTensors(fsys fs.FS, fn string, offset uint64, conv Converter, params *Params) ([]llm.Tensor, uint64, error) {
+	f, err := fsys.Open(fn)
 	if err != nil {
 		return []llm.Tensor{}, 0, err
 	}
@@ -81,63 +131,73 @@ func ReadSafeTensors(fn string, offset uint64) ([]llm.Tensor, uint64, error) {
 			return []llm.Tensor{}, 0, err
 		}
 
-		var size uint64
-		var kind uint32
-		switch len(data.Shape) {
-		case 0:
+		if len(data.Offsets) != 2 {
 			// metadata
 			continue
-		case 1:
-			// convert to float32
-			kind = 0
-			size = uint64(data.Shape[0] * 4)
-		case 2:
-			// convert to float16
-			kind = 1
-			size = uint64(data.Shape[0] * data.Shape[1] * 2)
 		}
 
-		ggufName, err := GetTensorName(k)
-		if err != nil {
-			slog.Error("%v", err)
+		t, size, err := newTensor(k, data.Shape, data.Type, fsys, fn, int64(8+jsonSize)+int64(data.Offsets[0]), int64(data.Offsets[1]-data.Offsets[0]), offset, conv, params)
+		if errors.Is(err, errNotTensor) {
+			continue
+		} else if err != nil {
 			return []llm.Tensor{}, 0, err
 		}
 
-		shape := []uint64{0, 0, 0, 0}
-		for i := range data.Shape {
-			shape[i] = uint64(data.Shape[i])
-		}
-
-		t := llm.Tensor{
-			Name:          ggufName,
-			Kind:          kind,
-			Offset:        offset,
-			Shape:         shape[:],
-			FileName:      fn,
-			OffsetPadding: 8 + jsonSize,
-			FileOffsets:   []uint64{uint64(data.Offsets[0]), uint64(data.Offsets[1])},
-		}
-		slog.Debug(fmt.Sprintf("%v", t))
 		tensors = append(tensors, t)
 		offset += size
 	}
 	return tensors, offset, nil
 }
 
-func GetSafeTensors(dirpath string) ([]llm.Tensor, error) {
-	var tensors []llm.Tensor
-	files, err := filepath.Glob(filepath.Join(dirpath, "/model-*.safetensors"))
+// GetTensors reads the tensors of the safetensors files in fsys or, if there
+// aren't any, of its PyTorch checkpoints. The tensors' data is read from fsys
+// when they're written.
+func GetTensors(fsys fs.FS, conv Converter, params *Params) ([]llm.Tensor, error) {
+	files, err := fs.Glob(fsys, "model-*.safetensors")
+	if err != nil {
+		return []llm.Tensor{}, err
+	}
+
+	if len(files) > 0 {
+		return readTensors(fsys, files, ReadSafeTensors, conv, params)
+	}
+
+	files, err = TorchFiles(fsys)
 	if err != nil {
 		return []llm.Tensor{}, err
 	}
 
+	if len(files) == 0 {
+		return []llm.Tensor{}, errors.New("no safetensors or pytorch files were found")
+	}
+
+	return readTensors(fsys, files, ReadTorch, conv, params)
+}
+
+// TorchFiles returns the PyTorch checkpoints in fsys
+func TorchFiles(fsys fs.FS) ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"pytorch_model*.bin", "*.pth"} {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+func readTensors(fsys fs.FS, files []string, read func(fs.FS, string, uint64, Converter, *Params) ([]llm.Tensor, uint64, error), conv Converter, params *Params) ([]llm.Tensor, error) {
+	var tensors []llm.Tensor
 	var offset uint64
 	for _, f := range files {
 		var t []llm.Tensor
 		var err error
-		t, offset, err = ReadSafeTensors(f, offset)
+		t, offset, err = read(fsys, f, offset, conv, params)
 		if err != nil {
-			slog.Error("%v", err)
+			slog.Error(err.Error())
 			return []llm.Tensor{}, err
 		}
 		tensors = append(tensors, t...)
@@ -145,8 +205,177 @@ func GetSafeTensors(dirpath string) ([]llm.Tensor, error) {
 	return tensors, nil
 }
 
-func GetParams(dirpath string) (*Params, error) {
-	f, err := os.Open(filepath.Join(dirpath, "config.json"))
+var errNotTensor = errors.New("not a tensor")
+
+// newTensor returns the gguf tensor for the checkpoint tensor called name,
+// whose data is size bytes at offset in the file fn of fsys, and how many
+// bytes it takes up in the gguf file. The gguf tensor starts at ggufOffset.
+func newTensor(name string, shape []int, dtype string, fsys fs.FS, fn string, offset, size int64, ggufOffset uint64, conv Converter, params *Params) (llm.Tensor, uint64, error) {
+	var ggufSize uint64
+	var kind uint32
+	switch len(shape) {
+	case 0:
+		return llm.Tensor{}, 0, errNotTensor
+	case 1:
+		// convert to float32
+		kind = 0
+		ggufSize = uint64(shape[0] * 4)
+	case 2:
+		// convert to float16
+		kind = 1
+		ggufSize = uint64(shape[0] * shape[1] * 2)
+	default:
+		return llm.Tensor{}, 0, fmt.Errorf("tensor '%s' has %d dimensions", name, len(shape))
+	}
+
+	ggufName, err := conv.TensorName(name)
+	if err != nil {
+		slog.Error(err.Error())
+		return llm.Tensor{}, 0, err
+	}
+
+	padded := []uint64{0, 0, 0, 0}
+	for i := range shape {
+		padded[i] = uint64(shape[i])
+	}
+
+	t := llm.Tensor{
+		Name:   ggufName,
+		Kind:   kind,
+		Offset: ggufOffset,
+		Shape:  padded,
+	}
+
+	t.WriterTo = tensorData{
+		fsys:   fsys,
+		fn:     fn,
+		offset: offset,
+		size:   size,
+		dtype:  dtype,
+		tensor: t,
+		conv:   conv,
+		params: params,
+	}
+
+	slog.Debug(fmt.Sprintf("%v", t))
+
+	// tensor data is padded to 32 bytes
+	return t, (ggufSize + 31) &^ 31, nil
+}
+
+// tensorData writes the data of a tensor in a checkpoint as the tensor's
+// kind, repacking it with the model's converter
+type tensorData struct {
+	fsys   fs.FS
+	fn     string
+	offset int64
+	size   int64
+	dtype  string
+
+	tensor llm.Tensor
+	conv   Converter
+	params *Params
+}
+
+func (st tensorData) WriteTo(w io.Writer) (int64, error) {
+	f, err := st.fsys.Open(st.fn)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// tensors are read in place so their files mustn't be compressed
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		return 0, fmt.Errorf("%s can't be read in place, it mustn't be compressed", st.fn)
+	}
+
+	buf := make([]byte, st.size)
+	if _, err := ra.ReadAt(buf, st.offset); err != nil {
+		return 0, err
+	}
+
+	var data []float32
+	switch st.dtype {
+	case "BF16":
+		data = bfloat16.DecodeFloat32(buf)
+	case "F16":
+		data = make([]float32, len(buf)/2)
+		for i := range data {
+			data[i] = float16.Frombits(binary.LittleEndian.Uint16(buf[i*2:])).Float32()
+		}
+	case "F32":
+		data = make([]float32, len(buf)/4)
+		if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, data); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("tensor '%s' has unsupported type %s", st.tensor.Name, st.dtype)
+	}
+
+	data, err = st.conv.Repack(st.tensor.Name, data, st.tensor.Shape, st.params)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := llm.Quantize(st.tensor.Kind, data)
+	if err != nil {
+		return 0, fmt.Errorf("tensor '%s': %w", st.tensor.Name, err)
+	}
+
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// quantize returns the tensors with the kinds of fileType and the offsets of
+// their data at those kinds, and the size of the data
+func quantize(tensors []llm.Tensor, fileType uint32, params *Params) ([]llm.Tensor, uint64, error) {
+	tensors = slices.Clone(tensors)
+
+	var offset uint64
+	for i := range tensors {
+		t := &tensors[i]
+		st, ok := t.WriterTo.(tensorData)
+		if !ok {
+			return nil, 0, fmt.Errorf("tensor '%s' can't be quantized", t.Name)
+		}
+
+		// shapes are rows then columns and are padded with zeros
+		dims, rowSize, size := 1, t.Shape[0], t.Shape[0]
+		if t.Shape[1] > 0 {
+			dims, rowSize, size = 2, t.Shape[1], t.Shape[0]*t.Shape[1]
+		}
+
+		t.Kind = llm.QuantizeKind(t.Name, dims, rowSize, fileType, params.HiddenLayers)
+		t.Offset = offset
+
+		st.tensor = *t
+		t.WriterTo = st
+
+		// tensor data is padded to 32 bytes
+		offset += (size/t.BlockSize()*t.TypeSize() + 31) &^ 31
+	}
+
+	return tensors, offset, nil
+}
+
+// progressWriter calls fn after its tensor is written
+type progressWriter struct {
+	io.WriterTo
+	fn func()
+}
+
+func (pw progressWriter) WriteTo(w io.Writer) (int64, error) {
+	n, err := pw.WriterTo.WriteTo(w)
+	if err == nil {
+		pw.fn()
+	}
+
+	return n, err
+}
+
+func GetParams(fsys fs.FS) (*Params, error) {
+	f, err := fsys.Open("config.json")
 	if err != nil {
 		return nil, err
 	}
@@ -166,14 +395,55 @@ func GetParams(dirpath string) (*Params, error) {
 // Details on gguf's tokenizer can be found at:
 // https://github.com/ggerganov/ggml/blob/master/docs/gguf.md#tokenizer
 type Vocab struct {
+	// Model is the tokenizer, llama for sentencepiece or gpt2 for byte level bpe
+	Model string
+
 	Tokens []string
 	Scores []float32
 	Types  []int32
+	Merges []string
+
+	// SpecialIDs are the ids of special tokens keyed by their gguf name, such
+	// as bos for tokenizer.ggml.bos_token_id. They replace the ids in the params.
+	SpecialIDs map[string]int
+
+	// AddBOS and AddEOS are set if the tokenizer's config sets them
+	AddBOS, AddEOS *bool
+
+	// ChatTemplate is the Jinja chat template of the tokenizer's config, if any
+	ChatTemplate string
 }
 
-func LoadTokens(dirpath string) (*Vocab, error) {
-	slog.Info(fmt.Sprintf("reading vocab from %s", filepath.Join(dirpath, "tokenizer.model")))
-	in, err := os.ReadFile(filepath.Join(dirpath, "tokenizer.model"))
+// LoadTokens reads the vocab from a sentencepiece tokenizer.model if there is
+// one and otherwise from a Hugging Face tokenizer.json
+func LoadTokens(fsys fs.FS) (*Vocab, error) {
+	var v *Vocab
+	if _, err := fs.Stat(fsys, "tokenizer.model"); errors.Is(err, fs.ErrNotExist) {
+		v, err = loadTokenizerJSON(fsys)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		v, err = loadSentencePiece(fsys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := loadTokenizerConfig(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	v.ChatTemplate = string(config.ChatTemplate)
+	return v, nil
+}
+
+func loadSentencePiece(fsys fs.FS) (*Vocab, error) {
+	slog.Info("reading vocab from tokenizer.model")
+	in, err := fs.ReadFile(fsys, "tokenizer.model")
 	if err != nil {
 		return nil, err
 	}
@@ -186,6 +456,7 @@ func LoadTokens(dirpath string) (*Vocab, error) {
 	}
 
 	v := &Vocab{
+		Model:  "llama",
 		Tokens: make([]string, 0),
 		Scores: make([]float32, 0),
 		Types:  make([]int32, 0),
@@ -202,8 +473,8 @@ func LoadTokens(dirpath string) (*Vocab, error) {
 	slog.Info(fmt.Sprintf("vocab size: %d", len(v.Tokens)))
 
 	// add any additional tokens
-	addIn, err := os.ReadFile(filepath.Join(dirpath, "added_tokens.json"))
-	if os.IsNotExist(err) {
+	addIn, err := fs.ReadFile(fsys, "added_tokens.json")
+	if errors.Is(err, fs.ErrNotExist) {
 		return v, nil
 	} else if err != nil {
 		return nil, err
@@ -246,86 +517,106 @@ func LoadTokens(dirpath string) (*Vocab, error) {
 	return v, nil
 }
 
-func GetTensorName(n string) (string, error) {
-	tMap := map[string]string{
-		"model.embed_tokens.weight":                           "token_embd.weight",
-		"model.layers.(\\d+).input_layernorm.weight":          "blk.$1.attn_norm.weight",
-		"model.layers.(\\d+).mlp.down_proj.weight":            "blk.$1.ffn_down.weight",
-		"model.layers.(\\d+).mlp.gate_proj.weight":            "blk.$1.ffn_gate.weight",
-		"model.layers.(\\d+).mlp.up_proj.weight":              "blk.$1.ffn_up.weight",
-		"model.layers.(\\d+).post_attention_layernorm.weight": "blk.$1.ffn_norm.weight",
-		"model.layers.(\\d+).self_attn.k_proj.weight":         "blk.$1.attn_k.weight",
-		"model.layers.(\\d+).self_attn.o_proj.weight":         "blk.$1.attn_output.weight",
-		"model.layers.(\\d+).self_attn.q_proj.weight":         "blk.$1.attn_q.weight",
-		"model.layers.(\\d+).self_attn.v_proj.weight":         "blk.$1.attn_v.weight",
-		"lm_head.weight":    "output.weight",
-		"model.norm.weight": "output_norm.weight",
-	}
-
-	v, ok := tMap[n]
-	if ok {
-		return v, nil
+// tensorNames renames checkpoint tensors to gguf names
+type tensorNames []tensorName
+
+type tensorName struct {
+	re   *regexp.Regexp
+	name string
+}
+
+// newTensorNames compiles patterns, which match the whole name of a
+// checkpoint tensor, keyed to gguf names which may refer to their groups
+func newTensorNames(patterns map[string]string) tensorNames {
+	names := make(tensorNames, 0, len(patterns))
+	for pattern, name := range patterns {
+		names = append(names, tensorName{regexp.MustCompile("^" + pattern + "$"), name})
 	}
 
-	// quick hack to rename the layers to gguf format
-	for k, v := range tMap {
-		re := regexp.MustCompile(k)
-		newName := re.ReplaceAllString(n, v)
-		if newName != n {
-			return newName, nil
+	return names
+}
+
+func (names tensorNames) rename(n string) (string, error) {
+	for _, name := range names {
+		if name.re.MatchString(n) {
+			return name.re.ReplaceAllString(n, name.name), nil
 		}
 	}
 
 	return "", fmt.Errorf("couldn't find a layer name for '%s'", n)
 }
 
-func WriteGGUF(name string, tensors []llm.Tensor, params *Params, vocab *Vocab) (string, error) {
+// WriteOptions are the options for writing a converted model
+type WriteOptions struct {
+	// FileType is the file type of the model such as Q4_K_M, F16 by default
+	FileType string
+
+	// Progress is called after each tensor is written with the bytes of
+	// tensor data written so far and in total
+	Progress func(t llm.Tensor, completed, total uint64)
+}
+
+// WriteGGUF writes the converted model to w as it's converted, so a model
+// never has to be converted to a temporary file
+func WriteGGUF(w io.Writer, name string, conv Converter, tensors []llm.Tensor, params *Params, vocab *Vocab, opts WriteOptions) error {
+	fileType, err := llm.ParseFileType(cmp.Or(opts.FileType, "F16"))
+	if err != nil {
+		return err
+	}
+
+	tensors, size, err := quantize(tensors, fileType, params)
+	if err != nil {
+		return err
+	}
+
+	if opts.Progress != nil {
+		for i, t := range tensors {
+			completed := size
+			if i+1 < len(tensors) {
+				completed = tensors[i+1].Offset
+			}
+
+			tensors[i].WriterTo = progressWriter{t.WriterTo, func() { opts.Progress(t, completed, size) }}
+		}
+	}
+
 	c := llm.ContainerGGUF{
 		ByteOrder: binary.LittleEndian,
 	}
 
 	m := llm.NewGGUFModel(&c)
 	m.Tensors = tensors
-	m.KV["general.architecture"] = "llama"
 	m.KV["general.name"] = name
-	m.KV["llama.context_length"] = uint32(params.ContextSize)
-	m.KV["llama.embedding_length"] = uint32(params.HiddenSize)
-	m.KV["llama.block_count"] = uint32(params.HiddenLayers)
-	m.KV["llama.feed_forward_length"] = uint32(params.IntermediateSize)
-	m.KV["llama.rope.dimension_count"] = uint32(128)
-	m.KV["llama.attention.head_count"] = uint32(params.AttentionHeads)
-	m.KV["llama.attention.head_count_kv"] = uint32(params.KeyValHeads)
-	m.KV["llama.attention.layer_norm_rms_epsilon"] = float32(params.NormEPS)
-	m.KV["llama.rope.freq_base"] = float32(params.RopeFreqBase)
-	m.KV["general.file_type"] = uint32(1)
-	m.KV["tokenizer.ggml.model"] = "llama"
+	m.KV["general.file_type"] = fileType
+	m.KV["tokenizer.ggml.model"] = cmp.Or(vocab.Model, "llama")
 
 	m.KV["tokenizer.ggml.tokens"] = vocab.Tokens
-	m.KV["tokenizer.ggml.scores"] = vocab.Scores
+	if len(vocab.Scores) > 0 {
+		m.KV["tokenizer.ggml.scores"] = vocab.Scores
+	}
 	m.KV["tokenizer.ggml.token_type"] = vocab.Types
+	if len(vocab.Merges) > 0 {
+		m.KV["tokenizer.ggml.merges"] = vocab.Merges
+	}
 
 	m.KV["tokenizer.ggml.bos_token_id"] = uint32(params.BoSTokenID)
 	m.KV["tokenizer.ggml.eos_token_id"] = uint32(params.EoSTokenID)
-	m.KV["tokenizer.ggml.unknown_token_id"] = uint32(0)
-	m.KV["tokenizer.ggml.add_bos_token"] = true
-	m.KV["tokenizer.ggml.add_eos_token"] = false
-
-	// llamacpp sets the chat template, however we don't need to set it since we pass it in through a layer
-	// m.KV["tokenizer.chat_template"] = "{{ bos_token }}{% for message in messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}{% if message['role'] == 'user' %}{{ '[INST] ' + message['content'] + ' [/INST]' }}{% elif message['role'] == 'assistant' %}{{ message['content'] + eos_token}}{% else %}{{ raise_exception('Only user and assistant roles are supported!') }}{% endif %}{% endfor %}" // XXX removeme
-
-	c.V3.NumTensor = uint64(len(tensors))
-	c.V3.NumKV = uint64(len(m.KV))
-
-	f, err := os.CreateTemp("", "ollama-gguf")
-	if err != nil {
-		return "", err
+	if m.KV["tokenizer.ggml.model"] == "llama" {
+		// sentencepiece vocabs start with <unk>, which byte level bpe
+		// vocabs don't have unless the tokenizer's config names one
+		m.KV["tokenizer.ggml.unknown_token_id"] = uint32(0)
+	}
+	for name, id := range vocab.SpecialIDs {
+		m.KV[fmt.Sprintf("tokenizer.ggml.%s_token_id", name)] = uint32(id)
 	}
-	defer f.Close()
 
-	err = m.Encode(f)
-	if err != nil {
-		return "", err
+	m.KV["tokenizer.ggml.add_bos_token"] = vocab.AddBOS == nil || *vocab.AddBOS
+	m.KV["tokenizer.ggml.add_eos_token"] = vocab.AddEOS != nil && *vocab.AddEOS
+	if vocab.ChatTemplate != "" {
+		m.KV["tokenizer.chat_template"] = vocab.ChatTemplate
 	}
 
-	return f.Name(), nil
+	maps.Copy(m.KV, conv.KV(params))
+
+	return m.Encode(w)
 }
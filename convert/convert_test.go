@@ -0,0 +1,388 @@
+package convert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/go-bfloat16"
+	"github.com/stretchr/testify/assert"
+	"github.com/x448/float16"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/jmorganca/ollama/convert/sentencepiece"
+	"github.com/jmorganca/ollama/llm"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkpoint writes a tiny Hugging Face checkpoint with params and tensors of
+// the given shapes to a temporary directory
+func checkpoint(t *testing.T, params Params, dtype string, shapes map[string][]int) string {
+	dir := t.TempDir()
+
+	bts, err := json.Marshal(params)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "config.json"), bts, 0o644))
+
+	pieces := []*sentencepiece.ModelProto_SentencePiece{
+		{Piece: proto.String("<unk>"), Score: proto.Float32(0), Type: sentencepiece.ModelProto_SentencePiece_UNKNOWN.Enum()},
+		{Piece: proto.String("<s>"), Score: proto.Float32(0), Type: sentencepiece.ModelProto_SentencePiece_CONTROL.Enum()},
+		{Piece: proto.String("</s>"), Score: proto.Float32(0), Type: sentencepiece.ModelProto_SentencePiece_CONTROL.Enum()},
+		{Piece: proto.String("▁a"), Score: proto.Float32(-1), Type: sentencepiece.ModelProto_SentencePiece_NORMAL.Enum()},
+	}
+
+	bts, err = proto.Marshal(&sentencepiece.ModelProto{Pieces: pieces})
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "tokenizer.model"), bts, 0o644))
+
+	header := map[string]any{"__metadata__": map[string]string{"format": "pt"}}
+	var data bytes.Buffer
+	for name, shape := range shapes {
+		n := 1
+		for _, d := range shape {
+			n *= d
+		}
+
+		values := make([]float32, n)
+		for i := range values {
+			values[i] = float32(i%17-8) / 8
+		}
+
+		start := data.Len()
+		switch dtype {
+		case "BF16":
+			data.Write(bfloat16.EncodeFloat32(values))
+		case "F16":
+			for _, v := range values {
+				binary.Write(&data, binary.LittleEndian, float16.Fromfloat32(v).Bits())
+			}
+		case "F32":
+			binary.Write(&data, binary.LittleEndian, values)
+		}
+
+		header[name] = map[string]any{"dtype": dtype, "shape": shape, "data_offsets": []int{start, data.Len()}}
+	}
+
+	bts, err = json.Marshal(header)
+	assert.Nil(t, err)
+
+	var f bytes.Buffer
+	binary.Write(&f, binary.LittleEndian, uint64(len(bts)))
+	f.Write(bts)
+	f.Write(data.Bytes())
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "model-00001-of-00001.safetensors"), f.Bytes(), 0o644))
+
+	return dir
+}
+
+// layerShapes returns the shapes of the tensors of a layer with the usual
+// llama names
+func layerShapes(layer int, embd, kvEmbd, ffn int) map[string][]int {
+	prefix := fmt.Sprintf("model.layers.%d.", layer)
+	shapes := map[string][]int{
+		prefix + "input_layernorm.weight":          {embd},
+		prefix + "post_attention_layernorm.weight": {embd},
+		prefix + "self_attn.q_proj.weight":         {embd, embd},
+		prefix + "self_attn.k_proj.weight":         {kvEmbd, embd},
+		prefix + "self_attn.v_proj.weight":         {kvEmbd, embd},
+		prefix + "self_attn.o_proj.weight":         {embd, embd},
+	}
+
+	if ffn > 0 {
+		shapes[prefix+"mlp.gate_proj.weight"] = []int{ffn, embd}
+		shapes[prefix+"mlp.up_proj.weight"] = []int{ffn, embd}
+		shapes[prefix+"mlp.down_proj.weight"] = []int{embd, ffn}
+	}
+
+	return shapes
+}
+
+type golden struct {
+	KV      llm.KV         `json:"kv"`
+	Tensors []goldenTensor `json:"tensors"`
+}
+
+type goldenTensor struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Shape  []uint64 `json:"shape"`
+	Offset uint64   `json:"offset"`
+	SHA256 string   `json:"sha256"`
+}
+
+func TestConvert(t *testing.T) {
+	base := Params{
+		VocabSize:        4,
+		HiddenSize:       8,
+		HiddenLayers:     1,
+		ContextSize:      64,
+		IntermediateSize: 16,
+		AttentionHeads:   2,
+		NormEPS:          1e-5,
+		BoSTokenID:       1,
+		EoSTokenID:       2,
+	}
+
+	cases := []struct {
+		name   string
+		dtype  string
+		params func(Params) Params
+		shapes func() map[string][]int
+	}{
+		{
+			name:  "llama",
+			dtype: "F16",
+			params: func(p Params) Params {
+				p.Architectures = []string{"LlamaForCausalLM"}
+				return p
+			},
+			shapes: func() map[string][]int {
+				shapes := layerShapes(0, 8, 8, 16)
+				shapes["model.embed_tokens.weight"] = []int{4, 8}
+				shapes["model.norm.weight"] = []int{8}
+				shapes["lm_head.weight"] = []int{4, 8}
+				return shapes
+			},
+		},
+		{
+			name:  "mistral",
+			dtype: "BF16",
+			params: func(p Params) Params {
+				p.Architectures = []string{"MistralForCausalLM"}
+				p.KeyValHeads = 1
+				p.RopeFreqBase = 1e6
+				return p
+			},
+			shapes: func() map[string][]int {
+				shapes := layerShapes(0, 8, 4, 16)
+				shapes["model.embed_tokens.weight"] = []int{4, 8}
+				shapes["model.norm.weight"] = []int{8}
+				shapes["lm_head.weight"] = []int{4, 8}
+				return shapes
+			},
+		},
+		{
+			name:  "mixtral",
+			dtype: "BF16",
+			params: func(p Params) Params {
+				p.Architectures = []string{"MixtralForCausalLM"}
+				p.KeyValHeads = 1
+				p.Experts = 2
+				p.ExpertsUsed = 1
+				return p
+			},
+			shapes: func() map[string][]int {
+				shapes := layerShapes(0, 8, 4, 0)
+				shapes["model.layers.0.block_sparse_moe.gate.weight"] = []int{2, 8}
+				for e := range 2 {
+					prefix := fmt.Sprintf("model.layers.0.block_sparse_moe.experts.%d.", e)
+					shapes[prefix+"w1.weight"] = []int{16, 8}
+					shapes[prefix+"w2.weight"] = []int{8, 16}
+					shapes[prefix+"w3.weight"] = []int{16, 8}
+				}
+				shapes["model.embed_tokens.weight"] = []int{4, 8}
+				shapes["model.norm.weight"] = []int{8}
+				shapes["lm_head.weight"] = []int{4, 8}
+				return shapes
+			},
+		},
+		{
+			name:  "gemma",
+			dtype: "F32",
+			params: func(p Params) Params {
+				p.Architectures = []string{"GemmaForCausalLM"}
+				p.KeyValHeads = 1
+				p.HeadDim = 8
+				return p
+			},
+			shapes: func() map[string][]int {
+				// the attention is wider than the embeddings since head_dim * heads > hidden_size
+				shapes := layerShapes(0, 8, 8, 16)
+				shapes["model.layers.0.self_attn.q_proj.weight"] = []int{16, 8}
+				shapes["model.layers.0.self_attn.o_proj.weight"] = []int{8, 16}
+				shapes["model.embed_tokens.weight"] = []int{4, 8}
+				shapes["model.norm.weight"] = []int{8}
+				return shapes
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := checkpoint(t, tt.params(base), tt.dtype, tt.shapes())
+
+			params, err := GetParams(os.DirFS(dir))
+			assert.Nil(t, err)
+
+			conv, err := GetConverter(params)
+			assert.Nil(t, err)
+
+			tensors, err := GetTensors(os.DirFS(dir), conv, params)
+			assert.Nil(t, err)
+
+			vocab, err := LoadTokens(os.DirFS(dir))
+			assert.Nil(t, err)
+
+			var b bytes.Buffer
+			assert.Nil(t, WriteGGUF(&b, tt.name, conv, tensors, params, vocab, WriteOptions{}))
+
+			actual := decodeGolden(t, b.Bytes())
+
+			bts, err := json.MarshalIndent(actual, "", "  ")
+			assert.Nil(t, err)
+
+			path := filepath.Join("testdata", tt.name+".json")
+			if *update {
+				assert.Nil(t, os.WriteFile(path, append(bts, '\n'), 0o644))
+			}
+
+			expected, err := os.ReadFile(path)
+			assert.Nil(t, err)
+			assert.JSONEq(t, string(expected), string(bts))
+		})
+	}
+}
+
+func decodeGolden(t *testing.T, bts []byte) golden {
+	ggml, err := llm.DecodeGGML(bytes.NewReader(bts))
+	assert.Nil(t, err)
+
+	// tensor data is at the end of the file and each tensor is padded to 32 bytes
+	tensors := ggml.Tensors()
+	last := tensors[len(tensors)-1]
+	start := uint64(len(bts)) - (last.Offset+last.Size()+31)&^31
+
+	var g golden
+	g.KV = ggml.KV()
+	for _, tensor := range tensors {
+		data := bts[start+tensor.Offset : start+tensor.Offset+tensor.Size()]
+		g.Tensors = append(g.Tensors, goldenTensor{
+			Name:   tensor.Name,
+			Type:   tensor.TypeName(),
+			Shape:  tensor.Shape,
+			Offset: tensor.Offset,
+			SHA256: fmt.Sprintf("%x", sha256.Sum256(data)),
+		})
+	}
+
+	return g
+}
+
+func TestPermuteQK(t *testing.T) {
+	// two heads of four rows, each row is its index
+	data := make([]float32, 8*2)
+	for i := range data {
+		data[i] = float32(i / 2)
+	}
+
+	permuted, err := permuteQK("blk.0.attn_q.weight", data, []uint64{8, 2, 0, 0}, &Params{AttentionHeads: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []float32{0, 0, 2, 2, 1, 1, 3, 3, 4, 4, 6, 6, 5, 5, 7, 7}, permuted)
+
+	// other tensors aren't permuted
+	permuted, err = permuteQK("blk.0.attn_v.weight", data, []uint64{8, 2, 0, 0}, &Params{AttentionHeads: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, data, permuted)
+}
+
+func TestGetConverter(t *testing.T) {
+	_, err := GetConverter(&Params{Architectures: []string{"GPT2LMHeadModel"}})
+	assert.ErrorIs(t, err, ErrUnsupportedArchitecture)
+
+	conv, err := GetConverter(&Params{Architectures: []string{"GPT2LMHeadModel", "MistralForCausalLM"}})
+	assert.Nil(t, err)
+	assert.Equal(t, llama{}, conv)
+
+	// the rope dimensions are the size of a head, which may be set
+	params := &Params{HiddenSize: 4096, AttentionHeads: 32}
+	assert.Equal(t, uint32(128), conv.KV(params)["llama.rope.dimension_count"])
+
+	params.HeadDim = 64
+	assert.Equal(t, uint32(64), conv.KV(params)["llama.rope.dimension_count"])
+
+	name, err := conv.TensorName("model.layers.12.self_attn.q_proj.weight")
+	assert.Nil(t, err)
+	assert.Equal(t, "blk.12.attn_q.weight", name)
+}
+
+func TestConvertQuantize(t *testing.T) {
+	params := Params{
+		Architectures:    []string{"LlamaForCausalLM"},
+		VocabSize:        4,
+		HiddenSize:       256,
+		HiddenLayers:     1,
+		ContextSize:      64,
+		IntermediateSize: 512,
+		AttentionHeads:   2,
+		NormEPS:          1e-5,
+		BoSTokenID:       1,
+		EoSTokenID:       2,
+	}
+
+	shapes := layerShapes(0, 256, 256, 512)
+	shapes["model.embed_tokens.weight"] = []int{4, 256}
+	shapes["model.norm.weight"] = []int{256}
+	shapes["lm_head.weight"] = []int{4, 256}
+
+	dir := checkpoint(t, params, "F16", shapes)
+
+	p, err := GetParams(os.DirFS(dir))
+	assert.Nil(t, err)
+
+	conv, err := GetConverter(p)
+	assert.Nil(t, err)
+
+	tensors, err := GetTensors(os.DirFS(dir), conv, p)
+	assert.Nil(t, err)
+
+	vocab, err := LoadTokens(os.DirFS(dir))
+	assert.Nil(t, err)
+
+	var names []string
+	var completed, total uint64
+	var b bytes.Buffer
+	assert.Nil(t, WriteGGUF(&b, "llama", conv, tensors, p, vocab, WriteOptions{
+		FileType: "q4_k_m",
+		Progress: func(t llm.Tensor, c, n uint64) {
+			names = append(names, t.Name)
+			completed, total = c, n
+		},
+	}))
+
+	assert.Len(t, names, len(tensors))
+	assert.Equal(t, total, completed)
+
+	actual := decodeGolden(t, b.Bytes())
+	assert.Equal(t, uint32(15), actual.KV["general.file_type"])
+
+	kinds := make(map[string]string)
+	for _, tensor := range actual.Tensors {
+		kinds[tensor.Name] = tensor.Type
+	}
+
+	assert.Equal(t, map[string]string{
+		"token_embd.weight":        "Q4_K",
+		"blk.0.attn_norm.weight":   "F32",
+		"blk.0.ffn_down.weight":    "Q6_K",
+		"blk.0.ffn_gate.weight":    "Q4_K",
+		"blk.0.ffn_up.weight":      "Q4_K",
+		"blk.0.ffn_norm.weight":    "F32",
+		"blk.0.attn_k.weight":      "Q4_K",
+		"blk.0.attn_output.weight": "Q4_K",
+		"blk.0.attn_q.weight":      "Q4_K",
+		"blk.0.attn_v.weight":      "Q6_K",
+		"output_norm.weight":       "F32",
+		"output.weight":            "Q6_K",
+	}, kinds)
+
+	err = WriteGGUF(io.Discard, "llama", conv, tensors, p, vocab, WriteOptions{FileType: "Q3_K_S"})
+	assert.ErrorContains(t, err, "unsupported quantization type")
+}
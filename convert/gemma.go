@@ -0,0 +1,64 @@
+package convert
+
+import (
+	"cmp"
+	"strings"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+func init() {
+	Register("GemmaForCausalLM", gemma{})
+}
+
+// gemma ties the output to the token embeddings so it has no lm_head
+var gemmaTensorNames = newTensorNames(map[string]string{
+	`model\.embed_tokens\.weight`:                            "token_embd.weight",
+	`model\.layers\.(\d+)\.input_layernorm\.weight`:          "blk.$1.attn_norm.weight",
+	`model\.layers\.(\d+)\.mlp\.down_proj\.weight`:           "blk.$1.ffn_down.weight",
+	`model\.layers\.(\d+)\.mlp\.gate_proj\.weight`:           "blk.$1.ffn_gate.weight",
+	`model\.layers\.(\d+)\.mlp\.up_proj\.weight`:             "blk.$1.ffn_up.weight",
+	`model\.layers\.(\d+)\.post_attention_layernorm\.weight`: "blk.$1.ffn_norm.weight",
+	`model\.layers\.(\d+)\.self_attn\.k_proj\.weight`:        "blk.$1.attn_k.weight",
+	`model\.layers\.(\d+)\.self_attn\.o_proj\.weight`:        "blk.$1.attn_output.weight",
+	`model\.layers\.(\d+)\.self_attn\.q_proj\.weight`:        "blk.$1.attn_q.weight",
+	`model\.layers\.(\d+)\.self_attn\.v_proj\.weight`:        "blk.$1.attn_v.weight",
+	`model\.norm\.weight`:                                    "output_norm.weight",
+})
+
+type gemma struct{}
+
+func (gemma) KV(params *Params) llm.KV {
+	headDim := uint32(cmp.Or(params.HeadDim, params.HiddenSize/params.AttentionHeads))
+	return llm.KV{
+		"general.architecture":                   "gemma",
+		"gemma.context_length":                   uint32(params.ContextSize),
+		"gemma.embedding_length":                 uint32(params.HiddenSize),
+		"gemma.block_count":                      uint32(params.HiddenLayers),
+		"gemma.feed_forward_length":              uint32(params.IntermediateSize),
+		"gemma.attention.head_count":             uint32(params.AttentionHeads),
+		"gemma.attention.head_count_kv":          uint32(cmp.Or(params.KeyValHeads, params.AttentionHeads)),
+		"gemma.attention.key_length":             headDim,
+		"gemma.attention.value_length":           headDim,
+		"gemma.attention.layer_norm_rms_epsilon": float32(params.NormEPS),
+
+		"tokenizer.ggml.unknown_token_id": uint32(3),
+		"tokenizer.ggml.padding_token_id": uint32(params.PadTokenID),
+	}
+}
+
+func (gemma) TensorName(n string) (string, error) {
+	return gemmaTensorNames.rename(n)
+}
+
+// Repack adds one to the norm weights since gemma scales by 1 + weight where
+// llama.cpp scales by weight
+func (gemma) Repack(n string, data []float32, shape []uint64, params *Params) ([]float32, error) {
+	if strings.HasSuffix(n, "norm.weight") {
+		for i := range data {
+			data[i]++
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,1787 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// jinjaTemplate is a parsed Jinja template. It supports the subset of Jinja
+// which Hugging Face chat templates use: output, if, for and set tags,
+// expressions with filters and tests, and the whitespace control of
+// transformers, which sets trim_blocks and lstrip_blocks.
+type jinjaTemplate struct {
+	nodes []jinjaNode
+}
+
+type jinjaNode interface {
+	render(*strings.Builder, *jinjaScope) error
+}
+
+type jinjaText string
+
+type jinjaOutput struct {
+	expr jinjaExpr
+}
+
+type jinjaIf struct {
+	conds  []jinjaExpr
+	bodies [][]jinjaNode
+	orElse []jinjaNode
+}
+
+type jinjaFor struct {
+	targets []string
+	iter    jinjaExpr
+	filter  jinjaExpr
+	body    []jinjaNode
+	orElse  []jinjaNode
+}
+
+type jinjaSet struct {
+	name string
+	expr jinjaExpr
+}
+
+// jinjaUndefined is the value of variables and keys which aren't set
+type jinjaUndefined struct{}
+
+// jinjaScope holds the variables of a for loop's iteration, which can't be
+// seen or changed outside the loop
+type jinjaScope struct {
+	vars   map[string]any
+	parent *jinjaScope
+
+	// iterations counts the iterations of every loop in the render
+	iterations *int
+}
+
+func (s *jinjaScope) child() *jinjaScope {
+	return &jinjaScope{vars: make(map[string]any), parent: s, iterations: s.iterations}
+}
+
+// Templates come from model files so what they may render is limited, a
+// template which renders more than this can't be translated
+const (
+	// maxJinjaSize is the size of the output and of any value
+	maxJinjaSize = 1 << 20
+
+	// maxJinjaRange is the length of a range
+	maxJinjaRange = 1 << 16
+
+	// maxJinjaIterations is the iterations of all loops together
+	maxJinjaIterations = 1 << 16
+)
+
+var errJinjaLimit = errors.New("template renders too much")
+
+// step counts an iteration of a loop
+func (s *jinjaScope) step() error {
+	*s.iterations++
+	if *s.iterations > maxJinjaIterations {
+		return fmt.Errorf("%w: more than %d loop iterations", errJinjaLimit, maxJinjaIterations)
+	}
+
+	return nil
+}
+
+// jinjaCheckSize returns v unless it would render to more than maxJinjaSize
+func jinjaCheckSize(v any) (any, error) {
+	size := 0
+	var walk func(v any) bool
+	walk = func(v any) bool {
+		switch v := v.(type) {
+		case string:
+			size += len(v)
+		case []any:
+			for _, item := range v {
+				// the quotes and separator of the item
+				size += 4
+				if size > maxJinjaSize || !walk(item) {
+					return false
+				}
+			}
+		case map[string]any:
+			for k, item := range v {
+				size += len(k) + 4
+				if size > maxJinjaSize || !walk(item) {
+					return false
+				}
+			}
+		}
+
+		return size <= maxJinjaSize
+	}
+
+	if !walk(v) {
+		return nil, fmt.Errorf("%w: a value is larger than %d bytes", errJinjaLimit, maxJinjaSize)
+	}
+
+	return v, nil
+}
+
+func (s *jinjaScope) get(name string) any {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+
+	return jinjaUndefined{}
+}
+
+type jinjaSegment struct {
+	// kind is the tag's opening delimiter, or empty for text
+	kind    string
+	content string
+
+	// trimLeft and trimRight are set by - in the tag's delimiters, keep by +
+	trimLeft, trimRight, keep bool
+}
+
+// splitJinja splits a template into text and tags
+func splitJinja(s string) ([]jinjaSegment, error) {
+	var segments []jinjaSegment
+	for len(s) > 0 {
+		i := indexTag(s)
+		if i < 0 {
+			segments = append(segments, jinjaSegment{content: s})
+			break
+		}
+
+		if i > 0 {
+			segments = append(segments, jinjaSegment{content: s[:i]})
+		}
+
+		kind := s[i : i+2]
+		close := map[string]string{"{{": "}}", "{%": "%}", "{#": "#}"}[kind]
+		s = s[i+2:]
+
+		seg := jinjaSegment{kind: kind}
+		if strings.HasPrefix(s, "-") {
+			seg.trimLeft = true
+			s = s[1:]
+		} else if strings.HasPrefix(s, "+") {
+			seg.keep = true
+			s = s[1:]
+		}
+
+		end := indexClose(s, close, kind != "{#")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed %s", kind)
+		}
+
+		content := s[:end]
+		if strings.HasSuffix(content, "-") {
+			seg.trimRight = true
+			content = content[:len(content)-1]
+		}
+
+		seg.content = strings.TrimSpace(content)
+		segments = append(segments, seg)
+		s = s[end+2:]
+	}
+
+	// apply whitespace control to the text around tags
+	for i := range segments {
+		if segments[i].kind != "" {
+			continue
+		}
+
+		text := segments[i].content
+		if i > 0 {
+			prev := segments[i-1]
+			switch {
+			case prev.trimRight:
+				text = strings.TrimLeftFunc(text, unicode.IsSpace)
+			case prev.kind != "{{":
+				// trim_blocks
+				text = strings.TrimPrefix(strings.TrimPrefix(text, "\r"), "\n")
+			}
+		}
+
+		if i+1 < len(segments) {
+			next := segments[i+1]
+			switch {
+			case next.trimLeft:
+				text = strings.TrimRightFunc(text, unicode.IsSpace)
+			case next.kind != "{{" && !next.keep:
+				// lstrip_blocks strips the whitespace before a tag at the start of a line
+				line := text[strings.LastIndex(text, "\n")+1:]
+				if strings.Trim(line, " \t") == "" && (strings.Contains(text, "\n") || i == 0) {
+					text = text[:len(text)-len(line)]
+				}
+			}
+		}
+
+		segments[i].content = text
+	}
+
+	return segments, nil
+}
+
+// indexTag returns the index of the next tag's opening delimiter
+func indexTag(s string) int {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] == '{' && strings.ContainsRune("{%#", rune(s[i+1])) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// indexClose returns the index of the closing delimiter, skipping strings in
+// expressions
+func indexClose(s, close string, quoted bool) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote != 0:
+		case quoted && (s[i] == '\'' || s[i] == '"'):
+			quote = s[i]
+		case strings.HasPrefix(s[i:], close):
+			return i
+		}
+	}
+
+	return -1
+}
+
+func parseJinja(s string) (*jinjaTemplate, error) {
+	segments, err := splitJinja(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := jinjaParser{segments: segments}
+	nodes, end, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if end != "" {
+		return nil, fmt.Errorf("unexpected %s", end)
+	}
+
+	return &jinjaTemplate{nodes: nodes}, nil
+}
+
+type jinjaParser struct {
+	segments []jinjaSegment
+	pos      int
+
+	// tag is the statement which ended the last parseNodes
+	tag *jinjaTokens
+}
+
+// parseNodes parses nodes until a statement which ends a block, which it
+// returns the keyword of
+func (p *jinjaParser) parseNodes() ([]jinjaNode, string, error) {
+	var nodes []jinjaNode
+	for p.pos < len(p.segments) {
+		seg := p.segments[p.pos]
+		p.pos++
+
+		switch seg.kind {
+		case "":
+			if seg.content != "" {
+				nodes = append(nodes, jinjaText(seg.content))
+			}
+		case "{#":
+		case "{{":
+			t, err := tokenizeJinja(seg.content)
+			if err != nil {
+				return nil, "", err
+			}
+
+			expr, err := t.parseExpr()
+			if err != nil {
+				return nil, "", err
+			}
+
+			if err := t.end(); err != nil {
+				return nil, "", err
+			}
+
+			nodes = append(nodes, jinjaOutput{expr})
+		case "{%":
+			t, err := tokenizeJinja(seg.content)
+			if err != nil {
+				return nil, "", err
+			}
+
+			keyword := t.next()
+			switch keyword.value {
+			case "if":
+				node, err := p.parseIf(t)
+				if err != nil {
+					return nil, "", err
+				}
+
+				nodes = append(nodes, node)
+			case "for":
+				node, err := p.parseFor(t)
+				if err != nil {
+					return nil, "", err
+				}
+
+				nodes = append(nodes, node)
+			case "set":
+				name := t.next()
+				if name.kind != jinjaName || !t.accept("=") {
+					return nil, "", errors.New("only set name = value is supported")
+				}
+
+				expr, err := t.parseExpr()
+				if err != nil {
+					return nil, "", err
+				}
+
+				if err := t.end(); err != nil {
+					return nil, "", err
+				}
+
+				nodes = append(nodes, jinjaSet{name.value, expr})
+			case "elif", "else", "endif", "endfor":
+				p.tag = t
+				return nodes, keyword.value, nil
+			default:
+				return nil, "", fmt.Errorf("unsupported tag %q", keyword.value)
+			}
+		}
+	}
+
+	return nodes, "", nil
+}
+
+func (p *jinjaParser) parseIf(t *jinjaTokens) (jinjaNode, error) {
+	var node jinjaIf
+	for {
+		cond, err := t.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := t.end(); err != nil {
+			return nil, err
+		}
+
+		body, end, err := p.parseNodes()
+		if err != nil {
+			return nil, err
+		}
+
+		node.conds = append(node.conds, cond)
+		node.bodies = append(node.bodies, body)
+
+		switch end {
+		case "elif":
+			t = p.tag
+			continue
+		case "else":
+			if err := p.tag.end(); err != nil {
+				return nil, err
+			}
+
+			node.orElse, end, err = p.parseNodes()
+			if err != nil {
+				return nil, err
+			}
+
+			if end != "endif" {
+				return nil, fmt.Errorf("expected endif, got %q", end)
+			}
+
+			return node, nil
+		case "endif":
+			return node, nil
+		default:
+			return nil, fmt.Errorf("expected endif, got %q", end)
+		}
+	}
+}
+
+func (p *jinjaParser) parseFor(t *jinjaTokens) (jinjaNode, error) {
+	var node jinjaFor
+	for {
+		name := t.next()
+		if name.kind != jinjaName {
+			return nil, fmt.Errorf("unexpected %q in for", name.value)
+		}
+
+		node.targets = append(node.targets, name.value)
+		if !t.accept(",") {
+			break
+		}
+	}
+
+	if !t.accept("in") {
+		return nil, errors.New("expected in")
+	}
+
+	iter, err := t.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	node.iter = iter
+	if t.accept("if") {
+		if node.filter, err = t.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.end(); err != nil {
+		return nil, err
+	}
+
+	body, end, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	node.body = body
+	if end == "else" {
+		if node.orElse, end, err = p.parseNodes(); err != nil {
+			return nil, err
+		}
+	}
+
+	if end != "endfor" {
+		return nil, fmt.Errorf("expected endfor, got %q", end)
+	}
+
+	return node, nil
+}
+
+func (t *jinjaTemplate) render(vars map[string]any) (string, error) {
+	var sb strings.Builder
+	if err := renderNodes(&sb, t.nodes, &jinjaScope{vars: vars, iterations: new(int)}); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+func renderNodes(sb *strings.Builder, nodes []jinjaNode, scope *jinjaScope) error {
+	for _, n := range nodes {
+		if err := n.render(sb, scope); err != nil {
+			return err
+		}
+
+		if sb.Len() > maxJinjaSize {
+			return fmt.Errorf("%w: the output is larger than %d bytes", errJinjaLimit, maxJinjaSize)
+		}
+	}
+
+	return nil
+}
+
+func (n jinjaText) render(sb *strings.Builder, _ *jinjaScope) error {
+	sb.WriteString(string(n))
+	return nil
+}
+
+func (n jinjaOutput) render(sb *strings.Builder, scope *jinjaScope) error {
+	v, err := n.expr.eval(scope)
+	if err != nil {
+		return err
+	}
+
+	if _, err := jinjaCheckSize(v); err != nil {
+		return err
+	}
+
+	sb.WriteString(jinjaString(v))
+	return nil
+}
+
+func (n jinjaIf) render(sb *strings.Builder, scope *jinjaScope) error {
+	for i, cond := range n.conds {
+		v, err := cond.eval(scope)
+		if err != nil {
+			return err
+		}
+
+		if jinjaTruthy(v) {
+			return renderNodes(sb, n.bodies[i], scope)
+		}
+	}
+
+	return renderNodes(sb, n.orElse, scope)
+}
+
+func (n jinjaFor) render(sb *strings.Builder, scope *jinjaScope) error {
+	v, err := n.iter.eval(scope)
+	if err != nil {
+		return err
+	}
+
+	var items []any
+	switch v := v.(type) {
+	case []any:
+		items = v
+	case string:
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+	default:
+		return fmt.Errorf("can't loop over %s", jinjaType(v))
+	}
+
+	bind := func(vars map[string]any, item any) error {
+		if len(n.targets) == 1 {
+			vars[n.targets[0]] = item
+			return nil
+		}
+
+		values, ok := item.([]any)
+		if !ok || len(values) != len(n.targets) {
+			return fmt.Errorf("can't unpack %s into %d values", jinjaType(item), len(n.targets))
+		}
+
+		for i, target := range n.targets {
+			vars[target] = values[i]
+		}
+
+		return nil
+	}
+
+	if n.filter != nil {
+		var filtered []any
+		for _, item := range items {
+			if err := scope.step(); err != nil {
+				return err
+			}
+
+			inner := scope.child()
+			if err := bind(inner.vars, item); err != nil {
+				return err
+			}
+
+			keep, err := n.filter.eval(inner)
+			if err != nil {
+				return err
+			}
+
+			if jinjaTruthy(keep) {
+				filtered = append(filtered, item)
+			}
+		}
+
+		items = filtered
+	}
+
+	if len(items) == 0 {
+		return renderNodes(sb, n.orElse, scope)
+	}
+
+	for i, item := range items {
+		if err := scope.step(); err != nil {
+			return err
+		}
+
+		inner := scope.child()
+		if err := bind(inner.vars, item); err != nil {
+			return err
+		}
+
+		inner.vars["loop"] = map[string]any{
+			"index":     int64(i + 1),
+			"index0":    int64(i),
+			"revindex":  int64(len(items) - i),
+			"revindex0": int64(len(items) - i - 1),
+			"first":     i == 0,
+			"last":      i == len(items)-1,
+			"length":    int64(len(items)),
+		}
+
+		if err := renderNodes(sb, n.body, inner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n jinjaSet) render(_ *strings.Builder, scope *jinjaScope) error {
+	v, err := n.expr.eval(scope)
+	if err != nil {
+		return err
+	}
+
+	if _, err := jinjaCheckSize(v); err != nil {
+		return err
+	}
+
+	scope.vars[n.name] = v
+	return nil
+}
+
+// jinjaString formats a value like Python's str
+func jinjaString(v any) string {
+	switch v := v.(type) {
+	case jinjaUndefined:
+		return ""
+	case nil:
+		return "None"
+	case string:
+		return v
+	case bool:
+		if v {
+			return "True"
+		}
+
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		s := make([]string, len(v))
+		for i, item := range v {
+			if str, ok := item.(string); ok {
+				s[i] = strconv.Quote(str)
+			} else {
+				s[i] = jinjaString(item)
+			}
+		}
+
+		return "[" + strings.Join(s, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func jinjaTruthy(v any) bool {
+	switch v := v.(type) {
+	case jinjaUndefined, nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+func jinjaType(v any) string {
+	switch v.(type) {
+	case jinjaUndefined:
+		return "undefined"
+	case nil:
+		return "none"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "dict"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+const (
+	jinjaName = iota
+	jinjaStr
+	jinjaNumber
+	jinjaOp
+	jinjaEOF
+)
+
+type jinjaToken struct {
+	kind  int
+	value string
+}
+
+type jinjaTokens struct {
+	tokens []jinjaToken
+	pos    int
+}
+
+var jinjaOps = []string{"==", "!=", "<=", ">=", "//", "**", "(", ")", "[", "]", "{", "}", ".", ",", ":", "|", "+", "-", "*", "/", "%", "~", "<", ">", "="}
+
+func tokenizeJinja(s string) (*jinjaTokens, error) {
+	var tokens []jinjaToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+
+			tokens = append(tokens, jinjaToken{jinjaName, s[i:j]})
+			i = j
+		case unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+
+			tokens = append(tokens, jinjaToken{jinjaNumber, s[i:j]})
+			i = j
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] != '\\' || j+1 == len(s) {
+					sb.WriteByte(s[j])
+					continue
+				}
+
+				j++
+				switch s[j] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				case 'r':
+					sb.WriteByte('\r')
+				default:
+					sb.WriteByte(s[j])
+				}
+			}
+
+			if j == len(s) {
+				return nil, errors.New("unterminated string")
+			}
+
+			tokens = append(tokens, jinjaToken{jinjaStr, sb.String()})
+			i = j + 1
+		default:
+			var op string
+			for _, o := range jinjaOps {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+
+			tokens = append(tokens, jinjaToken{jinjaOp, op})
+			i += len(op)
+		}
+	}
+
+	return &jinjaTokens{tokens: tokens}, nil
+}
+
+func (t *jinjaTokens) peek() jinjaToken {
+	if t.pos < len(t.tokens) {
+		return t.tokens[t.pos]
+	}
+
+	return jinjaToken{kind: jinjaEOF}
+}
+
+func (t *jinjaTokens) next() jinjaToken {
+	tok := t.peek()
+	if t.pos < len(t.tokens) {
+		t.pos++
+	}
+
+	return tok
+}
+
+// accept consumes the next token if it's the name or operator s
+func (t *jinjaTokens) accept(s string) bool {
+	if tok := t.peek(); (tok.kind == jinjaName || tok.kind == jinjaOp) && tok.value == s {
+		t.pos++
+		return true
+	}
+
+	return false
+}
+
+func (t *jinjaTokens) end() error {
+	if tok := t.peek(); tok.kind != jinjaEOF {
+		return fmt.Errorf("unexpected %q", tok.value)
+	}
+
+	return nil
+}
+
+type jinjaExpr interface {
+	eval(*jinjaScope) (any, error)
+}
+
+type (
+	jinjaLiteral struct{ value any }
+	jinjaVar     struct{ name string }
+	jinjaList    struct{ items []jinjaExpr }
+	jinjaDict    struct{ keys, values []jinjaExpr }
+	jinjaCond    struct{ cond, then, orElse jinjaExpr }
+	jinjaNot     struct{ expr jinjaExpr }
+	jinjaNeg     struct{ expr jinjaExpr }
+	jinjaBinary  struct {
+		op          string
+		left, right jinjaExpr
+	}
+	jinjaAttr struct {
+		expr jinjaExpr
+		name string
+	}
+	jinjaIndex struct{ expr, index jinjaExpr }
+	jinjaSlice struct{ expr, start, stop jinjaExpr }
+	jinjaCall  struct {
+		callee jinjaExpr
+		args   []jinjaExpr
+	}
+	jinjaFilter struct {
+		expr jinjaExpr
+		name string
+		args []jinjaExpr
+	}
+	jinjaTest struct {
+		expr   jinjaExpr
+		name   string
+		negate bool
+	}
+)
+
+func (t *jinjaTokens) parseExpr() (jinjaExpr, error) {
+	expr, err := t.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !t.accept("if") {
+		return expr, nil
+	}
+
+	cond, err := t.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	var orElse jinjaExpr = jinjaLiteral{jinjaUndefined{}}
+	if t.accept("else") {
+		if orElse, err = t.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+
+	return jinjaCond{cond, expr, orElse}, nil
+}
+
+func (t *jinjaTokens) parseOr() (jinjaExpr, error) {
+	return t.parseBinary([]string{"or"}, t.parseAnd)
+}
+
+func (t *jinjaTokens) parseAnd() (jinjaExpr, error) {
+	return t.parseBinary([]string{"and"}, t.parseNot)
+}
+
+func (t *jinjaTokens) parseNot() (jinjaExpr, error) {
+	if t.accept("not") {
+		expr, err := t.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return jinjaNot{expr}, nil
+	}
+
+	return t.parseCompare()
+}
+
+func (t *jinjaTokens) parseCompare() (jinjaExpr, error) {
+	left, err := t.parseMath1()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op string
+		switch tok := t.peek(); {
+		case tok.kind == jinjaOp && slices.Contains([]string{"==", "!=", "<", ">", "<=", ">="}, tok.value):
+			op = tok.value
+			t.pos++
+		case tok.kind == jinjaName && tok.value == "in":
+			op = "in"
+			t.pos++
+		case tok.kind == jinjaName && tok.value == "not" && t.pos+1 < len(t.tokens) && t.tokens[t.pos+1].value == "in":
+			op = "not in"
+			t.pos += 2
+		default:
+			return left, nil
+		}
+
+		right, err := t.parseMath1()
+		if err != nil {
+			return nil, err
+		}
+
+		left = jinjaBinary{op, left, right}
+	}
+}
+
+func (t *jinjaTokens) parseMath1() (jinjaExpr, error) {
+	return t.parseBinary([]string{"+", "-"}, t.parseConcat)
+}
+
+func (t *jinjaTokens) parseConcat() (jinjaExpr, error) {
+	return t.parseBinary([]string{"~"}, t.parseMath2)
+}
+
+func (t *jinjaTokens) parseMath2() (jinjaExpr, error) {
+	return t.parseBinary([]string{"*", "/", "//", "%"}, t.parseUnary)
+}
+
+func (t *jinjaTokens) parseBinary(ops []string, operand func() (jinjaExpr, error)) (jinjaExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := t.peek()
+		if (tok.kind != jinjaOp && tok.kind != jinjaName) || !slices.Contains(ops, tok.value) {
+			return left, nil
+		}
+
+		t.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		left = jinjaBinary{tok.value, left, right}
+	}
+}
+
+func (t *jinjaTokens) parseUnary() (jinjaExpr, error) {
+	if t.accept("-") {
+		expr, err := t.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return jinjaNeg{expr}, nil
+	}
+
+	expr, err := t.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+
+	// filters and tests bind tighter than operators
+	for {
+		switch {
+		case t.accept("|"):
+			name := t.next()
+			if name.kind != jinjaName {
+				return nil, fmt.Errorf("unexpected %q after |", name.value)
+			}
+
+			filter := jinjaFilter{expr: expr, name: name.value}
+			if t.accept("(") {
+				if filter.args, err = t.parseArgs(")"); err != nil {
+					return nil, err
+				}
+			}
+
+			expr = filter
+		case t.accept("is"):
+			negate := t.accept("not")
+			name := t.next()
+			if name.kind != jinjaName {
+				return nil, fmt.Errorf("unexpected %q after is", name.value)
+			}
+
+			expr = jinjaTest{expr, name.value, negate}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (t *jinjaTokens) parsePostfix() (jinjaExpr, error) {
+	expr, err := t.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case t.accept("."):
+			name := t.next()
+			if name.kind != jinjaName && name.kind != jinjaNumber {
+				return nil, fmt.Errorf("unexpected %q after .", name.value)
+			}
+
+			expr = jinjaAttr{expr, name.value}
+		case t.accept("("):
+			args, err := t.parseArgs(")")
+			if err != nil {
+				return nil, err
+			}
+
+			expr = jinjaCall{expr, args}
+		case t.accept("["):
+			var start, stop jinjaExpr
+			if tok := t.peek(); tok.kind != jinjaOp || tok.value != ":" {
+				if start, err = t.parseExpr(); err != nil {
+					return nil, err
+				}
+			}
+
+			if t.accept(":") {
+				if tok := t.peek(); tok.kind != jinjaOp || tok.value != "]" {
+					if stop, err = t.parseExpr(); err != nil {
+						return nil, err
+					}
+				}
+
+				expr = jinjaSlice{expr, start, stop}
+			} else {
+				expr = jinjaIndex{expr, start}
+			}
+
+			if !t.accept("]") {
+				return nil, errors.New("expected ]")
+			}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (t *jinjaTokens) parseArgs(end string) ([]jinjaExpr, error) {
+	var args []jinjaExpr
+	for !t.accept(end) {
+		if len(args) > 0 && !t.accept(",") {
+			return nil, fmt.Errorf("expected , or %s", end)
+		}
+
+		arg, err := t.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+func (t *jinjaTokens) parsePrimary() (jinjaExpr, error) {
+	tok := t.next()
+	switch tok.kind {
+	case jinjaName:
+		switch tok.value {
+		case "true", "True":
+			return jinjaLiteral{true}, nil
+		case "false", "False":
+			return jinjaLiteral{false}, nil
+		case "none", "None":
+			return jinjaLiteral{nil}, nil
+		default:
+			return jinjaVar{tok.value}, nil
+		}
+	case jinjaStr:
+		// adjacent strings are joined
+		s := tok.value
+		for t.peek().kind == jinjaStr {
+			s += t.next().value
+		}
+
+		return jinjaLiteral{s}, nil
+	case jinjaNumber:
+		if n, err := strconv.ParseInt(tok.value, 10, 64); err == nil {
+			return jinjaLiteral{n}, nil
+		}
+
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return jinjaLiteral{f}, nil
+	case jinjaOp:
+		switch tok.value {
+		case "(":
+			expr, err := t.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+
+			if !t.accept(")") {
+				return nil, errors.New("expected )")
+			}
+
+			return expr, nil
+		case "[":
+			items, err := t.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+
+			return jinjaList{items}, nil
+		case "{":
+			var dict jinjaDict
+			for !t.accept("}") {
+				if len(dict.keys) > 0 && !t.accept(",") {
+					return nil, errors.New("expected , or }")
+				}
+
+				key, err := t.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+
+				if !t.accept(":") {
+					return nil, errors.New("expected :")
+				}
+
+				value, err := t.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+
+				dict.keys = append(dict.keys, key)
+				dict.values = append(dict.values, value)
+			}
+
+			return dict, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %q", tok.value)
+}
+
+func (e jinjaLiteral) eval(*jinjaScope) (any, error) {
+	return e.value, nil
+}
+
+func (e jinjaVar) eval(scope *jinjaScope) (any, error) {
+	return scope.get(e.name), nil
+}
+
+func (e jinjaList) eval(scope *jinjaScope) (any, error) {
+	items := make([]any, len(e.items))
+	for i, item := range e.items {
+		v, err := item.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		items[i] = v
+	}
+
+	return jinjaCheckSize(items)
+}
+
+func (e jinjaDict) eval(scope *jinjaScope) (any, error) {
+	dict := make(map[string]any)
+	for i := range e.keys {
+		k, err := e.keys[i].eval(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("unsupported %s dict key", jinjaType(k))
+		}
+
+		if dict[key], err = e.values[i].eval(scope); err != nil {
+			return nil, err
+		}
+	}
+
+	return dict, nil
+}
+
+func (e jinjaCond) eval(scope *jinjaScope) (any, error) {
+	cond, err := e.cond.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	if jinjaTruthy(cond) {
+		return e.then.eval(scope)
+	}
+
+	return e.orElse.eval(scope)
+}
+
+func (e jinjaNot) eval(scope *jinjaScope) (any, error) {
+	v, err := e.expr.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return !jinjaTruthy(v), nil
+}
+
+func (e jinjaNeg) eval(scope *jinjaScope) (any, error) {
+	v, err := e.expr.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case int64:
+		return -v, nil
+	case float64:
+		return -v, nil
+	default:
+		return nil, fmt.Errorf("can't negate %s", jinjaType(v))
+	}
+}
+
+func (e jinjaBinary) eval(scope *jinjaScope) (any, error) {
+	left, err := e.left.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// and and or return an operand without evaluating the other if they can
+	switch e.op {
+	case "and":
+		if !jinjaTruthy(left) {
+			return left, nil
+		}
+
+		return e.right.eval(scope)
+	case "or":
+		if jinjaTruthy(left) {
+			return left, nil
+		}
+
+		return e.right.eval(scope)
+	}
+
+	right, err := e.right.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return jinjaEqual(left, right), nil
+	case "!=":
+		return !jinjaEqual(left, right), nil
+	case "in", "not in":
+		in, err := jinjaIn(left, right)
+		if err != nil {
+			return nil, err
+		}
+
+		return in == (e.op == "in"), nil
+	case "~":
+		if _, err := jinjaCheckSize([]any{left, right}); err != nil {
+			return nil, err
+		}
+
+		return jinjaString(left) + jinjaString(right), nil
+	case "+":
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				return jinjaCheckSize(l + r)
+			}
+		case []any:
+			if r, ok := right.([]any); ok {
+				return jinjaCheckSize(append(append([]any{}, l...), r...))
+			}
+		}
+	}
+
+	// the remaining operators are arithmetic and comparisons of numbers
+	if l, ok := left.(int64); ok {
+		if r, ok := right.(int64); ok {
+			switch e.op {
+			case "+":
+				return l + r, nil
+			case "-":
+				return l - r, nil
+			case "*":
+				return l * r, nil
+			case "//", "%":
+				if r == 0 {
+					return nil, errors.New("division by zero")
+				}
+
+				// python rounds towards negative infinity
+				q, m := l/r, l%r
+				if m != 0 && (m < 0) != (r < 0) {
+					q, m = q-1, m+r
+				}
+
+				if e.op == "%" {
+					return m, nil
+				}
+
+				return q, nil
+			}
+		}
+	}
+
+	l, lok := jinjaFloat(left)
+	r, rok := jinjaFloat(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("unsupported operands %s %s %s", jinjaType(left), e.op, jinjaType(right))
+	}
+
+	switch e.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	case "<":
+		return l < r, nil
+	case ">":
+		return l > r, nil
+	case "<=":
+		return l <= r, nil
+	case ">=":
+		return l >= r, nil
+	default:
+		return nil, fmt.Errorf("unsupported operands %s %s %s", jinjaType(left), e.op, jinjaType(right))
+	}
+}
+
+func jinjaFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func jinjaEqual(a, b any) bool {
+	if x, ok := jinjaFloat(a); ok {
+		y, ok := jinjaFloat(b)
+		return ok && x == y
+	}
+
+	switch a := a.(type) {
+	case string, bool, nil, jinjaUndefined:
+		return a == b
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for i := range a {
+			if !jinjaEqual(a[i], b[i]) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return false
+	}
+}
+
+func jinjaIn(item, container any) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("can't look for %s in a string", jinjaType(item))
+		}
+
+		return strings.Contains(c, s), nil
+	case []any:
+		for _, v := range c {
+			if jinjaEqual(item, v) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	case map[string]any:
+		s, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+
+		_, ok = c[s]
+		return ok, nil
+	default:
+		return false, fmt.Errorf("can't look in %s", jinjaType(container))
+	}
+}
+
+func (e jinjaAttr) eval(scope *jinjaScope) (any, error) {
+	v, err := e.expr.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return jinjaGetItem(v, e.name)
+}
+
+func (e jinjaIndex) eval(scope *jinjaScope) (any, error) {
+	v, err := e.expr.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := e.index.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return jinjaGetItem(v, index)
+}
+
+// jinjaGetItem returns the key of a dict or the index of a list or string
+func jinjaGetItem(v, key any) (any, error) {
+	switch v := v.(type) {
+	case jinjaUndefined:
+		return nil, fmt.Errorf("can't get %v of an undefined value", key)
+	case map[string]any:
+		s, ok := key.(string)
+		if !ok {
+			return jinjaUndefined{}, nil
+		}
+
+		if item, ok := v[s]; ok {
+			return item, nil
+		}
+
+		return jinjaUndefined{}, nil
+	case []any, string:
+		var n int
+		switch k := key.(type) {
+		case int64:
+			n = int(k)
+		case string:
+			n64, err := strconv.ParseInt(k, 10, 64)
+			if err != nil {
+				return jinjaUndefined{}, nil
+			}
+
+			n = int(n64)
+		default:
+			return jinjaUndefined{}, nil
+		}
+
+		items := jinjaItems(v)
+		if n < 0 {
+			n += len(items)
+		}
+
+		if n < 0 || n >= len(items) {
+			return jinjaUndefined{}, nil
+		}
+
+		return items[n], nil
+	default:
+		return jinjaUndefined{}, nil
+	}
+}
+
+// jinjaItems returns the items of a list or the characters of a string
+func jinjaItems(v any) []any {
+	switch v := v.(type) {
+	case []any:
+		return v
+	case string:
+		var items []any
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+
+		return items
+	default:
+		return nil
+	}
+}
+
+func (e jinjaSlice) eval(scope *jinjaScope) (any, error) {
+	v, err := e.expr.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v.(type) {
+	case []any, string:
+	default:
+		return nil, fmt.Errorf("can't slice %s", jinjaType(v))
+	}
+
+	items := jinjaItems(v)
+
+	bound := func(expr jinjaExpr, def int) (int, error) {
+		if expr == nil {
+			return def, nil
+		}
+
+		b, err := expr.eval(scope)
+		if err != nil {
+			return 0, err
+		}
+
+		n, ok := b.(int64)
+		if !ok {
+			return 0, fmt.Errorf("slice bound is %s", jinjaType(b))
+		}
+
+		i := int(n)
+		if i < 0 {
+			i += len(items)
+		}
+
+		return min(max(i, 0), len(items)), nil
+	}
+
+	start, err := bound(e.start, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	stop, err := bound(e.stop, len(items))
+	if err != nil {
+		return nil, err
+	}
+
+	items = items[start:max(start, stop)]
+	if _, ok := v.(string); ok {
+		var sb strings.Builder
+		for _, item := range items {
+			sb.WriteString(item.(string))
+		}
+
+		return sb.String(), nil
+	}
+
+	return append([]any{}, items...), nil
+}
+
+func (e jinjaCall) eval(scope *jinjaScope) (any, error) {
+	args := make([]any, len(e.args))
+	for i, arg := range e.args {
+		v, err := arg.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = v
+	}
+
+	switch callee := e.callee.(type) {
+	case jinjaVar:
+		switch callee.name {
+		case "raise_exception":
+			if len(args) > 0 {
+				return nil, fmt.Errorf("template raised: %s", jinjaString(args[0]))
+			}
+
+			return nil, errors.New("template raised an exception")
+		case "range":
+			var start, stop int64
+			switch len(args) {
+			case 1:
+				stop, _ = args[0].(int64)
+			case 2:
+				start, _ = args[0].(int64)
+				stop, _ = args[1].(int64)
+			default:
+				return nil, errors.New("range takes one or two arguments")
+			}
+
+			// the difference can't overflow as an unsigned integer
+			if start < stop && uint64(stop-start) > maxJinjaRange {
+				return nil, fmt.Errorf("%w: range of more than %d items", errJinjaLimit, maxJinjaRange)
+			}
+
+			var items []any
+			for i := start; i < stop; i++ {
+				items = append(items, i)
+			}
+
+			return items, nil
+		}
+	case jinjaAttr:
+		v, err := callee.expr.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		return jinjaMethod(v, callee.name, args)
+	}
+
+	return nil, errors.New("unsupported function call")
+}
+
+// jinjaMethod calls the python method name of v
+func jinjaMethod(v any, name string, args []any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		arg := func() (string, error) {
+			if len(args) != 1 {
+				return "", fmt.Errorf("%s takes one argument", name)
+			}
+
+			s, ok := args[0].(string)
+			if !ok {
+				return "", fmt.Errorf("%s takes a string", name)
+			}
+
+			return s, nil
+		}
+
+		switch name {
+		case "strip":
+			return strings.TrimSpace(v), nil
+		case "lstrip":
+			return strings.TrimLeftFunc(v, unicode.IsSpace), nil
+		case "rstrip":
+			return strings.TrimRightFunc(v, unicode.IsSpace), nil
+		case "upper":
+			return strings.ToUpper(v), nil
+		case "lower":
+			return strings.ToLower(v), nil
+		case "startswith":
+			s, err := arg()
+			return strings.HasPrefix(v, s), err
+		case "endswith":
+			s, err := arg()
+			return strings.HasSuffix(v, s), err
+		}
+	case map[string]any:
+		switch name {
+		case "get":
+			if len(args) == 0 {
+				return nil, errors.New("get takes a key")
+			}
+
+			item, err := jinjaGetItem(v, args[0])
+			if _, ok := item.(jinjaUndefined); ok && len(args) > 1 {
+				return args[1], nil
+			}
+
+			return item, err
+		case "items":
+			var items []any
+			for k, item := range v {
+				items = append(items, []any{k, item})
+			}
+
+			return items, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported method %s of %s", name, jinjaType(v))
+}
+
+func (e jinjaFilter) eval(scope *jinjaScope) (any, error) {
+	v, err := e.expr.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.name {
+	case "trim":
+		return strings.TrimSpace(jinjaString(v)), nil
+	case "upper":
+		return strings.ToUpper(jinjaString(v)), nil
+	case "lower":
+		return strings.ToLower(jinjaString(v)), nil
+	case "string":
+		return jinjaString(v), nil
+	case "length", "count":
+		switch v := v.(type) {
+		case string:
+			return int64(len([]rune(v))), nil
+		case []any:
+			return int64(len(v)), nil
+		case map[string]any:
+			return int64(len(v)), nil
+		}
+
+		return nil, fmt.Errorf("%s has no length", jinjaType(v))
+	case "first", "last":
+		items := jinjaItems(v)
+		if len(items) == 0 {
+			return jinjaUndefined{}, nil
+		}
+
+		if e.name == "first" {
+			return items[0], nil
+		}
+
+		return items[len(items)-1], nil
+	case "default", "d":
+		if _, ok := v.(jinjaUndefined); ok && len(e.args) > 0 {
+			return e.args[0].eval(scope)
+		}
+
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("unsupported filter %s", e.name)
+}
+
+func (e jinjaTest) eval(scope *jinjaScope) (any, error) {
+	v, err := e.expr.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var result bool
+	switch e.name {
+	case "defined":
+		_, undefined := v.(jinjaUndefined)
+		result = !undefined
+	case "undefined":
+		_, result = v.(jinjaUndefined)
+	case "none":
+		result = v == nil
+	case "string":
+		_, result = v.(string)
+	case "true":
+		result = v == true
+	case "false":
+		result = v == false
+	default:
+		return nil, fmt.Errorf("unsupported test %s", e.name)
+	}
+
+	return result != e.negate, nil
+}
@@ -0,0 +1,92 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+func init() {
+	Register("LlamaForCausalLM", llama{})
+	Register("MistralForCausalLM", llama{})
+}
+
+var llamaTensorPatterns = map[string]string{
+	`model\.embed_tokens\.weight`:                            "token_embd.weight",
+	`model\.layers\.(\d+)\.input_layernorm\.weight`:          "blk.$1.attn_norm.weight",
+	`model\.layers\.(\d+)\.mlp\.down_proj\.weight`:           "blk.$1.ffn_down.weight",
+	`model\.layers\.(\d+)\.mlp\.gate_proj\.weight`:           "blk.$1.ffn_gate.weight",
+	`model\.layers\.(\d+)\.mlp\.up_proj\.weight`:             "blk.$1.ffn_up.weight",
+	`model\.layers\.(\d+)\.post_attention_layernorm\.weight`: "blk.$1.ffn_norm.weight",
+	`model\.layers\.(\d+)\.self_attn\.k_proj\.weight`:        "blk.$1.attn_k.weight",
+	`model\.layers\.(\d+)\.self_attn\.o_proj\.weight`:        "blk.$1.attn_output.weight",
+	`model\.layers\.(\d+)\.self_attn\.q_proj\.weight`:        "blk.$1.attn_q.weight",
+	`model\.layers\.(\d+)\.self_attn\.v_proj\.weight`:        "blk.$1.attn_v.weight",
+	`lm_head\.weight`:     "output.weight",
+	`model\.norm\.weight`: "output_norm.weight",
+}
+
+var llamaTensorNames = newTensorNames(llamaTensorPatterns)
+
+// llama converts llama and mistral models, which differ only in their params
+type llama struct{}
+
+func (llama) KV(params *Params) llm.KV {
+	return llm.KV{
+		"general.architecture":                   "llama",
+		"llama.context_length":                   uint32(params.ContextSize),
+		"llama.embedding_length":                 uint32(params.HiddenSize),
+		"llama.block_count":                      uint32(params.HiddenLayers),
+		"llama.feed_forward_length":              uint32(params.IntermediateSize),
+		"llama.rope.dimension_count":             uint32(cmp.Or(params.HeadDim, params.HiddenSize/params.AttentionHeads)),
+		"llama.attention.head_count":             uint32(params.AttentionHeads),
+		"llama.attention.head_count_kv":          uint32(cmp.Or(params.KeyValHeads, params.AttentionHeads)),
+		"llama.attention.layer_norm_rms_epsilon": float32(params.NormEPS),
+		"llama.rope.freq_base":                   float32(cmp.Or(params.RopeFreqBase, 10000)),
+	}
+}
+
+func (llama) TensorName(n string) (string, error) {
+	return llamaTensorNames.rename(n)
+}
+
+func (llama) Repack(n string, data []float32, shape []uint64, params *Params) ([]float32, error) {
+	return permuteQK(n, data, shape, params)
+}
+
+// permuteQK undoes the permutation of the rows of the query and key weights
+// which Hugging Face's conversion of llama checkpoints applies so rope is
+// applied to interleaved pairs again
+func permuteQK(n string, data []float32, shape []uint64, params *Params) ([]float32, error) {
+	var heads int
+	switch {
+	case strings.HasSuffix(n, "attn_q.weight"):
+		heads = params.AttentionHeads
+	case strings.HasSuffix(n, "attn_k.weight"):
+		heads = cmp.Or(params.KeyValHeads, params.AttentionHeads)
+	default:
+		return data, nil
+	}
+
+	rows, cols := int(shape[0]), int(shape[1])
+	if heads == 0 || rows%(heads*2) != 0 {
+		return nil, fmt.Errorf("can't split %d rows of '%s' between %d heads", rows, n, heads)
+	}
+
+	// the rows of each head are split in halves which are interleaved
+	half := rows / heads / 2
+	permuted := make([]float32, len(data))
+	for h := range heads {
+		for i := range half {
+			for j := range 2 {
+				src := (h*rows/heads + j*half + i) * cols
+				dst := (h*rows/heads + i*2 + j) * cols
+				copy(permuted[dst:dst+cols], data[src:src+cols])
+			}
+		}
+	}
+
+	return permuted, nil
+}
@@ -0,0 +1,245 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+// AdapterConfig is the adapter_config.json of a PEFT adapter
+type AdapterConfig struct {
+	PeftType  string  `json:"peft_type"`
+	Rank      int     `json:"r"`
+	Alpha     float64 `json:"lora_alpha"`
+	BaseModel string  `json:"base_model_name_or_path"`
+}
+
+// GetAdapterConfig reads the config of the PEFT LoRA adapter in fsys
+func GetAdapterConfig(fsys fs.FS) (*AdapterConfig, error) {
+	f, err := fsys.Open("adapter_config.json")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config AdapterConfig
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.PeftType != "LORA":
+		return nil, fmt.Errorf("%s adapters aren't supported, only LORA", config.PeftType)
+	case config.Rank <= 0:
+		return nil, fmt.Errorf("adapter has an invalid rank %d", config.Rank)
+	case config.Alpha <= 0 || config.Alpha != math.Trunc(config.Alpha):
+		// ggla stores alpha as an integer
+		return nil, fmt.Errorf("adapter has an unsupported alpha %v", config.Alpha)
+	}
+
+	return &config, nil
+}
+
+// AdapterFiles returns the weights of the PEFT adapter in fsys, which are
+// either safetensors or a PyTorch checkpoint
+func AdapterFiles(fsys fs.FS) ([]string, error) {
+	for _, name := range []string{"adapter_model.safetensors", "adapter_model.bin"} {
+		if _, err := fs.Stat(fsys, name); err == nil {
+			return []string{name}, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// ggufArchitectures are the Hugging Face architectures whose converters name
+// the tensors of gguf architectures
+var ggufArchitectures = map[string]string{
+	"llama": "LlamaForCausalLM",
+	"gemma": "GemmaForCausalLM",
+}
+
+// BaseParams returns the params of a gguf model from its key-values, for
+// converting adapters of the model
+func BaseParams(kv llm.KV) (*Params, error) {
+	arch, _ := kv["general.architecture"].(string)
+	value := func(k string) int {
+		v, _ := kv[arch+"."+k].(uint32)
+		return int(v)
+	}
+
+	params := Params{
+		HiddenSize:       value("embedding_length"),
+		HiddenLayers:     value("block_count"),
+		ContextSize:      value("context_length"),
+		IntermediateSize: value("feed_forward_length"),
+		AttentionHeads:   value("attention.head_count"),
+		KeyValHeads:      value("attention.head_count_kv"),
+		Experts:          value("expert_count"),
+		ExpertsUsed:      value("expert_used_count"),
+	}
+
+	hfArch, ok := ggufArchitectures[arch]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedArchitecture, arch)
+	}
+
+	if arch == "llama" && params.Experts > 0 {
+		hfArch = "MixtralForCausalLM"
+	}
+
+	params.Architectures = []string{hfArch}
+	return &params, nil
+}
+
+// GetAdapterTensors reads the tensors of the PEFT LoRA adapter in fsys,
+// naming them after the tensors of the base model they apply to with conv.
+// The tensors are named like blk.0.attn_q.weight.loraA and are written as F32.
+func GetAdapterTensors(fsys fs.FS, conv Converter, params *Params) ([]llm.Tensor, error) {
+	files, err := AdapterFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("no adapter_model.safetensors or adapter_model.bin was found")
+	}
+
+	read := ReadSafeTensors
+	if path.Ext(files[0]) == ".bin" {
+		read = ReadTorch
+	}
+
+	tensors, err := readTensors(fsys, files, read, lora{conv}, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range tensors {
+		t := &tensors[i]
+		st, ok := t.WriterTo.(tensorData)
+		if !ok || t.Shape[1] == 0 {
+			return nil, fmt.Errorf("adapter tensor '%s' isn't a matrix", t.Name)
+		}
+
+		t.Kind = 0
+		if strings.HasSuffix(t.Name, ".loraA") {
+			// A is stored transposed, with a row for each column of the base weight
+			t.Shape = []uint64{t.Shape[1], t.Shape[0], 0, 0}
+		}
+
+		st.tensor.Kind = t.Kind
+		t.WriterTo = st
+	}
+
+	return tensors, nil
+}
+
+// CheckAdapter checks that the tensors of an adapter fit the tensors of the
+// base model with params, whose shapes are in ggml order
+func CheckAdapter(tensors []llm.Tensor, config *AdapterConfig, params *Params, base []llm.Tensor) error {
+	shapes := make(map[string][]uint64)
+	for _, t := range base {
+		shapes[t.Name] = t.Shape
+	}
+
+	pairs := make(map[string]int)
+	for _, t := range tensors {
+		name, ab, _ := cutLast(t.Name, ".")
+
+		var layer int
+		if _, err := fmt.Sscanf(name, "blk.%d.", &layer); err == nil && layer >= params.HiddenLayers {
+			return fmt.Errorf("adapter tensor '%s' is for layer %d but the base model has %d layers", t.Name, layer, params.HiddenLayers)
+		}
+
+		shape, ok := shapes[name]
+		if !ok {
+			return fmt.Errorf("the base model doesn't have the tensor '%s' of the adapter", name)
+		}
+
+		// A has a row for each column of the base weight and B a row for each
+		// of its rows
+		n := shape[0]
+		if ab == "loraB" {
+			n = shape[1]
+		}
+
+		if t.Shape[0] != n || t.Shape[1] != uint64(config.Rank) {
+			return fmt.Errorf("adapter tensor '%s' is %dx%d but should be %dx%d for the base model with rank %d", t.Name, t.Shape[0], t.Shape[1], n, config.Rank, config.Rank)
+		}
+
+		pairs[name]++
+	}
+
+	for name, n := range pairs {
+		if n != 2 {
+			return fmt.Errorf("the adapter doesn't have both lora_A and lora_B for '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+// WriteGGLA writes the tensors of an adapter to w in the ggla format
+func WriteGGLA(w io.Writer, tensors []llm.Tensor, config *AdapterConfig) error {
+	return llm.EncodeGGLA(w, uint32(config.Rank), uint32(config.Alpha), tensors)
+}
+
+var loraTensorName = regexp.MustCompile(`^base_model\.model\.(.+)\.lora_([AB])(?:\.default)?\.weight$`)
+
+// lora names the tensors of a PEFT LoRA adapter after the tensors of the base
+// model they apply to
+type lora struct {
+	Converter
+}
+
+func (c lora) TensorName(n string) (string, error) {
+	m := loraTensorName.FindStringSubmatch(n)
+	if m == nil {
+		return "", fmt.Errorf("'%s' isn't a lora tensor", n)
+	}
+
+	name, err := c.Converter.TensorName(m[1] + ".weight")
+	if err != nil {
+		return "", err
+	}
+
+	return name + ".lora" + m[2], nil
+}
+
+func (c lora) Repack(n string, data []float32, shape []uint64, params *Params) ([]float32, error) {
+	name, ab, _ := cutLast(n, ".")
+	if ab == "loraA" {
+		rows, cols := int(shape[0]), int(shape[1])
+		transposed := make([]float32, len(data))
+		for i := range rows {
+			for j := range cols {
+				transposed[j*rows+i] = data[i*cols+j]
+			}
+		}
+
+		return transposed, nil
+	}
+
+	// the rows of B are the rows of the base weight
+	return c.Converter.Repack(name, data, shape, params)
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,164 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+// adapter writes a PEFT LoRA adapter of rank r with lora_A and lora_B tensors
+// of the given shapes
+func adapter(t *testing.T, r int, shapes map[string][]int) string {
+	dir := checkpoint(t, Params{}, "F32", shapes)
+	assert.Nil(t, os.Rename(filepath.Join(dir, "model-00001-of-00001.safetensors"), filepath.Join(dir, "adapter_model.safetensors")))
+
+	bts, err := json.Marshal(map[string]any{"peft_type": "LORA", "r": r, "lora_alpha": 16, "base_model_name_or_path": "llama"})
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "adapter_config.json"), bts, 0o644))
+	return dir
+}
+
+func TestConvertAdapter(t *testing.T) {
+	params := Params{
+		Architectures:    []string{"LlamaForCausalLM"},
+		VocabSize:        4,
+		HiddenSize:       8,
+		HiddenLayers:     1,
+		ContextSize:      64,
+		IntermediateSize: 16,
+		AttentionHeads:   2,
+		NormEPS:          1e-5,
+		BoSTokenID:       1,
+		EoSTokenID:       2,
+	}
+
+	shapes := layerShapes(0, 8, 8, 16)
+	shapes["model.embed_tokens.weight"] = []int{4, 8}
+	shapes["model.norm.weight"] = []int{8}
+	shapes["lm_head.weight"] = []int{4, 8}
+
+	dir := checkpoint(t, params, "F16", shapes)
+	conv, err := GetConverter(&params)
+	assert.Nil(t, err)
+
+	tensors, err := GetTensors(os.DirFS(dir), conv, &params)
+	assert.Nil(t, err)
+
+	vocab, err := LoadTokens(os.DirFS(dir))
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	assert.Nil(t, WriteGGUF(&b, "llama", conv, tensors, &params, vocab, WriteOptions{}))
+
+	base, err := llm.DecodeGGML(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+
+	baseParams, err := BaseParams(base.KV())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"LlamaForCausalLM"}, baseParams.Architectures)
+	assert.Equal(t, 2, baseParams.AttentionHeads)
+
+	conv, err = GetConverter(baseParams)
+	assert.Nil(t, err)
+
+	read := func(t *testing.T, shapes map[string][]int, r int) ([]llm.Tensor, *AdapterConfig) {
+		dir := adapter(t, r, shapes)
+		config, err := GetAdapterConfig(os.DirFS(dir))
+		assert.Nil(t, err)
+
+		tensors, err := GetAdapterTensors(os.DirFS(dir), conv, baseParams)
+		assert.Nil(t, err)
+		return tensors, config
+	}
+
+	tensors, config := read(t, map[string][]int{
+		"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight": {2, 8},
+		"base_model.model.model.layers.0.self_attn.q_proj.lora_B.weight": {8, 2},
+		"base_model.model.model.layers.0.self_attn.v_proj.lora_A.weight": {2, 8},
+		"base_model.model.model.layers.0.self_attn.v_proj.lora_B.weight": {8, 2},
+	}, 2)
+	assert.Nil(t, CheckAdapter(tensors, config, baseParams, base.Tensors()))
+
+	b.Reset()
+	assert.Nil(t, WriteGGLA(&b, tensors, config))
+
+	g := bytes.NewReader(b.Bytes())
+	ggla, err := llm.DecodeGGML(g)
+	assert.Nil(t, err)
+	assert.Equal(t, llm.KV{"r": uint32(2), "alpha": uint32(16)}, ggla.KV())
+
+	decoded := make(map[string]llm.Tensor)
+	for _, t := range ggla.Tensors() {
+		decoded[t.Name] = t
+	}
+
+	assert.Len(t, decoded, 4)
+	assert.Equal(t, []uint64{8, 2}, decoded["blk.0.attn_q.weight.loraA"].Shape)
+	assert.Equal(t, []uint64{8, 2}, decoded["blk.0.attn_q.weight.loraB"].Shape)
+	assert.Equal(t, uint32(0), decoded["blk.0.attn_q.weight.loraA"].Kind)
+
+	data := func(name string) []float32 {
+		tensor := decoded[name]
+		values := make([]float32, tensor.Parameters())
+		_, err := g.Seek(int64(tensor.Offset), io.SeekStart)
+		assert.Nil(t, err)
+		assert.Nil(t, binary.Read(g, binary.LittleEndian, values))
+		return values
+	}
+
+	// A is transposed
+	values := data("blk.0.attn_q.weight.loraA")
+	for i := range 2 {
+		for j := range 8 {
+			assert.Equal(t, float32((i*8+j)%17-8)/8, values[j*2+i])
+		}
+	}
+
+	// the rows of B of the query are permuted like the query's
+	checkpointed := make([]float32, 16)
+	for i := range checkpointed {
+		checkpointed[i] = float32(i%17-8) / 8
+	}
+
+	permuted, err := permuteQK("blk.0.attn_q.weight", checkpointed, []uint64{8, 2}, baseParams)
+	assert.Nil(t, err)
+	assert.Equal(t, permuted, data("blk.0.attn_q.weight.loraB"))
+	assert.Equal(t, checkpointed, data("blk.0.attn_v.weight.loraB"))
+
+	t.Run("mismatch", func(t *testing.T) {
+		tensors, config := read(t, map[string][]int{
+			"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight": {4, 8},
+			"base_model.model.model.layers.0.self_attn.q_proj.lora_B.weight": {8, 4},
+		}, 2)
+		assert.ErrorContains(t, CheckAdapter(tensors, config, baseParams, base.Tensors()), "is 8x4 but should be 8x2")
+
+		tensors, config = read(t, map[string][]int{
+			"base_model.model.model.layers.1.self_attn.q_proj.lora_A.weight": {2, 8},
+			"base_model.model.model.layers.1.self_attn.q_proj.lora_B.weight": {8, 2},
+		}, 2)
+		assert.ErrorContains(t, CheckAdapter(tensors, config, baseParams, base.Tensors()), "is for layer 1 but the base model has 1 layers")
+
+		tensors, config = read(t, map[string][]int{
+			"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight": {2, 8},
+		}, 2)
+		assert.ErrorContains(t, CheckAdapter(tensors, config, baseParams, base.Tensors()), "doesn't have both lora_A and lora_B")
+	})
+
+	t.Run("config", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, "adapter_config.json"), []byte(`{"peft_type": "PREFIX_TUNING"}`), 0o644))
+		_, err := GetAdapterConfig(os.DirFS(dir))
+		assert.ErrorContains(t, err, "PREFIX_TUNING adapters aren't supported")
+
+		_, err = GetAdapterTensors(os.DirFS(dir), conv, baseParams)
+		assert.ErrorContains(t, err, "no adapter_model.safetensors or adapter_model.bin")
+	})
+}
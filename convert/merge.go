@@ -0,0 +1,233 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/x448/float16"
+
+	"github.com/jmorganca/ollama/gguf"
+	"github.com/jmorganca/ollama/llm"
+)
+
+// MergeAdapter writes the base model to w with the ggla adapter, whose data
+// is in ra, merged into its weights so the model doesn't need the adapter to
+// run. The weights the adapter applies to must be F16 or F32. The merged
+// weights keep their types unless opts.FileType is set, in which case the
+// F16 and F32 weights of the model are quantized to it.
+func MergeAdapter(w io.Writer, base *gguf.File, adapter *llm.GGML, ra io.ReaderAt, opts WriteOptions) error {
+	if base.ByteOrder == binary.BigEndian {
+		return errors.New("adapters can't be merged into big endian models")
+	}
+
+	r, _ := adapter.KV()["r"].(uint32)
+	alpha, _ := adapter.KV()["alpha"].(uint32)
+	if r == 0 {
+		return errors.New("the adapter has an invalid rank 0")
+	}
+
+	// a pair of tensors A and B for each weight the adapter applies to
+	pairs := make(map[string]*loraPair)
+	for _, t := range adapter.Tensors() {
+		name, ab, _ := cutLast(t.Name, ".")
+		if ab != "loraA" && ab != "loraB" {
+			return fmt.Errorf("'%s' isn't a lora tensor", t.Name)
+		}
+
+		if t.Kind > 1 {
+			return fmt.Errorf("adapter tensor '%s' is %s but should be F16 or F32", t.Name, t.TypeName())
+		}
+
+		if _, ok := pairs[name]; !ok {
+			pairs[name] = &loraPair{scale: float32(alpha) / float32(r)}
+		}
+
+		data := io.NewSectionReader(ra, int64(t.Offset), int64(t.Size()))
+		if ab == "loraA" {
+			pairs[name].a, pairs[name].aData = t, data
+		} else {
+			pairs[name].b, pairs[name].bData = t, data
+		}
+	}
+
+	for name, pair := range pairs {
+		t, ok := base.Tensor(name)
+		if !ok {
+			return fmt.Errorf("the base model doesn't have the tensor '%s' of the adapter", name)
+		}
+
+		if err := pair.check(name, t, r); err != nil {
+			return err
+		}
+	}
+
+	var fileType uint32
+	if opts.FileType != "" {
+		var err error
+		if fileType, err = llm.ParseFileType(opts.FileType); err != nil {
+			return err
+		}
+	}
+
+	arch, _ := base.String("general.architecture")
+	blocks, _ := base.Uint(arch + ".block_count")
+
+	merged := *base
+	merged.KV = append([]gguf.KV(nil), base.KV...)
+	merged.Tensors = make([]gguf.Tensor, len(base.Tensors))
+
+	var total uint64
+	for i, t := range base.Tensors {
+		pair := pairs[t.Name]
+		if t.Type <= 1 && (pair != nil || opts.FileType != "") {
+			kind := t.Type
+			if opts.FileType != "" {
+				kind = llm.QuantizeKind(t.Name, len(trimShape(t.Shape)), t.Shape[0], fileType, int(blocks))
+			}
+
+			t.WriterTo = mergedData{base: t, pair: pair, kind: kind}
+			t.Type = kind
+		} else if pair != nil {
+			return fmt.Errorf("the adapter can't be merged into '%s' which is %s, it should be F16 or F32", t.Name, llm.Tensor{Kind: t.Type}.TypeName())
+		}
+
+		total += (t.Size() + 31) &^ 31
+		merged.Tensors[i] = t
+	}
+
+	if opts.Progress != nil {
+		var completed uint64
+		for i, t := range merged.Tensors {
+			completed += (t.Size() + 31) &^ 31
+
+			done := completed
+			progress := llm.Tensor{Name: t.Name, Kind: t.Type}
+			merged.Tensors[i].WriterTo = progressWriter{t.WriterTo, func() { opts.Progress(progress, done, total) }}
+		}
+	}
+
+	if opts.FileType != "" {
+		if err := merged.Set("general.file_type", fileType); err != nil {
+			return err
+		}
+	}
+
+	return merged.Encode(w)
+}
+
+// trimShape returns the dimensions of a shape without trailing dimensions of
+// one element, which some writers pad shapes with
+func trimShape(shape []uint64) []uint64 {
+	for len(shape) > 1 && shape[len(shape)-1] == 1 {
+		shape = shape[:len(shape)-1]
+	}
+
+	return shape
+}
+
+// loraPair are the tensors A and B of the adapter of a weight. The weight is
+// merged with W + scale * B·A, where A is stored transposed.
+type loraPair struct {
+	a, b         llm.Tensor
+	aData, bData *io.SectionReader
+	scale        float32
+}
+
+func (p *loraPair) check(name string, t *gguf.Tensor, r uint32) error {
+	if p.a.Shape == nil || p.b.Shape == nil {
+		return fmt.Errorf("the adapter doesn't have both loraA and loraB for '%s'", name)
+	}
+
+	// the base weight has rows of shape[0] elements and shape[1] rows
+	shape := trimShape(t.Shape)
+	if len(shape) != 2 {
+		return fmt.Errorf("the adapter applies to '%s' which isn't a matrix", name)
+	}
+
+	for _, x := range []struct {
+		t    llm.Tensor
+		rows uint64
+	}{{p.a, shape[0]}, {p.b, shape[1]}} {
+		if len(x.t.Shape) != 2 || x.t.Shape[0] != x.rows || x.t.Shape[1] != uint64(r) {
+			return fmt.Errorf("adapter tensor '%s' is %v but should be [%d %d] for the base model with rank %d", x.t.Name, x.t.Shape, x.rows, r, r)
+		}
+	}
+
+	return nil
+}
+
+// mergedData writes a base tensor as kind, merging the adapter into it if it
+// has one
+type mergedData struct {
+	base gguf.Tensor
+	pair *loraPair
+	kind uint32
+}
+
+func (m mergedData) WriteTo(w io.Writer) (int64, error) {
+	data, err := readFloats(m.base.Data(), m.base.Type)
+	if err != nil {
+		return 0, err
+	}
+
+	if p := m.pair; p != nil {
+		a, err := readFloats(p.aData, p.a.Kind)
+		if err != nil {
+			return 0, err
+		}
+
+		b, err := readFloats(p.bData, p.b.Kind)
+		if err != nil {
+			return 0, err
+		}
+
+		cols, r := int(m.base.Shape[0]), int(p.a.Shape[1])
+		for i := range len(data) / cols {
+			bi := b[i*r : (i+1)*r]
+			for j := range cols {
+				aj := a[j*r : (j+1)*r]
+
+				var sum float32
+				for k := range r {
+					sum += bi[k] * aj[k]
+				}
+
+				data[i*cols+j] += p.scale * sum
+			}
+		}
+	}
+
+	bts, err := llm.Quantize(m.kind, data)
+	if err != nil {
+		return 0, fmt.Errorf("tensor '%s': %w", m.base.Name, err)
+	}
+
+	n, err := w.Write(bts)
+	return int64(n), err
+}
+
+// readFloats reads F16 or F32 data from the start of sr
+func readFloats(sr *io.SectionReader, kind uint32) ([]float32, error) {
+	bts, err := io.ReadAll(io.NewSectionReader(sr, 0, sr.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 0:
+		data := make([]float32, len(bts)/4)
+		return data, binary.Read(bytes.NewReader(bts), binary.LittleEndian, data)
+	case 1:
+		data := make([]float32, len(bts)/2)
+		for i := range data {
+			data[i] = float16.Frombits(binary.LittleEndian.Uint16(bts[i*2:])).Float32()
+		}
+
+		return data, nil
+	default:
+		return nil, fmt.Errorf("can't read %s data", llm.Tensor{Kind: kind}.TypeName())
+	}
+}
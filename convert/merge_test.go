@@ -0,0 +1,140 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/gguf"
+	"github.com/jmorganca/ollama/llm"
+)
+
+func f32s(values []float32) *bytes.Reader {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, values)
+	return bytes.NewReader(b.Bytes())
+}
+
+func sequence(n int, scale float32) []float32 {
+	values := make([]float32, n)
+	for i := range values {
+		values[i] = float32(i%7-3) * scale
+	}
+
+	return values
+}
+
+func TestMergeAdapter(t *testing.T) {
+	// a weight with 3 rows of 32 and an adapter of rank 2
+	const rows, cols, r = 3, 32, 2
+
+	weight := sequence(rows*cols, 1)
+	norm := sequence(cols, 1)
+
+	base := gguf.File{
+		KV: []gguf.KV{
+			{Key: "general.architecture", Value: "llama"},
+			{Key: "llama.block_count", Value: uint32(1)},
+			{Key: "general.file_type", Value: uint32(0)},
+		},
+		Tensors: []gguf.Tensor{
+			{Name: "blk.0.attn_q.weight", Type: 0, Shape: []uint64{cols, rows}, WriterTo: f32s(weight)},
+			{Name: "blk.0.attn_norm.weight", Type: 0, Shape: []uint64{cols}, WriterTo: f32s(norm)},
+		},
+	}
+
+	var b bytes.Buffer
+	assert.Nil(t, base.Encode(&b))
+
+	decoded, err := gguf.Decode(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.Nil(t, err)
+
+	// A is stored transposed with a row for each column of the weight, and B
+	// has a row for each row of the weight
+	a, bb := sequence(cols*r, 0.5), sequence(rows*r, 0.25)
+
+	var g bytes.Buffer
+	assert.Nil(t, llm.EncodeGGLA(&g, r, 4, []llm.Tensor{
+		{Name: "blk.0.attn_q.weight.loraA", Kind: 0, Shape: []uint64{cols, r, 0, 0}, WriterTo: f32s(a)},
+		{Name: "blk.0.attn_q.weight.loraB", Kind: 0, Shape: []uint64{rows, r, 0, 0}, WriterTo: f32s(bb)},
+	}))
+
+	gr := bytes.NewReader(g.Bytes())
+	ggla, err := llm.DecodeGGML(gr)
+	assert.Nil(t, err)
+
+	merge := func(t *testing.T, opts WriteOptions) *gguf.File {
+		var b bytes.Buffer
+		assert.Nil(t, MergeAdapter(&b, decoded, ggla, gr, opts))
+
+		merged, err := gguf.Decode(bytes.NewReader(b.Bytes()), int64(b.Len()))
+		assert.Nil(t, err)
+		return merged
+	}
+
+	merged := merge(t, WriteOptions{})
+	assert.Equal(t, decoded.KV, merged.KV)
+
+	q, _ := merged.Tensor("blk.0.attn_q.weight")
+	assert.Equal(t, uint32(0), q.Type)
+
+	values := make([]float32, rows*cols)
+	assert.Nil(t, binary.Read(q.Data(), binary.LittleEndian, values))
+
+	// W + alpha/r * B·A
+	for i := range rows {
+		for j := range cols {
+			var sum float32
+			for k := range r {
+				sum += bb[i*r+k] * a[j*r+k]
+			}
+
+			assert.InDelta(t, weight[i*cols+j]+2*sum, values[i*cols+j], 1e-5)
+		}
+	}
+
+	// weights the adapter doesn't apply to are unchanged
+	n, _ := merged.Tensor("blk.0.attn_norm.weight")
+	assert.Equal(t, uint32(0), n.Type)
+	values = make([]float32, cols)
+	assert.Nil(t, binary.Read(n.Data(), binary.LittleEndian, values))
+	assert.Equal(t, norm, values)
+
+	t.Run("quantize", func(t *testing.T) {
+		merged := merge(t, WriteOptions{FileType: "Q8_0"})
+		fileType, _ := merged.Uint("general.file_type")
+		assert.Equal(t, uint64(7), fileType)
+
+		q, _ := merged.Tensor("blk.0.attn_q.weight")
+		assert.Equal(t, uint32(8), q.Type)
+
+		n, _ := merged.Tensor("blk.0.attn_norm.weight")
+		assert.Equal(t, uint32(0), n.Type)
+	})
+
+	t.Run("quantized base", func(t *testing.T) {
+		quantized, err := gguf.Decode(bytes.NewReader(b.Bytes()), int64(b.Len()))
+		assert.Nil(t, err)
+		quantized.Tensors[0].Type = 8
+
+		err = MergeAdapter(&bytes.Buffer{}, quantized, ggla, gr, WriteOptions{})
+		assert.ErrorContains(t, err, "the adapter can't be merged into 'blk.0.attn_q.weight' which is Q8_0")
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		var g bytes.Buffer
+		assert.Nil(t, llm.EncodeGGLA(&g, r, 4, []llm.Tensor{
+			{Name: "blk.0.attn_q.weight.loraA", Kind: 0, Shape: []uint64{16, r, 0, 0}, WriterTo: f32s(sequence(16*r, 1))},
+			{Name: "blk.0.attn_q.weight.loraB", Kind: 0, Shape: []uint64{rows, r, 0, 0}, WriterTo: f32s(bb)},
+		}))
+
+		gr := bytes.NewReader(g.Bytes())
+		ggla, err := llm.DecodeGGML(gr)
+		assert.Nil(t, err)
+
+		err = MergeAdapter(&bytes.Buffer{}, decoded, ggla, gr, WriteOptions{})
+		assert.ErrorContains(t, err, "adapter tensor 'blk.0.attn_q.weight.loraA' is [16 2] but should be [32 2]")
+	})
+}
@@ -0,0 +1,39 @@
+package convert
+
+import (
+	"maps"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+func init() {
+	Register("MixtralForCausalLM", mixtral{})
+}
+
+var mixtralTensorNames = func() tensorNames {
+	names := maps.Clone(llamaTensorPatterns)
+	names[`model\.layers\.(\d+)\.block_sparse_moe\.gate\.weight`] = "blk.$1.ffn_gate_inp.weight"
+	names[`model\.layers\.(\d+)\.block_sparse_moe\.experts\.(\d+)\.w1\.weight`] = "blk.$1.ffn_gate.$2.weight"
+	names[`model\.layers\.(\d+)\.block_sparse_moe\.experts\.(\d+)\.w2\.weight`] = "blk.$1.ffn_down.$2.weight"
+	names[`model\.layers\.(\d+)\.block_sparse_moe\.experts\.(\d+)\.w3\.weight`] = "blk.$1.ffn_up.$2.weight"
+	return newTensorNames(names)
+}()
+
+// mixtral converts llama models whose feed forward layers are a mixture of
+// experts. Each expert's tensors are written separately.
+type mixtral struct{}
+
+func (mixtral) KV(params *Params) llm.KV {
+	kv := llama{}.KV(params)
+	kv["llama.expert_count"] = uint32(params.Experts)
+	kv["llama.expert_used_count"] = uint32(params.ExpertsUsed)
+	return kv
+}
+
+func (mixtral) TensorName(n string) (string, error) {
+	return mixtralTensorNames.rename(n)
+}
+
+func (mixtral) Repack(n string, data []float32, shape []uint64, params *Params) ([]float32, error) {
+	return permuteQK(n, data, shape, params)
+}
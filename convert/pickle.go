@@ -0,0 +1,629 @@
+package convert
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// unpickler decodes the subset of Python's pickle format which torch.save
+// uses for state dicts. Unlike Python's unpickler it never runs code from the
+// pickle: globals can only be loaded from allowedGlobals, and calling one runs
+// its Go implementation.
+type unpickler struct {
+	r *bufio.Reader
+
+	// size is the size of the pickle and n the bytes read from it so far,
+	// which bounds the values in it
+	size int64
+	n    *countingReader
+
+	stack     []any
+	metastack [][]any
+	memo      map[int]any
+
+	// persistentLoad resolves the persistent ids of BINPERSID
+	persistentLoad func(pid any) (any, error)
+}
+
+// pickleGlobal is a class or function loaded from allowedGlobals
+type pickleGlobal struct {
+	module, name string
+}
+
+func (g pickleGlobal) String() string {
+	return g.module + "." + g.name
+}
+
+// pickleDict is a dict or OrderedDict which keeps the order of its keys
+type pickleDict struct {
+	keys   []string
+	values map[string]any
+}
+
+func (d *pickleDict) set(k, v any) error {
+	key, ok := k.(string)
+	if !ok {
+		return fmt.Errorf("unsupported dict key of type %T", k)
+	}
+
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+
+	d.values[key] = v
+	return nil
+}
+
+type pickleList struct {
+	items []any
+}
+
+// pickleTuple is a tuple, which is distinguished from a list since only
+// tuples can be the arguments of a call
+type pickleTuple []any
+
+// allowedGlobals are the globals a pickle may load. Functions are called
+// with the arguments of REDUCE, classes which aren't called have no function.
+var allowedGlobals = map[pickleGlobal]func(args pickleTuple) (any, error){
+	{"collections", "OrderedDict"}: func(args pickleTuple) (any, error) {
+		if len(args) > 0 {
+			return nil, errors.New("OrderedDict with arguments isn't supported")
+		}
+
+		return &pickleDict{values: make(map[string]any)}, nil
+	},
+	{"torch._utils", "_rebuild_tensor_v2"}: rebuildTensor,
+	{"torch._utils", "_rebuild_parameter"}: func(args pickleTuple) (any, error) {
+		if len(args) < 1 {
+			return nil, errors.New("_rebuild_parameter needs the parameter's data")
+		}
+
+		return args[0], nil
+	},
+	{"torch", "HalfStorage"}:     nil,
+	{"torch", "FloatStorage"}:    nil,
+	{"torch", "BFloat16Storage"}: nil,
+}
+
+const (
+	opMark            = '('
+	opStop            = '.'
+	opPop             = '0'
+	opBinFloat        = 'G'
+	opBinInt          = 'J'
+	opBinInt1         = 'K'
+	opBinInt2         = 'M'
+	opNone            = 'N'
+	opBinPersID       = 'Q'
+	opReduce          = 'R'
+	opBinString       = 'T'
+	opShortBinString  = 'U'
+	opBinUnicode      = 'X'
+	opAppend          = 'a'
+	opBuild           = 'b'
+	opGlobal          = 'c'
+	opAppends         = 'e'
+	opBinGet          = 'h'
+	opLongBinGet      = 'j'
+	opEmptyList       = ']'
+	opBinPut          = 'q'
+	opLongBinPut      = 'r'
+	opSetItem         = 's'
+	opTuple           = 't'
+	opSetItems        = 'u'
+	opEmptyDict       = '}'
+	opEmptyTuple      = ')'
+	opProto           = 0x80
+	opTuple1          = 0x85
+	opTuple2          = 0x86
+	opTuple3          = 0x87
+	opNewTrue         = 0x88
+	opNewFalse        = 0x89
+	opLong1           = 0x8a
+	opShortBinUnicode = 0x8c
+	opBinUnicode8     = 0x8d
+	opStackGlobal     = 0x93
+	opMemoize         = 0x94
+	opFrame           = 0x95
+)
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// unpickle decodes the pickle of size bytes in r
+func unpickle(r io.Reader, size int64, persistentLoad func(any) (any, error)) (any, error) {
+	cr := &countingReader{r: r}
+	u := unpickler{
+		r:              bufio.NewReader(cr),
+		size:           size,
+		n:              cr,
+		memo:           make(map[int]any),
+		persistentLoad: persistentLoad,
+	}
+
+	return u.load()
+}
+
+func (u *unpickler) push(v any) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) pop() (any, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("pickle stack underflow")
+	}
+
+	v := u.stack[len(u.stack)-1]
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+// popMark returns the items pushed since the last MARK
+func (u *unpickler) popMark() ([]any, error) {
+	if len(u.metastack) == 0 {
+		return nil, errors.New("pickle has no mark")
+	}
+
+	items := u.stack
+	u.stack = u.metastack[len(u.metastack)-1]
+	u.metastack = u.metastack[:len(u.metastack)-1]
+	return items, nil
+}
+
+func (u *unpickler) top() (any, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("pickle stack underflow")
+	}
+
+	return u.stack[len(u.stack)-1], nil
+}
+
+func (u *unpickler) read(n uint64) ([]byte, error) {
+	// guard against lengths which are longer than the rest of the pickle
+	if remaining := u.size - u.n.n + int64(u.r.Buffered()); n > uint64(max(remaining, 0)) {
+		return nil, fmt.Errorf("pickle value of %d bytes is larger than the rest of the pickle", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(u.r, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (u *unpickler) readUint(n int) (uint64, error) {
+	b, err := u.read(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+
+	return v, nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	line, err := u.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func (u *unpickler) load() (any, error) {
+	for {
+		op, err := u.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opProto:
+			if _, err := u.r.ReadByte(); err != nil {
+				return nil, err
+			}
+		case opFrame:
+			// frames only hint how much to buffer
+			if _, err := u.readUint(8); err != nil {
+				return nil, err
+			}
+		case opStop:
+			return u.pop()
+		case opMark:
+			u.metastack = append(u.metastack, u.stack)
+			u.stack = nil
+		case opPop:
+			if _, err := u.pop(); err != nil {
+				return nil, err
+			}
+		case opNone:
+			u.push(nil)
+		case opNewTrue:
+			u.push(true)
+		case opNewFalse:
+			u.push(false)
+		case opBinInt:
+			v, err := u.readUint(4)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(int64(int32(v)))
+		case opBinInt1:
+			v, err := u.readUint(1)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(int64(v))
+		case opBinInt2:
+			v, err := u.readUint(2)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(int64(v))
+		case opLong1:
+			n, err := u.readUint(1)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := u.read(n)
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := decodeLong(b)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(v)
+		case opBinFloat:
+			b, err := u.read(8)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case opShortBinString, opShortBinUnicode:
+			n, err := u.readUint(1)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := u.read(n)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(string(b))
+		case opBinString, opBinUnicode:
+			n, err := u.readUint(4)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := u.read(n)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(string(b))
+		case opBinUnicode8:
+			n, err := u.readUint(8)
+			if err != nil {
+				return nil, err
+			}
+
+			b, err := u.read(n)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(string(b))
+		case opEmptyTuple:
+			u.push(pickleTuple{})
+		case opTuple:
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(pickleTuple(items))
+		case opTuple1, opTuple2, opTuple3:
+			n := int(op-opTuple1) + 1
+			if len(u.stack) < n {
+				return nil, errors.New("pickle stack underflow")
+			}
+
+			items := make(pickleTuple, n)
+			copy(items, u.stack[len(u.stack)-n:])
+			u.stack = u.stack[:len(u.stack)-n]
+			u.push(items)
+		case opEmptyList:
+			u.push(&pickleList{})
+		case opAppend:
+			v, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := u.appendItems([]any{v}); err != nil {
+				return nil, err
+			}
+		case opAppends:
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := u.appendItems(items); err != nil {
+				return nil, err
+			}
+		case opEmptyDict:
+			u.push(&pickleDict{values: make(map[string]any)})
+		case opSetItem:
+			v, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			k, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := u.setItems([]any{k, v}); err != nil {
+				return nil, err
+			}
+		case opSetItems:
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := u.setItems(items); err != nil {
+				return nil, err
+			}
+		case opBinPut, opLongBinPut:
+			n := 1
+			if op == opLongBinPut {
+				n = 4
+			}
+
+			i, err := u.readUint(n)
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+
+			u.memo[int(i)] = v
+		case opMemoize:
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+
+			u.memo[len(u.memo)] = v
+		case opBinGet, opLongBinGet:
+			n := 1
+			if op == opLongBinGet {
+				n = 4
+			}
+
+			i, err := u.readUint(n)
+			if err != nil {
+				return nil, err
+			}
+
+			v, ok := u.memo[int(i)]
+			if !ok {
+				return nil, fmt.Errorf("pickle memo has no item %d", i)
+			}
+
+			u.push(v)
+		case opGlobal:
+			module, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+
+			name, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := u.global(module, name); err != nil {
+				return nil, err
+			}
+		case opStackGlobal:
+			name, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			module, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			m, ok1 := module.(string)
+			n, ok2 := name.(string)
+			if !ok1 || !ok2 {
+				return nil, errors.New("STACK_GLOBAL needs a module and name")
+			}
+
+			if err := u.global(m, n); err != nil {
+				return nil, err
+			}
+		case opReduce:
+			args, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			callable, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := call(callable, args)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(v)
+		case opBuild:
+			if _, err := u.pop(); err != nil {
+				return nil, err
+			}
+
+			// only dicts are built, and the state is their attributes such as
+			// a state dict's _metadata which isn't needed
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := v.(*pickleDict); !ok {
+				return nil, fmt.Errorf("can't set the state of %T", v)
+			}
+		case opBinPersID:
+			pid, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+
+			if u.persistentLoad == nil {
+				return nil, errors.New("pickle has persistent ids")
+			}
+
+			v, err := u.persistentLoad(pid)
+			if err != nil {
+				return nil, err
+			}
+
+			u.push(v)
+		default:
+			return nil, fmt.Errorf("unsupported pickle opcode %s", strconv.QuoteRune(rune(op)))
+		}
+	}
+}
+
+func (u *unpickler) global(module, name string) error {
+	g := pickleGlobal{module, name}
+	if _, ok := allowedGlobals[g]; !ok {
+		return fmt.Errorf("pickle loads %s which isn't allowed", g)
+	}
+
+	u.push(g)
+	return nil
+}
+
+func (u *unpickler) appendItems(items []any) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+
+	l, ok := v.(*pickleList)
+	if !ok {
+		return fmt.Errorf("can't append to %T", v)
+	}
+
+	l.items = append(l.items, items...)
+	return nil
+}
+
+func (u *unpickler) setItems(items []any) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+
+	d, ok := v.(*pickleDict)
+	if !ok {
+		return fmt.Errorf("can't set items of %T", v)
+	}
+
+	if len(items)%2 != 0 {
+		return errors.New("dict items must be pairs")
+	}
+
+	for i := 0; i < len(items); i += 2 {
+		if err := d.set(items[i], items[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func call(callable, args any) (any, error) {
+	g, ok := callable.(pickleGlobal)
+	if !ok {
+		return nil, fmt.Errorf("can't call %T", callable)
+	}
+
+	fn := allowedGlobals[g]
+	if fn == nil {
+		return nil, fmt.Errorf("can't call %s", g)
+	}
+
+	tuple, ok := args.(pickleTuple)
+	if !ok {
+		return nil, fmt.Errorf("arguments of %s must be a tuple", g)
+	}
+
+	return fn(tuple)
+}
+
+// decodeLong decodes a little endian two's complement integer
+func decodeLong(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+
+	n := new(big.Int).SetBytes(be)
+	if b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("integer %s is too large", n)
+	}
+
+	return n.Int64(), nil
+}
@@ -0,0 +1,254 @@
+package convert
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// Template is a Modelfile TEMPLATE and the SYSTEM message it's used with by
+// default, if any
+type Template struct {
+	Template string
+	System   string
+}
+
+// knownTemplates are the Modelfile templates of chat templates which can't be
+// translated, keyed by the sha256 of the chat template
+var knownTemplates = map[string]Template{
+	// Qwen1.5 chat, which adds a system message to conversations without one
+	"7c0cc400db493cfc546224cbd626ef46495c4b19103042eb5518f06bf7f1e26d": {
+		Template: "{{ if .System }}<|im_start|>system\n{{ .System }}<|im_end|>\n{{ end }}<|im_start|>user\n{{ .Prompt }}<|im_end|>\n<|im_start|>assistant\n{{ .Response }}<|im_end|>\n",
+		System:   "You are a helpful assistant",
+	},
+}
+
+var errUntranslatable = errors.New("chat template can't be translated")
+
+// placeholders are rendered in the chat template where the Modelfile template
+// has its variables
+const (
+	placeholderSystem   = "\x00system\x00"
+	placeholderPrompt   = "\x00prompt\x00"
+	placeholderResponse = "\x00response\x00"
+)
+
+// TranslateChatTemplate translates the Jinja chat template of a Hugging Face
+// tokenizer, which renders whole conversations, into a Modelfile TEMPLATE,
+// which renders one turn of a conversation at a time. The translation is
+// checked by rendering sample conversations with both templates. Chat
+// templates which can't be translated are looked up in knownTemplates.
+func TranslateChatTemplate(chatTemplate, eos string) (*Template, error) {
+	tmpl, err := translateChatTemplate(chatTemplate, eos)
+	if err == nil {
+		return &Template{Template: tmpl}, nil
+	}
+
+	if known, ok := knownTemplates[fmt.Sprintf("%x", sha256.Sum256([]byte(strings.TrimSpace(chatTemplate))))]; ok {
+		return &known, nil
+	}
+
+	return nil, err
+}
+
+func translateChatTemplate(chatTemplate, eos string) (string, error) {
+	jinja, err := parseJinja(chatTemplate)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errUntranslatable, err)
+	}
+
+	render := func(generate bool, messages ...string) (string, error) {
+		var msgs []any
+		for i := 0; i < len(messages); i += 2 {
+			msgs = append(msgs, map[string]any{"role": messages[i], "content": messages[i+1]})
+		}
+
+		// the bos token is added when the prompt is tokenized
+		return jinja.render(map[string]any{
+			"messages":              msgs,
+			"bos_token":             "",
+			"eos_token":             eos,
+			"add_generation_prompt": generate,
+		})
+	}
+
+	turn, err := render(false, "user", placeholderPrompt, "assistant", placeholderResponse)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errUntranslatable, err)
+	}
+
+	prefix, rest, ok := strings.Cut(turn, placeholderPrompt)
+	if !ok {
+		return "", fmt.Errorf("%w: it doesn't render the prompt", errUntranslatable)
+	}
+
+	if strings.Contains(rest, placeholderPrompt) || strings.Count(rest, placeholderResponse) != 1 {
+		return "", fmt.Errorf("%w: it renders the prompt or response out of order", errUntranslatable)
+	}
+
+	var sb strings.Builder
+
+	// templates which raise an exception for system messages don't support them
+	if system, err := render(false, "system", placeholderSystem, "user", placeholderPrompt, "assistant", placeholderResponse); err == nil && strings.Contains(system, placeholderSystem) {
+		systemPrefix, systemRest, ok := strings.Cut(system, placeholderPrompt)
+		if !ok || systemRest != rest || !strings.Contains(systemPrefix, placeholderSystem) {
+			return "", fmt.Errorf("%w: the system message changes the rest of the turn", errUntranslatable)
+		}
+
+		// the system message is rendered between what the turn's prefix starts
+		// and ends with, usually before or after all of it
+		var common, suffix string
+		switch {
+		case strings.HasSuffix(systemPrefix, prefix):
+			suffix = prefix
+		case strings.HasPrefix(systemPrefix, prefix):
+			common = prefix
+		default:
+			i := strings.Index(systemPrefix, placeholderSystem)
+			common = commonPrefix(prefix, systemPrefix)
+			common = common[:min(len(common), i)]
+			suffix = commonSuffix(prefix[len(common):], systemPrefix[len(common):])
+			suffix = suffix[max(0, len(suffix)-(len(systemPrefix)-i-len(placeholderSystem))):]
+		}
+
+		sb.WriteString(goText(common))
+		sb.WriteString("{{ if .System }}")
+		sb.WriteString(goText(systemPrefix[len(common) : len(systemPrefix)-len(suffix)]))
+		if noSystem := prefix[len(common) : len(prefix)-len(suffix)]; noSystem != "" {
+			sb.WriteString("{{ else }}")
+			sb.WriteString(goText(noSystem))
+		}
+
+		sb.WriteString("{{ end }}")
+		sb.WriteString(goText(suffix))
+	} else {
+		sb.WriteString(goText(prefix))
+	}
+
+	sb.WriteString("{{ .Prompt }}")
+	sb.WriteString(goText(rest))
+
+	tmpl := sb.String()
+	if err := checkTemplate(tmpl, render); err != nil {
+		return "", fmt.Errorf("%w: %w", errUntranslatable, err)
+	}
+
+	return tmpl, nil
+}
+
+// checkTemplate checks that a Modelfile template renders sample
+// conversations like the chat template
+func checkTemplate(tmpl string, render func(bool, ...string) (string, error)) error {
+	t, err := template.New("").Parse(tmpl)
+	if err != nil {
+		return err
+	}
+
+	// prompts for generating a response stop before the response
+	generate, err := template.New("").Parse(tmpl[:strings.Index(tmpl, "{{ .Response }}")])
+	if err != nil {
+		return err
+	}
+
+	execute := func(t *template.Template, system, prompt, response string) string {
+		var sb strings.Builder
+		if err := t.Execute(&sb, map[string]string{"System": system, "Prompt": prompt, "Response": response}); err != nil {
+			return err.Error()
+		}
+
+		return sb.String()
+	}
+
+	system := "You are a helpful assistant."
+	// messages without surrounding whitespace, which chat templates often trim
+	turns := [][2]string{
+		{"Why is the sky blue?", "Because of Rayleigh scattering."},
+		{"What is that?", "The scattering of light by small particles."},
+	}
+
+	samples := []struct {
+		system   bool
+		generate bool
+	}{
+		{system: false, generate: false},
+		{system: false, generate: true},
+		{system: true, generate: false},
+		{system: true, generate: true},
+	}
+
+	for _, sample := range samples {
+		var messages []string
+		if sample.system {
+			messages = append(messages, "system", system)
+		}
+
+		var expected strings.Builder
+		for i, turn := range turns {
+			s := ""
+			if i == 0 && sample.system {
+				s = system
+			}
+
+			messages = append(messages, "user", turn[0])
+			if sample.generate && i == len(turns)-1 {
+				expected.WriteString(execute(generate, s, turn[0], ""))
+				break
+			}
+
+			messages = append(messages, "assistant", turn[1])
+			expected.WriteString(execute(t, s, turn[0], turn[1]))
+		}
+
+		actual, err := render(sample.generate, messages...)
+		if sample.system && err != nil {
+			// the chat template doesn't support system messages
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// whitespace after the prompt for generating a response is where the
+		// response starts, which chat templates often leave out
+		if sample.generate {
+			actual = strings.TrimRightFunc(actual, unicode.IsSpace)
+			generated := strings.TrimRightFunc(expected.String(), unicode.IsSpace)
+			expected.Reset()
+			expected.WriteString(generated)
+		}
+
+		if actual != expected.String() {
+			return fmt.Errorf("the template renders %q instead of %q", expected.String(), actual)
+		}
+	}
+
+	return nil
+}
+
+// goText escapes text for a Go template and replaces the placeholders with
+// the template's variables
+func goText(s string) string {
+	s = strings.ReplaceAll(s, "{{", `{{ "{{" }}`)
+	s = strings.ReplaceAll(s, placeholderSystem, "{{ .System }}")
+	return strings.ReplaceAll(s, placeholderResponse, "{{ .Response }}")
+}
+
+func commonPrefix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return a[:i]
+}
+
+func commonSuffix(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && a[len(a)-1-i] == b[len(b)-1-i] {
+		i++
+	}
+
+	return a[len(a)-i:]
+}
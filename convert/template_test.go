@@ -0,0 +1,148 @@
+package convert
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const qwenChatTemplate = `{% for message in messages %}{% if loop.first and messages[0]['role'] != 'system' %}{{ '<|im_start|>system\nYou are a helpful assistant<|im_end|>\n' }}{% endif %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}`
+
+func TestTranslateChatTemplate(t *testing.T) {
+	cases := []struct {
+		name         string
+		chatTemplate string
+		template     string
+	}{
+		{
+			name:         "chatml",
+			chatTemplate: `{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}{% if add_generation_prompt %}{{ '<|im_start|>assistant\n' }}{% endif %}`,
+			template:     "{{ if .System }}<|im_start|>system\n{{ .System }}<|im_end|>\n{{ end }}<|im_start|>user\n{{ .Prompt }}<|im_end|>\n<|im_start|>assistant\n{{ .Response }}<|im_end|>\n",
+		},
+		{
+			name: "llama2",
+			chatTemplate: `{% if messages[0]['role'] == 'system' %}{% set loop_messages = messages[1:] %}{% set system_message = messages[0]['content'] %}{% else %}{% set loop_messages = messages %}{% set system_message = false %}{% endif %}` +
+				`{% for message in loop_messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}` +
+				`{% if loop.index0 == 0 and system_message != false %}{% set content = '<<SYS>>\n' + system_message + '\n<</SYS>>\n\n' + message['content'] %}{% else %}{% set content = message['content'] %}{% endif %}` +
+				`{% if message['role'] == 'user' %}{{ bos_token + '[INST] ' + content.strip() + ' [/INST]' }}{% elif message['role'] == 'assistant' %}{{ ' '  + content.strip() + ' ' + eos_token }}{% endif %}{% endfor %}`,
+			template: "[INST] {{ if .System }}<<SYS>>\n{{ .System }}\n<</SYS>>\n\n{{ end }}{{ .Prompt }} [/INST] {{ .Response }} </s>",
+		},
+		{
+			name: "mistral",
+			chatTemplate: `{{ bos_token }}{% for message in messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}` +
+				`{% if message['role'] == 'user' %}{{ '[INST] ' + message['content'] + ' [/INST]' }}{% elif message['role'] == 'assistant' %}{{ message['content'] + eos_token}}{% else %}{{ raise_exception('Only user and assistant roles are supported!') }}{% endif %}{% endfor %}`,
+			template: "[INST] {{ .Prompt }} [/INST]{{ .Response }}</s>",
+		},
+		{
+			name: "gemma",
+			chatTemplate: `{{ bos_token }}{% if messages[0]['role'] == 'system' %}{{ raise_exception('System role not supported') }}{% endif %}{% for message in messages %}{% if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}{{ raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}{% endif %}` +
+				`{% if (message['role'] == 'assistant') %}{% set role = 'model' %}{% else %}{% set role = message['role'] %}{% endif %}{{ '<start_of_turn>' + role + '\n' + message['content'] | trim + '<end_of_turn>\n' }}{% endfor %}{% if add_generation_prompt %}{{'<start_of_turn>model\n'}}{% endif %}`,
+			template: "<start_of_turn>user\n{{ .Prompt }}<end_of_turn>\n<start_of_turn>model\n{{ .Response }}<end_of_turn>\n",
+		},
+		{
+			name: "zephyr",
+			chatTemplate: `{% for message in messages %}
+{% if message['role'] == 'user' %}
+{{ '<|user|>
+' + message['content'] + eos_token }}
+{% elif message['role'] == 'system' %}
+{{ '<|system|>
+' + message['content'] + eos_token }}
+{% elif message['role'] == 'assistant' %}
+{{ '<|assistant|>
+'  + message['content'] + eos_token }}
+{% endif %}
+{% if loop.last and add_generation_prompt %}
+{{ '<|assistant|>' }}
+{% endif %}
+{% endfor %}`,
+			template: "{{ if .System }}<|system|>\n{{ .System }}</s>\n{{ end }}<|user|>\n{{ .Prompt }}</s>\n<|assistant|>\n{{ .Response }}</s>\n",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := TranslateChatTemplate(tt.chatTemplate, "</s>")
+			assert.Nil(t, err)
+			assert.Equal(t, &Template{Template: tt.template}, tmpl)
+		})
+	}
+
+	t.Run("known", func(t *testing.T) {
+		_, err := translateChatTemplate(qwenChatTemplate, "<|endoftext|>")
+		assert.ErrorIs(t, err, errUntranslatable)
+
+		tmpl, err := TranslateChatTemplate(qwenChatTemplate+"\n", "<|endoftext|>")
+		assert.Nil(t, err)
+		assert.Equal(t, "You are a helpful assistant", tmpl.System)
+
+		_, ok := knownTemplates[fmt.Sprintf("%x", sha256.Sum256([]byte(qwenChatTemplate)))]
+		assert.True(t, ok)
+	})
+
+	t.Run("untranslatable", func(t *testing.T) {
+		// the last message is rendered differently from the others
+		_, err := TranslateChatTemplate(`{% for message in messages %}{% if loop.last %}{{ '>> ' }}{% endif %}{{ message['content'] }}{% endfor %}`, "</s>")
+		assert.ErrorIs(t, err, errUntranslatable)
+
+		_, err = TranslateChatTemplate(`{% for message in messages %}{{ message.content | tojson }}{% endfor %}`, "</s>")
+		assert.ErrorIs(t, err, errUntranslatable)
+
+		_, err = TranslateChatTemplate(`{% for message in messages %}`, "</s>")
+		assert.ErrorIs(t, err, errUntranslatable)
+	})
+}
+
+func TestJinja(t *testing.T) {
+	cases := []struct {
+		template string
+		vars     map[string]any
+		expected string
+	}{
+		{"{{ a ~ b }}", map[string]any{"a": "x", "b": int64(1)}, "x1"},
+		{"  {%- if a %}yes{% else %}no{% endif -%}  \n", map[string]any{"a": ""}, "no"},
+		{"{% if a is defined %}{{ a }}{% endif %}!", nil, "!"},
+		{"{% for x in xs if x != 2 %}{{ loop.index }}:{{ x }}{% if not loop.last %},{% endif %}{% else %}empty{% endfor %}", map[string]any{"xs": []any{int64(1), int64(2), int64(3)}}, "1:1,2:3"},
+		{"{% for x in xs %}{% else %}empty{% endfor %}", map[string]any{"xs": []any{}}, "empty"},
+		// trim_blocks and lstrip_blocks
+		{"<ul>\n  {% for x in xs %}\n  <li>{{ x }}</li>\n  {% endfor %}\n</ul>", map[string]any{"xs": []any{"a", "b"}}, "<ul>\n  <li>a</li>\n  <li>b</li>\n</ul>"},
+		// sets in loops don't leak out of them
+		{"{% set n = 0 %}{% for x in xs %}{% set n = n + x %}{% endfor %}{{ n }}", map[string]any{"xs": []any{int64(1), int64(2)}}, "0"},
+		{"{{ (s[1:] | upper).strip() }}{{ d.get('k', 'v') }}", map[string]any{"s": " ab ", "d": map[string]any{}}, "ABv"},
+	}
+
+	for _, tt := range cases {
+		j, err := parseJinja(tt.template)
+		assert.Nil(t, err, tt.template)
+
+		actual, err := j.render(tt.vars)
+		assert.Nil(t, err, tt.template)
+		assert.Equal(t, tt.expected, actual, tt.template)
+	}
+
+	j, err := parseJinja("{{ raise_exception('no') }}")
+	assert.Nil(t, err)
+
+	_, err = j.render(nil)
+	assert.ErrorContains(t, err, "no")
+
+	// templates can't render without limit
+	for _, tmpl := range []string{
+		"{% for i in range(10000000000) %}{% endfor %}",
+		"{% for i in range(-9223372036854775807, 9223372036854775807) %}{% endfor %}",
+		"{% for i in range(256) %}{% for j in range(257) %}{% endfor %}{% endfor %}",
+		"{% for i in range(65536) %}{{ 'aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa' }}{% endfor %}",
+		"{% set a = range(60000) %}{% set a = a + a %}{% set a = a + a %}{% set a = a + a %}{{ a }}",
+	} {
+		j, err := parseJinja(tmpl)
+		assert.Nil(t, err, tmpl)
+
+		_, err = j.render(map[string]any{})
+		assert.ErrorIs(t, err, errJinjaLimit, tmpl)
+
+		_, err = TranslateChatTemplate(tmpl, "</s>")
+		assert.ErrorIs(t, err, errUntranslatable, tmpl)
+	}
+}
@@ -0,0 +1,218 @@
+package convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+// tokenizer is the part of a Hugging Face tokenizer.json which describes the
+// vocab
+type tokenizer struct {
+	Model struct {
+		Type   string          `json:"type"`
+		Vocab  map[string]int  `json:"vocab"`
+		Merges json.RawMessage `json:"merges"`
+	} `json:"model"`
+
+	AddedTokens []struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+}
+
+// tokenizerConfig is the part of a Hugging Face tokenizer_config.json which
+// names the special tokens
+type tokenizerConfig struct {
+	BOSToken specialToken `json:"bos_token"`
+	EOSToken specialToken `json:"eos_token"`
+	UNKToken specialToken `json:"unk_token"`
+	PADToken specialToken `json:"pad_token"`
+
+	AddBOSToken *bool `json:"add_bos_token"`
+	AddEOSToken *bool `json:"add_eos_token"`
+
+	ChatTemplate chatTemplate `json:"chat_template"`
+}
+
+// chatTemplate is a Jinja chat template, which is either a string or a list
+// of named templates of which the default one is used
+type chatTemplate string
+
+func (t *chatTemplate) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = chatTemplate(s)
+		return nil
+	}
+
+	var templates []struct {
+		Name     string `json:"name"`
+		Template string `json:"template"`
+	}
+
+	if err := json.Unmarshal(b, &templates); err != nil {
+		return err
+	}
+
+	for _, template := range templates {
+		if template.Name == "default" {
+			*t = chatTemplate(template.Template)
+		}
+	}
+
+	return nil
+}
+
+// loadTokenizerConfig reads the tokenizer_config.json in fsys, which is
+// optional
+func loadTokenizerConfig(fsys fs.FS) (*tokenizerConfig, error) {
+	bts, err := fs.ReadFile(fsys, "tokenizer_config.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return &tokenizerConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var config tokenizerConfig
+	if err := json.Unmarshal(bts, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// specialToken is the content of a special token, which is either a string or
+// an object with the content
+type specialToken string
+
+func (t *specialToken) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = specialToken(s)
+		return nil
+	}
+
+	var token struct {
+		Content string `json:"content"`
+	}
+
+	if err := json.Unmarshal(b, &token); err != nil {
+		return err
+	}
+
+	*t = specialToken(token.Content)
+	return nil
+}
+
+// loadTokenizerJSON reads the byte level bpe vocab of a tokenizer.json and
+// the special tokens of its tokenizer_config.json
+func loadTokenizerJSON(fsys fs.FS) (*Vocab, error) {
+	slog.Info("reading vocab from tokenizer.json")
+	bts, err := fs.ReadFile(fsys, "tokenizer.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("no tokenizer.model or tokenizer.json found")
+	} else if err != nil {
+		return nil, err
+	}
+
+	var t tokenizer
+	if err := json.Unmarshal(bts, &t); err != nil {
+		return nil, err
+	}
+
+	if t.Model.Type != "BPE" {
+		return nil, fmt.Errorf("unsupported tokenizer type %q", t.Model.Type)
+	}
+
+	ids := make(map[string]int)
+	types := make(map[int]int32)
+	for token, id := range t.Model.Vocab {
+		ids[token] = id
+		types[id] = int32(llm.GGUFTokenNormal)
+	}
+
+	for _, token := range t.AddedTokens {
+		ids[token.Content] = token.ID
+		if token.Special {
+			types[token.ID] = int32(llm.GGUFTokenControl)
+		} else {
+			types[token.ID] = int32(llm.GGUFTokenUserDefined)
+		}
+	}
+
+	size := 0
+	for _, id := range ids {
+		size = max(size, id+1)
+	}
+
+	v := &Vocab{
+		Model:  "gpt2",
+		Tokens: make([]string, size),
+		Types:  make([]int32, size),
+	}
+
+	// ids which aren't used are filled with padding tokens
+	for id := range size {
+		v.Tokens[id] = fmt.Sprintf("[PAD%d]", id)
+		v.Types[id] = int32(llm.GGUFTokenUserDefined)
+	}
+
+	for token, id := range ids {
+		v.Tokens[id] = token
+		v.Types[id] = types[id]
+	}
+
+	// merges are either "a b" or ["a", "b"]
+	var merges []string
+	if err := json.Unmarshal(t.Model.Merges, &merges); err != nil {
+		merges = nil
+
+		var pairs [][2]string
+		if err := json.Unmarshal(t.Model.Merges, &pairs); err != nil {
+			return nil, fmt.Errorf("invalid merges: %w", err)
+		}
+
+		for _, pair := range pairs {
+			merges = append(merges, strings.Join(pair[:], " "))
+		}
+	}
+
+	v.Merges = merges
+
+	slog.Info(fmt.Sprintf("vocab size: %d", len(v.Tokens)))
+
+	config, err := loadTokenizerConfig(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	v.AddBOS, v.AddEOS = config.AddBOSToken, config.AddEOSToken
+
+	v.SpecialIDs = make(map[string]int)
+	for name, token := range map[string]specialToken{
+		"bos":     config.BOSToken,
+		"eos":     config.EOSToken,
+		"unknown": config.UNKToken,
+		"padding": config.PADToken,
+	} {
+		if token == "" {
+			continue
+		}
+
+		id, ok := ids[string(token)]
+		if !ok {
+			return nil, fmt.Errorf("special token %q isn't in the vocab", token)
+		}
+
+		v.SpecialIDs[name] = id
+	}
+
+	return v, nil
+}
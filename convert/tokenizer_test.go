@@ -0,0 +1,88 @@
+package convert
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+func TestLoadTokenizerJSON(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := LoadTokens(os.DirFS(dir))
+	assert.ErrorContains(t, err, "no tokenizer.model or tokenizer.json")
+
+	// id 3 isn't used
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "tokenizer.json"), []byte(`{
+		"added_tokens": [
+			{"id": 4, "content": "<|begin|>", "special": true},
+			{"id": 5, "content": "<|end|>", "special": true},
+			{"id": 6, "content": "<tool>", "special": false}
+		],
+		"model": {
+			"type": "BPE",
+			"vocab": {"a": 0, "b": 1, "ab": 2},
+			"merges": [["a", "b"]]
+		}
+	}`), 0o644))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "tokenizer_config.json"), []byte(`{
+		"add_bos_token": true,
+		"bos_token": "<|begin|>",
+		"eos_token": {"content": "<|end|>", "lstrip": false},
+		"pad_token": null,
+		"chat_template": [
+			{"name": "default", "template": "{{ messages[0].content }}"},
+			{"name": "tool_use", "template": "{{ tools }}"}
+		]
+	}`), 0o644))
+
+	vocab, err := LoadTokens(os.DirFS(dir))
+	assert.Nil(t, err)
+	assert.Equal(t, "gpt2", vocab.Model)
+	assert.Equal(t, []string{"a", "b", "ab", "[PAD3]", "<|begin|>", "<|end|>", "<tool>"}, vocab.Tokens)
+	assert.Equal(t, []int32{
+		int32(llm.GGUFTokenNormal),
+		int32(llm.GGUFTokenNormal),
+		int32(llm.GGUFTokenNormal),
+		int32(llm.GGUFTokenUserDefined),
+		int32(llm.GGUFTokenControl),
+		int32(llm.GGUFTokenControl),
+		int32(llm.GGUFTokenUserDefined),
+	}, vocab.Types)
+	assert.Equal(t, []string{"a b"}, vocab.Merges)
+	assert.Equal(t, map[string]int{"bos": 4, "eos": 5}, vocab.SpecialIDs)
+	assert.Equal(t, "{{ messages[0].content }}", vocab.ChatTemplate)
+
+	params := &Params{Architectures: []string{"LlamaForCausalLM"}, HiddenSize: 8, AttentionHeads: 2, BoSTokenID: 1, EoSTokenID: 2}
+	conv, err := GetConverter(params)
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	assert.Nil(t, WriteGGUF(&b, "bpe", conv, nil, params, vocab, WriteOptions{}))
+
+	ggml, err := llm.DecodeGGML(bytes.NewReader(b.Bytes()))
+	assert.Nil(t, err)
+
+	kv := ggml.KV()
+	assert.Equal(t, "gpt2", kv["tokenizer.ggml.model"])
+	assert.Equal(t, []any{"a b"}, kv["tokenizer.ggml.merges"])
+	assert.Equal(t, uint32(4), kv["tokenizer.ggml.bos_token_id"])
+	assert.Equal(t, uint32(5), kv["tokenizer.ggml.eos_token_id"])
+	assert.Equal(t, true, kv["tokenizer.ggml.add_bos_token"])
+	assert.Equal(t, "{{ messages[0].content }}", kv["tokenizer.chat_template"])
+	assert.NotContains(t, kv, "tokenizer.ggml.scores")
+	assert.NotContains(t, kv, "tokenizer.ggml.unknown_token_id")
+
+	// merges may also be strings
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "tokenizer.json"), []byte(`{"model": {"type": "BPE", "vocab": {"a": 0, "b": 1, "ab": 2}, "merges": ["a b"]}}`), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "tokenizer_config.json"), []byte(`{"eos_token": "<|missing|>"}`), 0o644))
+
+	_, err = LoadTokens(os.DirFS(dir))
+	assert.ErrorContains(t, err, "isn't in the vocab")
+}
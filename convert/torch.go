@@ -0,0 +1,280 @@
+package convert
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/jmorganca/ollama/llm"
+)
+
+// torchDtypes are the dtypes of the storage classes allowed in checkpoints
+var torchDtypes = map[pickleGlobal]string{
+	{"torch", "HalfStorage"}:     "F16",
+	{"torch", "FloatStorage"}:    "F32",
+	{"torch", "BFloat16Storage"}: "BF16",
+}
+
+// torchStorage is the data of one or more tensors, which is a file in the
+// checkpoint's archive
+type torchStorage struct {
+	dtype string
+	key   string
+}
+
+type torchTensor struct {
+	storage *torchStorage
+	offset  int64
+	size    []int
+	stride  []int
+}
+
+// rebuildTensor is torch._utils._rebuild_tensor_v2(storage, storage_offset,
+// size, stride, requires_grad, backward_hooks)
+func rebuildTensor(args pickleTuple) (any, error) {
+	if len(args) < 4 {
+		return nil, errors.New("_rebuild_tensor_v2 needs a storage, offset, size and stride")
+	}
+
+	storage, ok := args[0].(*torchStorage)
+	if !ok {
+		return nil, fmt.Errorf("tensor storage is %T", args[0])
+	}
+
+	offset, ok := args[1].(int64)
+	if !ok {
+		return nil, fmt.Errorf("tensor offset is %T", args[1])
+	} else if offset < 0 {
+		return nil, fmt.Errorf("tensor offset %d is negative", offset)
+	}
+
+	size, err := ints(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	stride, err := ints(args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(size) != len(stride) {
+		return nil, fmt.Errorf("tensor has %d dimensions but %d strides", len(size), len(stride))
+	}
+
+	return &torchTensor{storage: storage, offset: offset, size: size, stride: stride}, nil
+}
+
+func ints(v any) ([]int, error) {
+	tuple, ok := v.(pickleTuple)
+	if !ok {
+		return nil, fmt.Errorf("expected a tuple of integers, got %T", v)
+	}
+
+	s := make([]int, len(tuple))
+	for i, item := range tuple {
+		n, ok := item.(int64)
+		if !ok {
+			return nil, fmt.Errorf("expected a tuple of integers, got %T", item)
+		} else if n < 0 {
+			return nil, fmt.Errorf("expected a tuple of non-negative integers, got %d", n)
+		}
+
+		s[i] = int(n)
+	}
+
+	return s, nil
+}
+
+// contiguous reports whether the tensor's elements are stored in row major
+// order with nothing between them
+func (t *torchTensor) contiguous() bool {
+	expected := 1
+	for i := len(t.size) - 1; i >= 0; i-- {
+		if t.size[i] != 1 && t.stride[i] != expected {
+			return false
+		}
+
+		expected *= t.size[i]
+	}
+
+	return true
+}
+
+// ReadTorch reads the tensors of a PyTorch checkpoint saved by torch.save,
+// which is a zip archive of a pickled state dict and the tensors' storages.
+// The pickle may only build tensors, nothing in it is run.
+func ReadTorch(fsys fs.FS, fn string, offset uint64, conv Converter, params *Params) ([]llm.Tensor, uint64, error) {
+	f, err := fsys.Open(fn)
+	if err != nil {
+		return []llm.Tensor{}, 0, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return []llm.Tensor{}, 0, err
+	}
+
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		return []llm.Tensor{}, 0, fmt.Errorf("%s can't be read in place, it mustn't be compressed", fn)
+	}
+
+	r, err := zip.NewReader(ra, fi.Size())
+	if errors.Is(err, zip.ErrFormat) {
+		return []llm.Tensor{}, 0, fmt.Errorf("%s isn't a zip archive, checkpoints saved before PyTorch 1.6 aren't supported", fn)
+	} else if err != nil {
+		return []llm.Tensor{}, 0, err
+	}
+
+	files := make(map[string]*zip.File)
+	var pkl *zip.File
+	for _, f := range r.File {
+		files[f.Name] = f
+		if path.Base(f.Name) == "data.pkl" {
+			pkl = f
+		}
+	}
+
+	if pkl == nil {
+		return []llm.Tensor{}, 0, fmt.Errorf("%s has no data.pkl", fn)
+	}
+
+	prefix := path.Dir(pkl.Name)
+	if f, ok := files[path.Join(prefix, "byteorder")]; ok {
+		rc, err := f.Open()
+		if err != nil {
+			return []llm.Tensor{}, 0, err
+		}
+
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return []llm.Tensor{}, 0, err
+		}
+
+		if strings.TrimSpace(string(b)) != "little" {
+			return []llm.Tensor{}, 0, fmt.Errorf("%s is %s endian", fn, b)
+		}
+	}
+
+	rc, err := pkl.Open()
+	if err != nil {
+		return []llm.Tensor{}, 0, err
+	}
+	defer rc.Close()
+
+	// persistent ids are ('storage', storage_type, key, location, numel)
+	storages := make(map[string]*torchStorage)
+	root, err := unpickle(rc, int64(pkl.UncompressedSize64), func(pid any) (any, error) {
+		tuple, ok := pid.(pickleTuple)
+		if !ok || len(tuple) < 3 || tuple[0] != "storage" {
+			return nil, fmt.Errorf("unsupported persistent id %v", pid)
+		}
+
+		storageType, _ := tuple[1].(pickleGlobal)
+		dtype, ok := torchDtypes[storageType]
+		if !ok {
+			return nil, fmt.Errorf("unsupported storage type %v", tuple[1])
+		}
+
+		key, ok := tuple[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("storage key is %T", tuple[2])
+		}
+
+		if s, ok := storages[key]; ok {
+			return s, nil
+		}
+
+		storages[key] = &torchStorage{dtype: dtype, key: key}
+		return storages[key], nil
+	})
+	if err != nil {
+		return []llm.Tensor{}, 0, fmt.Errorf("couldn't read %s: %w", fn, err)
+	}
+
+	stateDict, ok := root.(*pickleDict)
+	if !ok {
+		return []llm.Tensor{}, 0, fmt.Errorf("%s isn't a state dict", fn)
+	}
+
+	keys := slices.Clone(stateDict.keys)
+	slices.Sort(keys)
+
+	slog.Info("converting layers")
+
+	var tensors []llm.Tensor
+	for _, k := range keys {
+		tt, ok := stateDict.values[k].(*torchTensor)
+		if !ok {
+			// other values are metadata
+			continue
+		}
+
+		if !tt.contiguous() {
+			return []llm.Tensor{}, 0, fmt.Errorf("tensor '%s' isn't contiguous", k)
+		}
+
+		f, ok := files[path.Join(prefix, "data", tt.storage.key)]
+		if !ok {
+			return []llm.Tensor{}, 0, fmt.Errorf("tensor '%s' has no data", k)
+		}
+
+		// tensors are read from the archive directly so they mustn't be compressed
+		if f.Method != zip.Store {
+			return []llm.Tensor{}, 0, fmt.Errorf("tensor '%s' is compressed", k)
+		}
+
+		dataOffset, err := f.DataOffset()
+		if err != nil {
+			return []llm.Tensor{}, 0, err
+		}
+
+		elementSize := int64(2)
+		if tt.storage.dtype == "F32" {
+			elementSize = 4
+		}
+
+		// the tensor must be within its storage, which also keeps the
+		// offsets below from overflowing
+		storageElements := int64(f.UncompressedSize64) / elementSize
+		if tt.offset > storageElements {
+			return []llm.Tensor{}, 0, fmt.Errorf("tensor '%s' is larger than its storage", k)
+		}
+
+		elements := int64(1)
+		for _, n := range tt.size {
+			if n > 0 && elements > storageElements/int64(n) {
+				return []llm.Tensor{}, 0, fmt.Errorf("tensor '%s' is larger than its storage", k)
+			}
+
+			elements *= int64(n)
+		}
+
+		start := dataOffset + tt.offset*elementSize
+		end := start + elements*elementSize
+		if start < dataOffset || end > dataOffset+int64(f.UncompressedSize64) {
+			return []llm.Tensor{}, 0, fmt.Errorf("tensor '%s' is larger than its storage", k)
+		}
+
+		t, size, err := newTensor(k, tt.size, tt.storage.dtype, fsys, fn, start, end-start, offset, conv, params)
+		if errors.Is(err, errNotTensor) {
+			continue
+		} else if err != nil {
+			return []llm.Tensor{}, 0, err
+		}
+
+		tensors = append(tensors, t)
+		offset += size
+	}
+
+	return tensors, offset, nil
+}
@@ -0,0 +1,225 @@
+package convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/x448/float16"
+)
+
+// pickler writes the opcodes torch.save uses for a state dict
+type pickler struct {
+	bytes.Buffer
+}
+
+func (p *pickler) global(module, name string) {
+	p.WriteByte(opGlobal)
+	p.WriteString(module + "\n" + name + "\n")
+}
+
+func (p *pickler) str(s string) {
+	p.WriteByte(opBinUnicode)
+	binary.Write(p, binary.LittleEndian, uint32(len(s)))
+	p.WriteString(s)
+}
+
+func (p *pickler) int(n int) {
+	p.WriteByte(opBinInt)
+	binary.Write(p, binary.LittleEndian, int32(n))
+}
+
+func (p *pickler) tuple(ns []int) {
+	p.WriteByte(opMark)
+	for _, n := range ns {
+		p.int(n)
+	}
+	p.WriteByte(opTuple)
+}
+
+// torchCheckpoint writes the F16 tensors of shapes to a PyTorch checkpoint in
+// dir. All tensors share one storage, like the views of a tensor would.
+func torchCheckpoint(t *testing.T, dir string, shapes map[string][]int) {
+	var names []string
+	for name := range shapes {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	var p pickler
+	p.Write([]byte{opProto, 2})
+	p.global("collections", "OrderedDict")
+	p.WriteByte(opEmptyTuple)
+	p.WriteByte(opReduce)
+
+	var data bytes.Buffer
+	for _, name := range names {
+		shape := shapes[name]
+		n := 1
+		for _, d := range shape {
+			n *= d
+		}
+
+		offset := data.Len() / 2
+		for i := range n {
+			binary.Write(&data, binary.LittleEndian, float16.Fromfloat32(float32(i%17-8)/8).Bits())
+		}
+
+		stride := make([]int, len(shape))
+		s := 1
+		for i := len(shape) - 1; i >= 0; i-- {
+			stride[i] = s
+			s *= shape[i]
+		}
+
+		p.str(name)
+		p.global("torch._utils", "_rebuild_tensor_v2")
+		p.WriteByte(opMark)
+
+		// the persistent id of the storage
+		p.WriteByte(opMark)
+		p.str("storage")
+		p.global("torch", "HalfStorage")
+		p.str("0")
+		p.str("cpu")
+		p.int(0)
+		p.WriteByte(opTuple)
+		p.WriteByte(opBinPersID)
+
+		p.int(offset)
+		p.tuple(shape)
+		p.tuple(stride)
+		p.WriteByte(opNewFalse)
+		p.global("collections", "OrderedDict")
+		p.WriteByte(opEmptyTuple)
+		p.WriteByte(opReduce)
+		p.WriteByte(opTuple)
+		p.WriteByte(opReduce)
+		p.WriteByte(opSetItem)
+	}
+
+	p.WriteByte(opStop)
+
+	f, err := os.Create(filepath.Join(dir, "pytorch_model.bin"))
+	assert.Nil(t, err)
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, b := range map[string][]byte{
+		"archive/data.pkl":  p.Bytes(),
+		"archive/byteorder": []byte("little"),
+		"archive/data/0":    data.Bytes(),
+	} {
+		zf, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		assert.Nil(t, err)
+
+		_, err = zf.Write(b)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, w.Close())
+}
+
+func TestReadTorch(t *testing.T) {
+	params := Params{
+		Architectures:    []string{"LlamaForCausalLM"},
+		VocabSize:        4,
+		HiddenSize:       8,
+		HiddenLayers:     1,
+		ContextSize:      64,
+		IntermediateSize: 16,
+		AttentionHeads:   2,
+		NormEPS:          1e-5,
+		BoSTokenID:       1,
+		EoSTokenID:       2,
+	}
+
+	shapes := layerShapes(0, 8, 8, 16)
+	shapes["model.embed_tokens.weight"] = []int{4, 8}
+	shapes["model.norm.weight"] = []int{8}
+	shapes["lm_head.weight"] = []int{4, 8}
+
+	// the same model as the llama safetensors checkpoint
+	dir := checkpoint(t, params, "F16", shapes)
+	assert.Nil(t, os.Remove(filepath.Join(dir, "model-00001-of-00001.safetensors")))
+	torchCheckpoint(t, dir, shapes)
+
+	p, err := GetParams(os.DirFS(dir))
+	assert.Nil(t, err)
+
+	conv, err := GetConverter(p)
+	assert.Nil(t, err)
+
+	tensors, err := GetTensors(os.DirFS(dir), conv, p)
+	assert.Nil(t, err)
+
+	vocab, err := LoadTokens(os.DirFS(dir))
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	assert.Nil(t, WriteGGUF(&b, "llama", conv, tensors, p, vocab, WriteOptions{}))
+
+	bts, err := json.Marshal(decodeGolden(t, b.Bytes()))
+	assert.Nil(t, err)
+
+	expected, err := os.ReadFile(filepath.Join("testdata", "llama.json"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(bts))
+}
+
+func TestUnpickleGlobals(t *testing.T) {
+	// os.system("echo") must never be called
+	var p pickler
+	p.Write([]byte{opProto, 2})
+	p.global("os", "system")
+	p.WriteByte(opMark)
+	p.str("echo")
+	p.WriteByte(opTuple)
+	p.WriteByte(opReduce)
+	p.WriteByte(opStop)
+
+	_, err := unpickle(&p, int64(p.Len()), nil)
+	assert.ErrorContains(t, err, "os.system which isn't allowed")
+
+	// storages can be loaded but not called
+	p.Reset()
+	p.Write([]byte{opProto, 2})
+	p.global("torch", "HalfStorage")
+	p.WriteByte(opEmptyTuple)
+	p.WriteByte(opReduce)
+	p.WriteByte(opStop)
+
+	_, err = unpickle(&p, int64(p.Len()), nil)
+	assert.ErrorContains(t, err, "can't call torch.HalfStorage")
+}
+
+func TestUnpickleLength(t *testing.T) {
+	// a string which claims to be longer than the pickle isn't allocated
+	var p pickler
+	p.Write([]byte{opProto, 2})
+	p.WriteByte(opBinUnicode)
+	binary.Write(&p, binary.LittleEndian, uint32(1<<31))
+	p.WriteString("abc")
+	p.WriteByte(opStop)
+
+	_, err := unpickle(&p, int64(p.Len()), nil)
+	assert.ErrorContains(t, err, "pickle value of 2147483648 bytes is larger than the rest of the pickle")
+}
+
+func TestReadTorchInvalid(t *testing.T) {
+	dir := t.TempDir()
+	torchCheckpoint(t, dir, map[string][]int{"lm_head.weight": {-4, 8}})
+
+	_, _, err := ReadTorch(os.DirFS(dir), "pytorch_model.bin", 0, nil, &Params{})
+	assert.ErrorContains(t, err, "expected a tuple of non-negative integers, got -4")
+
+	_, err = rebuildTensor(pickleTuple{&torchStorage{dtype: "F16"}, int64(-1), pickleTuple{int64(4)}, pickleTuple{int64(1)}})
+	assert.ErrorContains(t, err, "tensor offset -1 is negative")
+}
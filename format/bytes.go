@@ -3,6 +3,9 @@ package format
 import (
 	"fmt"
 	"math"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
 const (
@@ -45,3 +48,31 @@ func HumanBytes(b int64) string {
 		return fmt.Sprintf("%d %s", int(value), unit)
 	}
 }
+
+// ParseBytes parses a size such as "512", "10KB" or "1.5G" into bytes. Units
+// are decimal like HumanBytes and may be lowercase or omit the trailing B.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	value := strings.TrimRightFunc(s, unicode.IsLetter)
+	unit := strings.ToUpper(strings.TrimSpace(s[len(value):]))
+
+	multiplier := map[string]int64{
+		"": Byte, "B": Byte,
+		"K": KiloByte, "KB": KiloByte,
+		"M": MegaByte, "MB": MegaByte,
+		"G": GigaByte, "GB": GigaByte,
+		"T": TeraByte, "TB": TeraByte,
+	}
+
+	m, ok := multiplier[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(f * float64(m)), nil
+}
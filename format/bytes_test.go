@@ -0,0 +1,31 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	cases := map[string]int64{
+		"512":    512,
+		"10KB":   10 * KiloByte,
+		"10 kb":  10 * KiloByte,
+		"1.5G":   1500 * MegaByte,
+		"100M":   100 * MegaByte,
+		"2TB":    2 * TeraByte,
+		" 64B  ": 64,
+	}
+
+	for s, want := range cases {
+		got, err := ParseBytes(s)
+		if err != nil {
+			t.Errorf("ParseBytes(%q): %v", s, err)
+		}
+		assertEqual(t, got, want)
+	}
+
+	for _, s := range []string{"", "MB", "10XB", "-1", "ten"} {
+		if _, err := ParseBytes(s); err == nil {
+			t.Errorf("ParseBytes(%q): expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package gguf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidMagic       = errors.New("not a gguf file")
+	ErrUnsupportedVersion = errors.New("unsupported gguf version")
+)
+
+// decoder reads a file in order, counting its offset
+type decoder struct {
+	r       *bufio.Reader
+	order   binary.ByteOrder
+	version uint32
+
+	offset int64
+	size   int64
+}
+
+func (d *decoder) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	d.offset += int64(n)
+	return n, err
+}
+
+func read[T any](d *decoder) (T, error) {
+	var v T
+	err := binary.Read(d, d.order, &v)
+	return v, err
+}
+
+// readLength reads the length of a string or array, which is 32-bit in
+// version 1, and checks the file has at least size bytes for each element
+func (d *decoder) readLength(size int64) (uint64, error) {
+	var n uint64
+	if d.version == 1 {
+		n32, err := read[uint32](d)
+		if err != nil {
+			return 0, err
+		}
+
+		n = uint64(n32)
+	} else {
+		var err error
+		if n, err = read[uint64](d); err != nil {
+			return 0, err
+		}
+	}
+
+	if hi, lo := bits.Mul64(n, uint64(size)); hi != 0 || lo > uint64(d.size-d.offset) {
+		return 0, fmt.Errorf("length %d at offset %d is past the end of the file: %w", n, d.offset, io.ErrUnexpectedEOF)
+	}
+
+	return n, nil
+}
+
+func (d *decoder) readString() (string, error) {
+	n, err := d.readLength(1)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d, b); err != nil {
+		return "", err
+	}
+
+	s := string(b)
+	if d.version == 1 {
+		// version 1 strings are null-terminated
+		s = strings.TrimSuffix(s, "\x00")
+	}
+
+	return s, nil
+}
+
+func readArray[T any](d *decoder) ([]T, error) {
+	var zero T
+	n, err := d.readLength(int64(binary.Size(zero)))
+	if err != nil {
+		return nil, err
+	}
+
+	s := make([]T, n)
+	if err := binary.Read(d, d.order, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (d *decoder) readValue(t Type) (any, error) {
+	switch t {
+	case TypeUint8:
+		return read[uint8](d)
+	case TypeInt8:
+		return read[int8](d)
+	case TypeUint16:
+		return read[uint16](d)
+	case TypeInt16:
+		return read[int16](d)
+	case TypeUint32:
+		return read[uint32](d)
+	case TypeInt32:
+		return read[int32](d)
+	case TypeFloat32:
+		return read[float32](d)
+	case TypeBool:
+		return read[bool](d)
+	case TypeString:
+		return d.readString()
+	case TypeUint64:
+		return read[uint64](d)
+	case TypeInt64:
+		return read[int64](d)
+	case TypeFloat64:
+		return read[float64](d)
+	case TypeArray:
+		return d.readArray()
+	default:
+		return nil, fmt.Errorf("invalid type %d", uint32(t))
+	}
+}
+
+func (d *decoder) readArray() (any, error) {
+	t, err := read[Type](d)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case TypeUint8:
+		return readArray[uint8](d)
+	case TypeInt8:
+		return readArray[int8](d)
+	case TypeUint16:
+		return readArray[uint16](d)
+	case TypeInt16:
+		return readArray[int16](d)
+	case TypeUint32:
+		return readArray[uint32](d)
+	case TypeInt32:
+		return readArray[int32](d)
+	case TypeFloat32:
+		return readArray[float32](d)
+	case TypeBool:
+		return readArray[bool](d)
+	case TypeUint64:
+		return readArray[uint64](d)
+	case TypeInt64:
+		return readArray[int64](d)
+	case TypeFloat64:
+		return readArray[float64](d)
+	case TypeString:
+		// each string has at least its length
+		n, err := d.readLength(4)
+		if err != nil {
+			return nil, err
+		}
+
+		s := make([]string, n)
+		for i := range s {
+			if s[i], err = d.readString(); err != nil {
+				return nil, err
+			}
+		}
+
+		return s, nil
+	case TypeArray:
+		return nil, errors.New("arrays of arrays aren't supported")
+	default:
+		return nil, fmt.Errorf("invalid array type %d", uint32(t))
+	}
+}
+
+// Decode decodes the GGUF file of size bytes in ra. The data of its tensors
+// is read from ra as it's needed so ra must stay open while the file is used.
+func Decode(ra io.ReaderAt, size int64) (*File, error) {
+	d := decoder{r: bufio.NewReader(io.NewSectionReader(ra, 0, size)), size: size}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(&d, magic); err != nil {
+		return nil, err
+	}
+
+	if string(magic) != "GGUF" {
+		return nil, ErrInvalidMagic
+	}
+
+	// the version is the first value in the file's byte order, which is
+	// big endian if its low bytes are empty
+	var f File
+	d.order = binary.LittleEndian
+	version, err := read[uint32](&d)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != 0 && version&0xffff == 0 {
+		d.order = binary.BigEndian
+		version = bits.ReverseBytes32(version)
+	}
+
+	if version < 1 || version > 3 {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+
+	d.version = version
+	f.ByteOrder = d.order
+	f.Version = version
+
+	// a key-value has at least a key length and a type, and a tensor a name
+	// length, dimensions, type and offset
+	numTensor, err := d.readLength(4 + 4 + 8)
+	if err != nil {
+		return nil, err
+	}
+
+	numKV, err := d.readLength(4 + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	for range numKV {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := read[Type](&d)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := d.readValue(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		f.KV = append(f.KV, KV{Key: key, Value: v})
+	}
+
+	for range numTensor {
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		dims, err := read[uint32](&d)
+		if err != nil {
+			return nil, err
+		}
+
+		if int64(dims)*4 > d.size-d.offset {
+			return nil, fmt.Errorf("tensor %s has %d dimensions: %w", name, dims, io.ErrUnexpectedEOF)
+		}
+
+		shape := make([]uint64, dims)
+		for i := range shape {
+			if version == 1 {
+				n, err := read[uint32](&d)
+				if err != nil {
+					return nil, err
+				}
+
+				shape[i] = uint64(n)
+			} else if shape[i], err = read[uint64](&d); err != nil {
+				return nil, err
+			}
+		}
+
+		kind, err := read[uint32](&d)
+		if err != nil {
+			return nil, err
+		}
+
+		offset, err := read[uint64](&d)
+		if err != nil {
+			return nil, err
+		}
+
+		f.Tensors = append(f.Tensors, Tensor{Name: name, Type: kind, Shape: shape, Offset: offset})
+	}
+
+	f.layout = &layout{alignment: f.Alignment(), padded: true, offsets: make(map[*io.SectionReader]uint64, len(f.Tensors))}
+	dataOffset := align(uint64(d.offset), f.Alignment())
+	if dataOffset > uint64(size) {
+		if len(f.Tensors) > 0 {
+			return nil, fmt.Errorf("tensor data is past the end of the file: %w", io.ErrUnexpectedEOF)
+		}
+
+		// files without tensors may not be padded
+		dataOffset = uint64(size)
+		f.layout.padded = false
+	}
+
+	dataSize := uint64(size) - dataOffset
+	f.layout.data = io.NewSectionReader(ra, int64(dataOffset), int64(dataSize))
+
+	// the data of tensors of unknown types runs to the next tensor's
+	ends := make([]uint64, 0, len(f.Tensors)+1)
+	for _, t := range f.Tensors {
+		ends = append(ends, t.Offset)
+	}
+	ends = append(ends, dataSize)
+	slices.Sort(ends)
+
+	for i := range f.Tensors {
+		t := &f.Tensors[i]
+		n := t.Size()
+		if typeSize, _ := TypeSize(t.Type); typeSize == 0 {
+			j, _ := slices.BinarySearch(ends, t.Offset+1)
+			if j < len(ends) {
+				n = ends[j] - t.Offset
+			}
+		}
+
+		if t.Offset > dataSize || n > dataSize-t.Offset {
+			return nil, fmt.Errorf("tensor %s is past the end of the file: %w", t.Name, io.ErrUnexpectedEOF)
+		}
+
+		t.data = io.NewSectionReader(ra, int64(dataOffset+t.Offset), int64(n))
+		t.WriterTo = data{t.data}
+		f.layout.offsets[t.data] = t.Offset
+	}
+
+	return &f, nil
+}
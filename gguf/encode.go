@@ -0,0 +1,188 @@
+package gguf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// encoder writes a file in order, counting its offset. Writes after an error
+// are dropped and the error is kept.
+type encoder struct {
+	w       *bufio.Writer
+	order   binary.ByteOrder
+	version uint32
+
+	offset int64
+	err    error
+}
+
+func (e *encoder) Write(b []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	n, err := e.w.Write(b)
+	e.offset += int64(n)
+	e.err = err
+	return n, err
+}
+
+func (e *encoder) write(v any) {
+	if e.err == nil {
+		e.err = binary.Write(e, e.order, v)
+	}
+}
+
+// writeLength writes the length of a string or array, which is 32-bit in
+// version 1
+func (e *encoder) writeLength(n int) {
+	if e.version == 1 {
+		e.write(uint32(n))
+	} else {
+		e.write(uint64(n))
+	}
+}
+
+func (e *encoder) writeString(s string) {
+	if e.version == 1 {
+		// version 1 strings are null-terminated
+		s += "\x00"
+	}
+
+	e.writeLength(len(s))
+	e.write([]byte(s))
+}
+
+func (e *encoder) writeValue(v any) error {
+	t, elem, err := TypeOf(v)
+	if err != nil {
+		return err
+	}
+
+	e.write(t)
+	switch v := v.(type) {
+	case string:
+		e.writeString(v)
+	case []string:
+		e.write(elem)
+		e.writeLength(len(v))
+		for _, s := range v {
+			e.writeString(s)
+		}
+	default:
+		if t == TypeArray {
+			e.write(elem)
+			e.writeLength(reflect.ValueOf(v).Len())
+		}
+
+		e.write(v)
+	}
+
+	return nil
+}
+
+func (e *encoder) writePadding(alignment uint64) {
+	offset := uint64(e.offset)
+	e.write(make([]byte, align(offset, alignment)-offset))
+}
+
+// Encode writes the file to w. Its key-values and tensors are written in
+// order and the data of the tensors is laid out in the same order, each
+// aligned to the file's alignment. The data of a decoded file is copied as it
+// was laid out, including any gaps and trailing bytes, if its tensors and
+// alignment haven't changed. Nothing is written out of order, so w can be a
+// stream.
+func (f *File) Encode(w io.Writer) error {
+	version := f.version()
+	if version < 1 || version > 3 {
+		return fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+
+	e := encoder{w: bufio.NewWriter(w), order: f.byteOrder(), version: version}
+	alignment := f.Alignment()
+
+	e.write([]byte("GGUF"))
+	e.write(version)
+	e.writeLength(len(f.Tensors))
+	e.writeLength(len(f.KV))
+
+	for _, kv := range f.KV {
+		e.writeString(kv.Key)
+		if err := e.writeValue(kv.Value); err != nil {
+			return fmt.Errorf("%s: %w", kv.Key, err)
+		}
+	}
+
+	keep := f.layout.keep(f.Tensors, alignment)
+
+	var offset uint64
+	for _, t := range f.Tensors {
+		if _, ok := t.WriterTo.(data); !ok {
+			if typeSize, _ := TypeSize(t.Type); typeSize == 0 {
+				return fmt.Errorf("tensor %s has an unknown type %d", t.Name, t.Type)
+			}
+		}
+
+		e.writeString(t.Name)
+		e.write(uint32(len(t.Shape)))
+		for _, n := range t.Shape {
+			if version == 1 {
+				e.write(uint32(n))
+			} else {
+				e.write(n)
+			}
+		}
+
+		e.write(t.Type)
+		if keep {
+			e.write(t.Offset)
+		} else {
+			e.write(offset)
+			offset = align(offset+t.Size(), alignment)
+		}
+	}
+
+	if keep {
+		if f.layout.padded {
+			e.writePadding(alignment)
+		}
+
+		if _, err := io.Copy(&e, io.NewSectionReader(f.layout.data, 0, f.layout.data.Size())); err != nil {
+			return err
+		}
+
+		if e.err != nil {
+			return e.err
+		}
+
+		return e.w.Flush()
+	}
+
+	e.writePadding(alignment)
+
+	for _, t := range f.Tensors {
+		if t.WriterTo == nil {
+			return fmt.Errorf("tensor %s has no data", t.Name)
+		}
+
+		n, err := t.WriterTo.WriteTo(&e)
+		if err != nil {
+			return err
+		}
+
+		if uint64(n) != t.Size() {
+			return fmt.Errorf("tensor %s has %d bytes of data but should have %d", t.Name, n, t.Size())
+		}
+
+		e.writePadding(alignment)
+	}
+
+	if e.err != nil {
+		return e.err
+	}
+
+	return e.w.Flush()
+}
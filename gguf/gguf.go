@@ -0,0 +1,466 @@
+// Package gguf reads and writes GGUF files, the model format of llama.cpp.
+//
+// Files are decoded with their key-values in order and with their types, and
+// the data of their tensors is read lazily from the file, so a decoded file
+// can be changed and encoded again without loading its tensors into memory.
+// Encoding a decoded file without changes reproduces it byte for byte.
+package gguf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+// Type is the type of a key-value's value
+type Type uint32
+
+const (
+	TypeUint8 Type = iota
+	TypeInt8
+	TypeUint16
+	TypeInt16
+	TypeUint32
+	TypeInt32
+	TypeFloat32
+	TypeBool
+	TypeString
+	TypeArray
+	TypeUint64
+	TypeInt64
+	TypeFloat64
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeUint8:
+		return "uint8"
+	case TypeInt8:
+		return "int8"
+	case TypeUint16:
+		return "uint16"
+	case TypeInt16:
+		return "int16"
+	case TypeUint32:
+		return "uint32"
+	case TypeInt32:
+		return "int32"
+	case TypeFloat32:
+		return "float32"
+	case TypeBool:
+		return "bool"
+	case TypeString:
+		return "string"
+	case TypeArray:
+		return "array"
+	case TypeUint64:
+		return "uint64"
+	case TypeInt64:
+		return "int64"
+	case TypeFloat64:
+		return "float64"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(t))
+	}
+}
+
+// TypeOf returns the type of a value and, for arrays, the type of its
+// elements. Values are Go values of the matching type such as uint32 or
+// string, and arrays are slices of them such as []uint32 or []string.
+func TypeOf(v any) (t Type, elem Type, err error) {
+	switch v.(type) {
+	case uint8:
+		return TypeUint8, 0, nil
+	case int8:
+		return TypeInt8, 0, nil
+	case uint16:
+		return TypeUint16, 0, nil
+	case int16:
+		return TypeInt16, 0, nil
+	case uint32:
+		return TypeUint32, 0, nil
+	case int32:
+		return TypeInt32, 0, nil
+	case float32:
+		return TypeFloat32, 0, nil
+	case bool:
+		return TypeBool, 0, nil
+	case string:
+		return TypeString, 0, nil
+	case uint64:
+		return TypeUint64, 0, nil
+	case int64:
+		return TypeInt64, 0, nil
+	case float64:
+		return TypeFloat64, 0, nil
+	case []uint8:
+		return TypeArray, TypeUint8, nil
+	case []int8:
+		return TypeArray, TypeInt8, nil
+	case []uint16:
+		return TypeArray, TypeUint16, nil
+	case []int16:
+		return TypeArray, TypeInt16, nil
+	case []uint32:
+		return TypeArray, TypeUint32, nil
+	case []int32:
+		return TypeArray, TypeInt32, nil
+	case []float32:
+		return TypeArray, TypeFloat32, nil
+	case []bool:
+		return TypeArray, TypeBool, nil
+	case []string:
+		return TypeArray, TypeString, nil
+	case []uint64:
+		return TypeArray, TypeUint64, nil
+	case []int64:
+		return TypeArray, TypeInt64, nil
+	case []float64:
+		return TypeArray, TypeFloat64, nil
+	default:
+		return 0, 0, fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// KV is a key-value of a file
+type KV struct {
+	Key   string
+	Value any
+}
+
+// Tensor is the info of a tensor and its data
+type Tensor struct {
+	Name string
+
+	// Type is the ggml type of the tensor's data such as 2 for Q4_0
+	Type uint32
+
+	// Shape is the number of elements in each dimension in ggml order, which
+	// is the reverse of PyTorch's
+	Shape []uint64
+
+	// Offset is the offset of the tensor's data from the start of the data
+	// section. Offsets are laid out again when a file is encoded unless its
+	// tensors are the ones it was decoded with.
+	Offset uint64
+
+	// WriterTo writes the tensor's data when the file is encoded. It's the
+	// tensor's data in the file for decoded tensors.
+	WriterTo io.WriterTo
+
+	data *io.SectionReader
+}
+
+// Data returns the tensor's data in the file it was decoded from, which is
+// read as it's needed
+func (t Tensor) Data() *io.SectionReader {
+	return t.data
+}
+
+// Parameters returns the number of elements of the tensor
+func (t Tensor) Parameters() uint64 {
+	var count uint64 = 1
+	for _, n := range t.Shape {
+		count *= n
+	}
+	return count
+}
+
+// Size returns the number of bytes of the tensor's data. The size of tensors
+// of types this package doesn't know is the size of their data in the file
+// they were decoded from.
+func (t Tensor) Size() uint64 {
+	if typeSize, blockSize := TypeSize(t.Type); typeSize > 0 {
+		return t.Parameters() * typeSize / blockSize
+	}
+
+	if t.data != nil {
+		return uint64(t.data.Size())
+	}
+
+	return 0
+}
+
+// TypeSize returns the number of bytes of a block of a ggml type and the
+// number of elements in the block. The size is 0 for unknown types.
+func TypeSize(kind uint32) (typeSize, blockSize uint64) {
+	blockSize = 256
+	switch {
+	case kind < 2:
+		blockSize = 1
+	case kind < 10:
+		blockSize = 32
+	}
+
+	switch kind {
+	case 0: // F32
+		return 4, blockSize
+	case 1: // F16
+		return 2, blockSize
+	case 2: // Q4_0
+		return 2 + blockSize/2, blockSize
+	case 3: // Q4_1
+		return 2 + 2 + blockSize/2, blockSize
+	case 6: // Q5_0
+		return 2 + 4 + blockSize/2, blockSize
+	case 7: // Q5_1
+		return 2 + 2 + 4 + blockSize/2, blockSize
+	case 8: // Q8_0
+		return 2 + blockSize, blockSize
+	case 9: // Q8_1
+		return 4 + 4 + blockSize, blockSize
+	case 10: // Q2_K
+		return blockSize/16 + blockSize/4 + 2 + 2, blockSize
+	case 11: // Q3_K
+		return blockSize/8 + blockSize/4 + 12 + 2, blockSize
+	case 12: // Q4_K
+		return 2 + 2 + 12 + blockSize/2, blockSize
+	case 13: // Q5_K
+		return 2 + 2 + 12 + blockSize/8 + blockSize/2, blockSize
+	case 14: // Q6_K
+		return blockSize/2 + blockSize/4 + blockSize/16 + 2, blockSize
+	case 15: // Q8_K
+		return 2 + blockSize + 2*blockSize/16, blockSize
+	case 16: // IQ2_XXS
+		return 2 + 2*blockSize/8, blockSize
+	case 17: // IQ2_XS
+		return 2 + 2*blockSize/8 + blockSize/32, blockSize
+	case 18: // IQ3_XXS
+		return 2 + 3*blockSize/8, blockSize
+	default:
+		return 0, blockSize
+	}
+}
+
+// File is a GGUF file
+type File struct {
+	// ByteOrder is the byte order of the file, little endian if it's nil
+	ByteOrder binary.ByteOrder
+
+	// Version is the version of the format, 1 to 3. Files are encoded as
+	// version 3 if it's 0.
+	Version uint32
+
+	// KV are the key-values of the file in order
+	KV []KV
+
+	// Tensors are the tensors of the file in order
+	Tensors []Tensor
+
+	// layout is the layout of the data of the file it was decoded from
+	layout *layout
+}
+
+// layout is how the data of a decoded file was laid out, which is kept when
+// the file is encoded with the same tensors and alignment
+type layout struct {
+	alignment uint64
+
+	// padded is false for files without tensors whose header isn't padded
+	padded bool
+
+	// data is the data section including any gaps and trailing bytes
+	data *io.SectionReader
+
+	// offsets are the offsets of the data of each tensor
+	offsets map[*io.SectionReader]uint64
+}
+
+// keep reports whether the tensors are the ones the file was decoded with,
+// at the same offsets, so their data can be copied as it was
+func (l *layout) keep(tensors []Tensor, alignment uint64) bool {
+	if l == nil || l.alignment != alignment || len(tensors) != len(l.offsets) {
+		return false
+	}
+
+	seen := make(map[*io.SectionReader]bool, len(tensors))
+	for _, t := range tensors {
+		d, ok := t.WriterTo.(data)
+		if !ok || d.SectionReader != t.data || seen[t.data] {
+			return false
+		}
+
+		if offset, ok := l.offsets[t.data]; !ok || offset != t.Offset {
+			return false
+		}
+
+		seen[t.data] = true
+	}
+
+	return true
+}
+
+func (f *File) byteOrder() binary.ByteOrder {
+	if f.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+
+	return f.ByteOrder
+}
+
+func (f *File) version() uint32 {
+	if f.Version == 0 {
+		return 3
+	}
+
+	return f.Version
+}
+
+// Alignment returns the alignment of tensor data, which is general.alignment
+// or 32 by default
+func (f *File) Alignment() uint64 {
+	if v, ok := f.Uint("general.alignment"); ok && v > 0 {
+		return v
+	}
+
+	return 32
+}
+
+// Value returns the value of the key-value with the key
+func (f *File) Value(key string) (any, bool) {
+	for _, kv := range f.KV {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// String returns the value of a string key-value
+func (f *File) String(key string) (string, bool) {
+	v, _ := f.Value(key)
+	s, ok := v.(string)
+	return s, ok
+}
+
+// Strings returns the value of a string array key-value
+func (f *File) Strings(key string) ([]string, bool) {
+	v, _ := f.Value(key)
+	s, ok := v.([]string)
+	return s, ok
+}
+
+// Bool returns the value of a bool key-value
+func (f *File) Bool(key string) (bool, bool) {
+	v, _ := f.Value(key)
+	b, ok := v.(bool)
+	return b, ok
+}
+
+// Uint returns the value of an integer key-value of any type if it isn't
+// negative
+func (f *File) Uint(key string) (uint64, bool) {
+	v, _ := f.Value(key)
+	switch v := v.(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	}
+
+	if i, ok := f.Int(key); ok && i >= 0 {
+		return uint64(i), true
+	}
+
+	return 0, false
+}
+
+// Int returns the value of an integer key-value of any type if it fits in an
+// int64
+func (f *File) Int(key string) (int64, bool) {
+	v, _ := f.Value(key)
+	switch v := v.(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), true
+		}
+	}
+
+	return 0, false
+}
+
+// Float returns the value of a float32 or float64 key-value
+func (f *File) Float(key string) (float64, bool) {
+	v, _ := f.Value(key)
+	switch v := v.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// Set sets the value of the key-value with the key, which keeps its place if
+// it exists and is added to the end otherwise
+func (f *File) Set(key string, value any) error {
+	if _, _, err := TypeOf(value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	for i := range f.KV {
+		if f.KV[i].Key == key {
+			f.KV[i].Value = value
+			return nil
+		}
+	}
+
+	f.KV = append(f.KV, KV{Key: key, Value: value})
+	return nil
+}
+
+// Delete deletes the key-value with the key and reports whether it existed
+func (f *File) Delete(key string) bool {
+	n := len(f.KV)
+	f.KV = slices.DeleteFunc(f.KV, func(kv KV) bool {
+		return kv.Key == key
+	})
+
+	return len(f.KV) < n
+}
+
+// Tensor returns the tensor with the name
+func (f *File) Tensor(name string) (*Tensor, bool) {
+	for i := range f.Tensors {
+		if f.Tensors[i].Name == name {
+			return &f.Tensors[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// data is the data of a decoded tensor
+type data struct {
+	*io.SectionReader
+}
+
+func (d data) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(d.SectionReader, 0, d.Size()))
+}
+
+func align(offset, alignment uint64) uint64 {
+	return (offset + alignment - 1) / alignment * alignment
+}
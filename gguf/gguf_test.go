@@ -0,0 +1,326 @@
+package gguf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// raw writes a file by hand like other writers lay them out, with the data
+// of each tensor padded to 32 bytes
+type raw struct {
+	bytes.Buffer
+	order   binary.ByteOrder
+	version uint32
+}
+
+func (r *raw) length(n int) {
+	if r.version == 1 {
+		binary.Write(r, r.order, uint32(n))
+	} else {
+		binary.Write(r, r.order, uint64(n))
+	}
+}
+
+func (r *raw) write(t *testing.T, v ...any) {
+	for _, v := range v {
+		switch v := v.(type) {
+		case string:
+			if r.version == 1 {
+				v += "\x00"
+			}
+
+			r.length(len(v))
+			r.WriteString(v)
+		case []string:
+			r.length(len(v))
+			for _, s := range v {
+				r.write(t, s)
+			}
+		case int:
+			// lengths
+			r.length(v)
+		default:
+			assert.Nil(t, binary.Write(r, r.order, v))
+		}
+	}
+}
+
+func (r *raw) pad() {
+	r.Write(make([]byte, (32-r.Len()%32)%32))
+}
+
+func testFile(t *testing.T, order binary.ByteOrder, version uint32) []byte {
+	r := raw{order: order, version: version}
+	r.WriteString("GGUF")
+	r.write(t, version, 3, 8)
+
+	r.write(t, "general.architecture", TypeString, "llama")
+	r.write(t, "llama.block_count", TypeUint32, uint32(2))
+	r.write(t, "llama.rope.freq_base", TypeFloat32, float32(10000))
+	r.write(t, "general.signed", TypeInt8, int8(-3))
+	r.write(t, "tokenizer.ggml.add_bos_token", TypeBool, true)
+	r.write(t, "tokenizer.ggml.tokens", TypeArray, TypeString, []string{"<unk>", "<s>", "</s>"})
+	r.write(t, "tokenizer.ggml.scores", TypeArray, TypeFloat32, 3, []float32{0, -1, -2})
+	r.write(t, "general.empty", TypeArray, TypeUint16, 0)
+
+	// name, dimensions, shape, type and offset
+	r.write(t, "token_embd.weight", uint32(2))
+	if version == 1 {
+		r.write(t, uint32(4), uint32(2))
+	} else {
+		r.write(t, uint64(4), uint64(2))
+	}
+	r.write(t, uint32(0), uint64(0))
+
+	r.write(t, "output_norm.weight", uint32(1))
+	if version == 1 {
+		r.write(t, uint32(32))
+	} else {
+		r.write(t, uint64(32))
+	}
+	r.write(t, uint32(2), uint64(32))
+
+	// a type this package doesn't know
+	r.write(t, "output.weight", uint32(1))
+	if version == 1 {
+		r.write(t, uint32(7))
+	} else {
+		r.write(t, uint64(7))
+	}
+	r.write(t, uint32(100), uint64(64))
+	r.pad()
+
+	for i := range 8 {
+		r.write(t, float32(i))
+	}
+	r.pad()
+
+	r.Write(bytes.Repeat([]byte{1}, 18))
+	r.pad()
+
+	r.Write(bytes.Repeat([]byte{2}, 7))
+	r.pad()
+	return r.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		order   binary.ByteOrder
+		version uint32
+	}{
+		{"v1", binary.LittleEndian, 1},
+		{"v2", binary.LittleEndian, 2},
+		{"v3", binary.LittleEndian, 3},
+		{"big endian", binary.BigEndian, 3},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			bts := testFile(t, tt.order, tt.version)
+			f, err := Decode(bytes.NewReader(bts), int64(len(bts)))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.version, f.Version)
+			assert.Equal(t, tt.order, f.ByteOrder)
+
+			keys := make([]string, len(f.KV))
+			for i, kv := range f.KV {
+				keys[i] = kv.Key
+			}
+
+			assert.Equal(t, []string{
+				"general.architecture",
+				"llama.block_count",
+				"llama.rope.freq_base",
+				"general.signed",
+				"tokenizer.ggml.add_bos_token",
+				"tokenizer.ggml.tokens",
+				"tokenizer.ggml.scores",
+				"general.empty",
+			}, keys)
+
+			arch, _ := f.String("general.architecture")
+			assert.Equal(t, "llama", arch)
+
+			blocks, ok := f.Uint("llama.block_count")
+			assert.True(t, ok)
+			assert.Equal(t, uint64(2), blocks)
+
+			freqBase, _ := f.Float("llama.rope.freq_base")
+			assert.Equal(t, float64(10000), freqBase)
+
+			signed, _ := f.Int("general.signed")
+			assert.Equal(t, int64(-3), signed)
+			_, ok = f.Uint("general.signed")
+			assert.False(t, ok)
+
+			addBOS, _ := f.Bool("tokenizer.ggml.add_bos_token")
+			assert.True(t, addBOS)
+
+			tokens, _ := f.Strings("tokenizer.ggml.tokens")
+			assert.Equal(t, []string{"<unk>", "<s>", "</s>"}, tokens)
+
+			scores, _ := f.Value("tokenizer.ggml.scores")
+			assert.Equal(t, []float32{0, -1, -2}, scores)
+
+			empty, _ := f.Value("general.empty")
+			assert.Equal(t, []uint16{}, empty)
+
+			assert.Len(t, f.Tensors, 3)
+			embd, ok := f.Tensor("token_embd.weight")
+			assert.True(t, ok)
+			assert.Equal(t, []uint64{4, 2}, embd.Shape)
+			assert.Equal(t, uint64(32), embd.Size())
+
+			values := make([]float32, 8)
+			assert.Nil(t, binary.Read(embd.Data(), tt.order, values))
+			assert.Equal(t, []float32{0, 1, 2, 3, 4, 5, 6, 7}, values)
+
+			norm, _ := f.Tensor("output_norm.weight")
+			assert.Equal(t, uint64(18), norm.Size())
+
+			// the data of unknown types runs to the end of the file or the next tensor
+			output, _ := f.Tensor("output.weight")
+			assert.Equal(t, uint64(32), output.Size())
+
+			var b bytes.Buffer
+			assert.Nil(t, f.Encode(&b))
+			assert.Equal(t, bts, b.Bytes())
+
+			// tensors can be encoded again
+			b.Reset()
+			assert.Nil(t, f.Encode(&b))
+			assert.Equal(t, bts, b.Bytes())
+		})
+	}
+}
+
+// layoutFile writes a file whose tensor data isn't in header order, with
+// gaps between tensors and trailing bytes
+func layoutFile(t *testing.T) []byte {
+	r := raw{order: binary.LittleEndian, version: 3}
+	r.WriteString("GGUF")
+	r.write(t, uint32(3), 2, 1)
+	r.write(t, "general.architecture", TypeString, "llama")
+
+	r.write(t, "a.weight", uint32(1), uint64(4), uint32(0), uint64(96))
+	r.write(t, "b.weight", uint32(1), uint64(2), uint32(0), uint64(0))
+	r.pad()
+
+	for i := range 2 {
+		r.write(t, float32(i))
+	}
+	r.Write(bytes.Repeat([]byte{0xff}, 88))
+
+	for i := range 4 {
+		r.write(t, float32(10+i))
+	}
+	r.Write(bytes.Repeat([]byte{0xee}, 40))
+	return r.Bytes()
+}
+
+func TestRoundTripLayout(t *testing.T) {
+	bts := layoutFile(t)
+	f, err := Decode(bytes.NewReader(bts), int64(len(bts)))
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	assert.Nil(t, f.Encode(&b))
+	assert.Equal(t, bts, b.Bytes())
+
+	// the layout is kept when key-values change
+	assert.Nil(t, f.Set("general.name", "layout"))
+	b.Reset()
+	assert.Nil(t, f.Encode(&b))
+
+	g, err := Decode(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(96), g.Tensors[0].Offset)
+	assert.Equal(t, uint64(0), g.Tensors[1].Offset)
+
+	values := make([]float32, 4)
+	assert.Nil(t, binary.Read(g.Tensors[0].Data(), binary.LittleEndian, values))
+	assert.Equal(t, []float32{10, 11, 12, 13}, values)
+
+	data, err := io.ReadAll(io.NewSectionReader(bytes.NewReader(b.Bytes()), int64(b.Len()-40), 40))
+	assert.Nil(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{0xee}, 40), data)
+
+	// and laid out again when tensors change
+	f.Tensors = f.Tensors[1:]
+	b.Reset()
+	assert.Nil(t, f.Encode(&b))
+
+	g, err = Decode(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.Nil(t, err)
+	assert.Len(t, g.Tensors, 1)
+	assert.Equal(t, uint64(0), g.Tensors[0].Offset)
+	assert.Equal(t, int64(32), g.layout.data.Size())
+
+	values = make([]float32, 2)
+	assert.Nil(t, binary.Read(g.Tensors[0].Data(), binary.LittleEndian, values))
+	assert.Equal(t, []float32{0, 1}, values)
+}
+
+func TestEdit(t *testing.T) {
+	bts := testFile(t, binary.LittleEndian, 3)
+	f, err := Decode(bytes.NewReader(bts), int64(len(bts)))
+	assert.Nil(t, err)
+
+	assert.Nil(t, f.Set("llama.block_count", uint64(3)))
+	assert.Nil(t, f.Set("general.name", "test"))
+	assert.ErrorContains(t, f.Set("general.int", 1), "unsupported value type int")
+	assert.True(t, f.Delete("general.signed"))
+	assert.False(t, f.Delete("general.signed"))
+
+	// tensors can be dropped and their data replaced
+	f.Tensors = f.Tensors[:2]
+	f.Tensors[0].WriterTo = bytes.NewReader(make([]byte, 32))
+
+	var b bytes.Buffer
+	assert.Nil(t, f.Encode(&b))
+
+	g, err := Decode(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.Nil(t, err)
+	assert.Equal(t, f.KV, g.KV)
+	assert.Equal(t, KV{Key: "llama.block_count", Value: uint64(3)}, g.KV[1])
+	assert.Equal(t, KV{Key: "general.name", Value: "test"}, g.KV[len(g.KV)-1])
+
+	assert.Len(t, g.Tensors, 2)
+	data, err := io.ReadAll(g.Tensors[0].Data())
+	assert.Nil(t, err)
+	assert.Equal(t, make([]byte, 32), data)
+
+	// the data must match the tensor's size
+	f.Tensors[0].WriterTo = bytes.NewReader(make([]byte, 16))
+	assert.ErrorContains(t, f.Encode(io.Discard), "tensor token_embd.weight has 16 bytes of data but should have 32")
+
+	f.Tensors = append(f.Tensors, Tensor{Name: "new.weight", Type: 100, Shape: []uint64{1}, WriterTo: bytes.NewReader([]byte{0})})
+	assert.ErrorContains(t, f.Encode(io.Discard), "tensor new.weight has an unknown type 100")
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	bts := testFile(t, binary.LittleEndian, 3)
+
+	_, err := Decode(bytes.NewReader([]byte("GGML")), 4)
+	assert.ErrorIs(t, err, ErrInvalidMagic)
+
+	version := append([]byte("GGUF"), 4, 0, 0, 0)
+	_, err = Decode(bytes.NewReader(version), int64(len(version)))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// truncated data
+	_, err = Decode(bytes.NewReader(bts), int64(len(bts)-64))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// a count larger than the file
+	large := append([]byte("GGUF"), 3, 0, 0, 0)
+	large = binary.LittleEndian.AppendUint64(large, 1<<60)
+	large = binary.LittleEndian.AppendUint64(large, 0)
+	_, err = Decode(bytes.NewReader(large), int64(len(large)))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
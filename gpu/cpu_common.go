@@ -19,3 +19,13 @@ func GetCPUVariant() string {
 	// else LCD
 	return ""
 }
+
+// CheckRAM returns the free system memory, or 0 if it's unknown
+func CheckRAM() int64 {
+	mem, err := getCPUMem()
+	if err != nil {
+		return 0
+	}
+
+	return int64(mem.FreeMemory)
+}
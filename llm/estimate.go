@@ -0,0 +1,179 @@
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/jmorganca/ollama/api"
+	"github.com/jmorganca/ollama/gpu"
+)
+
+var cpuOnlyFamilies = []string{
+	"mamba",
+}
+
+// Estimate is the memory a model needs and how many of its layers are
+// offloaded to the gpu
+type Estimate struct {
+	// Info is the library the model runs with, which is the cpu if no layers
+	// are offloaded
+	Info gpu.GpuInfo
+
+	// Options are the options the model runs with, with NumCtx limited to
+	// the model's context length and NumGPU set to the offloaded layers
+	Options api.Options
+
+	Layers      int
+	TotalLayers int
+
+	// Size, KV and Graph are the sizes of the weights, the kv cache and the
+	// compute graph
+	Size  int64
+	KV    int64
+	Graph int64
+
+	// VRAM and RAM are the memory used on the gpu and the cpu, and
+	// AvailableVRAM and AvailableRAM what's free, which is 0 if it's unknown
+	VRAM          int64
+	RAM           int64
+	AvailableVRAM int64
+	AvailableRAM  int64
+}
+
+// Fits reports whether the model fits in the available memory
+func (e Estimate) Fits() bool {
+	return e.VRAM <= e.AvailableVRAM && (e.AvailableRAM == 0 || e.RAM <= e.AvailableRAM)
+}
+
+// EstimateMemory estimates the memory the model file at path needs with opts
+// on this machine without loading it
+func EstimateMemory(model string, opts api.Options) (*Estimate, error) {
+	f, err := os.Open(model)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ggml, err := DecodeGGML(f)
+	if err != nil {
+		return nil, err
+	}
+
+	vram, _ := gpu.CheckVRAM()
+	est := estimate(ggml, gpu.GetGPUInfo(), vram, gpu.CheckRAM(), opts)
+	return &est, nil
+}
+
+// estimate decides how many layers of ggml to offload to the gpus described
+// by info, which have vram bytes available, and how much memory that needs
+func estimate(ggml *GGML, info gpu.GpuInfo, vram, ram int64, opts api.Options) Estimate {
+	if opts.NumCtx > int(ggml.NumCtx()) {
+		slog.Warn(fmt.Sprintf("requested context length is greater than model's max context length (%d > %d), using %d instead", opts.NumCtx, ggml.NumCtx(), ggml.NumCtx()))
+		opts.NumCtx = int(ggml.NumCtx())
+	}
+
+	if opts.NumCtx < 4 {
+		opts.NumCtx = 4
+	}
+
+	size := ggml.Size
+
+	// fp16 k,v matrices require = n_ctx * n_layer * n_embd / n_head * n_head_kv * 2 bytes each * 2 key and value
+	kv := 2 * 2 * int64(opts.NumCtx) * int64(ggml.NumLayers()) * int64(ggml.NumEmbed()) * int64(ggml.NumHeadKv()) / int64(max(ggml.NumHead(), 1))
+
+	// this amount is the overhead + tensors in memory
+	// TODO: get this from the llama.cpp's graph calculations instead of
+	// estimating it's 1/6 * kv_cache_size * num_gqa
+	graph := int64(ggml.NumGQA()) * kv / 6
+
+	// certain model architectures don't support gpu inference yet
+	if slices.Contains(cpuOnlyFamilies, ggml.ModelFamily()) {
+		opts.NumGPU = 0
+	}
+
+	maxlayers := int64(ggml.NumLayers()) + 1
+
+	switch info.Library {
+	case "metal":
+		if opts.NumGPU == 0 {
+			break
+		}
+
+		if size+kv+graph > vram {
+			slog.Info("not enough vram available, setting num_gpu=0")
+			opts.NumGPU = 0
+			break
+		}
+
+		// TODO: implement layer splitting on macOS
+		opts.NumGPU = 999
+	case "cpu":
+		slog.Info("GPU not available, falling back to CPU")
+		opts.NumGPU = 0
+	default:
+		// don't use GPU at all if no layers are loaded
+		if opts.NumGPU == 0 {
+			info.Library = "cpu"
+			info.Variant = gpu.GetCPUVariant()
+			break
+		}
+
+		// user-defined GPU count
+		if opts.NumGPU != -1 {
+			break
+		}
+
+		// the "main" GPU needs the most memory and determines the limit
+		// of how many layers can be loaded. It needs to fit:
+		// 1. the full compute graph allocation for all devices (graph)
+		// 2. the proportional kv cache for all devices (kv * % layers)
+		// 3. the proportional model (size * % layers / # devices)
+		// This estimates the number of layers
+		devices := int64(max(info.DeviceCount, 1))
+		avg := vram / devices
+		layers := maxlayers * (avg - graph) / (kv + size/devices)
+		if layers > maxlayers {
+			layers = maxlayers
+		}
+
+		// 1 + 2 must fit on the main gpu
+		min := graph + kv*layers/maxlayers
+		if layers <= 0 || min > avg {
+			slog.Info("not enough vram available, falling back to CPU only")
+			info.Library = "cpu"
+			info.Variant = gpu.GetCPUVariant()
+			opts.NumGPU = 0
+			break
+		}
+
+		opts.NumGPU = int(layers)
+	}
+
+	layers := min(int64(max(opts.NumGPU, 0)), maxlayers)
+
+	est := Estimate{
+		Info:          info,
+		Options:       opts,
+		Layers:        int(layers),
+		TotalLayers:   int(maxlayers),
+		Size:          size,
+		KV:            kv,
+		Graph:         graph,
+		AvailableVRAM: vram,
+		AvailableRAM:  ram,
+	}
+
+	// the compute graph is allocated on the gpu if any layers are offloaded,
+	// the weights and kv cache are split between the gpu and cpu by layer
+	offloaded := (size + kv) * layers / maxlayers
+	if layers > 0 {
+		est.VRAM = graph + offloaded
+		est.RAM = size + kv - offloaded
+	} else {
+		est.RAM = size + kv + graph
+	}
+
+	return est
+}
@@ -0,0 +1,100 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+	"github.com/jmorganca/ollama/gpu"
+)
+
+func TestEstimate(t *testing.T) {
+	ggml := &GGML{
+		container: &ContainerGGUF{},
+		model: &GGUFModel{KV: KV{
+			"general.architecture":          "llama",
+			"llama.block_count":             uint32(31),
+			"llama.context_length":          uint32(4096),
+			"llama.embedding_length":        uint32(4096),
+			"llama.attention.head_count":    uint32(32),
+			"llama.attention.head_count_kv": uint32(8),
+		}},
+		Size: 3_200_000_000,
+	}
+
+	cuda := gpu.GpuInfo{Library: "cuda"}
+	cuda.DeviceCount = 1
+
+	opts := api.DefaultOptions()
+	opts.NumCtx = 2048
+
+	// 4 bytes * 2048 ctx * 31 layers * 4096 embd * 8 kv heads / 32 heads
+	const kv = 260_046_848
+	const graph = 4 * kv / 6
+	const size = 3_200_000_000
+
+	t.Run("full offload", func(t *testing.T) {
+		est := estimate(ggml, cuda, 8_000_000_000, 16_000_000_000, opts)
+		assert.Equal(t, "cuda", est.Info.Library)
+		assert.Equal(t, 32, est.Layers)
+		assert.Equal(t, 32, est.TotalLayers)
+		assert.Equal(t, 32, est.Options.NumGPU)
+		assert.Equal(t, int64(kv), est.KV)
+		assert.Equal(t, int64(graph), est.Graph)
+		assert.Equal(t, int64(graph+size+kv), est.VRAM)
+		assert.Equal(t, int64(0), est.RAM)
+		assert.True(t, est.Fits())
+	})
+
+	t.Run("partial offload", func(t *testing.T) {
+		est := estimate(ggml, cuda, 2_000_000_000, 16_000_000_000, opts)
+		assert.Equal(t, "cuda", est.Info.Library)
+		assert.Equal(t, 16, est.Layers)
+		assert.Equal(t, int64(graph+(size+kv)/2), est.VRAM)
+		assert.Equal(t, int64((size+kv)/2), est.RAM)
+		assert.True(t, est.Fits())
+	})
+
+	t.Run("not enough vram", func(t *testing.T) {
+		est := estimate(ggml, cuda, 100_000_000, 2_000_000_000, opts)
+		assert.Equal(t, "cpu", est.Info.Library)
+		assert.Equal(t, 0, est.Layers)
+		assert.Equal(t, int64(0), est.VRAM)
+		assert.Equal(t, int64(size+kv+graph), est.RAM)
+		assert.False(t, est.Fits())
+	})
+
+	t.Run("user defined layers", func(t *testing.T) {
+		opts := opts
+		opts.NumGPU = 40
+		est := estimate(ggml, cuda, 2_000_000_000, 0, opts)
+		assert.Equal(t, 40, est.Options.NumGPU)
+		assert.Equal(t, 32, est.Layers)
+		assert.False(t, est.Fits())
+	})
+
+	t.Run("context length", func(t *testing.T) {
+		opts := opts
+		opts.NumCtx = 8192
+		est := estimate(ggml, cuda, 8_000_000_000, 0, opts)
+		assert.Equal(t, 4096, est.Options.NumCtx)
+		assert.Equal(t, int64(2*kv), est.KV)
+	})
+
+	t.Run("metal", func(t *testing.T) {
+		est := estimate(ggml, gpu.GpuInfo{Library: "metal"}, 8_000_000_000, 0, opts)
+		assert.Equal(t, 999, est.Options.NumGPU)
+		assert.Equal(t, 32, est.Layers)
+
+		est = estimate(ggml, gpu.GpuInfo{Library: "metal"}, 1_000_000_000, 0, opts)
+		assert.Equal(t, 0, est.Options.NumGPU)
+		assert.Equal(t, "metal", est.Info.Library)
+	})
+
+	t.Run("cpu", func(t *testing.T) {
+		est := estimate(ggml, gpu.GpuInfo{Library: "cpu"}, 0, 0, opts)
+		assert.Equal(t, 0, est.Layers)
+		assert.True(t, est.Fits())
+	})
+}
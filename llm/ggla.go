@@ -1,8 +1,10 @@
 package llm
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"slices"
 )
@@ -107,6 +109,70 @@ func (m *ModelGGLA) decode(rso *readSeekOffset) error {
 	}
 }
 
+// offsetWriter counts the bytes written to it, for padding
+type offsetWriter struct {
+	io.Writer
+	offset int64
+}
+
+func (w *offsetWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.offset += int64(n)
+	return n, err
+}
+
+// EncodeGGLA writes a LoRA adapter of rank r and alpha in the ggla format. As
+// for gguf, the shapes of the tensors are rows then columns, padded with zeros.
+func EncodeGGLA(w io.Writer, r, alpha uint32, tensors []Tensor) error {
+	bw := bufio.NewWriter(w)
+	f := &offsetWriter{Writer: bw}
+
+	for _, v := range []uint32{FILE_MAGIC_GGLA, 1, r, alpha} {
+		if err := binary.Write(f, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tensors {
+		dims := 1
+		if t.Shape[1] > 0 {
+			dims = 2
+		}
+
+		for _, v := range []uint32{uint32(dims), uint32(len(t.Name)), t.Kind} {
+			if err := binary.Write(f, binary.LittleEndian, v); err != nil {
+				return err
+			}
+		}
+
+		// ggla tensor shape is reversed
+		for i := range dims {
+			if err := binary.Write(f, binary.LittleEndian, uint32(t.Shape[dims-1-i])); err != nil {
+				return err
+			}
+		}
+
+		if _, err := io.WriteString(f, t.Name); err != nil {
+			return err
+		}
+
+		// tensor data is aligned to 32 bytes
+		if _, err := f.Write(make([]byte, (f.offset+31)&^31-f.offset)); err != nil {
+			return err
+		}
+
+		if t.WriterTo == nil {
+			return fmt.Errorf("tensor %s has no data", t.Name)
+		}
+
+		if _, err := t.WriterTo.WriteTo(f); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
 func (m *ModelGGLA) KV() KV {
 	return m.kv
 }
@@ -159,6 +159,30 @@ func DecodeGGML(r io.ReadSeeker) (*GGML, error) {
 	}, nil
 }
 
+// KV returns the key-values of the model
+func (ggml *GGML) KV() KV {
+	switch m := ggml.model.(type) {
+	case *GGUFModel:
+		return m.KV
+	case *ModelGGLA:
+		return m.KV()
+	default:
+		return nil
+	}
+}
+
+// Tensors returns the tensors of the model in the order they're stored
+func (ggml *GGML) Tensors() []Tensor {
+	switch m := ggml.model.(type) {
+	case *GGUFModel:
+		return m.Tensors
+	case *ModelGGLA:
+		return m.Tensor()
+	default:
+		return nil
+	}
+}
+
 type readSeekOffset struct {
 	io.ReadSeeker
 	offset int64
@@ -5,16 +5,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log/slog"
-	"os"
-	"regexp"
-
-	"github.com/d4l3k/go-bfloat16"
-	"github.com/pdevine/tensor"
-	"github.com/pdevine/tensor/native"
-	"github.com/x448/float16"
+	"slices"
 
 	"github.com/jmorganca/ollama/format"
+	"github.com/jmorganca/ollama/gguf"
 )
 
 type ContainerGGUF struct {
@@ -96,9 +90,8 @@ type Tensor struct {
 	// shape is the number of elements in each dimension
 	Shape []uint64
 
-	FileName      string
-	OffsetPadding uint64
-	FileOffsets   []uint64
+	// WriterTo writes the tensor's data when the model is encoded
+	WriterTo io.WriterTo
 }
 
 func (t Tensor) BlockSize() uint64 {
@@ -155,6 +148,48 @@ func (t Tensor) TypeSize() uint64 {
 	}
 }
 
+// TypeName returns the name of the tensor's ggml type such as Q4_0
+func (t Tensor) TypeName() string {
+	switch t.Kind {
+	case 0:
+		return "F32"
+	case 1:
+		return "F16"
+	case 2:
+		return "Q4_0"
+	case 3:
+		return "Q4_1"
+	case 6:
+		return "Q5_0"
+	case 7:
+		return "Q5_1"
+	case 8:
+		return "Q8_0"
+	case 9:
+		return "Q8_1"
+	case 10:
+		return "Q2_K"
+	case 11:
+		return "Q3_K"
+	case 12:
+		return "Q4_K"
+	case 13:
+		return "Q5_K"
+	case 14:
+		return "Q6_K"
+	case 15:
+		return "Q8_K"
+	case 16:
+		return "IQ2_XXS"
+	case 17:
+		return "IQ2_XS"
+	case 18:
+		return "IQ3_XXS"
+	default:
+		return fmt.Sprintf("unknown(%d)", t.Kind)
+	}
+}
+
 func (t Tensor) Parameters() uint64 {
 	var count uint64 = 1
 	for _, n := range t.Shape {
@@ -167,38 +202,6 @@ func (t Tensor) Size() uint64 {
 	return t.Parameters() * t.TypeSize() / t.BlockSize()
 }
 
-func (t Tensor) Repack(data []uint16, heads int) ([]uint16, error) {
-	n := tensor.New(tensor.WithShape(int(t.Shape[0]), int(t.Shape[1])), tensor.WithBacking(data))
-	origShape := n.Shape().Clone()
-
-	// reshape the tensor and swap axes 1 and 2 to unpack the layer for gguf
-	if err := n.Reshape(heads, 2, origShape[0]/heads/2, origShape[1]); err != nil {
-		return []uint16{}, err
-	}
-
-	if err := n.T(0, 2, 1, 3); err != nil {
-		return []uint16{}, err
-	}
-
-	if err := n.Reshape(origShape...); err != nil {
-		return []uint16{}, err
-	}
-
-	if err := n.Transpose(); err != nil {
-		return []uint16{}, err
-	}
-	newN, err := native.SelectU16(n, 1)
-	if err != nil {
-		return []uint16{}, err
-	}
-
-	var fullTensor []uint16
-	for _, v := range newN {
-		fullTensor = append(fullTensor, v...)
-	}
-	return fullTensor, nil
-}
-
 type GGUFModel struct {
 	*ContainerGGUF
 
@@ -255,382 +258,78 @@ func (llm *GGUFModel) FileType() string {
 	return "unknown"
 }
 
-func (llm *GGUFModel) Encode(f *os.File) error {
+// Encode writes the model to w, which can be streamed since nothing is
+// written out of order
+func (llm *GGUFModel) Encode(w io.Writer) error {
+	arch := llm.ModelFamily()
+
 	// this mimics the order of the llama.cpp convert script
 	kOrder := []string{
 		"general.architecture",
 		"general.name",
-		"llama.context_length",
-		"llama.embedding_length",
-		"llama.block_count",
-		"llama.feed_forward_length",
-		"llama.rope.dimension_count",
-		"llama.attention.head_count",
-		"llama.attention.head_count_kv",
-		"llama.attention.layer_norm_rms_epsilon",
-		"llama.rope.freq_base",
+		arch + ".context_length",
+		arch + ".embedding_length",
+		arch + ".block_count",
+		arch + ".feed_forward_length",
+		arch + ".rope.dimension_count",
+		arch + ".attention.head_count",
+		arch + ".attention.head_count_kv",
+		arch + ".attention.key_length",
+		arch + ".attention.value_length",
+		arch + ".attention.layer_norm_rms_epsilon",
+		arch + ".rope.freq_base",
+		arch + ".expert_count",
+		arch + ".expert_used_count",
 		"general.file_type",
 		"tokenizer.ggml.model",
 		"tokenizer.ggml.tokens",
 		"tokenizer.ggml.scores",
 		"tokenizer.ggml.token_type",
+		"tokenizer.ggml.merges",
 		"tokenizer.ggml.bos_token_id",
 		"tokenizer.ggml.eos_token_id",
 		"tokenizer.ggml.unknown_token_id",
+		"tokenizer.ggml.padding_token_id",
 		"tokenizer.ggml.add_bos_token",
 		"tokenizer.ggml.add_eos_token",
 		"tokenizer.chat_template",
 	}
 
-	if err := binary.Write(f, llm.ByteOrder, []byte("GGUF")); err != nil {
-		return err
-	}
-
-	if err := binary.Write(f, llm.ByteOrder, uint32(3)); err != nil {
-		return err
-	}
-
-	if err := binary.Write(f, llm.ByteOrder, uint64(llm.V3.NumTensor)); err != nil {
-		return err
-	}
-
-	if err := binary.Write(f, llm.ByteOrder, uint64(llm.V3.NumKV)); err != nil {
-		return err
+	// any other keys follow in order
+	var rest []string
+	for k := range llm.KV {
+		if !slices.Contains(kOrder, k) {
+			rest = append(rest, k)
+		}
 	}
+	slices.Sort(rest)
+	kOrder = append(kOrder, rest...)
 
+	f := gguf.File{ByteOrder: llm.ByteOrder, Version: 3}
 	for _, k := range kOrder {
-		val, ok := llm.KV[k]
-		if !ok {
-			continue
-		}
-
-		if err := binary.Write(f, llm.ByteOrder, uint64(len(k))); err != nil {
-			return err
-		}
-		if err := binary.Write(f, llm.ByteOrder, []byte(k)); err != nil {
-			return err
-		}
-
-		switch v := val.(type) {
-		case uint32:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeUint32); err != nil {
-				return err
-			}
-
-			if err := llm.writeUint32(f, v); err != nil {
-				return err
-			}
-		case float32:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeFloat32); err != nil {
-				return err
-			}
-
-			if err := llm.writeF32(f, v); err != nil {
-				return err
-			}
-		case bool:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeBool); err != nil {
-				return err
-			}
-
-			if err := llm.writeBool(f, v); err != nil {
-				return err
-			}
-		case string:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeString); err != nil {
-				return err
-			}
-
-			if err := llm.writeString(f, v); err != nil {
-				return err
-			}
-		case []int32:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeArray); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeInt32); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, uint64(len(v))); err != nil {
-				return err
-			}
-			for _, i := range v {
-				if err := llm.writeInt32(f, i); err != nil {
-					return err
-				}
-			}
-		case []uint32:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeArray); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeUint32); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, uint64(len(v))); err != nil {
-				return err
-			}
-			for _, i := range v {
-				if err := llm.writeUint32(f, i); err != nil {
-					return err
-				}
-			}
-		case []float32:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeArray); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeFloat32); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, uint64(len(v))); err != nil {
+		if v, ok := llm.KV[k]; ok {
+			if err := f.Set(k, v); err != nil {
 				return err
 			}
-			for _, fl := range v {
-				if err := llm.writeF32(f, fl); err != nil {
-					return err
-				}
-			}
-		case []string:
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeArray); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, GGUFTypeString); err != nil {
-				return err
-			}
-
-			if err := binary.Write(f, llm.ByteOrder, uint64(len(v))); err != nil {
-				return err
-			}
-
-			for _, s := range v {
-				if err := llm.writeString(f, s); err != nil {
-					return err
-				}
-			}
 		}
 	}
 
-	// write layer metadata
 	for _, t := range llm.Tensors {
-		if err := llm.writeString(f, t.Name); err != nil {
-			return err
-		}
-
-		// the dimensions of the tensor
+		// the shape is in PyTorch order and padded with zeros
 		dims := 1
 		if t.Shape[1] > 0 {
 			dims = 2
 		}
 
-		if err := binary.Write(f, llm.ByteOrder, uint32(dims)); err != nil {
-			return err
-		}
-
-		for i := 0; i < dims; i++ {
-			if err := binary.Write(f, llm.ByteOrder, uint64(t.Shape[dims-1-i])); err != nil {
-				return err
-			}
-		}
-
-		if err := binary.Write(f, llm.ByteOrder, uint32(t.Kind)); err != nil {
-			return err
+		shape := make([]uint64, dims)
+		for i := range dims {
+			shape[i] = t.Shape[dims-1-i]
 		}
 
-		if err := binary.Write(f, llm.ByteOrder, uint64(t.Offset)); err != nil {
-			return err
-		}
+		f.Tensors = append(f.Tensors, gguf.Tensor{Name: t.Name, Type: t.Kind, Shape: shape, Offset: t.Offset, WriterTo: t.WriterTo})
 	}
 
-	offset, terr := f.Seek(0, io.SeekCurrent)
-	if terr != nil {
-		return terr
-	}
-	slog.Debug(fmt.Sprintf("tensors offset = %x", offset))
-
-	if err := llm.writePadding(f, 32); err != nil {
-		return err
-	}
-
-	var dataFile *os.File
-	var currentFile string
-	var err error
-	for _, t := range llm.Tensors {
-		if currentFile != t.FileName {
-			if f != nil {
-				dataFile.Close()
-			}
-			currentFile = t.FileName
-			dataFile, err = os.Open(t.FileName)
-			if err != nil {
-				fmt.Println(err)
-				return err
-			}
-		}
-
-		dataFile.Seek(int64(t.OffsetPadding+t.FileOffsets[0]), 0)
-
-		pattern := `^blk\.[0-9]+\.attn_(?P<layer>q|k)\.weight$`
-		re, err := regexp.Compile(pattern)
-		if err != nil {
-			return err
-		}
-
-		matches := re.FindAllStringSubmatch(t.Name, -1)
-		if len(matches) > 0 {
-			layerSize := t.FileOffsets[1] - t.FileOffsets[0]
-
-			var err error
-			tData := make([]uint16, layerSize/2)
-			if err = binary.Read(dataFile, llm.ByteOrder, tData); err != nil {
-				return err
-			}
-
-			layerType := matches[0][re.SubexpIndex("layer")]
-			var heads uint32
-			switch layerType {
-			case "q":
-				heads = llm.KV["llama.attention.head_count"].(uint32)
-			case "k":
-				heads = llm.KV["llama.attention.head_count_kv"].(uint32)
-				if heads == 0 {
-					heads = llm.KV["llama.attention.head_count"].(uint32)
-				}
-			}
-
-			tData, err = t.Repack(tData, int(heads))
-			if err != nil {
-				return err
-			}
-
-			var buf []byte
-			for _, n := range tData {
-				buf = binary.LittleEndian.AppendUint16(buf, n)
-			}
-
-			tempBuf := make([]uint16, len(tData))
-			tDataF32 := bfloat16.DecodeFloat32(buf)
-			for cnt, v := range tDataF32 {
-				tDataF16 := float16.Fromfloat32(v)
-				tempBuf[cnt] = uint16(tDataF16)
-			}
-
-			if err = binary.Write(f, llm.ByteOrder, tempBuf); err != nil {
-				return err
-			}
-
-			if err := llm.writePadding(f, 32); err != nil {
-				return err
-			}
-			continue
-		}
-
-		remaining := t.FileOffsets[1] - t.FileOffsets[0]
-
-		bufSize := uint64(10240)
-		var finished bool
-		for {
-			data := make([]byte, min(bufSize, remaining))
-
-			b, err := io.ReadFull(dataFile, data)
-			remaining -= uint64(b)
-
-			if err == io.EOF || remaining <= 0 {
-				finished = true
-			} else if err != nil {
-				return err
-			}
-
-			// convert bfloat16 -> ieee float32
-			tDataF32 := bfloat16.DecodeFloat32(data)
-
-			switch t.Kind {
-			case 0:
-				if err := binary.Write(f, llm.ByteOrder, tDataF32); err != nil {
-					return err
-				}
-			case 1:
-				// convert float32 -> float16
-				tempBuf := make([]uint16, len(data)/2)
-				for cnt, v := range tDataF32 {
-					tDataF16 := float16.Fromfloat32(v)
-					tempBuf[cnt] = uint16(tDataF16)
-				}
-				if err := binary.Write(f, llm.ByteOrder, tempBuf); err != nil {
-					return err
-				}
-			}
-			if finished {
-				break
-			}
-		}
-
-		if err := llm.writePadding(f, 32); err != nil {
-			return err
-		}
-	}
-	f.Close()
-
-	return nil
-}
-
-func (llm *GGUFModel) writePadding(f *os.File, align int64) error {
-	// gguf file padding is defined in https://github.com/ggerganov/ggml/blob/master/docs/gguf.md#file-structure
-	offset, err := f.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return err
-	}
-	padding := ((offset + align - 1) / align) * align
-	buf := make([]byte, padding-offset)
-	if err := binary.Write(f, llm.ByteOrder, buf); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (llm *GGUFModel) writeInt32(f *os.File, v int32) error {
-	if err := binary.Write(f, llm.ByteOrder, v); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (llm *GGUFModel) writeUint32(f *os.File, v uint32) error {
-	if err := binary.Write(f, llm.ByteOrder, v); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (llm *GGUFModel) writeF32(f *os.File, v float32) error {
-	if err := binary.Write(f, llm.ByteOrder, v); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (llm *GGUFModel) writeBool(f *os.File, b bool) error {
-	if err := binary.Write(f, llm.ByteOrder, b); err != nil {
-		return err
-	}
-	return nil
-}
-
-func (llm *GGUFModel) writeString(f *os.File, s string) error {
-	if err := binary.Write(f, llm.ByteOrder, uint64(len(s))); err != nil {
-		return err
-	}
-
-	if err := binary.Write(f, llm.ByteOrder, []byte(s)); err != nil {
-		return err
-	}
-	return nil
+	return f.Encode(w)
 }
 
 func (llm *GGUFModel) Decode(rso *readSeekOffset) error {
@@ -0,0 +1,498 @@
+package llm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/x448/float16"
+)
+
+// quantizeFileTypes are the file types models can be quantized to
+var quantizeFileTypes = []uint32{
+	fileTypeF32,
+	fileTypeF16,
+	fileTypeQ4_0,
+	fileTypeQ4_1,
+	fileTypeQ8_0,
+	fileTypeQ4_K_M,
+	fileTypeQ6_K,
+}
+
+// ParseFileType returns the file type called s, such as Q4_K_M, if models
+// can be quantized to it
+func ParseFileType(s string) (uint32, error) {
+	var names []string
+	for _, ft := range quantizeFileTypes {
+		if strings.EqualFold(s, fileType(ft)) {
+			return ft, nil
+		}
+
+		names = append(names, fileType(ft))
+	}
+
+	return 0, fmt.Errorf("unsupported quantization type %q, expected one of %s", s, strings.Join(names, ", "))
+}
+
+var blockPattern = regexp.MustCompile(`^blk\.(\d+)\.`)
+
+// QuantizeKind returns the kind of a tensor with dims dimensions and rows of
+// rowSize elements in a model of fileType with the given number of blocks.
+// This follows llama.cpp: some tensors keep more bits than the file type and
+// rows which can't be split into blocks fall back to a simpler kind.
+func QuantizeKind(name string, dims int, rowSize uint64, fileType uint32, blocks int) uint32 {
+	// norms and biases stay F32
+	if dims < 2 || fileType == fileTypeF32 {
+		return 0
+	}
+
+	var kind uint32
+	switch fileType {
+	case fileTypeQ4_0:
+		kind = 2
+	case fileTypeQ4_1:
+		kind = 3
+	case fileTypeQ8_0:
+		kind = 8
+	case fileTypeQ4_K_M:
+		kind = 12
+	case fileTypeQ6_K:
+		kind = 14
+	default:
+		return 1
+	}
+
+	switch {
+	case name == "output.weight" && kind != 8:
+		kind = 14
+	case fileType == fileTypeQ4_K_M && (strings.Contains(name, ".attn_v.") || strings.Contains(name, ".ffn_down")):
+		if m := blockPattern.FindStringSubmatch(name); m != nil {
+			if i, err := strconv.Atoi(m[1]); err == nil && useMoreBits(i, blocks) {
+				kind = 14
+			}
+		}
+	}
+
+	if rowSize%(Tensor{Kind: kind}).BlockSize() != 0 {
+		if rowSize%32 == 0 {
+			return 8
+		}
+
+		return 1
+	}
+
+	return kind
+}
+
+// useMoreBits reports whether block i of n keeps more bits, which is the
+// first and last eighth of the blocks and every third block between them
+func useMoreBits(i, n int) bool {
+	return i < n/8 || i >= 7*n/8 || (i-n/8)%3 == 2
+}
+
+// Quantize encodes data as kind. The data is a whole number of rows and each
+// row a whole number of the kind's blocks.
+func Quantize(kind uint32, data []float32) ([]byte, error) {
+	blockSize := int(Tensor{Kind: kind}.BlockSize())
+	if len(data)%blockSize != 0 {
+		return nil, fmt.Errorf("%d values can't be quantized in blocks of %d", len(data), blockSize)
+	}
+
+	var quantizeBlock func([]byte, []float32) []byte
+	switch kind {
+	case 0: // F32
+		b := make([]byte, 0, len(data)*4)
+		for _, v := range data {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+		}
+
+		return b, nil
+	case 1: // F16
+		b := make([]byte, 0, len(data)*2)
+		for _, v := range data {
+			b = binary.LittleEndian.AppendUint16(b, float16.Fromfloat32(v).Bits())
+		}
+
+		return b, nil
+	case 2:
+		quantizeBlock = quantizeQ4_0
+	case 3:
+		quantizeBlock = quantizeQ4_1
+	case 8:
+		quantizeBlock = quantizeQ8_0
+	case 12:
+		quantizeBlock = quantizeQ4_K
+	case 14:
+		quantizeBlock = quantizeQ6_K
+	default:
+		return nil, fmt.Errorf("can't quantize to %s", Tensor{Kind: kind}.TypeName())
+	}
+
+	b := make([]byte, 0, len(data)/blockSize*int(Tensor{Kind: kind}.TypeSize()))
+	for i := 0; i < len(data); i += blockSize {
+		b = quantizeBlock(b, data[i:i+blockSize])
+	}
+
+	return b, nil
+}
+
+func appendF16(b []byte, v float32) []byte {
+	return binary.LittleEndian.AppendUint16(b, float16.Fromfloat32(v).Bits())
+}
+
+func f16(v float32) float32 {
+	return float16.Fromfloat32(v).Float32()
+}
+
+// nearestInt rounds like llama.cpp's nearest_int
+func nearestInt(v float32) int {
+	return int(math.RoundToEven(float64(v)))
+}
+
+func clamp(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+// quantizeQ4_0 appends a block of 32 values as a scale and 4 bit values
+func quantizeQ4_0(b []byte, x []float32) []byte {
+	// the scale keeps the sign of the largest value so it maps to -8
+	var amax, vmax float32
+	for _, v := range x {
+		if abs := float32(math.Abs(float64(v))); abs > amax {
+			amax, vmax = abs, v
+		}
+	}
+
+	d := vmax / -8
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	b = appendF16(b, d)
+	for j := range 16 {
+		q0 := min(15, int(x[j]*id+8.5))
+		q1 := min(15, int(x[j+16]*id+8.5))
+		b = append(b, byte(q0)|byte(q1)<<4)
+	}
+
+	return b
+}
+
+// quantizeQ4_1 appends a block of 32 values as a scale, a minimum and 4 bit
+// values
+func quantizeQ4_1(b []byte, x []float32) []byte {
+	vmin, vmax := x[0], x[0]
+	for _, v := range x {
+		vmin, vmax = min(vmin, v), max(vmax, v)
+	}
+
+	d := (vmax - vmin) / 15
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	b = appendF16(b, d)
+	b = appendF16(b, vmin)
+	for j := range 16 {
+		q0 := min(15, int((x[j]-vmin)*id+0.5))
+		q1 := min(15, int((x[j+16]-vmin)*id+0.5))
+		b = append(b, byte(q0)|byte(q1)<<4)
+	}
+
+	return b
+}
+
+// quantizeQ8_0 appends a block of 32 values as a scale and 8 bit values
+func quantizeQ8_0(b []byte, x []float32) []byte {
+	var amax float32
+	for _, v := range x {
+		amax = max(amax, float32(math.Abs(float64(v))))
+	}
+
+	d := amax / 127
+	var id float32
+	if d != 0 {
+		id = 1 / d
+	}
+
+	b = appendF16(b, d)
+	for _, v := range x {
+		b = append(b, byte(int8(nearestInt(v*id))))
+	}
+
+	return b
+}
+
+// quantizeQ4_K appends a super block of 256 values as eight blocks of 32 4 bit
+// values, each with a 6 bit scale and minimum
+func quantizeQ4_K(b []byte, x []float32) []byte {
+	var L [256]int
+	var scales, mins [8]float32
+	var maxScale, maxMin float32
+	for j := range 8 {
+		block := x[32*j : 32*j+32]
+
+		var sum2 float32
+		for _, v := range block {
+			sum2 += v * v
+		}
+
+		avg := float32(math.Sqrt(float64(sum2 / 32)))
+
+		var weights [32]float32
+		for l, v := range block {
+			weights[l] = avg + float32(math.Abs(float64(v)))
+		}
+
+		scales[j], mins[j] = makeQKX2Quants(15, block, weights[:], L[32*j:32*j+32], -1, 0.1, 20)
+		maxScale, maxMin = max(maxScale, scales[j]), max(maxMin, mins[j])
+	}
+
+	var invScale, invMin float32
+	if maxScale > 0 {
+		invScale = 63 / maxScale
+	}
+
+	if maxMin > 0 {
+		invMin = 63 / maxMin
+	}
+
+	var sc [12]byte
+	for j := range 8 {
+		ls := byte(min(63, nearestInt(invScale*scales[j])))
+		lm := byte(min(63, nearestInt(invMin*mins[j])))
+		if j < 4 {
+			sc[j] = ls
+			sc[j+4] = lm
+		} else {
+			sc[j+4] = ls&0xf | lm&0xf<<4
+			sc[j-4] |= ls >> 4 << 6
+			sc[j] |= lm >> 4 << 6
+		}
+	}
+
+	d, dmin := f16(maxScale/63), f16(maxMin/63)
+	for j := range 8 {
+		s, m := scaleMinK4(j, sc[:])
+		if d*float32(s) == 0 {
+			continue
+		}
+
+		for l := range 32 {
+			L[32*j+l] = clamp(nearestInt((x[32*j+l]+dmin*float32(m))/(d*float32(s))), 0, 15)
+		}
+	}
+
+	b = appendF16(b, d)
+	b = appendF16(b, dmin)
+	b = append(b, sc[:]...)
+	for j := 0; j < 256; j += 64 {
+		for l := range 32 {
+			b = append(b, byte(L[j+l])|byte(L[j+l+32])<<4)
+		}
+	}
+
+	return b
+}
+
+// scaleMinK4 returns the 6 bit scale and minimum of block j of a Q4_K super
+// block
+func scaleMinK4(j int, q []byte) (byte, byte) {
+	if j < 4 {
+		return q[j] & 63, q[j+4] & 63
+	}
+
+	return q[j+4]&0xf | q[j-4]>>6<<4, q[j+4]>>4 | q[j]>>6<<4
+}
+
+// makeQKX2Quants quantizes x to values from 0 to nmax with a scale and
+// minimum, searching nsteps scales for the smallest weighted squared error.
+// It returns the scale and the negated minimum.
+func makeQKX2Quants(nmax int, x, weights []float32, L []int, rmin, rdelta float32, nsteps int) (float32, float32) {
+	vmin, vmax := x[0], x[0]
+	var sumW, sumX float32
+	for i, v := range x {
+		vmin, vmax = min(vmin, v), max(vmax, v)
+		sumW += weights[i]
+		sumX += weights[i] * v
+	}
+
+	vmin = min(vmin, 0)
+	if vmax == vmin {
+		for i := range L {
+			L[i] = 0
+		}
+
+		return 0, -vmin
+	}
+
+	iscale := float32(nmax) / (vmax - vmin)
+	scale := 1 / iscale
+
+	var bestMAD float32
+	for i, v := range x {
+		L[i] = clamp(nearestInt(iscale*(v-vmin)), 0, nmax)
+		diff := scale*float32(L[i]) + vmin - v
+		bestMAD += weights[i] * diff * diff
+	}
+
+	Laux := make([]int, len(x))
+	for is := 0; is <= nsteps; is++ {
+		iscale := (rmin + rdelta*float32(is) + float32(nmax)) / (vmax - vmin)
+
+		var sumL, sumL2, sumXL float32
+		for i, v := range x {
+			l := clamp(nearestInt(iscale*(v-vmin)), 0, nmax)
+			Laux[i] = l
+			w := weights[i]
+			sumL += w * float32(l)
+			sumL2 += w * float32(l*l)
+			sumXL += w * float32(l) * v
+		}
+
+		D := sumW*sumL2 - sumL*sumL
+		if D > 0 {
+			thisScale := (sumW*sumXL - sumX*sumL) / D
+			thisMin := (sumL2*sumX - sumL*sumXL) / D
+			if thisMin > 0 {
+				thisMin = 0
+				thisScale = sumXL / sumL2
+			}
+
+			var mad float32
+			for i, v := range x {
+				diff := thisScale*float32(Laux[i]) + thisMin - v
+				mad += weights[i] * diff * diff
+			}
+
+			if mad < bestMAD {
+				copy(L, Laux)
+				bestMAD = mad
+				scale = thisScale
+				vmin = thisMin
+			}
+		}
+	}
+
+	return scale, -vmin
+}
+
+// quantizeQ6_K appends a super block of 256 values as sixteen blocks of 16 6
+// bit values, each with an 8 bit scale
+func quantizeQ6_K(b []byte, x []float32) []byte {
+	var L [256]int
+	var scales [16]float32
+	var maxScale, maxAbsScale float32
+	for ib := range 16 {
+		scales[ib] = makeQXQuants(32, x[16*ib:16*ib+16], L[16*ib:16*ib+16])
+		if abs := float32(math.Abs(float64(scales[ib]))); abs > maxAbsScale {
+			maxAbsScale, maxScale = abs, scales[ib]
+		}
+	}
+
+	if maxAbsScale == 0 {
+		return append(b, make([]byte, 210)...)
+	}
+
+	iscale := -128 / maxScale
+	d := f16(1 / iscale)
+
+	var sc [16]int8
+	for ib := range 16 {
+		sc[ib] = int8(min(127, nearestInt(iscale*scales[ib])))
+	}
+
+	for j := range 16 {
+		dj := d * float32(sc[j])
+		if dj == 0 {
+			continue
+		}
+
+		for ii := range 16 {
+			L[16*j+ii] = clamp(nearestInt(x[16*j+ii]/dj), -32, 31) + 32
+		}
+	}
+
+	var ql [128]byte
+	var qh [64]byte
+	for j := 0; j < 256; j += 128 {
+		for l := range 32 {
+			q1, q2, q3, q4 := L[j+l], L[j+l+32], L[j+l+64], L[j+l+96]
+			ql[j/2+l] = byte(q1&0xf | q3&0xf<<4)
+			ql[j/2+l+32] = byte(q2&0xf | q4&0xf<<4)
+			qh[j/4+l] = byte(q1>>4 | q2>>4<<2 | q3>>4<<4 | q4>>4<<6)
+		}
+	}
+
+	b = append(b, ql[:]...)
+	b = append(b, qh[:]...)
+	for _, s := range sc {
+		b = append(b, byte(s))
+	}
+
+	return appendF16(b, d)
+}
+
+// makeQXQuants quantizes x to values from -nmax to nmax-1 with a scale,
+// searching scales around nmax for the smallest squared error weighted by
+// x². The values are stored offset by nmax.
+func makeQXQuants(nmax int, x []float32, L []int) float32 {
+	var amax, vmax float32
+	for _, v := range x {
+		if abs := float32(math.Abs(float64(v))); abs > amax {
+			amax, vmax = abs, v
+		}
+	}
+
+	if amax < 1e-30 {
+		for i := range L {
+			L[i] = 0
+		}
+
+		return 0
+	}
+
+	sums := func(iscale float32) (float32, float32) {
+		var sumLX, sumL2 float32
+		for _, v := range x {
+			l := float32(clamp(nearestInt(iscale*v), -nmax, nmax-1))
+			w := v * v
+			sumLX += w * v * l
+			sumL2 += w * l * l
+		}
+
+		return sumLX, sumL2
+	}
+
+	fill := func(iscale float32) {
+		for i, v := range x {
+			L[i] = clamp(nearestInt(iscale*v), -nmax, nmax-1) + nmax
+		}
+	}
+
+	iscale := -float32(nmax) / vmax
+	fill(iscale)
+	sumLX, sumL2 := sums(iscale)
+	scale := sumLX / sumL2
+	best := scale * sumLX
+	for is := -9; is <= 9; is++ {
+		if is == 0 {
+			continue
+		}
+
+		iscale := -(float32(nmax) + 0.1*float32(is)) / vmax
+		sumLX, sumL2 := sums(iscale)
+		if sumL2 > 0 && sumLX*sumLX > best*sumL2 {
+			fill(iscale)
+			scale = sumLX / sumL2
+			best = scale * sumLX
+		}
+	}
+
+	return scale
+}
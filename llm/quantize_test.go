@@ -0,0 +1,173 @@
+package llm
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/x448/float16"
+)
+
+func readF16(b []byte) float32 {
+	return float16.Frombits(binary.LittleEndian.Uint16(b)).Float32()
+}
+
+// dequantize decodes blocks of kind, following llama.cpp's dequantize_row_*
+func dequantize(t *testing.T, kind uint32, b []byte) []float32 {
+	var y []float32
+	typeSize := int(Tensor{Kind: kind}.TypeSize())
+	for ; len(b) > 0; b = b[typeSize:] {
+		switch kind {
+		case 2: // Q4_0
+			d := readF16(b)
+			var block [32]float32
+			for j := range 16 {
+				block[j] = float32(int(b[2+j]&0xf)-8) * d
+				block[j+16] = float32(int(b[2+j]>>4)-8) * d
+			}
+			y = append(y, block[:]...)
+		case 3: // Q4_1
+			d, m := readF16(b), readF16(b[2:])
+			var block [32]float32
+			for j := range 16 {
+				block[j] = float32(b[4+j]&0xf)*d + m
+				block[j+16] = float32(b[4+j]>>4)*d + m
+			}
+			y = append(y, block[:]...)
+		case 8: // Q8_0
+			d := readF16(b)
+			for j := range 32 {
+				y = append(y, float32(int8(b[2+j]))*d)
+			}
+		case 12: // Q4_K
+			d, dmin := readF16(b), readF16(b[2:])
+			sc, q := b[4:16], b[16:144]
+			for j := 0; j < 8; j += 2 {
+				s1, m1 := scaleMinK4(j, sc)
+				s2, m2 := scaleMinK4(j+1, sc)
+				for l := range 32 {
+					y = append(y, d*float32(s1)*float32(q[l]&0xf)-dmin*float32(m1))
+				}
+				for l := range 32 {
+					y = append(y, d*float32(s2)*float32(q[l]>>4)-dmin*float32(m2))
+				}
+				q = q[32:]
+			}
+		case 14: // Q6_K
+			ql, qh, sc := b[:128], b[128:192], b[192:208]
+			d := readF16(b[208:])
+			for n := 0; n < 256; n += 128 {
+				var block [128]float32
+				for l := range 32 {
+					is := l / 16
+					q1 := int(ql[l]&0xf|(qh[l]>>0&3)<<4) - 32
+					q2 := int(ql[l+32]&0xf|(qh[l]>>2&3)<<4) - 32
+					q3 := int(ql[l]>>4|(qh[l]>>4&3)<<4) - 32
+					q4 := int(ql[l+32]>>4|(qh[l]>>6&3)<<4) - 32
+					block[l] = d * float32(int8(sc[is])) * float32(q1)
+					block[l+32] = d * float32(int8(sc[is+2])) * float32(q2)
+					block[l+64] = d * float32(int8(sc[is+4])) * float32(q3)
+					block[l+96] = d * float32(int8(sc[is+6])) * float32(q4)
+				}
+				y = append(y, block[:]...)
+				ql, qh, sc = ql[64:], qh[32:], sc[8:]
+			}
+		default:
+			t.Fatalf("can't dequantize %d", kind)
+		}
+	}
+
+	return y
+}
+
+func TestQuantize(t *testing.T) {
+	// a row of values like a weight's, with one outlier
+	data := make([]float32, 512)
+	for i := range data {
+		data[i] = float32(math.Sin(float64(i)*0.37)) * 0.05
+	}
+	data[100] = 0.4
+
+	cases := []struct {
+		kind uint32
+		rmse float64
+	}{
+		{2, 0.006},
+		{3, 0.005},
+		{8, 0.0004},
+		{12, 0.003},
+		{14, 0.0008},
+	}
+
+	for _, tt := range cases {
+		t.Run(Tensor{Kind: tt.kind}.TypeName(), func(t *testing.T) {
+			b, err := Quantize(tt.kind, data)
+			assert.Nil(t, err)
+			assert.Equal(t, len(data)/int(Tensor{Kind: tt.kind}.BlockSize())*int(Tensor{Kind: tt.kind}.TypeSize()), len(b))
+
+			y := dequantize(t, tt.kind, b)
+			assert.Len(t, y, len(data))
+
+			var sum float64
+			for i := range data {
+				sum += math.Pow(float64(y[i]-data[i]), 2)
+			}
+
+			assert.Less(t, math.Sqrt(sum/float64(len(data))), tt.rmse)
+		})
+	}
+
+	// zeros stay zeros
+	for _, kind := range []uint32{2, 3, 8, 12, 14} {
+		b, err := Quantize(kind, make([]float32, 256))
+		assert.Nil(t, err)
+		assert.Equal(t, make([]float32, 256), dequantize(t, kind, b))
+	}
+
+	_, err := Quantize(12, make([]float32, 32))
+	assert.ErrorContains(t, err, "can't be quantized in blocks of 256")
+
+	_, err = Quantize(6, make([]float32, 32))
+	assert.ErrorContains(t, err, "can't quantize to Q5_0")
+}
+
+func TestQuantizeKind(t *testing.T) {
+	ft, err := ParseFileType("q4_k_m")
+	assert.Nil(t, err)
+	assert.Equal(t, fileTypeQ4_K_M, ft)
+
+	_, err = ParseFileType("Q3_K_S")
+	assert.ErrorContains(t, err, `unsupported quantization type "Q3_K_S"`)
+
+	cases := []struct {
+		name     string
+		dims     int
+		rowSize  uint64
+		fileType uint32
+		kind     uint32
+	}{
+		{"blk.0.attn_norm.weight", 1, 4096, fileTypeQ4_K_M, 0},
+		{"blk.0.attn_q.weight", 2, 4096, fileTypeF32, 0},
+		{"blk.0.attn_q.weight", 2, 4096, fileTypeF16, 1},
+		{"blk.0.attn_q.weight", 2, 4096, fileTypeQ4_0, 2},
+		{"blk.0.attn_q.weight", 2, 4096, fileTypeQ8_0, 8},
+		{"output.weight", 2, 4096, fileTypeQ4_0, 14},
+		{"output.weight", 2, 4096, fileTypeQ8_0, 8},
+		{"blk.10.attn_q.weight", 2, 4096, fileTypeQ4_K_M, 12},
+		// the first and last eighth of the blocks and every third between them keep more bits
+		{"blk.0.attn_v.weight", 2, 4096, fileTypeQ4_K_M, 14},
+		{"blk.31.ffn_down.weight", 2, 11008, fileTypeQ4_K_M, 14},
+		{"blk.12.ffn_down.weight", 2, 4096, fileTypeQ4_K_M, 14},
+		{"blk.10.ffn_down.weight", 2, 4096, fileTypeQ4_K_M, 12},
+		{"blk.11.attn_v.weight", 2, 4096, fileTypeQ4_K_M, 12},
+		{"blk.12.ffn_down.3.weight", 2, 4096, fileTypeQ4_K_M, 14},
+		// rows which don't fit the blocks fall back
+		{"blk.10.attn_q.weight", 2, 96, fileTypeQ4_K_M, 8},
+		{"blk.10.attn_q.weight", 2, 16, fileTypeQ4_0, 1},
+	}
+
+	for _, tt := range cases {
+		assert.Equal(t, tt.kind, QuantizeKind(tt.name, tt.dims, tt.rowSize, tt.fileType, 32), tt.name)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log/slog"
 	"slices"
+	"strings"
 )
 
 type Command struct {
@@ -20,6 +21,17 @@ func (c *Command) Reset() {
 	c.Args = ""
 }
 
+// AdapterArgs splits the args of an ADAPTER command into the adapter's path
+// and whether it's merged into the model's weights, which is when the path is
+// followed by MERGE
+func AdapterArgs(args string) (path string, merge bool) {
+	if i := strings.LastIndexAny(args, " \t"); i >= 0 && strings.EqualFold(args[i+1:], "MERGE") {
+		return strings.TrimSpace(args[:i]), true
+	}
+
+	return args, false
+}
+
 func Parse(reader io.Reader) ([]Command, error) {
 	var commands []Command
 	var command, modelCommand Command
@@ -96,3 +96,23 @@ MESSAGE badguy I'm a bad guy!
 	_, err := Parse(reader)
 	assert.ErrorContains(t, err, "role must be one of \"system\", \"user\", or \"assistant\"")
 }
+
+func Test_AdapterArgs(t *testing.T) {
+	cases := []struct {
+		args  string
+		path  string
+		merge bool
+	}{
+		{"./adapter", "./adapter", false},
+		{"./adapter MERGE", "./adapter", true},
+		{"./my adapter  merge", "./my adapter", true},
+		{"./merge", "./merge", false},
+		{"@sha256:abc MERGE", "@sha256:abc", true},
+	}
+
+	for _, tt := range cases {
+		path, merge := AdapterArgs(tt.args)
+		assert.Equal(t, tt.path, path, tt.args)
+		assert.Equal(t, tt.merge, merge, tt.args)
+	}
+}
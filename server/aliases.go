@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// Aliases are names which resolve to another model when it is used, unlike
+// copies which are separate manifests. They are kept in aliases.json in each
+// models root, the writable root's aliases are the ones which can be changed.
+
+var (
+	errAliasNotFound = errors.New("alias not found")
+	errAliasCycle    = errors.New("alias refers to itself")
+)
+
+// maxAliasDepth limits how many aliases may be followed to reach a model
+const maxAliasDepth = 8
+
+// aliasesMu guards the writable root's aliases file
+var aliasesMu sync.Mutex
+
+func readAliasesFile(root string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	bts, err := os.ReadFile(filepath.Join(root, "aliases.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return aliases, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &aliases); err != nil {
+		return nil, fmt.Errorf("invalid aliases in %s: %w", root, err)
+	}
+
+	return aliases, nil
+}
+
+// readAliases returns the aliases of every root keyed by full name. Aliases in
+// earlier roots take precedence.
+func readAliases() (map[string]string, error) {
+	roots, err := modelRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := make(map[string]string)
+	for i := len(roots) - 1; i >= 0; i-- {
+		rootAliases, err := readAliasesFile(roots[i].path)
+		if err != nil {
+			return nil, err
+		}
+
+		for alias, target := range rootAliases {
+			aliases[alias] = target
+		}
+	}
+
+	return aliases, nil
+}
+
+// updateAliases applies fn to the aliases of the writable root and saves them
+func updateAliases(fn func(map[string]string) error) error {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+
+	dir, err := modelsDir()
+	if err != nil {
+		return err
+	}
+
+	aliases, err := readAliasesFile(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(aliases); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	bts, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "aliases.json"), bts, 0o644)
+}
+
+// resolveAlias follows aliases from name until it reaches a model which
+// exists. Names which aren't aliases are returned as they are.
+func resolveAlias(name string) (string, error) {
+	aliases, err := readAliases()
+	if err != nil {
+		return "", err
+	}
+
+	seen := make(map[string]bool)
+	for range maxAliasDepth {
+		mp := ParseModelPath(name)
+		if _, _, err := GetManifest(mp); !errors.Is(err, os.ErrNotExist) {
+			// models take precedence over aliases with the same name
+			return name, nil
+		}
+
+		target, ok := aliases[mp.GetFullTagname()]
+		if !ok {
+			return name, nil
+		}
+
+		if seen[target] {
+			return "", fmt.Errorf("%w: %s", errAliasCycle, mp.GetShortTagname())
+		}
+
+		seen[target] = true
+		name = target
+	}
+
+	return "", fmt.Errorf("%w: more than %d aliases to follow", errAliasCycle, maxAliasDepth)
+}
+
+// CreateAlias makes alias resolve to target, which must exist
+func CreateAlias(alias, target string) error {
+	amp := ParseModelPath(alias)
+	if err := amp.Validate(); err != nil {
+		return err
+	}
+
+	if _, _, err := GetManifest(amp); err == nil {
+		return fmt.Errorf("model '%s' already exists, remove it before creating an alias with its name", amp.GetShortTagname())
+	}
+
+	resolved, err := resolveAlias(target)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := GetManifest(ParseModelPath(resolved)); err != nil {
+		return err
+	}
+
+	return updateAliases(func(aliases map[string]string) error {
+		aliases[amp.GetFullTagname()] = ParseModelPath(target).GetFullTagname()
+		return nil
+	})
+}
+
+// DeleteAlias removes alias without touching the model it resolves to
+func DeleteAlias(alias string) error {
+	return updateAliases(func(aliases map[string]string) error {
+		key := ParseModelPath(alias).GetFullTagname()
+		if _, ok := aliases[key]; !ok {
+			return fmt.Errorf("%w: %s", errAliasNotFound, alias)
+		}
+
+		delete(aliases, key)
+		return nil
+	})
+}
+
+// dropAliases removes the aliases of the writable root which resolve to the
+// model called name, directly or through other aliases, and returns them
+func dropAliases(name string) ([]string, error) {
+	var dropped []string
+	err := updateAliases(func(aliases map[string]string) error {
+		targets := map[string]bool{ParseModelPath(name).GetFullTagname(): true}
+		for found := true; found; {
+			found = false
+			for alias, target := range aliases {
+				if targets[target] {
+					targets[alias] = true
+					dropped = append(dropped, alias)
+					delete(aliases, alias)
+					found = true
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return dropped, err
+}
+
+// ListAliases returns every alias sorted by name
+func ListAliases() ([]api.Alias, error) {
+	aliases, err := readAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]api.Alias, 0, len(aliases))
+	for alias, target := range aliases {
+		list = append(list, api.Alias{
+			Alias:  ParseModelPath(alias).GetShortTagname(),
+			Target: ParseModelPath(target).GetShortTagname(),
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Alias < list[j].Alias
+	})
+
+	return list, nil
+}
+
+// maxTagPages limits how many pages of tags are requested from a registry
+const maxTagPages = 1000
+
+// RemoteTags lists the tags of the repository of name in its registry, following
+// the registry's pagination. A page which links to a page it has already
+// returned ends the list.
+func RemoteTags(ctx context.Context, name string, regOpts *registryOptions) ([]string, error) {
+	mp := ParseModelPath(name)
+	if mp.ProtocolScheme == "http" && !regOpts.Insecure {
+		return nil, ErrInsecureProtocol
+	}
+
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "tags", "list")
+
+	var tags []string
+	visited := make(map[string]bool)
+	for requestURL != nil && !visited[requestURL.String()] {
+		if len(visited) == maxTagPages {
+			return nil, fmt.Errorf("%s has more than %d pages of tags", mp.GetNamespaceRepository(), maxTagPages)
+		}
+
+		visited[requestURL.String()] = true
+
+		resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, nil, nil, regOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, list.Tags...)
+
+		requestURL, err = nextPage(requestURL, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// nextPage returns the URL of the next page from a Link header such as
+// `</v2/library/llama2/tags/list?last=7b&n=100>; rel="next"`, or nil if there
+// isn't one
+func nextPage(base *url.URL, link string) (*url.URL, error) {
+	for _, part := range strings.Split(link, ",") {
+		ref, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			continue
+		}
+
+		next, err := url.Parse(strings.Trim(strings.TrimSpace(ref), "<>"))
+		if err != nil {
+			return nil, err
+		}
+
+		return base.ResolveReference(next), nil
+	}
+
+	return nil, nil
+}
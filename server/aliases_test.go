@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+func TestAliases(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	createModelfile(t, "original", "SYSTEM hello")
+
+	assert.Nil(t, CreateAlias("prod", "original"))
+	assert.Nil(t, CreateAlias("latest-prod", "prod"))
+
+	for _, name := range []string{"prod", "latest-prod"} {
+		model, err := GetModel(name)
+		assert.Nil(t, err)
+		assert.Equal(t, "original:latest", model.ShortName)
+	}
+
+	aliases, err := ListAliases()
+	assert.Nil(t, err)
+	assert.Equal(t, []api.Alias{
+		{Alias: "latest-prod:latest", Target: "prod:latest"},
+		{Alias: "prod:latest", Target: "original:latest"},
+	}, aliases)
+
+	// aliases must refer to a model, which rules out cycles, and can't replace one
+	assert.ErrorIs(t, CreateAlias("dangling", "missing"), os.ErrNotExist)
+	assert.ErrorContains(t, CreateAlias("original", "prod"), "already exists")
+
+	// a model with the alias's name takes precedence
+	createModelfile(t, "shadow", "SYSTEM hello")
+	assert.Nil(t, CreateAlias("other", "shadow"))
+	assert.Nil(t, CopyModel("original", "other"))
+	model, err := GetModel("other")
+	assert.Nil(t, err)
+	assert.Equal(t, "other:latest", model.ShortName)
+
+	assert.Nil(t, DeleteAlias("prod"))
+	assert.ErrorIs(t, DeleteAlias("prod"), errAliasNotFound)
+
+	// the alias which referred to prod no longer resolves
+	_, err = GetModel("latest-prod")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = GetModel("original")
+	assert.Nil(t, err)
+}
+
+func TestRemoteTags(t *testing.T) {
+	pages := [][]string{{"7b", "latest"}, {"13b"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/llama2/tags/list" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		page := pages[0]
+		if r.URL.Query().Get("last") == "latest" {
+			page = pages[1]
+		} else {
+			w.Header().Set("Link", `</v2/library/llama2/tags/list?last=latest&n=2>; rel="next"`)
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"name": "library/llama2", "tags": page})
+	}))
+	defer srv.Close()
+
+	name := fmt.Sprintf("http://%s/library/llama2:7b", srv.Listener.Addr())
+
+	_, err := RemoteTags(context.TODO(), name, &registryOptions{})
+	assert.ErrorIs(t, err, ErrInsecureProtocol)
+
+	tags, err := RemoteTags(context.TODO(), name, &registryOptions{Insecure: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"13b", "7b", "latest"}, tags)
+
+	_, err = RemoteTags(context.TODO(), fmt.Sprintf("http://%s/library/missing", srv.Listener.Addr()), &registryOptions{Insecure: true})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	t.Run("endless", func(t *testing.T) {
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the second page links to itself
+			requests++
+			w.Header().Set("Link", `</v2/library/llama2/tags/list?last=loop>; rel="next"`)
+
+			json.NewEncoder(w).Encode(map[string]any{"name": "library/llama2", "tags": []string{"latest"}})
+		}))
+		defer srv.Close()
+
+		name := fmt.Sprintf("http://%s/library/llama2", srv.Listener.Addr())
+		tags, err := RemoteTags(context.TODO(), name, &registryOptions{Insecure: true})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "latest"}, tags)
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("too many pages", func(t *testing.T) {
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Link", fmt.Sprintf(`</v2/library/llama2/tags/list?last=%d>; rel="next"`, requests))
+			json.NewEncoder(w).Encode(map[string]any{"name": "library/llama2", "tags": []string{fmt.Sprint(requests)}})
+		}))
+		defer srv.Close()
+
+		name := fmt.Sprintf("http://%s/library/llama2", srv.Listener.Addr())
+		_, err := RemoteTags(context.TODO(), name, &registryOptions{Insecure: true})
+		assert.ErrorContains(t, err, "more than 1000 pages of tags")
+		assert.Equal(t, maxTagPages, requests)
+	})
+}
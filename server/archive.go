@@ -0,0 +1,359 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// Models are exported as OCI image layout archives. Each model is a manifest
+// in index.json named by the org.opencontainers.image.ref.name annotation, and
+// every blob, including the manifest itself, is stored under blobs/sha256.
+
+const (
+	ociLayoutFile        = "oci-layout"
+	ociIndexFile         = "index.json"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type archiveEntry struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+func archiveBlobName(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// modelArchive lists the files of the archive for the model called name
+func modelArchive(name string) ([]archiveEntry, error) {
+	mp := ParseModelPath(name)
+	manifest, digest, err := GetManifest(mp)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestPath, _, err := mp.findManifestPath()
+	if err != nil {
+		return nil, err
+	}
+
+	manifestJSON, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := json.Marshal(map[string]string{"imageLayoutVersion": "1.0.0"})
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests: []ociDescriptor{
+			{
+				MediaType:   mediaTypeDockerManifest,
+				Digest:      "sha256:" + digest,
+				Size:        int64(len(manifestJSON)),
+				Annotations: map[string]string{ociRefNameAnnotation: mp.GetFullTagname()},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bytesEntry := func(name string, bts []byte) archiveEntry {
+		return archiveEntry{
+			name: name,
+			size: int64(len(bts)),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(bts)), nil
+			},
+		}
+	}
+
+	entries := []archiveEntry{
+		bytesEntry(ociLayoutFile, layout),
+		bytesEntry(archiveBlobName("sha256:"+digest), manifestJSON),
+	}
+
+	for _, layer := range append([]*Layer{manifest.Config}, manifest.Layers...) {
+		fp, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		fi, err := os.Stat(fp)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, archiveEntry{
+			name: archiveBlobName(layer.Digest),
+			size: fi.Size(),
+			open: func() (io.ReadCloser, error) { return os.Open(fp) },
+		})
+	}
+
+	return append(entries, bytesEntry(ociIndexFile, index)), nil
+}
+
+// ArchiveSize returns the size in bytes of the archive SaveModel writes for name
+func ArchiveSize(name string) (int64, error) {
+	entries, err := modelArchive(name)
+	if err != nil {
+		return 0, err
+	}
+
+	// every entry is a 512 byte header followed by its contents padded to 512
+	// bytes. The archive ends with two empty blocks.
+	var size int64 = 1024
+	for _, entry := range entries {
+		size += 512 + (entry.size+511)/512*512
+	}
+
+	return size, nil
+}
+
+// SaveModel writes the model called name to w as an OCI image layout tar archive
+func SaveModel(name string, w io.Writer) error {
+	entries, err := modelArchive(name)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	now := time.Now().Truncate(time.Second)
+	for _, entry := range entries {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.name,
+			Size:     entry.size,
+			Mode:     0o644,
+			ModTime:  now,
+			Format:   tar.FormatUSTAR,
+		}); err != nil {
+			return err
+		}
+
+		r, err := entry.open()
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tw, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// errInvalidArchive is returned by LoadModels for archives which can't be loaded
+var errInvalidArchive = errors.New("invalid archive")
+
+// LoadModels imports the models in an OCI image layout tar archive and returns
+// their names. Blobs which already exist locally are skipped, every other blob
+// is checked against its digest before it is added to the store.
+func LoadModels(r io.Reader, fn func(api.ProgressResponse)) ([]string, error) {
+	var index *ociIndex
+
+	// small blobs are kept until the index is read since they may be
+	// manifests, which aren't blobs of the store
+	small := make(map[string][]byte)
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidArchive, err)
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(strings.TrimPrefix(h.Name, "./"))
+		switch {
+		case name == ociIndexFile:
+			if err := json.NewDecoder(tr).Decode(&index); err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", errInvalidArchive, ociIndexFile, err)
+			}
+		case strings.HasPrefix(name, "blobs/sha256/"):
+			digest := "sha256:" + strings.TrimPrefix(name, "blobs/sha256/")
+			if !digestRegexp.MatchString(digest) {
+				return nil, fmt.Errorf("%w: invalid blob %q", errInvalidArchive, h.Name)
+			}
+
+			if h.Size < 1<<20 {
+				bts, err := io.ReadAll(tr)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", errInvalidArchive, err)
+				}
+
+				small[digest] = bts
+				continue
+			}
+
+			if err := importBlob(digest, tr, fn); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if index == nil {
+		return nil, fmt.Errorf("%w: it has no %s", errInvalidArchive, ociIndexFile)
+	}
+
+	manifests := make(map[string][]byte)
+	for _, d := range index.Manifests {
+		if bts, ok := small[d.Digest]; ok {
+			manifests[d.Digest] = bts
+			delete(small, d.Digest)
+		}
+	}
+
+	for digest, bts := range small {
+		if err := importBlob(digest, bytes.NewReader(bts), fn); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, d := range index.Manifests {
+		name := d.Annotations[ociRefNameAnnotation]
+		if name == "" {
+			return nil, fmt.Errorf("%w: manifest %s has no %s annotation", errInvalidArchive, d.Digest, ociRefNameAnnotation)
+		}
+
+		mp := ParseModelPath(name)
+		if err := validateRegistryPath(mp); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidArchive, err)
+		}
+
+		manifestJSON, ok := manifests[d.Digest]
+		if !ok {
+			return nil, fmt.Errorf("%w: manifest %s is missing", errInvalidArchive, d.Digest)
+		}
+
+		if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifestJSON)); digest != d.Digest {
+			return nil, fmt.Errorf("%w: %w: want %s, got %s", errInvalidArchive, errDigestMismatch, d.Digest, digest)
+		}
+
+		var manifest ManifestV2
+		if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+			return nil, fmt.Errorf("%w: manifest %s: %w", errInvalidArchive, d.Digest, err)
+		}
+
+		if err := checkManifest(&manifest); err != nil {
+			return nil, fmt.Errorf("%w: manifest %s: %w", errInvalidArchive, d.Digest, err)
+		}
+
+		for _, layer := range append([]*Layer{manifest.Config}, manifest.Layers...) {
+			fp, err := GetBlobsPath(layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := os.Stat(fp); err != nil {
+				return nil, fmt.Errorf("%w: blob %s of %s is missing", errInvalidArchive, layer.Digest, name)
+			}
+		}
+
+		fn(api.ProgressResponse{Status: fmt.Sprintf("writing manifest for %s", mp.GetShortTagname())})
+		fp, err := mp.GetManifestPath()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			return nil, err
+		}
+
+		// write the manifest as is so its digest is preserved
+		if err := os.WriteFile(fp, manifestJSON, 0o644); err != nil {
+			return nil, err
+		}
+
+		names = append(names, mp.GetShortTagname())
+	}
+
+	return names, nil
+}
+
+// importTempPrefix starts the names of blobs which are being imported. They
+// don't end in -partial so they're never mistaken for partial downloads.
+const importTempPrefix = ".import-"
+
+// importBlob adds a blob to the store. The blob is written to a temporary
+// file, which is only renamed to the blob once it's verified.
+func importBlob(digest string, r io.Reader, fn func(api.ProgressResponse)) error {
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(fp); err == nil {
+		fn(api.ProgressResponse{Status: fmt.Sprintf("using already created layer %s", digest)})
+		return nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(fp), importTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: %w", errInvalidArchive, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	status := fmt.Sprintf("importing layer %s", digest)
+	if err := verifyFile(f.Name(), digest, func(completed, total int64) {
+		fn(api.ProgressResponse{Status: status, Digest: digest, Total: total, Completed: completed})
+	}); err != nil {
+		if errors.Is(err, errDigestMismatch) {
+			err = fmt.Errorf("%w: %w", errInvalidArchive, err)
+		}
+
+		return err
+	}
+
+	return os.Rename(f.Name(), fp)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+func TestSaveLoadModel(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	createModelfile(t, "archived", "SYSTEM hello")
+
+	manifest, digest, err := GetManifest(ParseModelPath("archived"))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, SaveModel("archived", &buf))
+
+	size, err := ArchiveSize("archived")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), size)
+
+	archive := buf.Bytes()
+
+	t.Run("load", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		names, err := LoadModels(bytes.NewReader(archive), func(api.ProgressResponse) {})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"archived:latest"}, names)
+
+		loaded, loadedDigest, err := GetManifest(ParseModelPath("archived"))
+		assert.Nil(t, err)
+		assert.Equal(t, digest, loadedDigest)
+		assert.Equal(t, manifest.Layers, loaded.Layers)
+
+		// only the config and layers are blobs, the manifest isn't
+		dir, err := GetBlobsPath("")
+		assert.Nil(t, err)
+		entries, err := os.ReadDir(dir)
+		assert.Nil(t, err)
+		assert.Len(t, entries, len(manifest.Layers)+1)
+
+		fp, err := GetBlobsPath("sha256:" + loadedDigest)
+		assert.Nil(t, err)
+		assert.NoFileExists(t, fp)
+
+		// loading again reuses the existing blobs
+		var statuses []string
+		_, err = LoadModels(bytes.NewReader(archive), func(resp api.ProgressResponse) {
+			statuses = append(statuses, resp.Status)
+		})
+		assert.Nil(t, err)
+		assert.Contains(t, statuses, "using already created layer "+manifest.Layers[0].Digest)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		// rewrite the archive with the model blob replaced by different bytes
+		var corrupt bytes.Buffer
+		tw := tar.NewWriter(&corrupt)
+		tr := tar.NewReader(bytes.NewReader(archive))
+		for {
+			h, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.Nil(t, err)
+
+			bts, err := io.ReadAll(tr)
+			assert.Nil(t, err)
+
+			if h.Name == archiveBlobName(manifest.Layers[0].Digest) {
+				bts = []byte("GGUF\x03\x00")
+			}
+
+			assert.Nil(t, tw.WriteHeader(h))
+			_, err = tw.Write(bts)
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, tw.Close())
+
+		corruptArchive := corrupt.Bytes()
+		_, err := LoadModels(bytes.NewReader(corruptArchive), func(api.ProgressResponse) {})
+		assert.ErrorIs(t, err, errDigestMismatch)
+		assert.ErrorIs(t, err, errInvalidArchive)
+
+		fp, err := GetBlobsPath(manifest.Layers[0].Digest)
+		assert.Nil(t, err)
+		_, err = os.Stat(fp)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+
+		// nothing which was being imported is left behind
+		entries, err := os.ReadDir(filepath.Dir(fp))
+		assert.Nil(t, err)
+		for _, entry := range entries {
+			assert.False(t, strings.HasPrefix(entry.Name(), importTempPrefix), entry.Name())
+		}
+
+		_, _, err = GetManifest(ParseModelPath("archived"))
+		assert.NotNil(t, err)
+
+		// invalid archives are bad requests
+		code, _, _ := loadArchive(t, "?stream=false", corruptArchive)
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+		// progress is streamed and the last response lists the models
+		code, contentType, body := loadArchive(t, "", archive)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "application/x-ndjson", contentType)
+
+		var responses []api.LoadResponse
+		for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+			var r api.LoadResponse
+			assert.Nil(t, json.Unmarshal([]byte(line), &r))
+			responses = append(responses, r)
+		}
+
+		assert.Contains(t, responses, api.LoadResponse{
+			ProgressResponse: api.ProgressResponse{Status: "importing layer " + manifest.Layers[0].Digest, Digest: manifest.Layers[0].Digest, Total: 6, Completed: 6},
+		})
+		assert.Equal(t, api.LoadResponse{ProgressResponse: api.ProgressResponse{Status: "success"}, Models: []string{"archived:latest"}}, responses[len(responses)-1])
+
+		// errors which aren't the archive's fault are server errors
+		dir := t.TempDir()
+		t.Setenv("OLLAMA_MODELS", dir)
+		writableRoots.Store(dir, false)
+		t.Cleanup(func() { writableRoots.Delete(dir) })
+
+		code, _, _ = loadArchive(t, "?stream=false", archive)
+		assert.Equal(t, http.StatusInternalServerError, code)
+	})
+}
+
+func loadArchive(t *testing.T, query string, archive []byte) (int, string, string) {
+	var s Server
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/load"+query, "application/x-tar", bytes.NewReader(archive))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+}
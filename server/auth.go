@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -20,6 +21,7 @@ import (
 )
 
 type registryChallenge struct {
+	Scheme  string
 	Realm   string
 	Service string
 	Scope   string
@@ -93,3 +95,103 @@ func getAuthorizationToken(ctx context.Context, challenge registryChallenge) (st
 
 	return token.Token, nil
 }
+
+// authenticate responds to a registry challenge by updating regOpts with whatever
+// the next attempt of the request needs. Credentials for host are taken from
+// regOpts if set and the docker client configuration otherwise. Requests to the
+// default registry without credentials are signed with the ollama key; any other
+// registry gets the standard docker token flow.
+func authenticate(ctx context.Context, host string, challenge registryChallenge, regOpts *registryOptions) error {
+	if regOpts.Username == "" && regOpts.Password == "" {
+		username, password, err := dockerCredentials(ctx, host)
+		if err != nil {
+			slog.Info(fmt.Sprintf("couldn't read docker credentials for %s: %v", host, err))
+		}
+
+		regOpts.Username, regOpts.Password = username, password
+	}
+
+	// makeRequest only sends basic auth with both a username and a password
+	hasCredentials := regOpts.Username != "" && regOpts.Password != ""
+
+	var token string
+	var err error
+	switch {
+	case strings.EqualFold(challenge.Scheme, "basic"):
+		if !hasCredentials {
+			return errUnauthorized
+		}
+
+		// makeRequest prefers the token over basic auth so drop it
+		regOpts.Token = ""
+		return nil
+	case host == DefaultRegistry && !hasCredentials:
+		token, err = getAuthorizationToken(ctx, challenge)
+	default:
+		token, err = getRegistryToken(ctx, challenge, regOpts)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	regOpts.Token = token
+	return nil
+}
+
+// getRegistryToken requests a bearer token from the realm of a docker registry
+// challenge, using basic auth if regOpts has credentials and anonymously otherwise.
+func getRegistryToken(ctx context.Context, challenge registryChallenge, regOpts *registryOptions) (string, error) {
+	redirectURL, err := url.Parse(challenge.Realm)
+	if err != nil {
+		return "", err
+	}
+
+	values := redirectURL.Query()
+	if challenge.Service != "" {
+		values.Add("service", challenge.Service)
+	}
+
+	for _, s := range strings.Fields(challenge.Scope) {
+		values.Add("scope", s)
+	}
+
+	redirectURL.RawQuery = values.Encode()
+
+	// only forward credentials, never a stale token
+	tokenOpts := &registryOptions{Username: regOpts.Username, Password: regOpts.Password}
+	response, err := makeRequest(ctx, http.MethodGet, redirectURL, nil, nil, tokenOpts)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", fmt.Errorf("%d: %v", response.StatusCode, err)
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		if len(body) > 0 {
+			return "", fmt.Errorf("%d: %s", response.StatusCode, body)
+		}
+
+		return "", fmt.Errorf("%d", response.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+
+	if token.Token == "" {
+		// some token servers, e.g. oauth2 compatible ones, only set access_token
+		return token.AccessToken, nil
+	}
+
+	return token.Token, nil
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRegistryChallenge(t *testing.T) {
+	cases := []struct {
+		header string
+		want   registryChallenge
+	}{
+		{
+			`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:library/llama2:pull"`,
+			registryChallenge{
+				Scheme:  "Bearer",
+				Realm:   "https://auth.example.com/token",
+				Service: "registry.example.com",
+				Scope:   "repository:library/llama2:pull",
+			},
+		},
+		{
+			`Basic realm="Registry Realm"`,
+			registryChallenge{Scheme: "Basic", Realm: "Registry Realm"},
+		},
+	}
+
+	for _, tt := range cases {
+		assert.Equal(t, tt.want, parseRegistryChallenge(tt.header))
+	}
+}
+
+func TestDockerCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	username, password, err := dockerCredentials(context.TODO(), "registry.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "", username)
+	assert.Equal(t, "", password)
+
+	config := `{
+		"auths": {
+			"https://registry.example.com/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("alice:s3cr:et")) + `"},
+			"zot.internal:5000": {"username": "bob", "password": "hunter2"}
+		}
+	}`
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600))
+
+	username, password, err = dockerCredentials(context.TODO(), "registry.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", username)
+	assert.Equal(t, "s3cr:et", password)
+
+	username, password, err = dockerCredentials(context.TODO(), "zot.internal:5000")
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", username)
+	assert.Equal(t, "hunter2", password)
+}
+
+func TestHelperCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper stand-in is a shell script")
+	}
+
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	script := `#!/bin/sh
+read host
+echo "{\"Username\": \"carol\", \"Secret\": \"$host\"}"
+`
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(script), 0o755))
+
+	username, password, err := helperCredentials(context.TODO(), "test", "registry.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "carol", username)
+	assert.Equal(t, "registry.example.com", password)
+
+	// helpers are stopped with the request
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, _, err = helperCredentials(ctx, "test", "registry.example.com")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAuthenticateBasic(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	challenge := registryChallenge{Scheme: "Basic", Realm: "Registry Realm"}
+
+	// basic auth is only sent with both a username and a password
+	regOpts := &registryOptions{Username: "alice"}
+	assert.ErrorIs(t, authenticate(context.TODO(), "registry.example.com", challenge, regOpts), errUnauthorized)
+
+	regOpts = &registryOptions{Username: "alice", Password: "s3cret", Token: "stale"}
+	assert.Nil(t, authenticate(context.TODO(), "registry.example.com", challenge, regOpts))
+	assert.Empty(t, regOpts.Token)
+}
+
+// newTestRegistry starts a registry stand-in which serves name:latest as an OCI
+// image index and requires a bearer token from its own token endpoint. The
+// token endpoint only accepts username/password.
+func newTestRegistry(t *testing.T, name, username, password string) *httptest.Server {
+	manifest, err := json.Marshal(ManifestV2{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        &Layer{MediaType: "application/vnd.docker.container.image.v1+json", Digest: "sha256:c0ffee", Size: 2},
+		Layers:        []*Layer{{MediaType: "application/vnd.ollama.image.model", Digest: "sha256:beef", Size: 4}},
+	})
+	assert.Nil(t, err)
+
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+	index, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIIndex,
+		"manifests": []map[string]any{
+			{"mediaType": mediaTypeOCIManifest, "digest": manifestDigest, "size": len(manifest)},
+		},
+	})
+	assert.Nil(t, err)
+
+	const token = "test-token"
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			u, p, ok := r.BasicAuth()
+			if !ok || u != username || p != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			assert.Equal(t, "repository:"+name+":pull", r.URL.Query().Get("scope"))
+			json.NewEncoder(w).Encode(map[string]string{"access_token": token})
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:%s:pull"`, srv.URL, name))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/" + name + "/manifests/latest":
+			assert.Contains(t, r.Header.Get("Accept"), mediaTypeOCIIndex)
+			w.Header().Set("Content-Type", mediaTypeOCIIndex)
+			w.Write(index)
+		case "/v2/" + name + "/manifests/" + manifestDigest:
+			w.Header().Set("Content-Type", mediaTypeOCIManifest)
+			w.Write(manifest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestPullModelManifestOCIIndex(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	srv := newTestRegistry(t, "library/llama2", "alice", "hunter2")
+	u, err := url.Parse(srv.URL)
+	assert.Nil(t, err)
+
+	mp := ParseModelPath(fmt.Sprintf("http://%s/library/llama2", u.Host))
+
+	_, err = pullModelManifest(context.TODO(), mp, &registryOptions{})
+	assert.NotNil(t, err)
+
+	m, err := pullModelManifest(context.TODO(), mp, &registryOptions{Username: "alice", Password: "hunter2"})
+	assert.Nil(t, err)
+	assert.Equal(t, "sha256:c0ffee", m.Config.Digest)
+	assert.Equal(t, 1, len(m.Layers))
+	assert.Equal(t, "sha256:beef", m.Layers[0].Digest)
+}
+
+func TestPullModelManifestDockerConfig(t *testing.T) {
+	srv := newTestRegistry(t, "library/llama2", "bob", "hunter2")
+	u, err := url.Parse(srv.URL)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	auth := base64.StdEncoding.EncodeToString([]byte("bob:hunter2"))
+	config := fmt.Sprintf(`{"auths": {%q: {"auth": %q}}}`, u.Host, auth)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600))
+
+	mp := ParseModelPath(fmt.Sprintf("http://%s/library/llama2", u.Host))
+	m, err := pullModelManifest(context.TODO(), mp, &registryOptions{})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(m.Layers[0].MediaType, "application/vnd.ollama.image"))
+}
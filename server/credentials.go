@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// credentialHelperTimeout limits how long a docker credential helper may run
+const credentialHelperTimeout = 30 * time.Second
+
+// dockerConfig is the subset of the docker client configuration, usually
+// ~/.docker/config.json, that holds registry credentials
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func dockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// dockerCredentials returns the username and password stored for host in the
+// docker client configuration. It returns empty strings if there are none.
+func dockerCredentials(ctx context.Context, host string) (string, string, error) {
+	fp, err := dockerConfigPath()
+	if err != nil {
+		return "", "", err
+	}
+
+	bts, err := os.ReadFile(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	var config dockerConfig
+	if err := json.Unmarshal(bts, &config); err != nil {
+		return "", "", fmt.Errorf("%s: %w", fp, err)
+	}
+
+	if helper, ok := config.CredHelpers[host]; ok {
+		return helperCredentials(ctx, helper, host)
+	}
+
+	for k, v := range config.Auths {
+		if normalizeRegistryHost(k) != host {
+			continue
+		}
+
+		if v.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(v.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("%s: %w", fp, err)
+			}
+
+			username, password, _ := strings.Cut(string(decoded), ":")
+			return username, password, nil
+		}
+
+		if v.Username != "" {
+			return v.Username, v.Password, nil
+		}
+	}
+
+	if config.CredsStore != "" {
+		return helperCredentials(ctx, config.CredsStore, host)
+	}
+
+	return "", "", nil
+}
+
+// normalizeRegistryHost reduces keys of the auths section, which may be URLs
+// such as https://index.docker.io/v1/, to a bare host
+func normalizeRegistryHost(s string) string {
+	if _, after, ok := strings.Cut(s, "://"); ok {
+		s = after
+	}
+
+	s, _, _ = strings.Cut(s, "/")
+	return s
+}
+
+// helperCredentials runs a docker credential helper, docker-credential-<helper>,
+// following the docker credential helper protocol. Helpers which don't answer
+// within credentialHelperTimeout are stopped.
+func helperCredentials(ctx context.Context, helper, host string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, credentialHelperTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// helpers exit non-zero when they have no credentials for host
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", "", err
+		} else if ctx.Err() != nil {
+			return "", "", fmt.Errorf("docker-credential-%s: %w", helper, ctx.Err())
+		}
+
+		return "", "", nil
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", err
+	}
+
+	return creds.Username, creds.Secret, nil
+}
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -43,6 +44,12 @@ type blobDownload struct {
 
 	context.CancelFunc
 
+	// limiter caps the rate of the download at the highest limit of the
+	// pulls waiting on it, or doesn't if one of them has no limit
+	limiter *rateLimiter
+	limitMu sync.Mutex
+	limits  []int64
+
 	done       bool
 	err        error
 	references atomic.Int32
@@ -64,6 +71,21 @@ const (
 	maxDownloadPartSize int64 = 1000 * format.MegaByte
 )
 
+// downloadParts returns how many parts a blob is split into and downloaded
+// concurrently, set by OLLAMA_DOWNLOAD_PARTS
+func downloadParts() int {
+	if s := os.Getenv("OLLAMA_DOWNLOAD_PARTS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err == nil && n > 0 {
+			return n
+		}
+
+		slog.Info(fmt.Sprintf("ignoring invalid OLLAMA_DOWNLOAD_PARTS %q", s))
+	}
+
+	return numDownloadParts
+}
+
 func (p *blobDownloadPart) Name() string {
 	return strings.Join([]string{
 		p.blobDownload.Name, "partial", strconv.Itoa(p.N),
@@ -111,7 +133,7 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 
 		b.Total, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 
-		size := b.Total / numDownloadParts
+		size := b.Total / int64(downloadParts())
 		switch {
 		case size < minDownloadPartSize:
 			size = minDownloadPartSize
@@ -154,7 +176,7 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 	_ = file.Truncate(b.Total)
 
 	g, inner := errgroup.WithContext(ctx)
-	g.SetLimit(numDownloadParts)
+	g.SetLimit(downloadParts())
 	for i := range b.Parts {
 		part := b.Parts[i]
 		if part.Completed == part.Size {
@@ -221,7 +243,15 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 		}
 		defer resp.Body.Close()
 
-		n, err := io.Copy(w, io.TeeReader(resp.Body, part))
+		body := &rateLimitedReader{
+			ctx:      ctx,
+			r:        resp.Body,
+			limiters: []*rateLimiter{globalDownloadLimiter(), b.limiter},
+			// throttled parts aren't stalled
+			waited: func() { part.lastUpdated = time.Now() },
+		}
+
+		n, err := io.Copy(w, io.TeeReader(body, part))
 		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
 			// rollback progress
 			b.Completed.Add(-n)
@@ -307,9 +337,42 @@ func (b *blobDownload) release() {
 	}
 }
 
-func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse)) error {
+// limit adds the rate limit of a pull waiting on the download and returns a
+// function which removes it
+func (b *blobDownload) limit(rate int64) func() {
+	b.limitMu.Lock()
+	defer b.limitMu.Unlock()
+
+	b.limits = append(b.limits, rate)
+	b.setRate()
+
+	return func() {
+		b.limitMu.Lock()
+		defer b.limitMu.Unlock()
+
+		i := slices.Index(b.limits, rate)
+		b.limits = slices.Delete(b.limits, i, i+1)
+		b.setRate()
+	}
+}
+
+// setRate sets the rate of the limiter from the limits of the waiting pulls.
+// b.limitMu must be held.
+func (b *blobDownload) setRate() {
+	var rate int64
+	if len(b.limits) > 0 && slices.Min(b.limits) > 0 {
+		rate = slices.Max(b.limits)
+	}
+
+	b.limiter.setRate(rate)
+}
+
+// Wait waits for the download, limiting it to rate bytes per second for as
+// long as this pull waits, unless rate isn't positive
+func (b *blobDownload) Wait(ctx context.Context, rate int64, fn func(api.ProgressResponse)) error {
 	b.acquire()
 	defer b.release()
+	defer b.limit(rate)()
 
 	ticker := time.NewTicker(60 * time.Millisecond)
 	for {
@@ -361,19 +424,104 @@ func downloadBlob(ctx context.Context, opts downloadOpts) error {
 		return nil
 	}
 
-	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
-	download := data.(*blobDownload)
-	if !ok {
-		requestURL := opts.mp.BaseURL()
-		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
-		if err := download.Prepare(ctx, requestURL, opts.regOpts); err != nil {
-			blobDownloadManager.Delete(opts.digest)
-			return err
+	for {
+		data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest, limiter: &rateLimiter{}})
+		download := data.(*blobDownload)
+		if !ok {
+			requestURL, regOpts := blobURL(ctx, opts.mp, opts.digest, opts.regOpts)
+			if err := download.Prepare(ctx, requestURL, regOpts); err != nil {
+				blobDownloadManager.Delete(opts.digest)
+				return err
+			}
+
+			// nolint: contextcheck
+			go download.Run(context.Background(), requestURL, regOpts)
+		}
+
+		err := download.Wait(ctx, opts.regOpts.LimitRate, opts.fn)
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			// joined a download as the pulls waiting on it went away, start it again
+			continue
 		}
 
-		// nolint: contextcheck
-		go download.Run(context.Background(), requestURL, opts.regOpts)
+		return err
+	}
+}
+
+// pullQueue limits how many pulls run at once. Pulls over the limit wait in
+// the order they arrived.
+type pullQueue struct {
+	mu      sync.Mutex
+	active  int
+	waiting []chan struct{}
+}
+
+var pulls pullQueue
+
+// maxPulls returns the number of concurrent pulls allowed by OLLAMA_MAX_PULLS,
+// or 0 for no limit
+func maxPulls() int {
+	if s := os.Getenv("OLLAMA_MAX_PULLS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err == nil && n >= 0 {
+			return n
+		}
+
+		slog.Info(fmt.Sprintf("ignoring invalid OLLAMA_MAX_PULLS %q", s))
+	}
+
+	return 0
+}
+
+// acquire blocks until the pull can start, reporting it as queued while it
+// waits. The returned func must be called when the pull finishes.
+func (q *pullQueue) acquire(ctx context.Context, fn func(api.ProgressResponse)) (func(), error) {
+	q.mu.Lock()
+	if limit := maxPulls(); limit == 0 || q.active < limit {
+		q.active++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+
+	ch := make(chan struct{})
+	q.waiting = append(q.waiting, ch)
+	q.mu.Unlock()
+
+	fn(api.ProgressResponse{Status: "queued"})
+
+	select {
+	case <-ch:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		for i := range q.waiting {
+			if q.waiting[i] == ch {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+
+		// the slot was handed over as the context was canceled so pass it on
+		q.releaseLocked()
+		return nil, ctx.Err()
+	}
+}
+
+func (q *pullQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+func (q *pullQueue) releaseLocked() {
+	if len(q.waiting) > 0 {
+		// hand the slot to the next pull so active stays the same
+		close(q.waiting[0])
+		q.waiting = q.waiting[1:]
+		return
 	}
 
-	return download.Wait(ctx, opts.fn)
+	q.active--
 }
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+func TestPullQueue(t *testing.T) {
+	t.Setenv("OLLAMA_MAX_PULLS", "1")
+
+	var q pullQueue
+	noop := func(api.ProgressResponse) {}
+
+	release, err := q.acquire(context.TODO(), noop)
+	assert.Nil(t, err)
+
+	// a canceled pull leaves the queue
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = q.acquire(ctx, noop)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, q.waiting)
+
+	started := make(chan string, 2)
+	queued := make(chan struct{}, 2)
+	for _, name := range []string{"first", "second"} {
+		go func() {
+			release, err := q.acquire(context.TODO(), func(resp api.ProgressResponse) {
+				assert.Equal(t, "queued", resp.Status)
+				queued <- struct{}{}
+			})
+			assert.Nil(t, err)
+			started <- name
+			release()
+		}()
+
+		// wait for each pull to queue so they are in order
+		<-queued
+	}
+
+	select {
+	case name := <-started:
+		t.Fatalf("%s started before a slot was free", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	assert.Equal(t, "first", <-started)
+	assert.Equal(t, "second", <-started)
+
+	assert.Eventually(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.active == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// gcGracePeriod protects blobs which were just written, e.g. by a create
+// which hasn't written its manifest yet
+const gcGracePeriod = time.Hour
+
+// GarbageCollect removes blobs which no manifest references and partial
+// downloads which no pull will resume. With dryRun nothing is removed but the
+// response is the same.
+func GarbageCollect(dryRun bool) (*api.GCResponse, error) {
+	dir, err := GetBlobsPath("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// partial downloads of running or recorded pulls are kept
+	inUse := make(map[string]bool)
+	blobDownloadManager.Range(func(key, _ any) bool {
+		inUse[key.(string)] = true
+		return true
+	})
+
+	records, err := readPullRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		for _, digest := range record.Digests {
+			inUse[digest] = true
+		}
+	}
+
+	var resp api.GCResponse
+	sizes := make(map[string]int64)
+	deleteMap := make(map[string]struct{})
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		recent := time.Since(info.ModTime()) < gcGracePeriod
+
+		// imports which were interrupted are removed like partial downloads
+		blob, _, partial := strings.Cut(entry.Name(), "-partial")
+		partial = partial || strings.HasPrefix(entry.Name(), importTempPrefix)
+		digest := strings.Replace(blob, "-", ":", 1)
+		switch {
+		case !partial:
+			if digestRegexp.MatchString(digest) && !recent {
+				sizes[digest] = info.Size()
+				deleteMap[digest] = struct{}{}
+			}
+
+			continue
+		case inUse[digest], recent:
+			// a download or a layer which is still being written
+			continue
+		}
+
+		if !dryRun {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+
+		resp.Partials = append(resp.Partials, entry.Name())
+		resp.Reclaimed += info.Size()
+	}
+
+	if err := deleteUnusedLayers(nil, deleteMap, dryRun); err != nil {
+		return nil, err
+	}
+
+	for digest := range deleteMap {
+		resp.Blobs = append(resp.Blobs, digest)
+		resp.Reclaimed += sizes[digest]
+	}
+
+	sort.Strings(resp.Blobs)
+	sort.Strings(resp.Partials)
+	return &resp, nil
+}
+
+// localModels returns the full names of the models in every root
+func localModels() ([]string, error) {
+	var names []string
+	err := walkManifests(func(_ modelRoot, name, _ string, _ os.FileInfo) error {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(names)
+	return names, nil
+}
+
+// VerifyModels re-hashes the blobs of the model called name, or of every model
+// if name is empty, and reports the ones which don't match their digest and
+// the models whose manifests can't be read. With repair, corrupt blobs are
+// removed and the models using them pulled again. Models whose manifests
+// can't be read are removed if they can't be pulled again.
+func VerifyModels(ctx context.Context, name string, repair bool, fn func(api.VerifyResponse)) error {
+	names := []string{name}
+	if name == "" {
+		var err error
+		if names, err = localModels(); err != nil {
+			return err
+		}
+	}
+
+	// blobs shared by several models are only checked once
+	var digests []string
+	users := make(map[string][]string)
+	corrupt := make(map[string]bool)
+	unreadable := make(map[string]bool)
+	for _, name := range names {
+		mp := ParseModelPath(name)
+		manifest, _, err := GetManifest(mp)
+		if err == nil {
+			err = checkManifest(manifest)
+		}
+
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				if len(names) == 1 {
+					return err
+				}
+
+				// the model was removed since it was listed
+				continue
+			}
+
+			fn(api.VerifyResponse{Status: "corrupt", Models: []string{mp.GetShortTagname()}, Reason: err.Error()})
+			corrupt[mp.GetShortTagname()] = true
+			unreadable[mp.GetShortTagname()] = true
+			continue
+		}
+
+		for _, layer := range append([]*Layer{manifest.Config}, manifest.Layers...) {
+			if _, ok := users[layer.Digest]; !ok {
+				digests = append(digests, layer.Digest)
+			}
+
+			users[layer.Digest] = append(users[layer.Digest], mp.GetShortTagname())
+		}
+	}
+
+	for _, digest := range digests {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := verifyBlobProgress(digest, fn); err != nil {
+			if !errors.Is(err, errDigestMismatch) && !errors.Is(err, errInvalidDigest) && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+
+			fn(api.VerifyResponse{Status: "corrupt", Digest: digest, Models: users[digest], Reason: err.Error()})
+			for _, name := range users[digest] {
+				corrupt[name] = true
+			}
+
+			if repair {
+				fp, err := GetBlobsPath(digest)
+				if err != nil {
+					return err
+				}
+
+				if inReadOnlyRoot(fp) {
+					fn(api.VerifyResponse{Status: fmt.Sprintf("couldn't repair %s: %v", digest, errModelReadOnly)})
+					for _, name := range users[digest] {
+						delete(corrupt, name)
+					}
+
+					continue
+				}
+
+				if err := os.Remove(fp); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+		}
+	}
+
+	if repair {
+		for _, name := range names {
+			short := ParseModelPath(name).GetShortTagname()
+			if !corrupt[short] {
+				continue
+			}
+
+			fn(api.VerifyResponse{Status: fmt.Sprintf("repairing %s", short)})
+			progress := func(resp api.ProgressResponse) {
+				fn(api.VerifyResponse{Status: resp.Status, Digest: resp.Digest, Total: resp.Total, Completed: resp.Completed})
+			}
+
+			err := activePulls.pull(ctx, name, &registryOptions{}, progress)
+			switch {
+			case err == nil:
+			case unreadable[short]:
+				// models which were created locally can't be pulled, and
+				// they can't be used without their manifest
+				if err := removeManifest(ParseModelPath(name)); err != nil {
+					fn(api.VerifyResponse{Status: fmt.Sprintf("couldn't repair %s: %v", short, err)})
+					continue
+				}
+
+				fn(api.VerifyResponse{Status: fmt.Sprintf("removed %s", short)})
+			default:
+				// models which were created locally can't be pulled
+				fn(api.VerifyResponse{Status: fmt.Sprintf("couldn't repair %s: %v", short, err)})
+			}
+		}
+	}
+
+	fn(api.VerifyResponse{Status: "success"})
+	return nil
+}
+
+var (
+	errInvalidManifest = errors.New("invalid manifest")
+	errInvalidDigest   = errors.New("invalid digest")
+)
+
+// checkManifest checks that a manifest has a config and that its digests are
+// valid, since they're used as file names
+func checkManifest(m *ManifestV2) error {
+	if m.Config == nil {
+		return fmt.Errorf("%w: it has no config", errInvalidManifest)
+	}
+
+	for _, layer := range append([]*Layer{m.Config}, m.Layers...) {
+		if layer == nil {
+			return fmt.Errorf("%w: it has an empty layer", errInvalidManifest)
+		}
+
+		if !digestRegexp.MatchString(layer.Digest) {
+			return fmt.Errorf("%w: %w %q", errInvalidManifest, errInvalidDigest, layer.Digest)
+		}
+	}
+
+	return nil
+}
+
+// removeManifest removes the manifest of mp, which can't be read so the model
+// can't be removed with DeleteModel
+func removeManifest(mp ModelPath) error {
+	fp, root, err := mp.findManifestPath()
+	if err != nil {
+		return err
+	}
+
+	if root.readOnly {
+		return fmt.Errorf("%w: %s", errModelReadOnly, root.path)
+	}
+
+	if err := os.Remove(fp); err != nil {
+		return err
+	}
+
+	forgetModel(mp.GetFullTagname())
+	return nil
+}
+
+// verifyBlobProgress is verifyBlob reporting how much of the blob it has read
+func verifyBlobProgress(digest string, fn func(api.VerifyResponse)) error {
+	if !digestRegexp.MatchString(digest) {
+		return fmt.Errorf("%w %q", errInvalidDigest, digest)
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	status := fmt.Sprintf("verifying %s", digest[7:19])
+	return verifyFile(fp, digest, func(completed, total int64) {
+		fn(api.VerifyResponse{Status: status, Digest: digest, Total: total, Completed: completed})
+	})
+}
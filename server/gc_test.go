@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	createModelfile(t, "collected", "SYSTEM hello")
+
+	dir, err := GetBlobsPath("")
+	assert.Nil(t, err)
+
+	write := func(name string, age time.Duration) string {
+		fp := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(fp, []byte(name), 0o644))
+		mtime := time.Now().Add(-age)
+		assert.Nil(t, os.Chtimes(fp, mtime, mtime))
+		return fp
+	}
+
+	orphan := write("sha256-"+fakeDigest('a'), 2*time.Hour)
+	recent := write("sha256-"+fakeDigest('b'), time.Minute)
+	stale := write("sha256-"+fakeDigest('c')+"-partial", 2*time.Hour)
+	recorded := write("sha256-"+fakeDigest('d')+"-partial-0", 2*time.Hour)
+
+	assert.Nil(t, writePullRecord("recorded", pullRecord{Model: "recorded", Digests: []string{"sha256:" + fakeDigest('d')}}))
+
+	resp, err := GarbageCollect(true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"sha256:" + fakeDigest('a')}, resp.Blobs)
+	assert.Equal(t, []string{filepath.Base(stale)}, resp.Partials)
+	assert.Equal(t, int64(len(filepath.Base(orphan))+len(filepath.Base(stale))), resp.Reclaimed)
+
+	// a dry run doesn't remove anything
+	for _, fp := range []string{orphan, recent, stale, recorded} {
+		assert.FileExists(t, fp)
+	}
+
+	collected, err := GarbageCollect(false)
+	assert.Nil(t, err)
+	assert.Equal(t, resp, collected)
+
+	assert.NoFileExists(t, orphan)
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, recent)
+	assert.FileExists(t, recorded)
+
+	// the model's own blobs are untouched
+	_, err = GetModel("collected")
+	assert.Nil(t, err)
+	assert.Nil(t, VerifyModels(context.TODO(), "collected", false, func(resp api.VerifyResponse) {
+		assert.NotEqual(t, "corrupt", resp.Status)
+	}))
+}
+
+func TestVerifyModels(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	createModelfile(t, "first", "SYSTEM hello")
+	createModelfile(t, "second", "SYSTEM hello")
+
+	manifest, _, err := GetManifest(ParseModelPath("first"))
+	assert.Nil(t, err)
+
+	var modelLayer *Layer
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == "application/vnd.ollama.image.model" {
+			modelLayer = layer
+		}
+	}
+
+	fp, err := GetBlobsPath(modelLayer.Digest)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(fp, []byte("corrupted"), 0o644))
+
+	var corrupt []api.VerifyResponse
+	var status string
+	assert.Nil(t, VerifyModels(context.TODO(), "", false, func(resp api.VerifyResponse) {
+		if resp.Status == "corrupt" {
+			corrupt = append(corrupt, resp)
+		}
+
+		status = resp.Status
+	}))
+
+	assert.Equal(t, "success", status)
+
+	// both models share the blob so it's reported once
+	assert.Len(t, corrupt, 1)
+	assert.Equal(t, modelLayer.Digest, corrupt[0].Digest)
+	assert.Equal(t, []string{"first:latest", "second:latest"}, corrupt[0].Models)
+	assert.ErrorContains(t, verifyBlob(modelLayer.Digest), errDigestMismatch.Error())
+
+	for _, manifest := range []string{"{", "{}", `{"config":{"digest":"sha256:"}}`, `{"config":{"digest":"sha256:` + fakeDigest('a') + `"},"layers":[null]}`} {
+		t.Run("manifest "+manifest, func(t *testing.T) {
+			t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+			// the model can't be pulled again from a registry which isn't running
+			name := "127.0.0.1:1/library/broken"
+			createModelfile(t, name, "SYSTEM hello")
+
+			fp, err := ParseModelPath(name).GetManifestPath()
+			assert.Nil(t, err)
+			assert.Nil(t, os.WriteFile(fp, []byte(manifest), 0o644))
+
+			var statuses []string
+			assert.Nil(t, VerifyModels(context.TODO(), "", true, func(resp api.VerifyResponse) {
+				statuses = append(statuses, resp.Status)
+			}))
+
+			assert.Contains(t, statuses, "corrupt")
+			assert.Contains(t, statuses, "repairing 127.0.0.1:1/library/broken:latest")
+			assert.Contains(t, statuses, "removed 127.0.0.1:1/library/broken:latest")
+			assert.NoFileExists(t, fp)
+		})
+	}
+
+	t.Run("digest", func(t *testing.T) {
+		assert.ErrorIs(t, verifyBlobProgress("sha256:a", func(api.VerifyResponse) {}), errInvalidDigest)
+	})
+}
+
+func fakeDigest(c byte) string {
+	bts := make([]byte, 64)
+	for i := range bts {
+		bts[i] = c
+	}
+
+	return string(bts)
+}
@@ -26,6 +26,8 @@ import (
 
 	"github.com/jmorganca/ollama/api"
 	"github.com/jmorganca/ollama/convert"
+	"github.com/jmorganca/ollama/format"
+	"github.com/jmorganca/ollama/gguf"
 	"github.com/jmorganca/ollama/llm"
 	"github.com/jmorganca/ollama/parser"
 	"github.com/jmorganca/ollama/version"
@@ -36,6 +38,13 @@ type registryOptions struct {
 	Username string
 	Password string
 	Token    string
+
+	// Sign signs manifests on push, RequireSigned rejects pulls without a trusted signature
+	Sign          bool
+	RequireSigned bool
+
+	// LimitRate caps the download rate of a pull in bytes per second
+	LimitRate int64
 }
 
 type Model struct {
@@ -50,6 +59,7 @@ type Model struct {
 	System         string
 	License        []string
 	Digest         string
+	Signer         string
 	Size           int64
 	Options        map[string]interface{}
 	Messages       []Message
@@ -65,10 +75,11 @@ type Message struct {
 }
 
 type ManifestV2 struct {
-	SchemaVersion int      `json:"schemaVersion"`
-	MediaType     string   `json:"mediaType"`
-	Config        *Layer   `json:"config"`
-	Layers        []*Layer `json:"layers"`
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        *Layer            `json:"config"`
+	Layers        []*Layer          `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
 }
 
 type ConfigV2 struct {
@@ -78,12 +89,27 @@ type ConfigV2 struct {
 	ModelType     string   `json:"model_type"`
 	FileType      string   `json:"file_type"`
 
+	// MergedAdapters are the adapters merged into the model's weights in
+	// the order they were merged
+	MergedAdapters []MergedAdapter `json:"merged_adapters,omitempty"`
+
 	// required by spec
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
 	RootFS       RootFS `json:"rootfs"`
 }
 
+// MergedAdapter is an adapter merged into the weights of a model
+type MergedAdapter struct {
+	// Adapter is the digest of the adapter
+	Adapter string `json:"adapter"`
+
+	// Base is the digest of the weights the adapter was merged into and From
+	// the model they're from, if they're from a model
+	Base string `json:"base"`
+	From string `json:"from,omitempty"`
+}
+
 func (c *ConfigV2) SetModelFormat(format string) {
 	if c.ModelFormat == "" {
 		c.ModelFormat = format
@@ -129,12 +155,17 @@ func (m *ManifestV2) GetTotalSize() (total int64) {
 }
 
 func GetManifest(mp ModelPath) (*ManifestV2, string, error) {
-	fp, err := mp.GetManifestPath()
+	fp, _, err := mp.findManifestPath()
 	if err != nil {
 		return nil, "", err
 	}
 
-	if _, err = os.Stat(fp); err != nil {
+	return readManifest(fp)
+}
+
+// readManifest reads the manifest file at fp and returns it with its digest
+func readManifest(fp string) (*ManifestV2, string, error) {
+	if _, err := os.Stat(fp); err != nil {
 		return nil, "", err
 	}
 
@@ -156,6 +187,11 @@ func GetManifest(mp ModelPath) (*ManifestV2, string, error) {
 }
 
 func GetModel(name string) (*Model, error) {
+	name, err := resolveAlias(name)
+	if err != nil {
+		return nil, err
+	}
+
 	mp := ParseModelPath(name)
 	manifest, digest, err := GetManifest(mp)
 	if err != nil {
@@ -171,6 +207,15 @@ func GetModel(name string) (*Model, error) {
 		Size:      manifest.GetTotalSize(),
 	}
 
+	if _, ok := manifest.Annotations[signatureAnnotation]; ok {
+		signer, err := manifestSigner(mp, manifest)
+		if err != nil {
+			slog.Info(fmt.Sprintf("couldn't verify signature of %s: %v", mp.GetShortTagname(), err))
+		} else {
+			model.Signer = signer
+		}
+	}
+
 	filename, err := GetBlobsPath(manifest.Config.Digest)
 	if err != nil {
 		return nil, err
@@ -283,7 +328,29 @@ func realpath(mfDir, from string) string {
 	return abspath
 }
 
-func CreateModel(ctx context.Context, name, modelFileDir string, commands []parser.Command, fn func(resp api.ProgressResponse)) error {
+// chatTemplate translates the chat template of a model into a Modelfile
+// template. It returns nil if the model doesn't have a chat template.
+func chatTemplate(kv llm.KV) (*convert.Template, error) {
+	chatTemplate, ok := kv["tokenizer.chat_template"].(string)
+	if !ok {
+		return nil, nil
+	}
+
+	var eos string
+	if tokens, ok := kv["tokenizer.ggml.tokens"].([]any); ok {
+		if id, ok := kv["tokenizer.ggml.eos_token_id"].(uint32); ok && int(id) < len(tokens) {
+			eos, _ = tokens[id].(string)
+		}
+	}
+
+	return convert.TranslateChatTemplate(chatTemplate, eos)
+}
+
+func CreateModel(ctx context.Context, name, modelFileDir, quantization string, commands []parser.Command, fn func(resp api.ProgressResponse)) error {
+	if err := ensureCreateSpace(name, modelFileDir, commands, fn); err != nil {
+		return err
+	}
+
 	deleteMap := make(map[string]struct{})
 	if manifest, _, err := GetManifest(ParseModelPath(name)); err == nil {
 		for _, layer := range append(manifest.Layers, manifest.Config) {
@@ -305,6 +372,29 @@ func CreateModel(ctx context.Context, name, modelFileDir string, commands []pars
 	params := make(map[string][]string)
 	fromParams := make(map[string]any)
 
+	// templates and system messages in the Modelfile replace the ones derived
+	// from a model's chat template
+	modelfile := make(map[string]bool)
+	for _, c := range commands {
+		modelfile[c.Name] = true
+	}
+
+	// models with merged adapters are merged at full precision and quantized
+	// once, by the last merge
+	var merges int
+	for _, c := range commands {
+		if c.Name == "adapter" {
+			if _, merge := parser.AdapterArgs(c.Args); merge {
+				merges++
+			}
+		}
+	}
+
+	convertQuantization := quantization
+	if merges > 0 {
+		convertQuantization = ""
+	}
+
 	for _, c := range commands {
 		mediatype := fmt.Sprintf("application/vnd.ollama.image.%s", c.Name)
 
@@ -321,7 +411,7 @@ func CreateModel(ctx context.Context, name, modelFileDir string, commands []pars
 
 			pathName := realpath(modelFileDir, c.Args)
 
-			ggufName, err := convertSafetensors(name, pathName)
+			converted, err := convertModel(name, pathName, convertQuantization, fn)
 			if err != nil {
 				var pathErr *fs.PathError
 				switch {
@@ -334,9 +424,11 @@ func CreateModel(ctx context.Context, name, modelFileDir string, commands []pars
 				}
 			}
 
-			if ggufName != "" {
-				pathName = ggufName
-				defer os.RemoveAll(ggufName)
+			if converted != nil {
+				// the converted model is read back from its layer
+				pathName = converted.tempFileName
+			} else if quantization != "" && merges == 0 {
+				return errors.New("only models converted from safetensors or pytorch, or with merged adapters, can be quantized")
 			}
 
 			bin, err := os.Open(pathName)
@@ -446,45 +538,104 @@ func CreateModel(ctx context.Context, name, modelFileDir string, commands []pars
 					mediatype = "application/vnd.ollama.image.projector"
 				}
 
-				sr := io.NewSectionReader(bin, offset, ggml.Size)
-				layer, err := NewLayer(sr, mediatype)
-				if err != nil {
-					return err
+				layer := converted
+				if layer == nil || offset > 0 {
+					sr := io.NewSectionReader(bin, offset, ggml.Size)
+					layer, err = NewLayer(sr, mediatype)
+					if err != nil {
+						return err
+					}
 				}
 
 				layers.Add(layer)
 
+				if mediatype == "application/vnd.ollama.image.model" && !modelfile["template"] {
+					tmpl, err := chatTemplate(ggml.KV())
+					if err != nil {
+						slog.Info(fmt.Sprintf("couldn't derive a template from the model's chat template: %v", err))
+					} else if tmpl != nil {
+						fn(api.ProgressResponse{Status: "creating template layer"})
+						layer, err := NewLayer(strings.NewReader(tmpl.Template), "application/vnd.ollama.image.template")
+						if err != nil {
+							return err
+						}
+
+						layers.Replace(layer)
+
+						if tmpl.System != "" && !modelfile["system"] {
+							layer, err := NewLayer(strings.NewReader(tmpl.System), "application/vnd.ollama.image.system")
+							if err != nil {
+								return err
+							}
+
+							layers.Replace(layer)
+						}
+					}
+				}
+
 				offset += ggml.Size
 			}
 		case "adapter":
-			if strings.HasPrefix(c.Args, "@") {
-				blobPath, err := GetBlobsPath(strings.TrimPrefix(c.Args, "@"))
+			arg, merge := parser.AdapterArgs(c.Args)
+			if strings.HasPrefix(arg, "@") {
+				blobPath, err := GetBlobsPath(strings.TrimPrefix(arg, "@"))
 				if err != nil {
 					return err
 				}
 
-				c.Args = blobPath
+				arg = blobPath
+			}
+
+			pathName := realpath(modelFileDir, arg)
+
+			// PEFT adapters are converted for the base model
+			layer, err := convertAdapter(pathName, &layers, fn)
+			if errors.Is(err, zip.ErrFormat) {
+				fn(api.ProgressResponse{Status: "creating adapter layer"})
+				layer, err = newAdapterLayer(pathName)
 			}
 
-			fn(api.ProgressResponse{Status: "creating adapter layer"})
-			bin, err := os.Open(realpath(modelFileDir, c.Args))
 			if err != nil {
 				return err
 			}
-			defer bin.Close()
 
-			ggml, err := llm.DecodeGGML(bin)
+			if !merge {
+				layers.Add(layer)
+				continue
+			}
+
+			base, err := layers.modelLayer()
 			if err != nil {
 				return err
 			}
 
-			sr := io.NewSectionReader(bin, 0, ggml.Size)
-			layer, err := NewLayer(sr, mediatype)
+			merges--
+
+			mergeQuantization := quantization
+			if merges > 0 {
+				mergeQuantization = ""
+			}
+
+			merged, err := mergeAdapter(base, layer, mergeQuantization, fn)
 			if err != nil {
 				return err
 			}
 
-			layers.Add(layer)
+			if base.tempFileName != "" {
+				os.Remove(base.tempFileName)
+			}
+
+			layers.Replace(merged)
+			config.MergedAdapters = append(config.MergedAdapters, MergedAdapter{Adapter: layer.Digest, Base: base.Digest, From: base.From})
+
+			if mergeQuantization != "" {
+				ggml, err := merged.decode()
+				if err != nil {
+					return err
+				}
+
+				config.FileType = ggml.FileType()
+			}
 		case "license":
 			fn(api.ProgressResponse{Status: "creating license layer"})
 
@@ -590,6 +741,13 @@ func CreateModel(ctx context.Context, name, modelFileDir string, commands []pars
 
 	delete(deleteMap, configLayer.Digest)
 
+	manifest := &ManifestV2{Config: configLayer, Layers: layers.items}
+	defer holdBlobs(manifest)()
+
+	if err := ensureStoreSpace(name, manifest, fn); err != nil {
+		return err
+	}
+
 	for _, layer := range append(layers.items, configLayer) {
 		committed, err := layer.Commit()
 		if err != nil {
@@ -611,6 +769,10 @@ func CreateModel(ctx context.Context, name, modelFileDir string, commands []pars
 		return err
 	}
 
+	if err := recordLocal(ParseModelPath(name)); err != nil {
+		return err
+	}
+
 	if noprune := os.Getenv("OLLAMA_NOPRUNE"); noprune == "" {
 		if err := deleteUnusedLayers(nil, deleteMap, false); err != nil {
 			return err
@@ -621,76 +783,192 @@ func CreateModel(ctx context.Context, name, modelFileDir string, commands []pars
 	return nil
 }
 
-func convertSafetensors(name, fn string) (string, error) {
-	r, err := zip.OpenReader(fn)
+// convertModel converts the checkpoint in the zip archive fn, which the client
+// creates from a checkpoint directory, to a model layer. Tensors are read from
+// the archive in place and the model is written to the blobs directory as
+// it's converted.
+func convertModel(name, fn, quantization string, progress func(api.ProgressResponse)) (*Layer, error) {
+	f, err := os.Open(fn)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer r.Close()
+	defer f.Close()
 
-	tempDir, err := os.MkdirTemp("", "ollama-convert")
+	fi, err := f.Stat()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	defer os.RemoveAll(tempDir)
 
-	for _, f := range r.File {
-		fpath := filepath.Join(tempDir, f.Name)
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return "", err
-		}
+	fsys, err := convert.NewZipFS(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
 
-		rc, err := f.Open()
-		if err != nil {
-			return "", err
-		}
+	params, err := convert.GetParams(fsys)
+	if err != nil {
+		return nil, err
+	}
 
-		_, err = io.Copy(outFile, rc)
-		if err != nil {
-			return "", err
-		}
+	conv, err := convert.GetConverter(params)
+	if err != nil {
+		return nil, fmt.Errorf("this model is not yet supported: %w", err)
+	}
 
-		outFile.Close()
-		rc.Close()
+	t, err := convert.GetTensors(fsys, conv, params)
+	if err != nil {
+		return nil, err
 	}
 
-	params, err := convert.GetParams(tempDir)
+	vocab, err := convert.LoadTokens(fsys)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	SupportedArchs := []string{
-		"MistralForCausalLM",
+	status := "converting model"
+	if quantization != "" {
+		status = fmt.Sprintf("quantizing model to %s", strings.ToUpper(quantization))
 	}
 
-	for _, arch := range params.Architectures {
-		if !slices.Contains(SupportedArchs, arch) {
-			return "", fmt.Errorf("this safetensors model is not yet supported")
-		}
+	return NewLayerFunc(func(w io.Writer) error {
+		return convert.WriteGGUF(w, name, conv, t, params, vocab, convert.WriteOptions{
+			FileType: quantization,
+			Progress: func(_ llm.Tensor, completed, total uint64) {
+				progress(api.ProgressResponse{Status: status, Total: int64(total), Completed: int64(completed)})
+			},
+		})
+	}, "application/vnd.ollama.image.model")
+}
+
+// convertAdapter converts the PEFT LoRA adapter in the zip archive fn to a
+// ggla adapter layer for the base model in layers
+func convertAdapter(fn string, layers *Layers, progress func(api.ProgressResponse)) (*Layer, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
 
-	t, err := convert.GetSafeTensors(tempDir)
+	fi, err := f.Stat()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	vocab, err := convert.LoadTokens(tempDir)
+	fsys, err := convert.NewZipFS(f, fi.Size())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	fn, err = convert.WriteGGUF(name, t, params, vocab)
+	progress(api.ProgressResponse{Status: "converting adapter"})
+	base, err := layers.baseModel()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	config, err := convert.GetAdapterConfig(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := convert.BaseParams(base.KV())
+	if err != nil {
+		return nil, fmt.Errorf("adapters of this model are not yet supported: %w", err)
+	}
+
+	conv, err := convert.GetConverter(params)
+	if err != nil {
+		return nil, fmt.Errorf("adapters of this model are not yet supported: %w", err)
+	}
+
+	t, err := convert.GetAdapterTensors(fsys, conv, params)
+	if err != nil {
+		return nil, err
 	}
 
-	return fn, nil
+	if err := convert.CheckAdapter(t, config, params, base.Tensors()); err != nil {
+		return nil, fmt.Errorf("the adapter doesn't match the base model: %w", err)
+	}
+
+	return NewLayerFunc(func(w io.Writer) error {
+		return convert.WriteGGLA(w, t, config)
+	}, "application/vnd.ollama.image.adapter")
+}
+
+// newAdapterLayer creates an adapter layer from the ggla adapter fn
+func newAdapterLayer(fn string) (*Layer, error) {
+	bin, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer bin.Close()
+
+	ggml, err := llm.DecodeGGML(bin)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLayer(io.NewSectionReader(bin, 0, ggml.Size), "application/vnd.ollama.image.adapter")
+}
+
+// mergeAdapter merges the ggla adapter layer into the base model layer and
+// returns the merged model layer. The adapter layer isn't kept since the
+// merged model doesn't need it.
+func mergeAdapter(base, adapter *Layer, quantization string, progress func(api.ProgressResponse)) (*Layer, error) {
+	defer os.Remove(adapter.tempFileName)
+
+	path, err := base.path()
+	if err != nil {
+		return nil, err
+	}
+
+	bf, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer bf.Close()
+
+	fi, err := bf.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := gguf.Decode(bf, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("adapters can only be merged into gguf models: %w", err)
+	}
+
+	af, err := os.Open(adapter.tempFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer af.Close()
+
+	ggla, err := llm.DecodeGGML(af)
+	if err != nil {
+		return nil, err
+	}
+
+	if ggla.Name() != "ggla" {
+		return nil, fmt.Errorf("only ggla adapters can be merged, not %s", ggla.Name())
+	}
+
+	status := "merging adapter"
+	if quantization != "" {
+		status = fmt.Sprintf("merging adapter and quantizing model to %s", strings.ToUpper(quantization))
+	}
+
+	return NewLayerFunc(func(w io.Writer) error {
+		return convert.MergeAdapter(w, g, ggla, af, convert.WriteOptions{
+			FileType: quantization,
+			Progress: func(_ llm.Tensor, completed, total uint64) {
+				progress(api.ProgressResponse{Status: status, Total: int64(total), Completed: int64(completed)})
+			},
+		})
+	}, "application/vnd.ollama.image.model")
 }
 
 func CopyModel(src, dest string) error {
 	srcModelPath := ParseModelPath(src)
-	srcPath, err := srcModelPath.GetManifestPath()
+	srcPath, _, err := srcModelPath.findManifestPath()
 	if err != nil {
 		return err
 	}
@@ -717,32 +995,20 @@ func CopyModel(src, dest string) error {
 		return err
 	}
 
-	return nil
+	return recordLocal(destModelPath)
 }
 
 func deleteUnusedLayers(skipModelPath *ModelPath, deleteMap map[string]struct{}, dryRun bool) error {
-	fp, err := GetManifestPath()
-	if err != nil {
-		return err
-	}
+	walkFunc := func(root modelRoot, name, path string, _ os.FileInfo) error {
+		fmp := ParseModelPath(name)
 
-	walkFunc := func(path string, info os.FileInfo, _ error) error {
-		if info.IsDir() {
-			return nil
-		}
-
-		dir, file := filepath.Split(path)
-		dir = strings.Trim(strings.TrimPrefix(dir, fp), string(os.PathSeparator))
-		tag := strings.Join([]string{dir, file}, ":")
-		fmp := ParseModelPath(tag)
-
-		// skip the manifest we're trying to delete
-		if skipModelPath != nil && skipModelPath.GetFullTagname() == fmp.GetFullTagname() {
+		// skip the manifest we're trying to delete, read-only roots keep theirs
+		if skipModelPath != nil && skipModelPath.GetFullTagname() == fmp.GetFullTagname() && !root.readOnly {
 			return nil
 		}
 
 		// save (i.e. delete from the deleteMap) any files used in other manifests
-		manifest, _, err := GetManifest(fmp)
+		manifest, _, err := readManifest(path)
 		if err != nil {
 			// nolint: nilerr
 			return nil
@@ -756,7 +1022,7 @@ func deleteUnusedLayers(skipModelPath *ModelPath, deleteMap map[string]struct{},
 		return nil
 	}
 
-	if err := filepath.Walk(fp, walkFunc); err != nil {
+	if err := walkManifests(walkFunc); err != nil {
 		return err
 	}
 
@@ -767,6 +1033,12 @@ func deleteUnusedLayers(skipModelPath *ModelPath, deleteMap map[string]struct{},
 			slog.Info(fmt.Sprintf("couldn't get file path for '%s': %v", k, err))
 			continue
 		}
+		// blobs of read-only roots are kept, as are blobs of pulls and
+		// creates which haven't written their manifests yet
+		if inReadOnlyRoot(fp) || isBlobActive(k) {
+			delete(deleteMap, k)
+			continue
+		}
 		if !dryRun {
 			if err := os.Remove(fp); err != nil {
 				slog.Info(fmt.Sprintf("couldn't remove file '%s': %v", fp, err))
@@ -795,6 +1067,11 @@ func PruneLayers() error {
 
 	for _, blob := range blobs {
 		name := blob.Name()
+		// partial downloads are kept so their pulls can resume
+		if strings.Contains(name, "-partial") {
+			continue
+		}
+
 		name = strings.ReplaceAll(name, "-", ":")
 		if strings.HasPrefix(name, "sha256:") {
 			deleteMap[name] = struct{}{}
@@ -846,6 +1123,8 @@ func PruneDirectory(path string) error {
 	return nil
 }
 
+var errModelReadOnly = errors.New("model is in a read-only models directory")
+
 func DeleteModel(name string) error {
 	mp := ParseModelPath(name)
 	manifest, _, err := GetManifest(mp)
@@ -853,6 +1132,12 @@ func DeleteModel(name string) error {
 		return err
 	}
 
+	if _, root, err := mp.findManifestPath(); err != nil {
+		return err
+	} else if root.readOnly {
+		return fmt.Errorf("%w: %s", errModelReadOnly, root.path)
+	}
+
 	deleteMap := make(map[string]struct{})
 	for _, layer := range manifest.Layers {
 		deleteMap[layer.Digest] = struct{}{}
@@ -874,6 +1159,7 @@ func DeleteModel(name string) error {
 		return err
 	}
 
+	forgetModel(name)
 	return nil
 }
 
@@ -966,6 +1252,13 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		}
 	}
 
+	if regOpts.Sign {
+		fn(api.ProgressResponse{Status: "signing manifest"})
+		if err := signManifest(ctx, mp, manifest); err != nil {
+			return fmt.Errorf("sign manifest: %w", err)
+		}
+	}
+
 	fn(api.ProgressResponse{Status: "pushing manifest"})
 	requestURL := mp.BaseURL()
 	requestURL = requestURL.JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
@@ -976,13 +1269,25 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	}
 
 	headers := make(http.Header)
-	headers.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+	headers.Set("Content-Type", mediaTypeDockerManifest)
 	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(manifestJSON), regOpts)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if regOpts.Sign {
+		// keep the signed manifest so the local copy matches the pushed one
+		fp, err := mp.GetManifestPath()
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(fp, manifestJSON, 0o644); err != nil {
+			return err
+		}
+	}
+
 	fn(api.ProgressResponse{Status: "success"})
 
 	return nil
@@ -1004,7 +1309,8 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 			return err
 		}
 
-		if manifest != nil {
+		// the layers of a broken manifest, which is being repaired, aren't known
+		if manifest != nil && checkManifest(manifest) == nil {
 			for _, l := range manifest.Layers {
 				deleteMap[l.Digest] = struct{}{}
 			}
@@ -1016,6 +1322,12 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return fmt.Errorf("insecure protocol http")
 	}
 
+	release, err := pulls.acquire(ctx, fn)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	fn(api.ProgressResponse{Status: "pulling manifest"})
 
 	manifest, err = pullModelManifest(ctx, mp, regOpts)
@@ -1023,6 +1335,16 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return fmt.Errorf("pull model manifest: %s", err)
 	}
 
+	if _, err := verifyManifestSignature(mp, manifest, regOpts.RequireSigned); err != nil {
+		return err
+	}
+
+	defer holdBlobs(manifest)()
+
+	if err := ensureStoreSpace(name, manifest, fn); err != nil {
+		return err
+	}
+
 	var layers []*Layer
 	layers = append(layers, manifest.Layers...)
 	layers = append(layers, manifest.Config)
@@ -1081,6 +1403,10 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return err
 	}
 
+	if err := recordPull(mp, fmt.Sprintf("sha256:%x", sha256.Sum256(manifestJSON))); err != nil {
+		return err
+	}
+
 	if noprune == "" {
 		fn(api.ProgressResponse{Status: "removing any unused layers"})
 		err = deleteUnusedLayers(nil, deleteMap, false)
@@ -1094,23 +1420,158 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	return nil
 }
 
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestIndex is an OCI image index or docker manifest list
+type manifestIndex struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	Manifests     []manifestDescriptor `json:"manifests"`
+}
+
+type manifestDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+// manifest picks the entry of the index to pull. Models are platform independent
+// so prefer an entry without a platform, then one matching this machine, then the first.
+func (idx *manifestIndex) manifest() (*manifestDescriptor, error) {
+	var candidates []*manifestDescriptor
+	for i := range idx.Manifests {
+		switch idx.Manifests[i].MediaType {
+		case mediaTypeDockerManifest, mediaTypeOCIManifest, "":
+			candidates = append(candidates, &idx.Manifests[i])
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.New("image index does not contain any manifests")
+	}
+
+	for _, d := range candidates {
+		if d.Platform == nil {
+			return d, nil
+		}
+	}
+
+	for _, d := range candidates {
+		if d.Platform.OS == runtime.GOOS && d.Platform.Architecture == runtime.GOARCH {
+			return d, nil
+		}
+	}
+
+	return candidates[0], nil
+}
+
+// pullModelManifest fetches the manifest for mp from the first of its mirrors
+// that has it, falling back to the registry itself
 func pullModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*ManifestV2, error) {
-	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+	for _, mirror := range registryMirrors(mp.Registry) {
+		manifestURL := func(reference string) *url.URL {
+			return mirrorURL(mirror, mp, "manifests", reference)
+		}
 
-	headers := make(http.Header)
-	headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+		m, err := pullManifestFrom(ctx, manifestURL, mp.Tag, &registryOptions{})
+		if err == nil {
+			return m, nil
+		}
+
+		slog.Info(fmt.Sprintf("couldn't pull manifest from mirror %s: %v", mirror.Host, err))
+	}
+
+	manifestURL := func(reference string) *url.URL {
+		return mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", reference)
+	}
+
+	return pullManifestFrom(ctx, manifestURL, mp.Tag, regOpts)
+}
+
+func pullManifestFrom(ctx context.Context, manifestURL func(string) *url.URL, tag string, regOpts *registryOptions) (*ManifestV2, error) {
+	bts, mediaType, err := getManifest(ctx, manifestURL(tag), regOpts)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+
+	switch mediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerManifestList:
+		var idx manifestIndex
+		if err := json.Unmarshal(bts, &idx); err != nil {
+			return nil, err
+		}
+
+		d, err := idx.manifest()
+		if err != nil {
+			return nil, err
+		}
+
+		bts, _, err = getManifest(ctx, manifestURL(d.Digest), regOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(bts)); digest != d.Digest {
+			return nil, fmt.Errorf("%w: want %s, got %s", errDigestMismatch, d.Digest, digest)
+		}
+	}
 
 	var m *ManifestV2
-	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+	if err := json.Unmarshal(bts, &m); err != nil {
 		return nil, err
 	}
 
-	return m, err
+	if m.Config == nil {
+		return nil, fmt.Errorf("unsupported manifest type %q", mediaType)
+	}
+
+	return m, nil
+}
+
+// getManifest fetches a raw manifest and returns it along with its media type
+func getManifest(ctx context.Context, requestURL *url.URL, regOpts *registryOptions) ([]byte, string, error) {
+	headers := make(http.Header)
+	headers.Set("Accept", strings.Join([]string{
+		mediaTypeDockerManifest,
+		mediaTypeOCIManifest,
+		mediaTypeOCIIndex,
+		mediaTypeDockerManifestList,
+	}, ", "))
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the mediaType field is optional for OCI manifests so fall back to the header
+	var m struct {
+		MediaType string `json:"mediaType"`
+	}
+
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return nil, "", err
+	}
+
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType, _, _ = strings.Cut(resp.Header.Get("Content-Type"), ";")
+	}
+
+	return bts, mediaType, nil
 }
 
 // GetSHA256Digest returns the SHA256 hash of a given buffer and returns it, and the size of buffer
@@ -1140,12 +1601,12 @@ func makeRequestWithRetry(ctx context.Context, method string, requestURL *url.UR
 		switch {
 		case resp.StatusCode == http.StatusUnauthorized:
 			// Handle authentication error with one retry
+			resp.Body.Close()
 			challenge := parseRegistryChallenge(resp.Header.Get("www-authenticate"))
-			token, err := getAuthorizationToken(ctx, challenge)
-			if err != nil {
+			if err := authenticate(ctx, requestURL.Host, challenge, regOpts); err != nil {
 				return nil, err
 			}
-			regOpts.Token = token
+
 			if body != nil {
 				_, err = body.Seek(0, io.SeekStart)
 				if err != nil {
@@ -1233,9 +1694,10 @@ func getValue(header, key string) string {
 }
 
 func parseRegistryChallenge(authStr string) registryChallenge {
-	authStr = strings.TrimPrefix(authStr, "Bearer ")
+	scheme, authStr, _ := strings.Cut(authStr, " ")
 
 	return registryChallenge{
+		Scheme:  scheme,
 		Realm:   getValue(authStr, "realm"),
 		Service: getValue(authStr, "service"),
 		Scope:   getValue(authStr, "scope"),
@@ -1250,14 +1712,49 @@ func verifyBlob(digest string) error {
 		return err
 	}
 
+	return verifyFile(fp, digest, nil)
+}
+
+// verifyFile checks the file at fp against digest. If fn isn't nil it's
+// called with how much of the file has been read, before reading it, after
+// each chunk and once it's read.
+func verifyFile(fp, digest string, fn func(completed, total int64)) error {
 	f, err := os.Open(fp)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	fileDigest, _ := GetSHA256Digest(f)
-	if digest != fileDigest {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fn == nil {
+		fn = func(int64, int64) {}
+	}
+
+	fn(0, fi.Size())
+
+	h := sha256.New()
+	buf := make([]byte, 8*format.MegaByte)
+	var completed int64
+	for {
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		completed += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		fn(completed, fi.Size())
+	}
+
+	fn(completed, fi.Size())
+
+	if fileDigest := fmt.Sprintf("sha256:%x", h.Sum(nil)); fileDigest != digest {
 		return fmt.Errorf("%w: want %s, got %s", errDigestMismatch, digest, fileDigest)
 	}
 
@@ -2,12 +2,15 @@ package server
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
 	"golang.org/x/exp/slices"
+
+	"github.com/jmorganca/ollama/llm"
 )
 
 type Layers struct {
@@ -31,6 +34,27 @@ func (ls *Layers) Replace(layer *Layer) {
 	}
 }
 
+// modelLayer returns the model layer, which adapters apply to
+func (ls *Layers) modelLayer() (*Layer, error) {
+	for _, layer := range ls.items {
+		if layer.MediaType == "application/vnd.ollama.image.model" {
+			return layer, nil
+		}
+	}
+
+	return nil, errors.New("an adapter requires a base model in FROM")
+}
+
+// baseModel decodes the model layer
+func (ls *Layers) baseModel() (*llm.GGML, error) {
+	layer, err := ls.modelLayer()
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.decode()
+}
+
 type Layer struct {
 	MediaType string `json:"mediaType"`
 	Digest    string `json:"digest"`
@@ -58,6 +82,7 @@ func NewLayer(r io.Reader, mediatype string) (*Layer, error) {
 	sha256sum := sha256.New()
 	n, err := io.Copy(io.MultiWriter(temp, sha256sum), r)
 	if err != nil {
+		os.Remove(temp.Name())
 		return nil, err
 	}
 
@@ -69,6 +94,21 @@ func NewLayer(r io.Reader, mediatype string) (*Layer, error) {
 	}, nil
 }
 
+// NewLayerFunc creates a layer from what write writes. The layer is hashed and
+// written to the blobs directory as write writes it so large layers, such as
+// converted models, aren't buffered in a temporary file first.
+func NewLayerFunc(write func(io.Writer) error, mediatype string) (*Layer, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+
+	layer, err := NewLayer(pr, mediatype)
+	// stops write if the layer couldn't be created
+	pr.CloseWithError(err)
+	return layer, err
+}
+
 func NewLayerFromLayer(digest, mediatype, from string) (*Layer, error) {
 	blob, err := GetBlobsPath(digest)
 	if err != nil {
@@ -88,6 +128,32 @@ func NewLayerFromLayer(digest, mediatype, from string) (*Layer, error) {
 	}, nil
 }
 
+// path returns the path of the layer's data, which is in its temporary file
+// until it's committed
+func (l *Layer) path() (string, error) {
+	if l.tempFileName != "" {
+		return l.tempFileName, nil
+	}
+
+	return GetBlobsPath(l.Digest)
+}
+
+// decode decodes the model in the layer
+func (l *Layer) decode() (*llm.GGML, error) {
+	path, err := l.path()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return llm.DecodeGGML(f)
+}
+
 func (l *Layer) Commit() (bool, error) {
 	// always remove temp
 	defer os.Remove(l.tempFileName)
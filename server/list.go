@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// listFilter selects and orders the models listed by ListModelsHandler
+type listFilter struct {
+	name           string
+	family         string
+	format         string
+	parameterSize  string
+	quantization   string
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+
+	sortBy string
+	desc   bool
+}
+
+func parseListFilter(c *gin.Context) (*listFilter, error) {
+	f := listFilter{
+		name:          c.Query("name"),
+		family:        c.Query("family"),
+		format:        c.Query("format"),
+		parameterSize: c.Query("parameter_size"),
+		quantization:  c.Query("quantization_level"),
+	}
+
+	if _, err := path.Match(f.name, ""); err != nil {
+		return nil, fmt.Errorf("invalid name pattern %q: %w", f.name, err)
+	}
+
+	var err error
+	if f.modifiedAfter, err = parseListTime(c.Query("modified_after")); err != nil {
+		return nil, err
+	}
+
+	if f.modifiedBefore, err = parseListTime(c.Query("modified_before")); err != nil {
+		return nil, err
+	}
+
+	f.sortBy, f.desc = strings.CutPrefix(c.Query("sort"), "-")
+	switch f.sortBy {
+	case "", "name", "size", "modified":
+	default:
+		return nil, fmt.Errorf("invalid sort %q, must be name, size or modified", f.sortBy)
+	}
+
+	return &f, nil
+}
+
+// parseListTime parses a time such as 2024-01-31 or 2024-01-31T12:00:00Z, or an
+// age such as 36h or 7d which is relative to now
+func parseListTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, must be a date, a RFC 3339 time or an age such as 7d", s)
+}
+
+func (f *listFilter) match(m api.ModelResponse) bool {
+	if f.name != "" {
+		// patterns without a tag match every tag
+		pattern := f.name
+		if !strings.Contains(path.Base(pattern), ":") {
+			pattern += ":*"
+		}
+
+		if ok, _ := path.Match(pattern, m.Name); !ok {
+			return false
+		}
+	}
+
+	if f.family != "" && !strings.EqualFold(f.family, m.Details.Family) &&
+		!slices.ContainsFunc(m.Details.Families, func(s string) bool { return strings.EqualFold(f.family, s) }) {
+		return false
+	}
+
+	if f.format != "" && !strings.EqualFold(f.format, m.Details.Format) {
+		return false
+	}
+
+	if f.parameterSize != "" && !strings.EqualFold(f.parameterSize, m.Details.ParameterSize) {
+		return false
+	}
+
+	if f.quantization != "" && !strings.EqualFold(f.quantization, m.Details.QuantizationLevel) {
+		return false
+	}
+
+	if !f.modifiedAfter.IsZero() && m.ModifiedAt.Before(f.modifiedAfter) {
+		return false
+	}
+
+	if !f.modifiedBefore.IsZero() && !m.ModifiedAt.Before(f.modifiedBefore) {
+		return false
+	}
+
+	return true
+}
+
+func (f *listFilter) sort(models []api.ModelResponse) {
+	var less func(a, b api.ModelResponse) bool
+	switch f.sortBy {
+	case "name":
+		less = func(a, b api.ModelResponse) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b api.ModelResponse) bool { return a.Size < b.Size }
+	case "modified":
+		less = func(a, b api.ModelResponse) bool { return a.ModifiedAt.Before(b.ModifiedAt) }
+	default:
+		return
+	}
+
+	sort.SliceStable(models, func(i, j int) bool {
+		if f.desc {
+			return less(models[j], models[i])
+		}
+
+		return less(models[i], models[j])
+	})
+}
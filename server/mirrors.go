@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// registryConfig holds per registry host settings, read from registries.json
+// in the ollama directory or the file named by OLLAMA_REGISTRIES
+type registryConfig struct {
+	// Mirrors are base URLs tried in order before the registry itself
+	Mirrors []string `json:"mirrors"`
+}
+
+func registryConfigPath() (string, error) {
+	if fp := os.Getenv("OLLAMA_REGISTRIES"); fp != "" {
+		return fp, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ollama", "registries.json"), nil
+}
+
+func readRegistryConfig(host string) (*registryConfig, error) {
+	fp, err := registryConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := os.ReadFile(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return &registryConfig{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var configs map[string]registryConfig
+	if err := json.Unmarshal(bts, &configs); err != nil {
+		return nil, fmt.Errorf("%s: %w", fp, err)
+	}
+
+	config := configs[host]
+	return &config, nil
+}
+
+// registryMirrors returns the mirrors configured for host in the order they should be tried
+func registryMirrors(host string) []*url.URL {
+	config, err := readRegistryConfig(host)
+	if err != nil {
+		slog.Info(fmt.Sprintf("couldn't read registry config: %v", err))
+		return nil
+	}
+
+	var mirrors []*url.URL
+	for _, s := range config.Mirrors {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			slog.Info(fmt.Sprintf("skipping invalid mirror %q for %s", s, host))
+			continue
+		}
+
+		mirrors = append(mirrors, u)
+	}
+
+	return mirrors
+}
+
+// mirrorURL builds the URL of a registry request for mp against a mirror. The
+// upstream registry is passed in the ns query parameter so a mirror can serve
+// more than one registry.
+func mirrorURL(mirror *url.URL, mp ModelPath, elem ...string) *url.URL {
+	u := mirror.JoinPath(append([]string{"v2", mp.GetNamespaceRepository()}, elem...)...)
+
+	values := u.Query()
+	values.Set("ns", mp.Registry)
+	u.RawQuery = values.Encode()
+	return u
+}
+
+// blobURL returns where to download a blob from: the first mirror which has it,
+// otherwise the registry itself. Mirrors get their own registry options so
+// credentials and tokens for the registry are never sent to them.
+func blobURL(ctx context.Context, mp ModelPath, digest string, regOpts *registryOptions) (*url.URL, *registryOptions) {
+	for _, mirror := range registryMirrors(mp.Registry) {
+		requestURL := mirrorURL(mirror, mp, "blobs", digest)
+		mirrorOpts := &registryOptions{}
+
+		resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, nil, nil, mirrorOpts)
+		if err != nil {
+			slog.Info(fmt.Sprintf("mirror %s doesn't have %s: %v", mirror.Host, digest[7:19], err))
+			continue
+		}
+		resp.Body.Close()
+
+		return requestURL, mirrorOpts
+	}
+
+	return mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "blobs", digest), regOpts
+}
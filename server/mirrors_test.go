@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeRegistryConfig(t *testing.T, configs map[string]registryConfig) {
+	fp := filepath.Join(t.TempDir(), "registries.json")
+	bts, err := json.Marshal(configs)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(fp, bts, 0o644))
+	t.Setenv("OLLAMA_REGISTRIES", fp)
+}
+
+func TestPullModelManifestMirrors(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	upstream := newTestRegistry(t, "library/llama2", "alice", "hunter2")
+	upstreamURL, err := url.Parse(upstream.URL)
+	assert.Nil(t, err)
+
+	mirrored := "sha256:" + strings.Repeat("b", 64)
+	missing := "sha256:" + strings.Repeat("c", 64)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, upstreamURL.Host, r.URL.Query().Get("ns"))
+
+		switch r.URL.Path {
+		case "/v2/library/llama2/manifests/latest":
+			json.NewEncoder(w).Encode(ManifestV2{
+				SchemaVersion: 2,
+				MediaType:     mediaTypeDockerManifest,
+				Config:        &Layer{Digest: "sha256:m1rr0r"},
+			})
+		case "/v2/library/llama2/blobs/" + mirrored:
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(mirror.Close)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+
+	mp := ParseModelPath(fmt.Sprintf("http://%s/library/llama2", upstreamURL.Host))
+	regOpts := &registryOptions{Username: "alice", Password: "hunter2"}
+
+	t.Run("mirror", func(t *testing.T) {
+		writeRegistryConfig(t, map[string]registryConfig{
+			upstreamURL.Host: {Mirrors: []string{broken.URL, mirror.URL}},
+		})
+
+		m, err := pullModelManifest(context.TODO(), mp, regOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, "sha256:m1rr0r", m.Config.Digest)
+
+		requestURL, mirrorOpts := blobURL(context.TODO(), mp, mirrored, regOpts)
+		assert.Equal(t, mirror.URL+"/v2/library/llama2/blobs/"+mirrored+"?ns="+url.QueryEscape(upstreamURL.Host), requestURL.String())
+		assert.Equal(t, "", mirrorOpts.Username)
+
+		requestURL, opts := blobURL(context.TODO(), mp, missing, regOpts)
+		assert.Equal(t, upstream.URL+"/v2/library/llama2/blobs/"+missing, requestURL.String())
+		assert.Equal(t, regOpts, opts)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		writeRegistryConfig(t, map[string]registryConfig{
+			upstreamURL.Host: {Mirrors: []string{broken.URL}},
+		})
+
+		m, err := pullModelManifest(context.TODO(), mp, regOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, "sha256:c0ffee", m.Config.Digest)
+	})
+}
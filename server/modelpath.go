@@ -6,7 +6,9 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 )
 
 type ModelPath struct {
@@ -99,20 +101,129 @@ func (mp ModelPath) GetShortTagname() string {
 	return fmt.Sprintf("%s/%s/%s:%s", mp.Registry, mp.Namespace, mp.Repository, mp.Tag)
 }
 
-// modelsDir returns the value of the OLLAMA_MODELS environment variable or the user's home directory if OLLAMA_MODELS is not set.
-// The models directory is where Ollama stores its model files and manifests.
-func modelsDir() (string, error) {
+// modelRoot is a directory holding manifests and blobs. Read-only roots are
+// searched for models but nothing is ever written to or removed from them.
+type modelRoot struct {
+	path     string
+	readOnly bool
+}
+
+var errNoWritableRoot = errors.New("none of the directories in OLLAMA_MODELS are writable")
+
+// writableRoots caches the roots which could be written to. Roots which
+// couldn't are probed again since they may become writable.
+var writableRoots sync.Map
+
+// modelRoots returns the directories listed in OLLAMA_MODELS, separated by the
+// OS path list separator, in the order they are searched. The first writable
+// root is searched first so models pulled or created into it aren't shadowed
+// by stale copies in read-only roots, the others are searched in the order
+// they're listed. If OLLAMA_MODELS is not set the only root is in the user's
+// home directory.
+func modelRoots() ([]modelRoot, error) {
+	var paths []string
 	if models, exists := os.LookupEnv("OLLAMA_MODELS"); exists {
-		return models, nil
+		paths = filepath.SplitList(models)
 	}
-	home, err := os.UserHomeDir()
+
+	if len(paths) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		paths = []string{filepath.Join(home, ".ollama", "models")}
+	}
+
+	roots := make([]modelRoot, len(paths))
+	for i, path := range paths {
+		writable, ok := writableRoots.Load(path)
+		if !ok {
+			writable = isWritable(path)
+			if writable.(bool) {
+				writableRoots.Store(path, writable)
+			}
+		}
+
+		roots[i] = modelRoot{path: path, readOnly: !writable.(bool)}
+	}
+
+	if i := slices.IndexFunc(roots, func(root modelRoot) bool { return !root.readOnly }); i > 0 {
+		writable := roots[i]
+		roots = slices.Insert(slices.Delete(roots, i, i+1), 0, writable)
+	}
+
+	return roots, nil
+}
+
+// isWritable reports whether files can be created in dir. A dir which doesn't
+// exist yet is writable if the nearest directory above it which does exist is,
+// since it's created when it's first written to.
+func isWritable(dir string) bool {
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return false
+			}
+
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return false
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+
+		dir = parent
+	}
+
+	f, err := os.CreateTemp(dir, ".ollama-write-test-")
+	if err != nil {
+		return false
+	}
+
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// modelsDir returns the first writable root in OLLAMA_MODELS, or the user's home directory if OLLAMA_MODELS is not set.
+// The models directory is where Ollama writes its model files and manifests.
+func modelsDir() (string, error) {
+	roots, err := modelRoots()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".ollama", "models"), nil
+
+	for _, root := range roots {
+		if !root.readOnly {
+			return root.path, nil
+		}
+	}
+
+	return "", errNoWritableRoot
+}
+
+// inReadOnlyRoot reports whether the file at path belongs to a read-only root
+func inReadOnlyRoot(path string) bool {
+	roots, err := modelRoots()
+	if err != nil {
+		return false
+	}
+
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root.path, path); err == nil && filepath.IsLocal(rel) {
+			return root.readOnly
+		}
+	}
+
+	return false
 }
 
-// GetManifestPath returns the path to the manifest file for the given model path, it is up to the caller to create the directory if it does not exist.
+// GetManifestPath returns the path to the manifest file for the given model path in the writable models directory, it is up to the caller to create the directory if it does not exist.
 func (mp ModelPath) GetManifestPath() (string, error) {
 	dir, err := modelsDir()
 	if err != nil {
@@ -122,6 +233,31 @@ func (mp ModelPath) GetManifestPath() (string, error) {
 	return filepath.Join(dir, "manifests", mp.Registry, mp.Namespace, mp.Repository, mp.Tag), nil
 }
 
+// findManifestPath returns the path to the manifest file for the given model
+// path in the first root which has it, searching the writable root first, or
+// the writable path if none do. If no root is writable it's the path in the
+// first root, which doesn't exist.
+func (mp ModelPath) findManifestPath() (path string, root modelRoot, err error) {
+	roots, err := modelRoots()
+	if err != nil {
+		return "", modelRoot{}, err
+	}
+
+	for _, root := range roots {
+		fp := filepath.Join(root.path, "manifests", mp.Registry, mp.Namespace, mp.Repository, mp.Tag)
+		if _, err := os.Stat(fp); err == nil {
+			return fp, root, nil
+		}
+	}
+
+	fp, err := mp.GetManifestPath()
+	if errors.Is(err, errNoWritableRoot) {
+		return filepath.Join(roots[0].path, "manifests", mp.Registry, mp.Namespace, mp.Repository, mp.Tag), roots[0], nil
+	}
+
+	return fp, modelRoot{}, err
+}
+
 func (mp ModelPath) BaseURL() *url.URL {
 	return &url.URL{
 		Scheme: mp.ProtocolScheme,
@@ -143,13 +279,72 @@ func GetManifestPath() (string, error) {
 	return path, nil
 }
 
+// walkManifests calls fn with the full name of every manifest in every root,
+// in search order. A model may be found in more than one root, only the first
+// is used by lookups.
+func walkManifests(fn func(root modelRoot, name, path string, info os.FileInfo) error) error {
+	roots, err := modelRoots()
+	if err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		dir := filepath.Join(root.path, "manifests")
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			name, tag := filepath.Split(filepath.ToSlash(rel))
+			return fn(root, strings.TrimSuffix(name, "/")+":"+tag, path, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetBlobsPath returns the path to the blob with the given digest in the first
+// root which has it, or in the writable models directory if none do. Without
+// a digest it returns the writable blobs directory. Blobs which exist are found
+// even if no root is writable, errNoWritableRoot is only returned for blobs
+// which would have to be written.
 func GetBlobsPath(digest string) (string, error) {
+	digest = strings.ReplaceAll(digest, ":", "-")
+	if digest != "" {
+		roots, err := modelRoots()
+		if err != nil {
+			return "", err
+		}
+
+		for _, root := range roots {
+			fp := filepath.Join(root.path, "blobs", digest)
+			if _, err := os.Stat(fp); err == nil {
+				return fp, nil
+			}
+		}
+	}
+
 	dir, err := modelsDir()
 	if err != nil {
 		return "", err
 	}
 
-	digest = strings.ReplaceAll(digest, ":", "-")
 	path := filepath.Join(dir, "blobs", digest)
 	dirPath := filepath.Dir(path)
 	if digest == "" {
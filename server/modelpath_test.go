@@ -1,6 +1,12 @@
 package server
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
 
 func TestParseModelPath(t *testing.T) {
 	tests := []struct {
@@ -86,3 +92,80 @@ func TestParseModelPath(t *testing.T) {
 		})
 	}
 }
+
+func TestModelRoots(t *testing.T) {
+	shared := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", shared)
+	createModelfile(t, "shared", "SYSTEM hello")
+
+	manifest, _, err := GetManifest(ParseModelPath("shared"))
+	assert.Nil(t, err)
+
+	// the shared root is read-only from now on, tests run as root so this
+	// can't be done with permissions
+	writableRoots.Store(shared, false)
+	t.Cleanup(func() { writableRoots.Delete(shared) })
+
+	local := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", local+string(os.PathListSeparator)+shared)
+
+	dir, err := modelsDir()
+	assert.Nil(t, err)
+	assert.Equal(t, local, dir)
+
+	fp, err := GetBlobsPath(manifest.Layers[0].Digest)
+	assert.Nil(t, err)
+	assert.Equal(t, shared, filepath.Dir(filepath.Dir(fp)))
+	assert.True(t, inReadOnlyRoot(fp))
+
+	_, err = GetModel("shared")
+	assert.Nil(t, err)
+	assert.ErrorIs(t, DeleteModel("shared"), errModelReadOnly)
+
+	// new models are written to the writable root and reuse shared blobs
+	createModelfile(t, "local", "SYSTEM hello")
+	names, err := localModels()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"registry.ollama.ai/library/local:latest", "registry.ollama.ai/library/shared:latest"}, names)
+
+	_, err = os.Stat(filepath.Join(local, "manifests", "registry.ollama.ai", "library", "local", "latest"))
+	assert.Nil(t, err)
+
+	// pruning never touches the shared root
+	assert.Nil(t, DeleteModel("local"))
+	assert.Nil(t, PruneLayers())
+	_, err = GarbageCollect(false)
+	assert.Nil(t, err)
+
+	assert.FileExists(t, fp)
+	_, _, err = GetManifest(ParseModelPath("shared"))
+	assert.Nil(t, err)
+
+	// the writable root is searched first even when it's listed last
+	t.Setenv("OLLAMA_MODELS", shared+string(os.PathListSeparator)+local)
+	createModelfile(t, "shared", "SYSTEM hello")
+
+	_, root, err := ParseModelPath("shared").findManifestPath()
+	assert.Nil(t, err)
+	assert.Equal(t, local, root.path)
+
+	t.Setenv("OLLAMA_MODELS", shared)
+	_, err = modelsDir()
+	assert.ErrorIs(t, err, errNoWritableRoot)
+
+	// models in read-only roots can be used without a writable root
+	_, err = GetModel("shared")
+	assert.Nil(t, err)
+
+	_, _, err = GetManifest(ParseModelPath("missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoDirExists(t, filepath.Join(shared, "manifests", "registry.ollama.ai", "library", "missing"))
+
+	_, err = GetBlobsPath("sha256:" + fakeDigest('a'))
+	assert.ErrorIs(t, err, errNoWritableRoot)
+
+	// roots which don't exist yet are probed without being created
+	missing := filepath.Join(local, "missing", "models")
+	assert.True(t, isWritable(missing))
+	assert.NoDirExists(t, missing)
+}
@@ -0,0 +1,459 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// Pulls are tracked by model so they can be listed, paused, resumed and
+// canceled independently of the requests waiting on them. Each pull is also
+// recorded in the models directory until it finishes so a restarted server can
+// pick up where it left off from the blobs' -partial-N files.
+
+var (
+	errPullNotFound    = errors.New("pull not found")
+	errPullPaused      = errors.New("pull paused")
+	errPullCanceled    = errors.New("pull canceled")
+	errPullInterrupted = errors.New("pull interrupted")
+)
+
+// pullRecord is what is persisted about a pull. Credentials aren't saved so
+// resumed pulls fall back to the docker configuration.
+type pullRecord struct {
+	Model         string `json:"model"`
+	Insecure      bool   `json:"insecure,omitempty"`
+	RequireSigned bool   `json:"require_signed,omitempty"`
+	LimitRate     int64  `json:"limit_rate,omitempty"`
+	Paused        bool   `json:"paused,omitempty"`
+
+	// Digests are the blobs the pull has started so their partial downloads
+	// aren't garbage collected
+	Digests []string `json:"digests,omitempty"`
+}
+
+func pullRecordsDir() (string, error) {
+	dir, err := modelsDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "pulls"), nil
+}
+
+func pullRecordPath(key string) (string, error) {
+	dir, err := pullRecordsDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(key)))), nil
+}
+
+func writePullRecord(key string, record pullRecord) error {
+	fp, err := pullRecordPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+		return err
+	}
+
+	bts, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fp, bts, 0o644)
+}
+
+func removePullRecord(key string) {
+	fp, err := pullRecordPath(key)
+	if err != nil {
+		return
+	}
+
+	if err := os.Remove(fp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Info(fmt.Sprintf("couldn't remove pull record for %s: %v", key, err))
+	}
+}
+
+type activePull struct {
+	mu sync.Mutex
+
+	// key is the full name of the model while record keeps the name as it
+	// was given, which may include the protocol scheme
+	key    string
+	record pullRecord
+	opts   *registryOptions
+
+	state  string
+	status string
+	layers map[string]api.ProgressResponse
+
+	subscribers map[int]func(api.ProgressResponse)
+	nextID      int
+	refs        int
+
+	// detached pulls were resumed without a client so they keep going when
+	// nobody is waiting on them
+	detached bool
+
+	cancel context.CancelCauseFunc
+	run    *pullRun
+}
+
+// pullRun is a single run of a pull, which may be paused and run again
+type pullRun struct {
+	done chan struct{}
+	err  error
+}
+
+func (p *activePull) running() bool {
+	return p.run != nil
+}
+
+// broadcast records the progress of the pull and passes it on to every request
+// waiting on it
+func (p *activePull) broadcast(resp api.ProgressResponse) {
+	p.mu.Lock()
+	p.status = resp.Status
+	if resp.Status == "queued" {
+		p.state = "queued"
+	} else {
+		p.state = "pulling"
+	}
+
+	if resp.Digest != "" {
+		if !slices.Contains(p.record.Digests, resp.Digest) {
+			p.record.Digests = append(p.record.Digests, resp.Digest)
+			if err := writePullRecord(p.key, p.record); err != nil {
+				slog.Info(fmt.Sprintf("couldn't record pull of %s: %v", p.record.Model, err))
+			}
+		}
+
+		p.layers[resp.Digest] = resp
+	}
+
+	fns := make([]func(api.ProgressResponse), 0, len(p.subscribers))
+	for _, fn := range p.subscribers {
+		fns = append(fns, fn)
+	}
+	p.mu.Unlock()
+
+	for _, fn := range fns {
+		fn(resp)
+	}
+}
+
+func (p *activePull) progress() api.PullStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := api.PullStatus{
+		Model:  ParseModelPath(p.key).GetShortTagname(),
+		State:  p.state,
+		Status: p.status,
+	}
+
+	for _, layer := range p.layers {
+		status.Total += layer.Total
+		status.Completed += layer.Completed
+	}
+
+	return status
+}
+
+type pullManager struct {
+	mu    sync.Mutex
+	pulls map[string]*activePull
+}
+
+var activePulls = pullManager{pulls: make(map[string]*activePull)}
+
+// start runs p in the background. It must be called with m.mu and p.mu held.
+func (m *pullManager) start(p *activePull, detached bool) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	p.cancel = cancel
+	p.run = &pullRun{done: make(chan struct{})}
+	p.detached = detached
+	p.state = "pulling"
+	p.status = ""
+	p.record.Paused = false
+
+	if err := writePullRecord(p.key, p.record); err != nil {
+		slog.Info(fmt.Sprintf("couldn't record pull of %s: %v", p.record.Model, err))
+	}
+
+	go func() {
+		err := PullModel(ctx, p.record.Model, p.opts, p.broadcast)
+		if cause := context.Cause(ctx); err != nil && cause != nil {
+			err = cause
+		}
+
+		m.finish(p, err)
+	}()
+}
+
+func (m *pullManager) finish(p *activePull, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case errors.Is(err, errPullPaused):
+		p.state = "paused"
+		p.record.Paused = true
+		if err := writePullRecord(p.key, p.record); err != nil {
+			slog.Info(fmt.Sprintf("couldn't record pull of %s: %v", p.record.Model, err))
+		}
+	default:
+		delete(m.pulls, p.key)
+		removePullRecord(p.key)
+	}
+
+	p.run.err = err
+	close(p.run.done)
+	p.run = nil
+}
+
+// pull starts pulling model, or joins the pull already running, and waits for
+// it to finish. Canceling ctx stops the pull once nobody else is waiting on it.
+func (m *pullManager) pull(ctx context.Context, model string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+	key := ParseModelPath(model).GetFullTagname()
+
+	m.mu.Lock()
+	p, ok := m.pulls[key]
+	if !ok {
+		p = &activePull{
+			key:         key,
+			layers:      make(map[string]api.ProgressResponse),
+			subscribers: make(map[int]func(api.ProgressResponse)),
+		}
+		m.pulls[key] = p
+	}
+
+	p.mu.Lock()
+	if !p.running() {
+		// a paused pull resumes with the options of the new request
+		p.opts = regOpts
+		p.record = pullRecord{
+			Model:         model,
+			Insecure:      regOpts.Insecure,
+			RequireSigned: regOpts.RequireSigned,
+			LimitRate:     regOpts.LimitRate,
+			Digests:       p.record.Digests,
+		}
+		m.start(p, false)
+	}
+
+	id := p.nextID
+	p.nextID++
+	p.subscribers[id] = fn
+	p.refs++
+	run := p.run
+	p.mu.Unlock()
+	m.mu.Unlock()
+
+	leave := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		delete(p.subscribers, id)
+		p.refs--
+		if p.refs == 0 && !p.detached && p.running() {
+			p.cancel(errPullInterrupted)
+		}
+	}
+
+	select {
+	case <-run.done:
+		leave()
+		return run.err
+	case <-ctx.Done():
+		leave()
+		return ctx.Err()
+	}
+}
+
+func (m *pullManager) get(model string) (*activePull, error) {
+	p, ok := m.pulls[ParseModelPath(model).GetFullTagname()]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errPullNotFound, model)
+	}
+
+	return p, nil
+}
+
+func (m *pullManager) pause(model string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.get(model)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running() {
+		p.cancel(errPullPaused)
+	}
+
+	return nil
+}
+
+func (m *pullManager) resume(model string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.get(model)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running() {
+		m.start(p, true)
+	}
+
+	return nil
+}
+
+// cancel stops a pull and forgets it. Downloaded parts are kept so pulling the
+// model again picks up where it stopped.
+func (m *pullManager) cancel(model string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.get(model)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running() {
+		p.cancel(errPullCanceled)
+		return nil
+	}
+
+	delete(m.pulls, p.key)
+	removePullRecord(p.key)
+	return nil
+}
+
+func (m *pullManager) list() []api.PullStatus {
+	m.mu.Lock()
+	pulls := make([]*activePull, 0, len(m.pulls))
+	for _, p := range m.pulls {
+		pulls = append(pulls, p)
+	}
+	m.mu.Unlock()
+
+	statuses := make([]api.PullStatus, 0, len(pulls))
+	for _, p := range pulls {
+		statuses = append(statuses, p.progress())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Model < statuses[j].Model
+	})
+
+	return statuses
+}
+
+// readPullRecords returns the pulls recorded in the models directory, removing
+// records which can't be read
+func readPullRecords() ([]pullRecord, error) {
+	dir, err := pullRecordsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []pullRecord
+	for _, entry := range entries {
+		fp := filepath.Join(dir, entry.Name())
+		bts, err := os.ReadFile(fp)
+		if err != nil {
+			return nil, err
+		}
+
+		var record pullRecord
+		if err := json.Unmarshal(bts, &record); err != nil || record.Model == "" {
+			slog.Info(fmt.Sprintf("removing invalid pull record %s", fp))
+			os.Remove(fp)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// resumePulls restarts the pulls recorded by a previous server. Paused pulls
+// are listed but stay paused.
+func (m *pullManager) resumePulls() error {
+	records, err := readPullRecords()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, record := range records {
+		key := ParseModelPath(record.Model).GetFullTagname()
+		if _, ok := m.pulls[key]; ok {
+			continue
+		}
+
+		p := &activePull{
+			key:    key,
+			record: record,
+			opts: &registryOptions{
+				Insecure:      record.Insecure,
+				RequireSigned: record.RequireSigned,
+				LimitRate:     record.LimitRate,
+			},
+			state:       "paused",
+			layers:      make(map[string]api.ProgressResponse),
+			subscribers: make(map[int]func(api.ProgressResponse)),
+		}
+		m.pulls[key] = p
+
+		if !record.Paused {
+			slog.Info(fmt.Sprintf("resuming pull of %s", ParseModelPath(key).GetShortTagname()))
+			p.mu.Lock()
+			m.start(p, true)
+			p.mu.Unlock()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// newBlockingRegistry serves a model whose layer isn't sent until unblock is called
+func newBlockingRegistry(t *testing.T) (srv *httptest.Server, requested <-chan struct{}, unblock func()) {
+	layer := []byte("GGUF\x02\x00")
+	config := []byte("{}")
+	digest := func(bts []byte) string { return fmt.Sprintf("sha256:%x", sha256.Sum256(bts)) }
+
+	manifest, err := json.Marshal(ManifestV2{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeDockerManifest,
+		Config:        &Layer{MediaType: "application/vnd.docker.container.image.v1+json", Digest: digest(config), Size: int64(len(config))},
+		Layers:        []*Layer{{MediaType: "application/vnd.ollama.image.model", Digest: digest(layer), Size: int64(len(layer))}},
+	})
+	assert.Nil(t, err)
+
+	requestedCh := make(chan struct{}, 16)
+	blocked := make(chan struct{})
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/blocked/manifests/latest":
+			w.Header().Set("Content-Type", mediaTypeDockerManifest)
+			w.Write(manifest)
+		case "/v2/library/blocked/blobs/" + digest(config):
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(config))
+		case "/v2/library/blocked/blobs/" + digest(layer):
+			if r.Method == http.MethodGet {
+				requestedCh <- struct{}{}
+				select {
+				case <-blocked:
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(layer))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var once sync.Once
+	return srv, requestedCh, func() { once.Do(func() { close(blocked) }) }
+}
+
+func TestPullManager(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRIES", filepath.Join(t.TempDir(), "registries.json"))
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	srv, requested, unblock := newBlockingRegistry(t)
+	defer unblock()
+
+	name := fmt.Sprintf("http://%s/library/blocked", srv.Listener.Addr())
+	short := ParseModelPath(name).GetShortTagname()
+
+	m := pullManager{pulls: make(map[string]*activePull)}
+
+	errCh := make(chan error)
+	go func() {
+		errCh <- m.pull(context.TODO(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+	}()
+
+	<-requested
+	pulls := m.list()
+	assert.Len(t, pulls, 1)
+	assert.Equal(t, short, pulls[0].Model)
+	assert.Equal(t, "pulling", pulls[0].State)
+
+	assert.Nil(t, m.pause(short))
+	assert.ErrorIs(t, <-errCh, errPullPaused)
+	assert.Equal(t, "paused", m.list()[0].State)
+
+	// a restarted server lists the pull but leaves it paused
+	restarted := pullManager{pulls: make(map[string]*activePull)}
+	assert.Nil(t, restarted.resumePulls())
+	assert.Len(t, restarted.list(), 1)
+	assert.Equal(t, "paused", restarted.list()[0].State)
+
+	assert.ErrorIs(t, m.pause("missing"), errPullNotFound)
+	assert.ErrorIs(t, m.cancel("missing"), errPullNotFound)
+
+	assert.Nil(t, m.resume(short))
+	<-requested
+	unblock()
+
+	assert.Eventually(t, func() bool { return len(m.list()) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	_, _, err := GetManifest(ParseModelPath(name))
+	assert.Nil(t, err)
+
+	// the pull finished so there's nothing to resume
+	restarted = pullManager{pulls: make(map[string]*activePull)}
+	assert.Nil(t, restarted.resumePulls())
+	assert.Empty(t, restarted.list())
+}
+
+func TestPullManagerCancel(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRIES", filepath.Join(t.TempDir(), "registries.json"))
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	srv, requested, unblock := newBlockingRegistry(t)
+	defer unblock()
+
+	name := fmt.Sprintf("http://%s/library/blocked", srv.Listener.Addr())
+	m := pullManager{pulls: make(map[string]*activePull)}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	errCh := make(chan error, 2)
+	for range 2 {
+		go func() {
+			errCh <- m.pull(ctx, name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+		}()
+	}
+
+	<-requested
+	assert.Eventually(t, func() bool {
+		p, err := m.get(name)
+		if err != nil {
+			return false
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.refs == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// both requests wait on the same pull
+	assert.Len(t, m.list(), 1)
+	assert.Nil(t, m.cancel(name))
+	assert.ErrorIs(t, <-errCh, errPullCanceled)
+	assert.ErrorIs(t, <-errCh, errPullCanceled)
+	assert.Empty(t, m.list())
+
+	restarted := pullManager{pulls: make(map[string]*activePull)}
+	assert.Nil(t, restarted.resumePulls())
+	assert.Empty(t, restarted.list())
+}
+
+func TestPullManagerDisconnect(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRIES", filepath.Join(t.TempDir(), "registries.json"))
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	srv, requested, unblock := newBlockingRegistry(t)
+	defer unblock()
+
+	name := fmt.Sprintf("http://%s/library/blocked", srv.Listener.Addr())
+	m := pullManager{pulls: make(map[string]*activePull)}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	errCh := make(chan error)
+	go func() {
+		errCh <- m.pull(ctx, name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {})
+	}()
+
+	<-requested
+	cancel()
+	assert.ErrorIs(t, <-errCh, context.Canceled)
+	assert.Eventually(t, func() bool { return len(m.list()) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// a pull the client stopped waiting on isn't resumed by a restarted
+	// server but its partial download is kept for the next pull
+	restarted := pullManager{pulls: make(map[string]*activePull)}
+	assert.Nil(t, restarted.resumePulls())
+	assert.Empty(t, restarted.list())
+
+	dir, err := GetBlobsPath("")
+	assert.Nil(t, err)
+	partials, err := filepath.Glob(filepath.Join(dir, "*-partial"))
+	assert.Nil(t, err)
+	assert.Len(t, partials, 1)
+}
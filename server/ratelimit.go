@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jmorganca/ollama/format"
+)
+
+// rateLimiter is a token bucket which allows rate bytes per second with bursts
+// of up to one second. It doesn't limit if rate isn't positive.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+
+	// now and sleep are the clock of the limiter, which is the real one if
+	// they're nil
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// newRateLimiter returns nil, which doesn't limit, if rate isn't positive
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	l := &rateLimiter{rate: rate}
+	l.last = l.time()
+	return l
+}
+
+func (l *rateLimiter) time() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+
+	return time.Now()
+}
+
+// setRate changes the rate of the limiter. The bucket starts empty if the
+// limiter didn't limit before.
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		l.tokens = 0
+	} else {
+		l.refill()
+	}
+
+	l.rate = rate
+	l.tokens = min(l.tokens, float64(rate))
+	l.last = l.time()
+}
+
+// refill adds the tokens allowed since the last call. l.mu must be held.
+func (l *rateLimiter) refill() {
+	now := l.time()
+	l.tokens = min(float64(l.rate), l.tokens+now.Sub(l.last).Seconds()*float64(l.rate))
+	l.last = now
+}
+
+// wait blocks until n more bytes are allowed
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+
+	l.refill()
+
+	// take the tokens now so concurrent callers queue behind each other
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	sleep := l.sleep
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	if sleep != nil {
+		return sleep(ctx, delay)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var downloadLimiter struct {
+	sync.Mutex
+	value   string
+	limiter *rateLimiter
+}
+
+// globalDownloadLimiter returns the limiter shared by all downloads, set by
+// OLLAMA_MAX_DOWNLOAD_RATE in bytes per second, e.g. "10MB"
+func globalDownloadLimiter() *rateLimiter {
+	downloadLimiter.Lock()
+	defer downloadLimiter.Unlock()
+
+	value := os.Getenv("OLLAMA_MAX_DOWNLOAD_RATE")
+	if value == downloadLimiter.value {
+		return downloadLimiter.limiter
+	}
+
+	var rate int64
+	if value != "" {
+		var err error
+		if rate, err = format.ParseBytes(value); err != nil {
+			slog.Info(fmt.Sprintf("ignoring OLLAMA_MAX_DOWNLOAD_RATE: %v", err))
+		}
+	}
+
+	downloadLimiter.value = value
+	downloadLimiter.limiter = newRateLimiter(rate)
+	return downloadLimiter.limiter
+}
+
+// rateLimitedReaderChunk bounds how long a single read waits for the limiters
+const rateLimitedReaderChunk = 32 * format.KiloByte
+
+type rateLimitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+
+	// waited is called after each wait so callers can tell throttling apart
+	// from a stalled connection
+	waited func()
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateLimitedReaderChunk {
+		p = p[:rateLimitedReaderChunk]
+	}
+
+	n, err := r.r.Read(p)
+	for _, l := range r.limiters {
+		if l == nil {
+			continue
+		}
+
+		if err := l.wait(r.ctx, n); err != nil {
+			return n, err
+		}
+
+		if r.waited != nil {
+			r.waited()
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock whose sleeps pass instantly
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.t = c.t.Add(d)
+	return nil
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 150*1000)
+
+	var clock fakeClock
+	limiter := &rateLimiter{now: clock.now, sleep: clock.sleep}
+	limiter.setRate(100 * 1000)
+
+	// the bucket starts empty so 150KB at 100KB/s takes 1.5s
+	r := &rateLimitedReader{
+		ctx:      context.TODO(),
+		r:        bytes.NewReader(data),
+		limiters: []*rateLimiter{limiter, nil},
+	}
+
+	bts, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, bts)
+	assert.InDelta(t, 1500*time.Millisecond, clock.t.Sub(time.Time{}), float64(time.Millisecond))
+
+	// a limiter without a rate doesn't wait
+	limiter.setRate(0)
+	start := clock.t
+	_, err = io.ReadAll(&rateLimitedReader{ctx: context.TODO(), r: bytes.NewReader(data), limiters: []*rateLimiter{limiter}})
+	assert.Nil(t, err)
+	assert.Equal(t, start, clock.t)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	r = &rateLimitedReader{
+		ctx:      ctx,
+		r:        bytes.NewReader(data),
+		limiters: []*rateLimiter{newRateLimiter(1)},
+	}
+
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBlobDownloadLimit(t *testing.T) {
+	b := blobDownload{limiter: &rateLimiter{}}
+
+	// the download is limited to the highest limit of the waiting pulls
+	removeSlow := b.limit(10)
+	assert.Equal(t, int64(10), b.limiter.rate)
+
+	removeFast := b.limit(20)
+	assert.Equal(t, int64(20), b.limiter.rate)
+
+	// unless one of them has none
+	removeUnlimited := b.limit(0)
+	assert.Equal(t, int64(0), b.limiter.rate)
+
+	removeUnlimited()
+	assert.Equal(t, int64(20), b.limiter.rate)
+
+	// and the limits of pulls which stopped waiting are dropped
+	removeFast()
+	assert.Equal(t, int64(10), b.limiter.rate)
+
+	removeSlow()
+	assert.Equal(t, int64(0), b.limiter.rate)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// The handlers in this file serve the local model store to other ollama
+// instances using the read-only subset of the OCI distribution API that
+// PullModel needs. They are enabled by OLLAMA_REGISTRY, or by
+// OLLAMA_REGISTRY_CACHE which additionally pulls missing models from upstream.
+
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+func registryError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"errors": []gin.H{{"code": code, "message": message}},
+	})
+}
+
+// upstreamModelPath maps a registry request to the model it refers to. Mirror
+// clients name the upstream registry in the ns query parameter.
+func upstreamModelPath(c *gin.Context, tag string) ModelPath {
+	registry := c.Query("ns")
+	if registry == "" {
+		registry = DefaultRegistry
+	}
+
+	return ModelPath{
+		ProtocolScheme: DefaultProtocolScheme,
+		Registry:       registry,
+		Namespace:      c.Param("namespace"),
+		Repository:     c.Param("repository"),
+		Tag:            tag,
+	}
+}
+
+var (
+	registryHostRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?(:[0-9]+)?$`)
+	registryNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]*$`)
+)
+
+// validateRegistryPath checks a model path built from request parameters before
+// it is used to build file paths
+func validateRegistryPath(mp ModelPath) error {
+	if !registryHostRegexp.MatchString(mp.Registry) {
+		return fmt.Errorf("%w: invalid registry %q", errModelPathInvalid, mp.Registry)
+	}
+
+	for _, s := range []string{mp.Namespace, mp.Repository} {
+		if !registryNameRegexp.MatchString(s) {
+			return fmt.Errorf("%w: invalid name %q", errModelPathInvalid, s)
+		}
+	}
+
+	if !registryNameRegexp.MatchString(mp.Tag) && !digestRegexp.MatchString(mp.Tag) {
+		return fmt.Errorf("%w: invalid reference %q", errModelPathInvalid, mp.Tag)
+	}
+
+	return nil
+}
+
+// pullThrough reports whether the server should fetch models it doesn't have
+// from registry. OLLAMA_REGISTRY_CACHE lists the registries it caches.
+func pullThrough(registry string) bool {
+	for _, host := range strings.Split(os.Getenv("OLLAMA_REGISTRY_CACHE"), ",") {
+		if strings.TrimSpace(host) == registry {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findManifest returns the path of the local manifest mp refers to. The
+// reference of mp may be a tag or the digest of a manifest.
+func findManifest(mp ModelPath) (string, error) {
+	if !digestRegexp.MatchString(mp.Tag) {
+		fp, _, err := mp.findManifestPath()
+		return fp, err
+	}
+
+	roots, err := modelRoots()
+	if err != nil {
+		return "", err
+	}
+
+	// manifests are stored by tag so look for one with a matching digest
+	for _, root := range roots {
+		dir := filepath.Join(root.path, "manifests", mp.Registry, mp.Namespace, mp.Repository)
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			fp := filepath.Join(dir, entry.Name())
+			if _, digest, err := readManifest(fp); err == nil && "sha256:"+digest == mp.Tag {
+				return fp, nil
+			}
+		}
+	}
+
+	return "", os.ErrNotExist
+}
+
+func RegistryManifestHandler(c *gin.Context) {
+	mp := upstreamModelPath(c, c.Param("reference"))
+	if err := validateRegistryPath(mp); err != nil {
+		registryError(c, http.StatusBadRequest, "NAME_INVALID", err.Error())
+		return
+	}
+
+	if pullThrough(mp.Registry) && !digestRegexp.MatchString(mp.Tag) {
+		// tags can move upstream so always check for a newer manifest
+		fn := func(api.ProgressResponse) {}
+		if err := PullModel(c.Request.Context(), mp.GetFullTagname(), &registryOptions{}, fn); err != nil {
+			slog.Info(fmt.Sprintf("couldn't pull %s, serving local copy: %v", mp.GetShortTagname(), err))
+		}
+	}
+
+	fp, err := findManifest(mp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	bts, err := os.ReadFile(fp)
+	if err != nil {
+		registryError(c, http.StatusNotFound, "MANIFEST_UNKNOWN", fmt.Sprintf("manifest %s not found", mp.GetShortTagname()))
+		return
+	}
+
+	c.Header("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(bts)))
+	c.Header("Content-Length", strconv.Itoa(len(bts)))
+	if c.Request.Method == http.MethodHead {
+		c.Header("Content-Type", mediaTypeDockerManifest)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	c.Data(http.StatusOK, mediaTypeDockerManifest, bts)
+}
+
+func RegistryBlobHandler(c *gin.Context) {
+	digest := c.Param("digest")
+	if !digestRegexp.MatchString(digest) {
+		registryError(c, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("invalid digest %q", digest))
+		return
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		registryError(c, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+
+	mp := upstreamModelPath(c, DefaultTag)
+	if err := validateRegistryPath(mp); err != nil {
+		registryError(c, http.StatusBadRequest, "NAME_INVALID", err.Error())
+		return
+	}
+
+	if _, err := os.Stat(fp); errors.Is(err, os.ErrNotExist) && pullThrough(mp.Registry) {
+		opts := downloadOpts{
+			mp:      mp,
+			digest:  digest,
+			regOpts: &registryOptions{},
+			fn:      func(api.ProgressResponse) {},
+		}
+
+		if err := downloadBlob(c.Request.Context(), opts); err != nil {
+			slog.Info(fmt.Sprintf("couldn't pull %s from %s: %v", digest[7:19], mp.GetNamespaceRepository(), err))
+		}
+	}
+
+	if _, err := os.Stat(fp); err != nil {
+		registryError(c, http.StatusNotFound, "BLOB_UNKNOWN", fmt.Sprintf("blob %s not found", digest))
+		return
+	}
+
+	// blobs are content addressed so they never change
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Docker-Content-Digest", digest)
+	c.Header("Cache-Control", "max-age=31536000, immutable")
+
+	// ServeFile handles HEAD and Range requests
+	c.File(fp)
+}
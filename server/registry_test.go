@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+func TestRegistryCache(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	// nothing listens on port 1 so pulling through always fails
+	t.Setenv("OLLAMA_REGISTRY_CACHE", "127.0.0.1:1")
+
+	createModelfile(t, "127.0.0.1:1/library/cached", "SYSTEM hello")
+
+	manifest, digest, err := GetManifest(ParseModelPath("127.0.0.1:1/library/cached"))
+	assert.Nil(t, err)
+
+	s := Server{}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	t.Cleanup(srv.Close)
+
+	get := func(method, path string) *http.Response {
+		req, err := http.NewRequestWithContext(context.TODO(), method, srv.URL+path, nil)
+		assert.Nil(t, err)
+		resp, err := srv.Client().Do(req)
+		assert.Nil(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := get(http.MethodGet, "/v2/library/cached/manifests/latest?ns=127.0.0.1:1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "sha256:"+digest, resp.Header.Get("Docker-Content-Digest"))
+
+	bts, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, digest, fmt.Sprintf("%x", sha256.Sum256(bts)))
+
+	resp = get(http.MethodGet, "/v2/library/cached/manifests/sha256:"+digest+"?ns=127.0.0.1:1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = get(http.MethodGet, "/v2/library/cached/blobs/"+manifest.Layers[0].Digest+"?ns=127.0.0.1:1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	bts, err = io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "GGUF\x02\x00", string(bts))
+
+	resp = get(http.MethodGet, "/v2/library/missing/manifests/latest?ns=127.0.0.1:1")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = get(http.MethodGet, "/v2/library/cached/blobs/sha256:"+strings.Repeat("0", 64)+"?ns=127.0.0.1:1")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = get(http.MethodGet, "/v2/library/cached/blobs/..")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestRegistryServe(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRY", "1")
+	t.Setenv("OLLAMA_REGISTRIES", filepath.Join(t.TempDir(), "registries.json"))
+
+	createModelfile(t, "served", "SYSTEM hello")
+
+	manifest, digest, err := GetManifest(ParseModelPath("served"))
+	assert.Nil(t, err)
+
+	s := Server{}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	t.Cleanup(srv.Close)
+
+	do := func(method, path string, headers map[string]string) *http.Response {
+		req, err := http.NewRequestWithContext(context.TODO(), method, srv.URL+path, nil)
+		assert.Nil(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := srv.Client().Do(req)
+		assert.Nil(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/v2/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "registry/2.0", resp.Header.Get("Docker-Distribution-API-Version"))
+
+	resp = do(http.MethodHead, "/v2/library/served/manifests/latest", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "sha256:"+digest, resp.Header.Get("Docker-Content-Digest"))
+	assert.NotEqual(t, "", resp.Header.Get("Content-Length"))
+
+	model := manifest.Layers[0]
+	resp = do(http.MethodHead, "/v2/library/served/blobs/"+model.Digest, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, fmt.Sprint(model.Size), resp.Header.Get("Content-Length"))
+
+	resp = do(http.MethodGet, "/v2/library/served/blobs/"+model.Digest, map[string]string{"Range": "bytes=1-3"})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	bts, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "GUF", string(bts))
+
+	// pull the model back from ourselves as another instance would
+	u, err := url.Parse(srv.URL)
+	assert.Nil(t, err)
+
+	name := fmt.Sprintf("%s/library/served", u.Host)
+	assert.Nil(t, PullModel(context.TODO(), name, &registryOptions{Insecure: true}, func(api.ProgressResponse) {}))
+
+	pulled, _, err := GetManifest(ParseModelPath(name))
+	assert.Nil(t, err)
+	assert.Equal(t, manifest.Config.Digest, pulled.Config.Digest)
+	assert.Equal(t, manifest.Layers, pulled.Layers)
+}
@@ -14,6 +14,7 @@ import (
 	"net/netip"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -100,6 +101,8 @@ func load(c *gin.Context, model *Model, opts api.Options, sessionDuration time.D
 		loaded.Options = &opts
 	}
 
+	markUsed(model.Name, time.Now())
+
 	loaded.expireAt = time.Now().Add(sessionDuration)
 
 	if loaded.expireTimer == nil {
@@ -118,6 +121,8 @@ func load(c *gin.Context, model *Model, opts api.Options, sessionDuration time.D
 			loaded.runner = nil
 			loaded.Model = nil
 			loaded.Options = nil
+
+			flushUsage()
 		})
 	}
 
@@ -509,18 +514,27 @@ func PullModelHandler(c *gin.Context) {
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		// the pull may outlive this request so don't block once it's gone
 		fn := func(r api.ProgressResponse) {
-			ch <- r
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+			}
 		}
 
 		regOpts := &registryOptions{
 			Insecure: req.Insecure,
-		}
+			Username: req.Username,
+			Password: req.Password,
 
-		ctx, cancel := context.WithCancel(c.Request.Context())
-		defer cancel()
+			RequireSigned: req.RequireSigned,
+			LimitRate:     req.LimitRate,
+		}
 
-		if err := PullModel(ctx, model, regOpts, fn); err != nil {
+		if err := activePulls.pull(ctx, model, regOpts, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
 		}
 	}()
@@ -533,6 +547,137 @@ func PullModelHandler(c *gin.Context) {
 	streamResponse(c, ch)
 }
 
+func GarbageCollectHandler(c *gin.Context) {
+	var req api.GCRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := GarbageCollect(req.DryRun)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func VerifyModelsHandler(c *gin.Context) {
+	var req api.VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model != "" {
+		if _, _, err := GetManifest(ParseModelPath(req.Model)); err != nil {
+			if os.IsNotExist(err) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		fn := func(r api.VerifyResponse) {
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+			}
+		}
+
+		if err := VerifyModels(ctx, req.Model, req.Repair, fn); err != nil {
+			ch <- gin.H{"error": err.Error()}
+		}
+	}()
+
+	streamResponse(c, ch)
+}
+
+func UpdatesHandler(c *gin.Context) {
+	models := c.QueryArray("model")
+	for _, model := range models {
+		if _, _, err := GetManifest(ParseModelPath(model)); err != nil {
+			if os.IsNotExist(err) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", model)})
+			} else {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+	}
+
+	regOpts := &registryOptions{Insecure: c.Query("insecure") == "true"}
+	updates, err := CheckUpdates(c.Request.Context(), models, regOpts)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if updates == nil {
+		updates = []api.ModelUpdate{}
+	}
+
+	c.JSON(http.StatusOK, api.UpdatesResponse{Models: updates})
+}
+
+func ListPullsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.PullsResponse{Pulls: activePulls.list()})
+}
+
+func CancelPullHandler(c *gin.Context) {
+	model := strings.TrimPrefix(c.Param("model"), "/")
+	if err := activePulls.cancel(model); err != nil {
+		pullError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+// PullActionHandler pauses or resumes the pull named by the path, which ends
+// with the action, e.g. /api/pulls/llama2:7b/pause
+func PullActionHandler(c *gin.Context) {
+	model, action := path.Split(strings.TrimPrefix(c.Param("model"), "/"))
+	model = strings.TrimSuffix(model, "/")
+
+	var err error
+	switch action {
+	case "pause":
+		err = activePulls.pause(model)
+	case "resume":
+		err = activePulls.resume(model)
+	default:
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("unknown pull action %q", action)})
+		return
+	}
+
+	if err != nil {
+		pullError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func pullError(c *gin.Context, err error) {
+	if errors.Is(err, errPullNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
 func PushModelHandler(c *gin.Context) {
 	var req api.PushRequest
 	err := c.ShouldBindJSON(&req)
@@ -564,6 +709,10 @@ func PushModelHandler(c *gin.Context) {
 
 		regOpts := &registryOptions{
 			Insecure: req.Insecure,
+			Username: req.Username,
+			Password: req.Password,
+
+			Sign: req.Sign,
 		}
 
 		ctx, cancel := context.WithCancel(c.Request.Context())
@@ -632,6 +781,13 @@ func CreateModelHandler(c *gin.Context) {
 		return
 	}
 
+	if req.Quantize != "" {
+		if _, err := llm.ParseFileType(req.Quantize); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -642,7 +798,7 @@ func CreateModelHandler(c *gin.Context) {
 		ctx, cancel := context.WithCancel(c.Request.Context())
 		defer cancel()
 
-		if err := CreateModel(ctx, model, filepath.Dir(req.Path), commands, fn); err != nil {
+		if err := CreateModel(ctx, model, filepath.Dir(req.Path), req.Quantize, commands, fn); err != nil {
 			ch <- gin.H{"error": err.Error()}
 		}
 	}()
@@ -677,9 +833,19 @@ func DeleteModelHandler(c *gin.Context) {
 		return
 	}
 
-	if err := DeleteModel(model); err != nil {
+	err = DeleteModel(model)
+	if os.IsNotExist(err) {
+		// removing an alias leaves the model it refers to
+		if aliasErr := DeleteAlias(model); aliasErr == nil {
+			err = nil
+		}
+	}
+
+	if err != nil {
 		if os.IsNotExist(err) {
 			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", model)})
+		} else if errors.Is(err, errModelReadOnly) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -700,6 +866,150 @@ func DeleteModelHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, nil)
 }
 
+func CreateAliasHandler(c *gin.Context) {
+	var req api.AliasRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Alias == "" || req.Target == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "alias and target are required"})
+		return
+	}
+
+	if err := CreateAlias(req.Alias, req.Target); err != nil {
+		switch {
+		case os.IsNotExist(err):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Target)})
+		case errors.Is(err, errModelPathInvalid), errors.Is(err, errAliasCycle):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func DeleteAliasHandler(c *gin.Context) {
+	var req api.AliasRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Alias == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "alias is required"})
+		return
+	}
+
+	if err := DeleteAlias(req.Alias); err != nil {
+		if errors.Is(err, errAliasNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func ListAliasesHandler(c *gin.Context) {
+	aliases, err := ListAliases()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.AliasesResponse{Aliases: aliases})
+}
+
+func RemoteTagsHandler(c *gin.Context) {
+	var req api.RemoteTagsRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	regOpts := &registryOptions{
+		Insecure: req.Insecure,
+		Username: req.Username,
+		Password: req.Password,
+	}
+
+	tags, err := RemoteTags(c.Request.Context(), req.Model, regOpts)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("repository of '%s' not found", req.Model)})
+		} else {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, api.RemoteTagsResponse{Tags: tags})
+}
+
+func PinModelHandler(c *gin.Context) {
+	pinModel(c, true)
+}
+
+func UnpinModelHandler(c *gin.Context) {
+	pinModel(c, false)
+}
+
+func pinModel(c *gin.Context, pinned bool) {
+	var req api.PinRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	if err := PinModel(req.Model, pinned); err != nil {
+		if os.IsNotExist(err) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		} else {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
 func ShowModelHandler(c *gin.Context) {
 	var req api.ShowRequest
 	err := c.ShouldBindJSON(&req)
@@ -768,6 +1078,7 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		Template: model.Template,
 		Details:  modelDetails,
 		Messages: msgs,
+		Signer:   model.Signer,
 	}
 
 	var params []string
@@ -797,17 +1108,71 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 
 	resp.Modelfile = mf
 
+	if req.Verbose {
+		if resp.Metadata, resp.Tensors, err = modelMetadata(model.ModelPath, req.FullArrays); err != nil {
+			return nil, err
+		}
+	}
+
 	return resp, nil
 }
 
+// maxShowArrayLen is the length above which metadata arrays are elided
+const maxShowArrayLen = 16
+
+// modelMetadata decodes the key-values and tensors of the model file at path.
+// Arrays longer than maxShowArrayLen are replaced by their length unless
+// fullArrays is set.
+func modelMetadata(path string, fullArrays bool) (map[string]any, []api.TensorInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	ggml, err := llm.DecodeGGML(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata := make(map[string]any)
+	for k, v := range ggml.KV() {
+		if a, ok := v.([]any); ok && len(a) > maxShowArrayLen && !fullArrays {
+			v = fmt.Sprintf("[%d values]", len(a))
+		}
+
+		metadata[k] = v
+	}
+
+	var tensors []api.TensorInfo
+	for _, t := range ggml.Tensors() {
+		// gguf shapes are padded to four dimensions
+		shape := t.Shape
+		for len(shape) > 1 && shape[len(shape)-1] == 1 {
+			shape = shape[:len(shape)-1]
+		}
+
+		tensors = append(tensors, api.TensorInfo{
+			Name:   t.Name,
+			Type:   t.TypeName(),
+			Shape:  shape,
+			Offset: t.Offset,
+			Size:   t.Size(),
+		})
+	}
+
+	return metadata, tensors, nil
+}
+
 func ListModelsHandler(c *gin.Context) {
-	models := make([]api.ModelResponse, 0)
-	manifestsPath, err := GetManifestPath()
+	filter, err := parseListFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	models := make([]api.ModelResponse, 0)
+
 	modelResponse := func(modelName string) (api.ModelResponse, error) {
 		model, err := GetModel(modelName)
 		if err != nil {
@@ -827,36 +1192,45 @@ func ListModelsHandler(c *gin.Context) {
 			Name:    model.ShortName,
 			Size:    model.Size,
 			Digest:  model.Digest,
+			Pinned:  isPinned(modelName),
 			Details: modelDetails,
 		}, nil
 	}
 
-	walkFunc := func(path string, info os.FileInfo, _ error) error {
-		if !info.IsDir() {
-			path, tag := filepath.Split(path)
-			model := strings.Trim(strings.TrimPrefix(path, manifestsPath), string(os.PathSeparator))
-			modelPath := strings.Join([]string{model, tag}, ":")
-			canonicalModelPath := strings.ReplaceAll(modelPath, string(os.PathSeparator), "/")
+	// a model in more than one root is listed from the first, which is the one
+	// which is used
+	seen := make(map[string]bool)
+	walkFunc := func(root modelRoot, name, _ string, info os.FileInfo) error {
+		if seen[name] {
+			return nil
+		}
 
-			resp, err := modelResponse(canonicalModelPath)
-			if err != nil {
-				slog.Info(fmt.Sprintf("skipping file: %s", canonicalModelPath))
-				// nolint: nilerr
-				return nil
-			}
+		seen[name] = true
+
+		resp, err := modelResponse(name)
+		if err != nil {
+			slog.Info(fmt.Sprintf("skipping file: %s", name))
+			// nolint: nilerr
+			return nil
+		}
 
-			resp.ModifiedAt = info.ModTime()
+		resp.ModifiedAt = info.ModTime()
+		resp.Root = root.path
+		resp.ReadOnly = root.readOnly
+		if filter.match(resp) {
 			models = append(models, resp)
 		}
 
 		return nil
 	}
 
-	if err := filepath.Walk(manifestsPath, walkFunc); err != nil {
+	if err := walkManifests(walkFunc); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	filter.sort(models)
+
 	c.JSON(http.StatusOK, api.ListResponse{Models: models})
 }
 
@@ -892,6 +1266,143 @@ func CopyModelHandler(c *gin.Context) {
 	}
 }
 
+func SaveModelHandler(c *gin.Context) {
+	var req api.SaveRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	size, err := ArchiveSize(req.Model)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Header("Content-Type", "application/x-tar")
+	c.Header("Content-Length", strconv.FormatInt(size, 10))
+	c.Status(http.StatusOK)
+
+	// the status has been sent so errors can only be logged, the client sees a short archive
+	if err := SaveModel(req.Model, c.Writer); err != nil {
+		slog.Info(fmt.Sprintf("couldn't save %s: %v", req.Model, err))
+	}
+}
+
+func LoadModelHandler(c *gin.Context) {
+	// the body is the archive so streaming is turned off with a query parameter
+	if stream, err := strconv.ParseBool(c.DefaultQuery("stream", "true")); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "stream must be a boolean"})
+		return
+	} else if !stream {
+		models, err := LoadModels(c.Request.Body, func(api.ProgressResponse) {})
+		switch {
+		case errors.Is(err, errInvalidArchive):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusOK, api.LoadResponse{ProgressResponse: api.ProgressResponse{Status: "success"}, Models: models})
+		}
+
+		return
+	}
+
+	// the archive is still being read while progress is written
+	if err := http.NewResponseController(c.Writer).EnableFullDuplex(); err != nil {
+		slog.Debug(fmt.Sprintf("couldn't enable full duplex: %v", err))
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		fn := func(resp api.ProgressResponse) {
+			ch <- api.LoadResponse{ProgressResponse: resp}
+		}
+
+		models, err := LoadModels(c.Request.Body, fn)
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		ch <- api.LoadResponse{ProgressResponse: api.ProgressResponse{Status: "success"}, Models: models}
+	}()
+
+	streamResponse(c, ch)
+}
+
+func EstimateHandler(c *gin.Context) {
+	var req api.EstimateRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	model, err := GetModel(req.Model)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		} else {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	opts, err := modelOptions(model, req.Options)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	est, err := llm.EstimateMemory(model.ModelPath, opts)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.EstimateResponse{
+		Model:         model.ShortName,
+		Library:       est.Info.Library,
+		NumCtx:        est.Options.NumCtx,
+		NumBatch:      est.Options.NumBatch,
+		Layers:        est.Layers,
+		TotalLayers:   est.TotalLayers,
+		Size:          est.Size,
+		KV:            est.KV,
+		Graph:         est.Graph,
+		VRAM:          est.VRAM,
+		RAM:           est.RAM,
+		AvailableVRAM: est.AvailableVRAM,
+		AvailableRAM:  est.AvailableRAM,
+		Fits:          est.Fits(),
+	})
+}
+
 func HeadBlobHandler(c *gin.Context) {
 	path, err := GetBlobsPath(c.Param("digest"))
 	if err != nil {
@@ -908,6 +1419,18 @@ func HeadBlobHandler(c *gin.Context) {
 }
 
 func CreateBlobHandler(c *gin.Context) {
+	if c.Request.ContentLength > 0 {
+		if err := ensureBlobSpace(c.Param("digest"), c.Request.ContentLength); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, errStoreFull) {
+				status = http.StatusInsufficientStorage
+			}
+
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	layer, err := NewLayer(c.Request.Body, "")
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -919,6 +1442,20 @@ func CreateBlobHandler(c *gin.Context) {
 		return
 	}
 
+	if c.Request.ContentLength <= 0 {
+		// the size of the blob is only known once it's written
+		if err := ensureBlobSpace(layer.Digest, layer.Size); err != nil {
+			os.Remove(layer.tempFileName)
+			status := http.StatusInternalServerError
+			if errors.Is(err, errStoreFull) {
+				status = http.StatusInsufficientStorage
+			}
+
+			c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	if _, err := layer.Commit(); err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1047,9 +1584,36 @@ func (s *Server) GenerateRoutes() http.Handler {
 	r.POST("/api/copy", CopyModelHandler)
 	r.DELETE("/api/delete", DeleteModelHandler)
 	r.POST("/api/show", ShowModelHandler)
+	r.GET("/api/aliases", ListAliasesHandler)
+	r.POST("/api/aliases", CreateAliasHandler)
+	r.DELETE("/api/aliases", DeleteAliasHandler)
+	r.POST("/api/tags/remote", RemoteTagsHandler)
+	r.POST("/api/pin", PinModelHandler)
+	r.POST("/api/unpin", UnpinModelHandler)
+	r.POST("/api/gc", GarbageCollectHandler)
+	r.POST("/api/verify", VerifyModelsHandler)
+	r.GET("/api/updates", UpdatesHandler)
+	r.GET("/api/pulls", ListPullsHandler)
+	r.DELETE("/api/pulls/*model", CancelPullHandler)
+	r.POST("/api/pulls/*model", PullActionHandler)
+	r.POST("/api/save", SaveModelHandler)
+	r.POST("/api/load", LoadModelHandler)
+	r.POST("/api/estimate", EstimateHandler)
 	r.POST("/api/blobs/:digest", CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", HeadBlobHandler)
 
+	if os.Getenv("OLLAMA_REGISTRY") != "" || os.Getenv("OLLAMA_REGISTRY_CACHE") != "" {
+		v2 := r.Group("/v2", func(c *gin.Context) {
+			c.Header("Docker-Distribution-API-Version", "registry/2.0")
+		})
+
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			v2.Handle(method, "/", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+			v2.Handle(method, "/:namespace/:repository/manifests/:reference", RegistryManifestHandler)
+			v2.Handle(method, "/:namespace/:repository/blobs/:digest", RegistryBlobHandler)
+		}
+	}
+
 	// Compatibility endpoints
 	r.POST("/v1/chat/completions", openai.Middleware(), ChatHandler)
 
@@ -1089,14 +1653,19 @@ func Serve(ln net.Listener) error {
 	slog.SetDefault(slog.New(handler))
 
 	blobsDir, err := GetBlobsPath("")
-	if err != nil {
-		return err
-	}
-	if err := fixBlobs(blobsDir); err != nil {
+	switch {
+	case errors.Is(err, errNoWritableRoot):
+		// models in read-only roots can still be run
+		slog.Info(err.Error())
+	case err != nil:
 		return err
+	default:
+		if err := fixBlobs(blobsDir); err != nil {
+			return err
+		}
 	}
 
-	if noprune := os.Getenv("OLLAMA_NOPRUNE"); noprune == "" {
+	if noprune := os.Getenv("OLLAMA_NOPRUNE"); noprune == "" && err == nil {
 		// clean up unused layers and manifests
 		if err := PruneLayers(); err != nil {
 			return err
@@ -1112,6 +1681,12 @@ func Serve(ln net.Listener) error {
 		}
 	}
 
+	if err := activePulls.resumePulls(); err != nil {
+		slog.Info(fmt.Sprintf("couldn't resume pulls: %v", err))
+	}
+
+	go flushUsagePeriodically()
+
 	s := &Server{addr: ln.Addr()}
 	r := s.GenerateRoutes()
 
@@ -1128,6 +1703,7 @@ func Serve(ln net.Listener) error {
 		if loaded.runner != nil {
 			loaded.runner.Close()
 		}
+		flushUsage()
 		gpu.Cleanup()
 		os.Exit(0)
 	}()
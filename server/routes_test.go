@@ -3,12 +3,14 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
@@ -16,6 +18,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/jmorganca/ollama/api"
+	"github.com/jmorganca/ollama/gguf"
 	"github.com/jmorganca/ollama/llm"
 	"github.com/jmorganca/ollama/parser"
 	"github.com/jmorganca/ollama/version"
@@ -52,7 +55,7 @@ func Test_Routes(t *testing.T) {
 		fn := func(resp api.ProgressResponse) {
 			t.Logf("Status: %s", resp.Status)
 		}
-		err = CreateModel(context.TODO(), name, "", commands, fn)
+		err = CreateModel(context.TODO(), name, "", "", commands, fn)
 		assert.Nil(t, err)
 	}
 
@@ -110,6 +113,57 @@ func Test_Routes(t *testing.T) {
 				assert.Equal(t, modelList.Models[0].Name, "test-model:latest")
 			},
 		},
+		{
+			Name:   "Tags Handler (filtered)",
+			Method: http.MethodGet,
+			Path:   "/api/tags?name=test-*&modified_after=1h",
+			Setup: func(t *testing.T, req *http.Request) {
+				createTestModel(t, "test-model")
+				createTestModel(t, "other-model")
+			},
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var modelList api.ListResponse
+				assert.Nil(t, json.NewDecoder(resp.Body).Decode(&modelList))
+				assert.Equal(t, 1, len(modelList.Models))
+				assert.Equal(t, "test-model:latest", modelList.Models[0].Name)
+			},
+		},
+		{
+			Name:   "Tags Handler (no match)",
+			Method: http.MethodGet,
+			Path:   "/api/tags?family=llama&modified_before=2000-01-01",
+			Expected: func(t *testing.T, resp *http.Response) {
+				var modelList api.ListResponse
+				assert.Nil(t, json.NewDecoder(resp.Body).Decode(&modelList))
+				assert.Empty(t, modelList.Models)
+			},
+		},
+		{
+			Name:   "Tags Handler (sorted descending)",
+			Method: http.MethodGet,
+			Path:   "/api/tags?sort=-name",
+			Setup: func(t *testing.T, req *http.Request) {
+				createTestModel(t, "test-model")
+				createTestModel(t, "other-model")
+			},
+			Expected: func(t *testing.T, resp *http.Response) {
+				var modelList api.ListResponse
+				assert.Nil(t, json.NewDecoder(resp.Body).Decode(&modelList))
+				assert.Equal(t, 2, len(modelList.Models))
+				assert.Equal(t, "test-model:latest", modelList.Models[0].Name)
+				assert.Equal(t, "other-model:latest", modelList.Models[1].Name)
+			},
+		},
+		{
+			Name:   "Tags Handler (invalid sort)",
+			Method: http.MethodGet,
+			Path:   "/api/tags?sort=family",
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			},
+		},
 		{
 			Name:   "Create Model Handler",
 			Method: http.MethodPost,
@@ -199,6 +253,40 @@ func Test_Routes(t *testing.T) {
 				assert.Equal(t, expectedParams, params)
 			},
 		},
+		{
+			Name:   "Estimate Handler",
+			Method: http.MethodPost,
+			Path:   "/api/estimate",
+			Setup: func(t *testing.T, req *http.Request) {
+				createTestModel(t, "estimate-model")
+				estimateReq := api.EstimateRequest{Model: "estimate-model", Options: map[string]interface{}{"num_ctx": 2048, "num_batch": 256}}
+				jsonData, err := json.Marshal(estimateReq)
+				assert.Nil(t, err)
+				req.Body = io.NopCloser(bytes.NewReader(jsonData))
+			},
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var estimateResp api.EstimateResponse
+				assert.Nil(t, json.NewDecoder(resp.Body).Decode(&estimateResp))
+				assert.Equal(t, "estimate-model:latest", estimateResp.Model)
+				assert.Equal(t, 256, estimateResp.NumBatch)
+				// the test model has no context length so it's limited to the minimum
+				assert.Equal(t, 4, estimateResp.NumCtx)
+				assert.Equal(t, 1, estimateResp.TotalLayers)
+			},
+		},
+		{
+			Name:   "Estimate Handler (missing model)",
+			Method: http.MethodPost,
+			Path:   "/api/estimate",
+			Setup: func(t *testing.T, req *http.Request) {
+				req.Body = io.NopCloser(strings.NewReader(`{"model":"missing-model"}`))
+			},
+			Expected: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			},
+		},
 	}
 
 	s := Server{}
@@ -233,6 +321,175 @@ func Test_Routes(t *testing.T) {
 	}
 }
 
+// writeGGUF encodes f to a file in a temporary directory and returns its path
+func writeGGUF(t *testing.T, f gguf.File) string {
+	var b bytes.Buffer
+	assert.Nil(t, f.Encode(&b))
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	assert.Nil(t, os.WriteFile(path, b.Bytes(), 0o644))
+	return path
+}
+
+func TestModelMetadata(t *testing.T) {
+	tokens := make([]string, maxShowArrayLen+1)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("<%d>", i)
+	}
+
+	path := writeGGUF(t, gguf.File{
+		KV: []gguf.KV{
+			{Key: "general.architecture", Value: "llama"},
+			{Key: "llama.context_length", Value: uint32(4096)},
+			{Key: "tokenizer.ggml.tokens", Value: tokens},
+		},
+		Tensors: []gguf.Tensor{
+			{Name: "token_embd.weight", Type: 2, Shape: []uint64{32, 8}, WriterTo: bytes.NewReader(make([]byte, 144))},
+		},
+	})
+
+	metadata, tensors, err := modelMetadata(path, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"general.architecture":  "llama",
+		"llama.context_length":  uint32(4096),
+		"tokenizer.ggml.tokens": fmt.Sprintf("[%d values]", maxShowArrayLen+1),
+	}, metadata)
+	assert.Equal(t, []api.TensorInfo{
+		{Name: "token_embd.weight", Type: "Q4_0", Shape: []uint64{32, 8}, Offset: 0, Size: 144},
+	}, tensors)
+
+	metadata, _, err = modelMetadata(path, true)
+	assert.Nil(t, err)
+	assert.Len(t, metadata["tokenizer.ggml.tokens"], maxShowArrayLen+1)
+}
+
+func TestCreateChatTemplate(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	path := writeGGUF(t, gguf.File{
+		KV: []gguf.KV{
+			{Key: "general.architecture", Value: "llama"},
+			{Key: "tokenizer.ggml.tokens", Value: []string{"<unk>", "<s>", "</s>"}},
+			{Key: "tokenizer.ggml.eos_token_id", Value: uint32(2)},
+			{Key: "tokenizer.chat_template", Value: `{% for message in messages %}{{ '<|' + message['role'] + '|>\n' + message['content'] + eos_token + '\n' }}{% endfor %}{% if add_generation_prompt %}{{ '<|assistant|>\n' }}{% endif %}`},
+		},
+	})
+
+	create := func(name, modelfile string) *Model {
+		commands, err := parser.Parse(strings.NewReader(modelfile))
+		assert.Nil(t, err)
+		assert.Nil(t, CreateModel(context.TODO(), name, "", "", commands, func(api.ProgressResponse) {}))
+
+		model, err := GetModel(name)
+		assert.Nil(t, err)
+		return model
+	}
+
+	model := create("chat", fmt.Sprintf("FROM %s", path))
+	assert.Equal(t, "{{ if .System }}<|system|>\n{{ .System }}</s>\n{{ end }}<|user|>\n{{ .Prompt }}</s>\n<|assistant|>\n{{ .Response }}</s>\n", model.Template)
+
+	// the Modelfile's template is used instead
+	model = create("templated", fmt.Sprintf("TEMPLATE {{ .Prompt }}\nFROM %s", path))
+	assert.Equal(t, "{{ .Prompt }}", model.Template)
+}
+
+func TestCreateMergedAdapter(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	f32s := func(n int) *bytes.Reader {
+		var b bytes.Buffer
+		for i := range n {
+			binary.Write(&b, binary.LittleEndian, float32(i%5-2))
+		}
+
+		return bytes.NewReader(b.Bytes())
+	}
+
+	base := gguf.File{
+		KV: []gguf.KV{
+			{Key: "general.architecture", Value: "llama"},
+			{Key: "general.file_type", Value: uint32(0)},
+		},
+		Tensors: []gguf.Tensor{
+			{Name: "blk.0.attn_q.weight", Type: 0, Shape: []uint64{32, 4}, WriterTo: f32s(128)},
+		},
+	}
+
+	var b bytes.Buffer
+	assert.Nil(t, base.Encode(&b))
+
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "model.gguf"), b.Bytes(), 0o644))
+
+	b.Reset()
+	assert.Nil(t, llm.EncodeGGLA(&b, 2, 2, []llm.Tensor{
+		{Name: "blk.0.attn_q.weight.loraA", Shape: []uint64{32, 2, 0, 0}, WriterTo: f32s(64)},
+		{Name: "blk.0.attn_q.weight.loraB", Shape: []uint64{4, 2, 0, 0}, WriterTo: f32s(8)},
+	}))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "adapter.bin"), b.Bytes(), 0o644))
+
+	create := func(name, quantization, modelfile string) (*Model, error) {
+		commands, err := parser.Parse(strings.NewReader(modelfile))
+		assert.Nil(t, err)
+		if err := CreateModel(context.TODO(), name, dir, quantization, commands, func(api.ProgressResponse) {}); err != nil {
+			return nil, err
+		}
+
+		return GetModel(name)
+	}
+
+	adapted, err := create("adapted", "", "FROM ./model.gguf\nADAPTER ./adapter.bin")
+	assert.Nil(t, err)
+	assert.Len(t, adapted.AdapterPaths, 1)
+
+	model, err := create("merged", "q8_0", "FROM ./model.gguf\nADAPTER ./adapter.bin MERGE")
+	assert.Nil(t, err)
+	assert.Empty(t, model.AdapterPaths)
+	assert.Equal(t, "Q8_0", model.Config.FileType)
+
+	// the lineage of the merged model is recorded
+	assert.Len(t, model.Config.MergedAdapters, 1)
+	assert.Equal(t, filepath.Base(adapted.ModelPath), strings.Replace(model.Config.MergedAdapters[0].Base, ":", "-", 1))
+	assert.Equal(t, filepath.Base(adapted.AdapterPaths[0]), strings.Replace(model.Config.MergedAdapters[0].Adapter, ":", "-", 1))
+
+	manifest, _, err := GetManifest(ParseModelPath("merged"))
+	assert.Nil(t, err)
+	assert.Len(t, manifest.Layers, 1)
+	assert.Equal(t, "application/vnd.ollama.image.model", manifest.Layers[0].MediaType)
+
+	f, err := os.Open(model.ModelPath)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	fi, err := f.Stat()
+	assert.Nil(t, err)
+
+	merged, err := gguf.Decode(f, fi.Size())
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(8), merged.Tensors[0].Type)
+
+	// the adapter and the unmerged model weren't kept
+	blobs, err := GetBlobsPath("")
+	assert.Nil(t, err)
+
+	partials, err := filepath.Glob(filepath.Join(blobs, "*-partial"))
+	assert.Nil(t, err)
+	assert.Empty(t, partials)
+
+	// several adapters are merged at full precision and quantized once
+	model, err = create("merged-twice", "q8_0", "FROM ./model.gguf\nADAPTER ./adapter.bin MERGE\nADAPTER ./adapter.bin MERGE")
+	assert.Nil(t, err)
+	assert.Equal(t, "Q8_0", model.Config.FileType)
+	assert.Len(t, model.Config.MergedAdapters, 2)
+	assert.Equal(t, filepath.Base(adapted.ModelPath), strings.Replace(model.Config.MergedAdapters[0].Base, ":", "-", 1))
+	assert.NotEqual(t, model.Config.MergedAdapters[0].Base, model.Config.MergedAdapters[1].Base)
+
+	// only the merged model can be quantized
+	_, err = create("quantized", "q8_0", "FROM ./model.gguf")
+	assert.ErrorContains(t, err, "can be quantized")
+}
+
 type MockLLM struct {
 	encoding []int
 }
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/jmorganca/ollama/auth"
+)
+
+// Manifests are signed with the same key auth.Sign uses to authenticate with
+// the registry. The signature is stored in a manifest annotation and covers the
+// namespace, repository and tag and the rest of the manifest so it can't be
+// moved to another model. It doesn't cover the registry so models can be
+// pulled from mirrors and peers. Which keys may publish to a namespace is set
+// by the trust policy.
+
+const signatureAnnotation = "ai.ollama.signature"
+
+var (
+	errSignatureInvalid  = errors.New("manifest signature is invalid")
+	errSignatureRequired = errors.New("manifest is not signed")
+	errUntrustedSigner   = errors.New("manifest is not signed by a trusted key")
+)
+
+// signingPayload returns the bytes signed for manifest, which is its name
+// without the registry and everything in the manifest except the signature
+// itself
+func signingPayload(mp ModelPath, manifest *ManifestV2) ([]byte, error) {
+	unsigned := *manifest
+	unsigned.Annotations = maps.Clone(manifest.Annotations)
+	delete(unsigned.Annotations, signatureAnnotation)
+	if len(unsigned.Annotations) == 0 {
+		unsigned.Annotations = nil
+	}
+
+	manifestJSON, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(mp.GetNamespaceRepository() + ":" + mp.Tag)
+	b.WriteByte('\n')
+	b.Write(manifestJSON)
+	return b.Bytes(), nil
+}
+
+func signManifest(ctx context.Context, mp ModelPath, manifest *ManifestV2) error {
+	payload, err := signingPayload(mp, manifest)
+	if err != nil {
+		return err
+	}
+
+	signature, err := auth.Sign(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	if manifest.Annotations == nil {
+		manifest.Annotations = make(map[string]string)
+	}
+
+	manifest.Annotations[signatureAnnotation] = signature
+	return nil
+}
+
+// manifestSigner verifies the signature of manifest and returns the signing
+// key in authorized_keys format
+func manifestSigner(mp ModelPath, manifest *ManifestV2) (string, error) {
+	signature, ok := manifest.Annotations[signatureAnnotation]
+	if !ok {
+		return "", errSignatureRequired
+	}
+
+	payload, err := signingPayload(mp, manifest)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := auth.Verify(payload, signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errSignatureInvalid, err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))), nil
+}
+
+// trustPolicyPath returns the path of the trust policy, a JSON object mapping
+// "registry/namespace" or "registry/namespace/repository" to the public keys
+// allowed to sign its models, e.g.
+//
+//	{"registry.ollama.ai/library": ["ssh-ed25519 AAAA..."]}
+func trustPolicyPath() (string, error) {
+	if fp := os.Getenv("OLLAMA_TRUST_POLICY"); fp != "" {
+		return fp, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ollama", "trust.json"), nil
+}
+
+// trustedKeys returns the keys trusted to sign mp. A missing policy or one
+// without an entry for mp returns no keys.
+func trustedKeys(mp ModelPath) ([]ssh.PublicKey, error) {
+	fp, err := trustPolicyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := os.ReadFile(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var policy map[string][]string
+	if err := json.Unmarshal(bts, &policy); err != nil {
+		return nil, fmt.Errorf("%s: %w", fp, err)
+	}
+
+	// a repository entry takes precedence over its namespace
+	entry, ok := policy[strings.Join([]string{mp.Registry, mp.Namespace, mp.Repository}, "/")]
+	if !ok {
+		entry = policy[strings.Join([]string{mp.Registry, mp.Namespace}, "/")]
+	}
+
+	var keys []ssh.PublicKey
+	for _, s := range entry {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fp, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// verifyManifestSignature checks manifest against the trust policy before it is
+// pulled. Models listed in the policy must be signed by one of their keys and
+// requireSigned rejects any model without a trusted signature. Otherwise
+// unsigned models are allowed but a signature which is present must be valid.
+func verifyManifestSignature(mp ModelPath, manifest *ManifestV2, requireSigned bool) (string, error) {
+	keys, err := trustedKeys(mp)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := manifestSigner(mp, manifest)
+	switch {
+	case errors.Is(err, errSignatureRequired) && len(keys) == 0 && !requireSigned:
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("%s: %w", mp.GetShortTagname(), err)
+	}
+
+	if len(keys) == 0 && requireSigned {
+		return "", fmt.Errorf("%s: %w: no keys are trusted for %s/%s", mp.GetShortTagname(), errUntrustedSigner, mp.Registry, mp.Namespace)
+	}
+
+	if len(keys) == 0 {
+		return signer, nil
+	}
+
+	for _, key := range keys {
+		if strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) == signer {
+			return signer, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s: %w: signed by %s", mp.GetShortTagname(), errUntrustedSigner, signer)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigningKey(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(home, ".ollama"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(home, ".ollama", "id_ed25519"), pem.EncodeToMemory(block), 0o600))
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+}
+
+func writeTrustPolicy(t *testing.T, policy map[string][]string) {
+	fp := filepath.Join(t.TempDir(), "trust.json")
+	bts, err := json.Marshal(policy)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(fp, bts, 0o644))
+	t.Setenv("OLLAMA_TRUST_POLICY", fp)
+}
+
+func TestManifestSignature(t *testing.T) {
+	signer := newSigningKey(t)
+	t.Setenv("OLLAMA_TRUST_POLICY", filepath.Join(t.TempDir(), "trust.json"))
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	assert.Nil(t, err)
+	otherKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+
+	mp := ParseModelPath("jdoe/llama2")
+	newManifest := func() *ManifestV2 {
+		return &ManifestV2{
+			SchemaVersion: 2,
+			MediaType:     mediaTypeDockerManifest,
+			Config:        &Layer{Digest: "sha256:" + strings.Repeat("a", 64)},
+			Layers:        []*Layer{{Digest: "sha256:" + strings.Repeat("b", 64), Size: 6}},
+		}
+	}
+
+	signed := newManifest()
+	assert.Nil(t, signManifest(context.TODO(), mp, signed))
+	s, err := manifestSigner(mp, signed)
+	assert.Nil(t, err)
+	assert.Equal(t, signer, s)
+
+	t.Run("no policy", func(t *testing.T) {
+		s, err := verifyManifestSignature(mp, signed, false)
+		assert.Nil(t, err)
+		assert.Equal(t, signer, s)
+
+		_, err = verifyManifestSignature(mp, newManifest(), false)
+		assert.Nil(t, err)
+
+		_, err = verifyManifestSignature(mp, newManifest(), true)
+		assert.ErrorIs(t, err, errSignatureRequired)
+
+		_, err = verifyManifestSignature(mp, signed, true)
+		assert.ErrorIs(t, err, errUntrustedSigner)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := newManifest()
+		tampered.Annotations = signed.Annotations
+		tampered.Layers[0].Size = 7
+
+		_, err := verifyManifestSignature(mp, tampered, false)
+		assert.ErrorIs(t, err, errSignatureInvalid)
+
+		// the signature is bound to the namespace, repository and tag
+		for _, name := range []string{"jdoe/mistral", "jdoe/llama2:7b", "other/llama2"} {
+			_, err = verifyManifestSignature(ParseModelPath(name), signed, false)
+			assert.ErrorIs(t, err, errSignatureInvalid, name)
+		}
+
+		// but not the registry, so it can be pulled from a peer
+		s, err := verifyManifestSignature(ParseModelPath("myhost:11434/jdoe/llama2"), signed, false)
+		assert.Nil(t, err)
+		assert.Equal(t, signer, s)
+	})
+
+	t.Run("trusted", func(t *testing.T) {
+		writeTrustPolicy(t, map[string][]string{"registry.ollama.ai/jdoe": {otherKey, signer}})
+
+		_, err := verifyManifestSignature(mp, signed, true)
+		assert.Nil(t, err)
+
+		_, err = verifyManifestSignature(mp, newManifest(), false)
+		assert.ErrorIs(t, err, errSignatureRequired)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		writeTrustPolicy(t, map[string][]string{
+			"registry.ollama.ai/jdoe":        {signer},
+			"registry.ollama.ai/jdoe/llama2": {otherKey},
+		})
+
+		_, err := verifyManifestSignature(mp, signed, false)
+		assert.ErrorIs(t, err, errUntrustedSigner)
+	})
+}
@@ -0,0 +1,559 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmorganca/ollama/api"
+	"github.com/jmorganca/ollama/format"
+	"github.com/jmorganca/ollama/parser"
+)
+
+// The models directory can be limited in size with OLLAMA_MAX_STORE_SIZE.
+// Before a model is pulled or created the space it needs is checked and the
+// least recently used models which aren't pinned are removed to make room.
+
+var errStoreFull = errors.New("model store is full")
+
+// modelUsage is what is recorded about each model to decide which to evict
+type modelUsage struct {
+	LastUsed time.Time `json:"last_used,omitempty"`
+	Pinned   bool      `json:"pinned,omitempty"`
+}
+
+// usageMu guards the usage file which is shared by every request
+var usageMu sync.Mutex
+
+// recentUse holds when models were last used since the usage file was last
+// written, keyed by full name. Models are used on every request so their use
+// is only written to the usage file by flushUsage.
+var recentUse = struct {
+	sync.Mutex
+	times map[string]time.Time
+}{times: make(map[string]time.Time)}
+
+// usageFlushInterval is how often recent use is written to the usage file
+const usageFlushInterval = time.Minute
+
+func usagePath() (string, error) {
+	dir, err := modelsDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "usage.json"), nil
+}
+
+// readUsage returns the usage of models keyed by their full name. It must be
+// called with usageMu held.
+func readUsage() (map[string]modelUsage, error) {
+	fp, err := usagePath()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make(map[string]modelUsage)
+	bts, err := os.ReadFile(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &usage); err != nil {
+		slog.Info(fmt.Sprintf("ignoring invalid model usage %s: %v", fp, err))
+		return make(map[string]modelUsage), nil
+	}
+
+	return usage, nil
+}
+
+// updateUsage applies fn to the usage of the model called name and saves it
+func updateUsage(name string, fn func(*modelUsage)) error {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	usage, err := readUsage()
+	if err != nil {
+		return err
+	}
+
+	key := ParseModelPath(name).GetFullTagname()
+	u := usage[key]
+	fn(&u)
+
+	if u == (modelUsage{}) {
+		delete(usage, key)
+	} else {
+		usage[key] = u
+	}
+
+	return writeUsage(usage)
+}
+
+// writeUsage saves usage to the usage file. It must be called with usageMu
+// held.
+func writeUsage(usage map[string]modelUsage) error {
+	fp, err := usagePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+		return err
+	}
+
+	bts, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fp, bts, 0o644)
+}
+
+// markUsed records that the model called name was used at t. It's kept in
+// memory until the next flushUsage.
+func markUsed(name string, t time.Time) {
+	recentUse.Lock()
+	defer recentUse.Unlock()
+
+	recentUse.times[ParseModelPath(name).GetFullTagname()] = t
+}
+
+// applyRecentUse updates usage with the use recorded since the last flush. It
+// must be called with usageMu held.
+func applyRecentUse(usage map[string]modelUsage) {
+	recentUse.Lock()
+	defer recentUse.Unlock()
+
+	applyUse(usage, recentUse.times)
+}
+
+func applyUse(usage map[string]modelUsage, times map[string]time.Time) {
+	for key, t := range times {
+		if u := usage[key]; t.After(u.LastUsed) {
+			u.LastUsed = t
+			usage[key] = u
+		}
+	}
+}
+
+// flushUsage writes the use recorded since the last flush to the usage file
+func flushUsage() {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	recentUse.Lock()
+	times := recentUse.times
+	recentUse.times = make(map[string]time.Time)
+	recentUse.Unlock()
+
+	if len(times) == 0 {
+		return
+	}
+
+	usage, err := readUsage()
+	if err == nil {
+		applyUse(usage, times)
+		err = writeUsage(usage)
+	}
+
+	if err != nil {
+		slog.Info(fmt.Sprintf("couldn't record model use: %v", err))
+
+		// keep the use to try again next time
+		for key, t := range times {
+			markUsed(key, t)
+		}
+	}
+}
+
+// flushUsagePeriodically calls flushUsage every usageFlushInterval
+func flushUsagePeriodically() {
+	for range time.Tick(usageFlushInterval) {
+		flushUsage()
+	}
+}
+
+// PinModel protects the model called name from being evicted, or allows it to
+// be evicted again if pinned is false
+func PinModel(name string, pinned bool) error {
+	if _, _, err := GetManifest(ParseModelPath(name)); err != nil {
+		return err
+	}
+
+	return updateUsage(name, func(u *modelUsage) { u.Pinned = pinned })
+}
+
+func isPinned(name string) bool {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	usage, err := readUsage()
+	if err != nil {
+		return false
+	}
+
+	return usage[ParseModelPath(name).GetFullTagname()].Pinned
+}
+
+// forgetModel removes what was recorded about a deleted model
+func forgetModel(name string) {
+	recentUse.Lock()
+	delete(recentUse.times, ParseModelPath(name).GetFullTagname())
+	recentUse.Unlock()
+
+	if err := updateUsage(name, func(u *modelUsage) { *u = modelUsage{} }); err != nil {
+		slog.Info(fmt.Sprintf("couldn't forget usage of %s: %v", name, err))
+	}
+}
+
+// activeBlobs counts the pulls and creates using each blob which haven't
+// written their manifest yet. No manifest references their blobs so they're
+// kept from being removed by eviction or pruning until they do.
+var activeBlobs = struct {
+	sync.Mutex
+	refs map[string]int
+}{refs: make(map[string]int)}
+
+// holdBlobs keeps the blobs of manifest from being removed until release is
+// called
+func holdBlobs(manifest *ManifestV2) (release func()) {
+	digests := []string{manifest.Config.Digest}
+	for _, layer := range manifest.Layers {
+		digests = append(digests, layer.Digest)
+	}
+
+	activeBlobs.Lock()
+	defer activeBlobs.Unlock()
+
+	for _, digest := range digests {
+		activeBlobs.refs[digest]++
+	}
+
+	return func() {
+		activeBlobs.Lock()
+		defer activeBlobs.Unlock()
+
+		for _, digest := range digests {
+			if activeBlobs.refs[digest]--; activeBlobs.refs[digest] <= 0 {
+				delete(activeBlobs.refs, digest)
+			}
+		}
+	}
+}
+
+// isBlobActive reports whether a pull or create is using the blob digest
+func isBlobActive(digest string) bool {
+	activeBlobs.Lock()
+	defer activeBlobs.Unlock()
+
+	return activeBlobs.refs[digest] > 0
+}
+
+// maxStoreSize returns the limit set with OLLAMA_MAX_STORE_SIZE, 0 if there isn't one
+func maxStoreSize() (int64, error) {
+	s := os.Getenv("OLLAMA_MAX_STORE_SIZE")
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := format.ParseBytes(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid OLLAMA_MAX_STORE_SIZE: %w", err)
+	}
+
+	return n, nil
+}
+
+// storeSize returns the size of every complete blob in the store
+func storeSize() (int64, error) {
+	dir, err := GetBlobsPath("")
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || strings.Contains(entry.Name(), "-partial") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// ensureStoreSpace makes room in the store for the blobs of manifest which
+// don't exist yet. Models are evicted least recently used first, skipping
+// pinned models and the model being written, called name. Nothing is evicted
+// if the manifest can't fit either way.
+func ensureStoreSpace(name string, manifest *ManifestV2, fn func(api.ProgressResponse)) error {
+	limit, err := maxStoreSize()
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	var need int64
+	for _, layer := range append([]*Layer{manifest.Config}, manifest.Layers...) {
+		keep[layer.Digest] = true
+
+		fp, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(fp); errors.Is(err, os.ErrNotExist) {
+			need += layer.Size
+		} else if err != nil {
+			return err
+		}
+	}
+
+	mp := ParseModelPath(name)
+	return freeStoreSpace(mp.GetShortTagname(), mp.GetFullTagname(), limit, manifest.GetTotalSize(), need, keep, fn)
+}
+
+// ensureCreateSpace makes room in the store for the model called name before
+// any of its layers are written. The sizes of the layers aren't known yet so
+// the files the model and its adapters are read from are counted instead.
+// Blobs and models which already exist don't need more space, models which
+// are pulled make room for themselves.
+func ensureCreateSpace(name, modelFileDir string, commands []parser.Command, fn func(api.ProgressResponse)) error {
+	limit, err := maxStoreSize()
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	var need int64
+	for _, c := range commands {
+		switch c.Name {
+		case "model", "adapter":
+			arg, _ := parser.AdapterArgs(c.Args)
+			if strings.HasPrefix(arg, "@") {
+				continue
+			}
+
+			if size, err := pathSize(realpath(modelFileDir, arg)); err == nil {
+				need += size
+			}
+		default:
+			need += int64(len(c.Args))
+		}
+	}
+
+	mp := ParseModelPath(name)
+	return freeStoreSpace(mp.GetShortTagname(), mp.GetFullTagname(), limit, need, need, make(map[string]bool), fn)
+}
+
+// ensureBlobSpace makes room in the store for a blob of size bytes before
+// it's written
+func ensureBlobSpace(digest string, size int64) error {
+	limit, err := maxStoreSize()
+	if err != nil || limit == 0 {
+		return err
+	}
+
+	return freeStoreSpace(digest, "", limit, size, size, map[string]bool{digest: true}, func(api.ProgressResponse) {})
+}
+
+// pathSize returns the size of the file at path, or of every file under it if
+// it's a directory
+func pathSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+
+	return size, err
+}
+
+// freeStoreSpace evicts models until need more bytes fit in the store, which
+// is limited to limit bytes. what is written needs total bytes once it's
+// complete. Blobs in keep and the model called skip aren't evicted.
+func freeStoreSpace(what, skip string, limit, total, need int64, keep map[string]bool, fn func(api.ProgressResponse)) error {
+	if total > limit {
+		return fmt.Errorf("%w: %s needs %s but OLLAMA_MAX_STORE_SIZE is %s", errStoreFull, what, format.HumanBytes(total), format.HumanBytes(limit))
+	}
+
+	used, err := storeSize()
+	if err != nil {
+		return err
+	}
+
+	if used+need <= limit {
+		return nil
+	}
+
+	candidates, err := evictionCandidates(skip, keep)
+	if err != nil {
+		return err
+	}
+
+	var reclaimable int64
+	for _, c := range candidates {
+		reclaimable += c.size
+	}
+
+	if used-reclaimable+need > limit {
+		return fmt.Errorf("%w: %s needs %s more but only %s of %s can be freed, remove or unpin models or raise OLLAMA_MAX_STORE_SIZE", errStoreFull, what, format.HumanBytes(need), format.HumanBytes(limit-used+reclaimable), format.HumanBytes(limit))
+	}
+
+	for _, c := range candidates {
+		if used+need <= limit {
+			break
+		}
+
+		short := ParseModelPath(c.name).GetShortTagname()
+		fn(api.ProgressResponse{Status: fmt.Sprintf("removing %s to free space", short)})
+		slog.Info(fmt.Sprintf("evicting %s, last used %s", short, c.lastUsed.Format(time.RFC3339)))
+		if err := DeleteModel(c.name); err != nil {
+			return err
+		}
+
+		// aliases of the evicted model would resolve to nothing
+		aliases, err := dropAliases(c.name)
+		if err != nil {
+			slog.Info(fmt.Sprintf("couldn't remove aliases of %s: %v", short, err))
+		}
+
+		for _, alias := range aliases {
+			slog.Info(fmt.Sprintf("removed alias %s of evicted %s", ParseModelPath(alias).GetShortTagname(), short))
+		}
+
+		if used, err = storeSize(); err != nil {
+			return err
+		}
+	}
+
+	manifestsPath, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	return PruneDirectory(manifestsPath)
+}
+
+type evictionCandidate struct {
+	name     string
+	lastUsed time.Time
+
+	// size is what removing the model frees once every other candidate has
+	// been removed too
+	size int64
+}
+
+// evictionCandidates returns the models which may be evicted, least recently
+// used first. Models which were never used count from when they were written.
+func evictionCandidates(skip string, keep map[string]bool) ([]evictionCandidate, error) {
+	names, err := localModels()
+	if err != nil {
+		return nil, err
+	}
+
+	usageMu.Lock()
+	usage, err := readUsage()
+	if err == nil {
+		applyRecentUse(usage)
+	}
+	usageMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []evictionCandidate
+	digests := make(map[string][]string)
+	for _, name := range names {
+		fp, root, err := ParseModelPath(name).findManifestPath()
+		if err != nil {
+			return nil, err
+		}
+
+		manifest, _, err := readManifest(fp)
+		if err != nil {
+			continue
+		}
+
+		for _, layer := range append([]*Layer{manifest.Config}, manifest.Layers...) {
+			digests[name] = append(digests[name], layer.Digest)
+		}
+
+		if name == skip || usage[name].Pinned || root.readOnly {
+			// blobs of models which stay can't be freed
+			for _, digest := range digests[name] {
+				keep[digest] = true
+			}
+
+			continue
+		}
+
+		lastUsed := usage[name].LastUsed
+		if lastUsed.IsZero() {
+			if info, err := os.Stat(fp); err == nil {
+				lastUsed = info.ModTime()
+			}
+		}
+
+		candidates = append(candidates, evictionCandidate{name: name, lastUsed: lastUsed})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+
+	// each blob is counted once, against the first candidate to use it
+	counted := make(map[string]bool)
+	for i, c := range candidates {
+		for _, digest := range digests[c.name] {
+			if keep[digest] || counted[digest] || isBlobActive(digest) {
+				continue
+			}
+
+			counted[digest] = true
+
+			fp, err := GetBlobsPath(digest)
+			if err != nil {
+				return nil, err
+			}
+
+			if info, err := os.Stat(fp); err == nil {
+				candidates[i].size += info.Size()
+			}
+		}
+	}
+
+	return candidates, nil
+}
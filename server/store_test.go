@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+	"github.com/jmorganca/ollama/parser"
+)
+
+// createModelfile creates a model from an empty gguf file and the rest of a
+// Modelfile after its FROM line
+func createModelfile(t *testing.T, name, modelfile string) {
+	f, err := os.CreateTemp(t.TempDir(), "ollama-model")
+	assert.Nil(t, err)
+	_, err = f.Write([]byte("GGUF\x02\x00"))
+	assert.Nil(t, err)
+	f.Close()
+
+	commands, err := parser.Parse(strings.NewReader(fmt.Sprintf("FROM %s\n%s", f.Name(), modelfile)))
+	assert.Nil(t, err)
+	assert.Nil(t, CreateModel(context.TODO(), name, "", "", commands, func(api.ProgressResponse) {}))
+}
+
+func TestEnsureStoreSpace(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	for _, name := range []string{"alpha", "bravo", "charlie"} {
+		createModelfile(t, name, "SYSTEM "+strings.Repeat(name[:1], 1000))
+	}
+
+	now := time.Now()
+	markUsed("alpha", now.Add(-3*time.Hour))
+	markUsed("bravo", now.Add(-2*time.Hour))
+	markUsed("charlie", now.Add(-time.Hour))
+	assert.Nil(t, PinModel("bravo", true))
+
+	used, err := storeSize()
+	assert.Nil(t, err)
+
+	incoming := &ManifestV2{
+		Config: &Layer{Digest: "sha256:" + fakeDigest('e'), Size: 10},
+		Layers: []*Layer{{Digest: "sha256:" + fakeDigest('f'), Size: 900}},
+	}
+
+	t.Run("fits", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_STORE_SIZE", fmt.Sprint(used+1000))
+		assert.Nil(t, ensureStoreSpace("delta", incoming, func(api.ProgressResponse) {}))
+		assert.Len(t, mustLocalModels(t), 3)
+	})
+
+	t.Run("too big", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_STORE_SIZE", "500")
+		assert.ErrorIs(t, ensureStoreSpace("delta", incoming, func(api.ProgressResponse) {}), errStoreFull)
+		assert.Len(t, mustLocalModels(t), 3)
+	})
+
+	t.Run("pinned", func(t *testing.T) {
+		// evicting both unpinned models still isn't enough
+		t.Setenv("OLLAMA_MAX_STORE_SIZE", fmt.Sprint(used-1000))
+		big := &ManifestV2{Config: incoming.Config, Layers: []*Layer{{Digest: incoming.Layers[0].Digest, Size: 2500}}}
+		assert.ErrorIs(t, ensureStoreSpace("delta", big, func(api.ProgressResponse) {}), errStoreFull)
+		assert.Len(t, mustLocalModels(t), 3)
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_STORE_SIZE", fmt.Sprint(used+500))
+		assert.Nil(t, CreateAlias("prod", "alpha"))
+		assert.Nil(t, CreateAlias("latest-prod", "prod"))
+		assert.Nil(t, CreateAlias("stable", "bravo"))
+
+		var statuses []string
+		assert.Nil(t, ensureStoreSpace("delta", incoming, func(resp api.ProgressResponse) {
+			statuses = append(statuses, resp.Status)
+		}))
+
+		// alpha was used least recently, bravo is pinned
+		assert.Equal(t, []string{"removing alpha:latest to free space"}, statuses)
+		assert.Equal(t, []string{
+			"registry.ollama.ai/library/bravo:latest",
+			"registry.ollama.ai/library/charlie:latest",
+		}, mustLocalModels(t))
+
+		_, err := GetModel("bravo")
+		assert.Nil(t, err)
+		assert.False(t, isPinned("alpha"))
+		assert.True(t, isPinned("bravo"))
+
+		// aliases of the evicted model are removed with it
+		aliases, err := ListAliases()
+		assert.Nil(t, err)
+		assert.Equal(t, []api.Alias{{Alias: "stable:latest", Target: "bravo:latest"}}, aliases)
+	})
+}
+
+func TestEnsureCreateSpace(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_MAX_STORE_SIZE", "100")
+
+	blobs, err := GetBlobsPath("")
+	assert.Nil(t, err)
+
+	// the model file is checked before any of its layers are written
+	f, err := os.CreateTemp(t.TempDir(), "ollama-model")
+	assert.Nil(t, err)
+	_, err = f.Write(append([]byte("GGUF\x02\x00"), make([]byte, 200)...))
+	assert.Nil(t, err)
+	f.Close()
+
+	commands, err := parser.Parse(strings.NewReader(fmt.Sprintf("FROM %s", f.Name())))
+	assert.Nil(t, err)
+	assert.ErrorIs(t, CreateModel(context.TODO(), "large", "", "", commands, func(api.ProgressResponse) {}), errStoreFull)
+
+	entries, err := os.ReadDir(blobs)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	// so are uploaded blobs
+	var s Server
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	body := bytes.Repeat([]byte("a"), 200)
+	resp, err := http.Post(fmt.Sprintf("%s/api/blobs/sha256:%x", srv.URL, sha256.Sum256(body)), "application/octet-stream", bytes.NewReader(body))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+
+	entries, err = os.ReadDir(blobs)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func mustLocalModels(t *testing.T) []string {
+	names, err := localModels()
+	assert.Nil(t, err)
+	return names
+}
+
+func TestFlushUsage(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	used := time.Now().Add(-time.Hour).Truncate(time.Second)
+	markUsed("echo", used)
+
+	// use is only written to the usage file when it's flushed
+	usage, err := readUsage()
+	assert.Nil(t, err)
+	assert.NotContains(t, usage, "registry.ollama.ai/library/echo:latest")
+
+	flushUsage()
+
+	usage, err = readUsage()
+	assert.Nil(t, err)
+	assert.True(t, used.Equal(usage["registry.ollama.ai/library/echo:latest"].LastUsed))
+}
+
+func TestActiveBlobs(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	createModelfile(t, "alpha", "SYSTEM aaaaaaaaaa")
+
+	manifest, _, err := GetManifest(ParseModelPath("alpha"))
+	assert.Nil(t, err)
+
+	fp, err := GetBlobsPath(manifest.Layers[0].Digest)
+	assert.Nil(t, err)
+
+	// a pull or create using the blobs keeps them when the model is removed
+	release := holdBlobs(manifest)
+	assert.Nil(t, DeleteModel("alpha"))
+	assert.FileExists(t, fp)
+
+	release()
+	assert.Nil(t, PruneLayers())
+	assert.NoFileExists(t, fp)
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+// maxUpdateChecks limits how many registries are asked at once
+const maxUpdateChecks = 8
+
+// The digest of the manifest each model was pulled with is kept in pulled.json
+// in its models root. Models which are created or copied are recorded without
+// a digest, so they aren't mistaken for a model of the same name on the
+// registry. Models without a record, such as ones pulled before records were
+// kept, are checked.
+
+// pulledMu guards the writable root's pulled file
+var pulledMu sync.Mutex
+
+func readPulledFile(root string) (map[string]string, error) {
+	pulled := make(map[string]string)
+	bts, err := os.ReadFile(filepath.Join(root, "pulled.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return pulled, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &pulled); err != nil {
+		return nil, fmt.Errorf("invalid pulled models in %s: %w", root, err)
+	}
+
+	return pulled, nil
+}
+
+// recordPull records that mp was pulled with the manifest digest
+func recordPull(mp ModelPath, digest string) error {
+	return recordManifest(mp, digest)
+}
+
+// recordLocal records that mp was created or copied locally
+func recordLocal(mp ModelPath) error {
+	return recordManifest(mp, "")
+}
+
+func recordManifest(mp ModelPath, digest string) error {
+	pulledMu.Lock()
+	defer pulledMu.Unlock()
+
+	dir, err := modelsDir()
+	if err != nil {
+		return err
+	}
+
+	pulled, err := readPulledFile(dir)
+	if err != nil {
+		return err
+	}
+
+	pulled[mp.GetFullTagname()] = digest
+
+	bts, err := json.Marshal(pulled)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, "pulled.json"), bts, 0o644)
+}
+
+// isLocal reports whether mp, whose manifest has the digest, was created or
+// copied locally rather than pulled
+func isLocal(mp ModelPath, digest string) (bool, error) {
+	_, root, err := mp.findManifestPath()
+	if err != nil {
+		return false, err
+	}
+
+	pulledMu.Lock()
+	defer pulledMu.Unlock()
+
+	pulled, err := readPulledFile(root.path)
+	if err != nil {
+		return false, err
+	}
+
+	record, ok := pulled[mp.GetFullTagname()]
+	return ok && record != digest, nil
+}
+
+// CheckUpdates compares the manifests of the local models called names, or of
+// every local model if there are none, with their registries and returns the
+// ones which are outdated. Models which were created or copied locally are
+// skipped.
+func CheckUpdates(ctx context.Context, names []string, regOpts *registryOptions) ([]api.ModelUpdate, error) {
+	if len(names) == 0 {
+		var err error
+		if names, err = localModels(); err != nil {
+			return nil, err
+		}
+	}
+
+	var mu sync.Mutex
+	var updates []api.ModelUpdate
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxUpdateChecks)
+	for _, name := range names {
+		g.Go(func() error {
+			// requests store the token they authenticate with in their options
+			opts := *regOpts
+			update, err := checkUpdate(ctx, ParseModelPath(name), &opts)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				return nil
+			case errors.Is(err, context.Canceled):
+				return err
+			case err != nil:
+				slog.Info(fmt.Sprintf("couldn't check %s for updates: %v", name, err))
+				update = &api.ModelUpdate{Model: ParseModelPath(name).GetShortTagname(), Error: err.Error()}
+			case update == nil:
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			updates = append(updates, *update)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].Model < updates[j].Model
+	})
+
+	return updates, nil
+}
+
+// checkUpdate returns how mp differs from its registry, or nil if it doesn't
+func checkUpdate(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*api.ModelUpdate, error) {
+	local, digest, err := GetManifest(mp)
+	if err != nil {
+		return nil, err
+	}
+
+	if created, err := isLocal(mp, "sha256:"+digest); err != nil || created {
+		return nil, err
+	}
+
+	// most registries return the digest of a manifest without sending it
+	remoteDigest, err := headModelManifest(ctx, mp, regOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if remoteDigest == "sha256:"+digest {
+		return nil, nil
+	}
+
+	// the digest may be of an index or be missing so compare the manifests
+	remote, err := pullModelManifest(ctx, mp, regOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if sameLayers(local, remote) {
+		return nil, nil
+	}
+
+	update := api.ModelUpdate{
+		Model:        mp.GetShortTagname(),
+		Digest:       "sha256:" + digest,
+		RemoteDigest: remoteDigest,
+		Size:         local.GetTotalSize(),
+		RemoteSize:   remote.GetTotalSize(),
+	}
+
+	for _, layer := range append([]*Layer{remote.Config}, remote.Layers...) {
+		fp, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := os.Stat(fp); err != nil {
+			update.Download += layer.Size
+		}
+	}
+
+	return &update, nil
+}
+
+// headModelManifest returns the digest of the manifest for mp from the first
+// of its mirrors that has it, falling back to the registry itself
+func headModelManifest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (string, error) {
+	for _, mirror := range registryMirrors(mp.Registry) {
+		digest, err := headManifest(ctx, mirrorURL(mirror, mp, "manifests", mp.Tag), &registryOptions{})
+		if err == nil {
+			return digest, nil
+		}
+
+		slog.Info(fmt.Sprintf("couldn't check manifest on mirror %s: %v", mirror.Host, err))
+	}
+
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+	return headManifest(ctx, requestURL, regOpts)
+}
+
+// headManifest returns the digest the registry reports for a manifest, which
+// is empty if it doesn't report one
+func headManifest(ctx context.Context, requestURL *url.URL, regOpts *registryOptions) (string, error) {
+	headers := make(http.Header)
+	headers.Set("Accept", strings.Join([]string{
+		mediaTypeDockerManifest,
+		mediaTypeOCIManifest,
+		mediaTypeOCIIndex,
+		mediaTypeDockerManifestList,
+	}, ", "))
+
+	resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, headers, nil, regOpts)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+func sameLayers(a, b *ManifestV2) bool {
+	digests := func(m *ManifestV2) []string {
+		s := []string{m.Config.Digest}
+		for _, layer := range m.Layers {
+			s = append(s, layer.Digest)
+		}
+
+		return s
+	}
+
+	return slices.Equal(digests(a), digests(b))
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jmorganca/ollama/api"
+)
+
+func TestCheckUpdates(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_REGISTRIES", filepath.Join(t.TempDir(), "registries.json"))
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	digest := func(bts []byte) string { return fmt.Sprintf("sha256:%x", sha256.Sum256(bts)) }
+
+	var mu sync.Mutex
+	blobs := make(map[string][]byte)
+	var manifest []byte
+
+	publish := func(layer []byte) {
+		config := []byte(fmt.Sprintf(`{"model_format":"gguf","rootfs":{"diff_ids":["%s"]}}`, digest(layer)))
+		bts, err := json.Marshal(ManifestV2{
+			SchemaVersion: 2,
+			MediaType:     mediaTypeDockerManifest,
+			Config:        &Layer{MediaType: "application/vnd.docker.container.image.v1+json", Digest: digest(config), Size: int64(len(config))},
+			Layers:        []*Layer{{MediaType: "application/vnd.ollama.image.model", Digest: digest(layer), Size: int64(len(layer))}},
+		})
+		assert.Nil(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		blobs[digest(config)] = config
+		blobs[digest(layer)] = layer
+		manifest = bts
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch dir, name := filepath.Split(r.URL.Path); dir {
+		case "/v2/library/updated/manifests/":
+			w.Header().Set("Content-Type", mediaTypeDockerManifest)
+			w.Header().Set("Docker-Content-Digest", digest(manifest))
+			if r.Method == http.MethodGet {
+				w.Write(manifest)
+			}
+		case "/v2/library/updated/blobs/":
+			if blob, ok := blobs[name]; ok {
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+				return
+			}
+
+			fallthrough
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	name := fmt.Sprintf("%s/library/updated", srv.Listener.Addr())
+	regOpts := &registryOptions{Insecure: true}
+
+	publish([]byte("GGUF\x02\x00"))
+	assert.Nil(t, PullModel(context.TODO(), name, regOpts, func(api.ProgressResponse) {}))
+
+	// models created locally are skipped, even if the registry has a model
+	// with the same name
+	createModelfile(t, fmt.Sprintf("%s/library/local", srv.Listener.Addr()), "SYSTEM hello")
+	createModelfile(t, name+":created", "SYSTEM hello")
+
+	updates, err := CheckUpdates(context.TODO(), nil, regOpts)
+	assert.Nil(t, err)
+	assert.Empty(t, updates)
+
+	publish([]byte("GGUF\x02\x00\x00\x00"))
+
+	// the digest is checked on the registry's mirrors first
+	var mirrored int
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodHead && r.URL.Path == "/v2/library/updated/manifests/latest" {
+			mirrored++
+			w.Header().Set("Docker-Content-Digest", digest(manifest))
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mirror.Close()
+
+	writeRegistryConfig(t, map[string]registryConfig{srv.Listener.Addr().String(): {Mirrors: []string{mirror.URL}}})
+
+	updates, err = CheckUpdates(context.TODO(), []string{name, name + ":created"}, regOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, mirrored)
+	assert.Len(t, updates, 1)
+	assert.Equal(t, ParseModelPath(name).GetShortTagname(), updates[0].Model)
+	assert.Equal(t, digest(manifest), updates[0].RemoteDigest)
+	assert.Equal(t, int64(2), updates[0].RemoteSize-updates[0].Size)
+	assert.Equal(t, updates[0].RemoteSize, updates[0].Download)
+	assert.Empty(t, updates[0].Error)
+
+	// models pulled before pulls were recorded are checked too
+	dir, err := modelsDir()
+	assert.Nil(t, err)
+	pulled, err := readPulledFile(dir)
+	assert.Nil(t, err)
+	delete(pulled, ParseModelPath(name).GetFullTagname())
+	bts, err := json.Marshal(pulled)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "pulled.json"), bts, 0o644))
+
+	updates, err = CheckUpdates(context.TODO(), []string{name}, regOpts)
+	assert.Nil(t, err)
+	assert.Len(t, updates, 1)
+
+	// and models copied over a pulled one aren't
+	assert.Nil(t, CopyModel(name+":created", name))
+	updates, err = CheckUpdates(context.TODO(), []string{name}, regOpts)
+	assert.Nil(t, err)
+	assert.Empty(t, updates)
+
+	assert.Nil(t, PullModel(context.TODO(), name, regOpts, func(api.ProgressResponse) {}))
+
+	updates, err = CheckUpdates(context.TODO(), nil, regOpts)
+	assert.Nil(t, err)
+	assert.Empty(t, updates)
+}
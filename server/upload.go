@@ -275,12 +275,10 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 	case resp.StatusCode == http.StatusUnauthorized:
 		w.Rollback()
 		challenge := parseRegistryChallenge(resp.Header.Get("www-authenticate"))
-		token, err := getAuthorizationToken(ctx, challenge)
-		if err != nil {
+		if err := authenticate(ctx, requestURL.Host, challenge, opts); err != nil {
 			return err
 		}
 
-		opts.Token = token
 		fallthrough
 	case resp.StatusCode >= http.StatusBadRequest:
 		w.Rollback()